@@ -10,7 +10,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/graphdb"
 	"github.com/yourusername/psagents/internal/inference"
+	"github.com/yourusername/psagents/internal/vector_db"
 )
 
 type BatchQuery struct {
@@ -24,6 +26,7 @@ type BatchQuery struct {
 
 var (
 	configPath string
+	persona    string
 	batchFile  string
 	difficulty string
 )
@@ -42,7 +45,7 @@ It supports both interactive and batch modes for processing questions.`,
 		Short: "Run in interactive mode",
 		Long:  `Start an interactive session where you can type questions and get immediate answers.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			cfg, err := config.LoadConfig(configPath)
+			cfg, err := loadConfig()
 			if err != nil {
 				fmt.Printf("Error loading config: %v\n", err)
 				os.Exit(1)
@@ -63,7 +66,7 @@ It supports both interactive and batch modes for processing questions.`,
 				os.Exit(1)
 			}
 
-			cfg, err := config.LoadConfig(configPath)
+			cfg, err := loadConfig()
 			if err != nil {
 				fmt.Printf("Error loading config: %v\n", err)
 				os.Exit(1)
@@ -84,7 +87,7 @@ It supports both interactive and batch modes for processing questions.`,
 				os.Exit(1)
 			}
 
-			cfg, err := config.LoadConfig(configPath)
+			cfg, err := loadConfig()
 			if err != nil {
 				fmt.Printf("Error loading config: %v\n", err)
 				os.Exit(1)
@@ -95,6 +98,7 @@ It supports both interactive and batch modes for processing questions.`,
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&configPath, "config", "config/config.example.yaml", "path to config file")
+	rootCmd.PersistentFlags().StringVar(&persona, "persona", "", "persona ID to answer as (overrides the config file)")
 
 	// Batch command flags
 	batchCmd.Flags().StringVarP(&batchFile, "file", "f", "", "path to batch query file (required)")
@@ -115,12 +119,50 @@ It supports both interactive and batch modes for processing questions.`,
 	}
 }
 
+// loadConfig loads the config file and scopes it to --persona if given
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if persona != "" {
+		return cfg.WithPersona(persona)
+	}
+	return cfg, nil
+}
+
+// newEngine opens the stores of cfg and an inference engine on them. The
+// returned function closes all three.
+func newEngine(cfg *config.Config) (*inference.Engine, func(), error) {
+	vectorDB, err := vector_db.New(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize vector DB: %w", err)
+	}
+	store, err := graphdb.NewStore(cfg)
+	if err != nil {
+		vectorDB.Close()
+		return nil, nil, fmt.Errorf("failed to initialize graph DB: %w", err)
+	}
+	engine, err := inference.NewEngine(cfg, vectorDB, store)
+	if err != nil {
+		store.Close()
+		vectorDB.Close()
+		return nil, nil, err
+	}
+	return engine, func() {
+		engine.Close()
+		store.Close()
+		vectorDB.Close()
+	}, nil
+}
+
 func runInterActiveMode(cfg *config.Config, params inference.InferenceParams) {
-	engine, err := inference.NewEngine(cfg)
+	engine, closeEngine, err := newEngine(cfg)
 	if err != nil {
 		fmt.Printf("Error initializing inference: %v\n", err)
 		os.Exit(1)
 	}
+	defer closeEngine()
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -234,11 +276,12 @@ func runBatchMode(cfg *config.Config, batchFile, difficulty string, params infer
 	}
 	defer evalFile.Close()
 
-	engine, err := inference.NewEngine(cfg)
+	engine, closeEngine, err := newEngine(cfg)
 	if err != nil {
 		fmt.Printf("Error initializing inference: %v\n", err)
 		os.Exit(1)
 	}
+	defer closeEngine()
 
 	// Process each query and stream results
 	for _, query := range queries {
@@ -313,11 +356,12 @@ func runEvaluationMode(cfg *config.Config, batchFile, difficulty string) {
 	}
 	defer evalFile.Close()
 
-	engine, err := inference.NewEngine(cfg)
+	engine, closeEngine, err := newEngine(cfg)
 	if err != nil {
 		fmt.Printf("Error initializing inference: %v\n", err)
 		os.Exit(1)
 	}
+	defer closeEngine()
 
 	// Define all strategies
 	strategies := []struct {
@@ -4,12 +4,23 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/dedup"
 	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/forget"
+	"github.com/yourusername/psagents/internal/graph"
 	"github.com/yourusername/psagents/internal/graphdb"
+	"github.com/yourusername/psagents/internal/importers"
+	"github.com/yourusername/psagents/internal/lexical"
 	"github.com/yourusername/psagents/internal/llm"
+	"github.com/yourusername/psagents/internal/snapshot"
 	"github.com/yourusername/psagents/internal/vector"
 	"github.com/yourusername/psagents/internal/vector_db"
 )
@@ -23,7 +34,39 @@ type Phase struct {
 
 var (
 	configPath string
-	phases     []string
+	persona     string
+	phases      []string
+	incremental bool
+	resume      bool
+	reclassify  bool
+
+	importFormat   string
+	importInput    string
+	importOutput   string
+	importOwner    string
+	importThread   string
+	importTimezone string
+	importAppend   bool
+	importReplies  bool
+
+	cacheModel     string
+	cacheOlderThan time.Duration
+	cacheAll       bool
+
+	collectionYes bool
+
+	snapshotBackend string
+	snapshotForce   bool
+	snapshotYes     bool
+
+	forgetIDs    []string
+	forgetText   string
+	forgetRegex  string
+	forgetFrom   string
+	forgetTo     string
+	forgetReason string
+	forgetDryRun bool
+	forgetYes    bool
 )
 
 func main() {
@@ -39,18 +82,195 @@ It handles embedding generation, vector database population, and graph construct
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&configPath, "config", "config/config.example.yaml", "path to config file")
+	rootCmd.PersistentFlags().StringVar(&persona, "persona", "", "persona ID to scope data, vector collection and graph to (overrides the config file)")
+	rootCmd.Flags().BoolVar(&incremental, "incremental", false, "only process messages that are not already embedded, stored and linked (overrides pipeline.incremental)")
+	rootCmd.Flags().BoolVar(&resume, "resume", false, "skip messages an interrupted graph_construction_pass_2 already classified (overrides pipeline.resume)")
+	rootCmd.Flags().BoolVar(&reclassify, "force-reclassify", false, "classify every message again in graph_construction_pass_2, discarding earlier progress (overrides pipeline.force_reclassify)")
+	rootCmd.MarkFlagsMutuallyExclusive("resume", "force-reclassify")
 	rootCmd.Flags().StringSliceVar(&phases, "phases", nil, "specific phases to run (comma-separated). If not specified, runs all enabled phases")
 
-	if err := rootCmd.Execute(); err != nil {
+	// Import command
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Convert a chat export into the ingest JSONL format",
+		Long: `Parses a chat export offline, keeps only the messages authored by the persona
+owner and writes them in the JSONL format consumed by the embedding phase.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImport()
+		},
+	}
+	importCmd.Flags().StringVar(&importFormat, "format", "", "export format: "+strings.Join(importers.Formats(), "|"))
+	importCmd.Flags().StringVarP(&importInput, "input", "i", "", "path to the export file")
+	importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "output JSONL file (default: <input_dir>/messages.jsonl)")
+	importCmd.Flags().StringVar(&importOwner, "owner", "", "persona owner as it appears in the export (name, phone number, email or user ID)")
+	importCmd.Flags().StringVar(&importThread, "thread", "", "thread ID for exports that cover a single chat (default: input file name)")
+	importCmd.Flags().StringVar(&importTimezone, "timezone", "UTC", "time zone of exports that record local times")
+	importCmd.Flags().BoolVar(&importAppend, "append", false, "append to the output file instead of overwriting it")
+	importCmd.Flags().BoolVar(&importReplies, "include-replies", false, "keep the assistant reply to each message as context (chatgpt, openrouter)")
+	importCmd.MarkFlagRequired("format")
+	importCmd.MarkFlagRequired("input")
+	rootCmd.AddCommand(importCmd)
+
+	// Cache commands
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and prune the embedding cache",
+	}
+	cacheStatsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the number of cached embeddings and their size per model",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheStats()
+		},
+	}
+	cachePruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove cached embeddings",
+		Long: `Removes cached embeddings of a model and/or the ones not used for a while.
+Pass --all to clear the whole cache.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCachePrune()
+		},
+	}
+	cachePruneCmd.Flags().StringVar(&cacheModel, "model", "", "only prune entries of this embedding model")
+	cachePruneCmd.Flags().DurationVar(&cacheOlderThan, "older-than", 0, "only prune entries not used for this long (e.g. 720h)")
+	cachePruneCmd.Flags().BoolVar(&cacheAll, "all", false, "prune every entry")
+	cacheCmd.AddCommand(cacheStatsCmd, cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+
+	// Collection management commands
+	collectionCmd := &cobra.Command{
+		Use:   "collection",
+		Short: "Create, drop, recreate or describe the vector collection",
+		Long: `Manages the collection of the configured vector backend (qdrant.collection_name,
+scoped per persona). Drop and recreate delete every stored point and need --yes.`,
+	}
+	collectionCmd.PersistentFlags().BoolVar(&collectionYes, "yes", false, "confirm deleting the stored points")
+	for _, action := range []struct {
+		use, short string
+	}{
+		{"create", "Create the collection, checking an existing one matches the config"},
+		{"drop", "Delete the collection and its points"},
+		{"recreate", "Drop the collection and create it from the config"},
+		{"describe", "Show the collection's vector size, distance and point count"},
+	} {
+		action := action
+		collectionCmd.AddCommand(&cobra.Command{
+			Use:   action.use,
+			Short: action.short,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return runCollection(action.use)
+			},
+		})
+	}
+	rootCmd.AddCommand(collectionCmd)
+
+	// Snapshot commands
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Back up and restore the vector store",
+		Long: `Saves every point of the vector store with the embedding model and dimension
+under data.backup_dir, and restores a saved snapshot into the qdrant or hnsw
+backend. Take one before a re-ingest to roll back a bad run.`,
+	}
+	snapshotCreateCmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Save the vector store as a named snapshot (default: a timestamp)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return runSnapshotCreate(name)
+		},
+	}
+	snapshotListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the saved snapshots",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSnapshotList()
+		},
+	}
+	snapshotRestoreCmd := &cobra.Command{
+		Use:   "restore <name>",
+		Short: "Replace the vector store with a snapshot",
+		Long: `Drops the collection of the target backend, loads the snapshot points into it
+and rebuilds the lexical index. A snapshot taken with another embedding model
+or dimension is refused unless --force is passed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSnapshotRestore(args[0])
+		},
+	}
+	snapshotRestoreCmd.Flags().StringVar(&snapshotBackend, "backend", "", "backend to restore into: qdrant|hnsw (default: vector.backend)")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotForce, "force", false, "restore even if the embedding model differs from the config")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotYes, "yes", false, "confirm replacing the stored points")
+	snapshotCmd.AddCommand(snapshotCreateCmd, snapshotListCmd, snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+
+	// Forget command
+	forgetCmd := &cobra.Command{
+		Use:   "forget",
+		Short: "Remove messages from every store for a right-to-be-forgotten request",
+		Long: `Removes the selected messages, and the chunks cut from them, from the vector
+store, the graph, the lexical index, the input and output files, snapshots and
+the embedding cache, and redacts them from the logs. Messages are selected by
+--id, plus those matching every one of --text, --regex, --from and --to that
+is given. Each request is recorded in data/logs/forget/audit.jsonl.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runForget(cmd.Context())
+		},
+	}
+	forgetCmd.Flags().StringSliceVar(&forgetIDs, "id", nil, "message ID to forget (repeatable or comma-separated)")
+	forgetCmd.Flags().StringVar(&forgetText, "text", "", "forget messages containing this text, ignoring case")
+	forgetCmd.Flags().StringVar(&forgetRegex, "regex", "", "forget messages matching this regular expression")
+	forgetCmd.Flags().StringVar(&forgetFrom, "from", "", "forget messages sent at or after this time (RFC3339 or YYYY-MM-DD)")
+	forgetCmd.Flags().StringVar(&forgetTo, "to", "", "forget messages sent at or before this time (RFC3339 or YYYY-MM-DD)")
+	forgetCmd.Flags().StringVar(&forgetReason, "reason", "", "reason recorded in the audit log")
+	forgetCmd.Flags().BoolVar(&forgetDryRun, "dry-run", false, "list the selected message IDs without removing anything")
+	forgetCmd.Flags().BoolVar(&forgetYes, "yes", false, "confirm removing the selected messages")
+	rootCmd.AddCommand(forgetCmd)
+
+	// ID migration command
+	migrateIDsCmd := &cobra.Command{
+		Use:   "migrate-ids",
+		Short: "Rewrite Qdrant points and graph nodes to canonical message IDs",
+		Long: `Older ingests stored Qdrant point UUIDs as message IDs in the graph. This adds
+the message ID to the payload of existing Qdrant points and rewrites graph
+nodes to the SHA-256 of their text and metadata, merging duplicates. It is safe to rerun.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMigrateIDs(cmd.Context())
+		},
+	}
+	rootCmd.AddCommand(migrateIDsCmd)
+
+	// Cancel long running phases on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func runIngest(ctx context.Context) error {
 	// Load configuration
-	cfg, err := config.LoadConfig(configPath)
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
+	}
+	if incremental {
+		cfg.Pipeline.Incremental = true
+	}
+	if resume {
+		cfg.Pipeline.Resume, cfg.Pipeline.ForceReclassify = true, false
+	}
+	if reclassify {
+		cfg.Pipeline.Resume, cfg.Pipeline.ForceReclassify = false, true
+	}
+	if cfg.Pipeline.Resume && cfg.Pipeline.ForceReclassify {
+		return fmt.Errorf("pipeline.resume and pipeline.force_reclassify are mutually exclusive")
 	}
 
 	// Initialize components
@@ -59,6 +279,19 @@ func runIngest(ctx context.Context) error {
 	var graphDB *graphdb.GraphDB
 	var llmClient llm.LLM
 
+	// Close the stores however the run ends, so that an interrupted pass
+	// keeps what it wrote
+	defer func() {
+		if vectorDB != nil {
+			if closer, ok := vectorDB.(interface{ Close() error }); ok {
+				closer.Close()
+			}
+		}
+		if graphDB != nil {
+			graphDB.Close()
+		}
+	}()
+
 	// Define all available phases
 	allPhases := []Phase{
 		{
@@ -81,36 +314,56 @@ func runIngest(ctx context.Context) error {
 				}
 
 				// Generate embeddings
-				if err := gen.GenerateEmbeddings(); err != nil {
+				if err := gen.GenerateEmbeddings(ctx); err != nil {
 					return fmt.Errorf("failed to generate embeddings: %w", err)
 				}
 				return nil
 			},
 		},
+		{
+			Name:    "dedup",
+			Enabled: isPhaseEnabled(cfg.Ingestion.Stages, "dedup"),
+			Handler: func(ctx context.Context) error {
+				fmt.Println("Finding near-duplicate messages...")
+				duplicates, err := dedup.Run(cfg)
+				if err != nil {
+					return fmt.Errorf("failed to find duplicates: %w", err)
+				}
+				fmt.Printf("Found %d near-duplicate messages\n", len(duplicates))
+				return nil
+			},
+		},
 		{
 			Name:    "semantic_search",
 			Enabled: isPhaseEnabled(cfg.Ingestion.Stages, "semantic_search"),
 			Handler: func(ctx context.Context) error {
 				fmt.Println("Initializing vector database...")
-				if cfg.Qdrant.Enabled {
-					// Initialize Qdrant database
-					qdrantDB, err := vector_db.NewQdrantDB(cfg)
+				if cfg.Qdrant.Enabled || cfg.Vector.Backend == vector_db.BackendHNSW {
+					// Initialize the configured vector database
+					db, err := vector_db.New(cfg)
 					if err != nil {
 						return fmt.Errorf("failed to initialize vector database: %w", err)
 					}
 
 					// Create collection if it doesn't exist
-					if err := qdrantDB.(vector_db.DB).CreateCollection(); err != nil {
+					if err := db.CreateCollection(); err != nil {
 						return fmt.Errorf("failed to create collection: %w", err)
 					}
 
 					// Inject messages into the database
-					if err := qdrantDB.(vector_db.DB).InjectMessages(); err != nil {
+					if err := db.InjectMessages(); err != nil {
 						return fmt.Errorf("failed to inject messages into vector database: %w", err)
 					}
 
 					fmt.Println("Successfully initialized vector database")
-					vectorDB = qdrantDB
+					vectorDB = db
+
+					// Index the same messages for lexical and hybrid anchors
+					added, err := lexical.Build(cfg)
+					if err != nil {
+						return fmt.Errorf("failed to build lexical index: %w", err)
+					}
+					fmt.Printf("Indexed %d messages for lexical search\n", added)
 				} else {
 					return fmt.Errorf("no vector database enabled in configuration")
 				}
@@ -168,6 +421,23 @@ func runIngest(ctx context.Context) error {
 				return nil
 			},
 		},
+		{
+			Name:    "graph_compression",
+			Enabled: isPhaseEnabled(cfg.Ingestion.Stages, "graph_compression"),
+			Handler: func(ctx context.Context) error {
+				if graphDB == nil {
+					return fmt.Errorf("graph database not initialized")
+				}
+				fmt.Println("Compressing graph...")
+				stats, err := graphDB.Compress(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to compress graph: %w", err)
+				}
+				printCompression(stats)
+				fmt.Println("Successfully compressed graph")
+				return nil
+			},
+		},
 	}
 
 	// Filter phases if specific ones were requested
@@ -199,17 +469,379 @@ func runIngest(ctx context.Context) error {
 		}
 	}
 
-	// Cleanup
-	if vectorDB != nil {
-		if closer, ok := vectorDB.(interface{ Close() error }); ok {
-			closer.Close()
+	fmt.Println("Successfully completed all enabled phases")
+	return nil
+}
+
+// printCompression shows the graph before and after compression and why
+// edges were removed
+func printCompression(stats graphdb.CompressionStats) {
+	fmt.Printf("%-14s %8s %8s\n", "", "before", "after")
+	fmt.Printf("%-14s %8d %8d\n", "nodes", stats.Before.Nodes, stats.After.Nodes)
+	for _, t := range graph.EdgeTypes {
+		fmt.Printf("%-14s %8d %8d\n", t, stats.Before.Edges[t], stats.After.Edges[t])
+	}
+	fmt.Printf("%-14s %8d %8d\n", "edges", stats.Before.TotalEdges(), stats.After.TotalEdges())
+	fmt.Printf("Removed %d edges: %d below the similarity threshold, %d topic switches, %d below the minimum confidence, %d mutual, %d over the degree cap\n",
+		stats.Removed(), stats.BelowThreshold, stats.TopicSwitches, stats.LowConfidence, stats.Mutual, stats.OverDegree)
+}
+
+// loadConfig loads the config file and scopes it to --persona if given
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if persona != "" {
+		if cfg, err = cfg.WithPersona(persona); err != nil {
+			return nil, err
 		}
 	}
-	if graphDB != nil {
-		graphDB.Close()
+	return cfg, nil
+}
+
+// runImport converts a chat export into the ingest JSONL format
+func runImport() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Successfully completed all enabled phases")
+	importer, err := importers.New(importFormat)
+	if err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(importTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	thread := importThread
+	if thread == "" {
+		thread = strings.TrimSuffix(filepath.Base(importInput), filepath.Ext(importInput))
+	}
+
+	input, err := os.Open(importInput)
+	if err != nil {
+		return fmt.Errorf("failed to open export: %w", err)
+	}
+	defer input.Close()
+
+	messages, err := importer.Import(input, importers.Options{
+		Owner:          importOwner,
+		Thread:         thread,
+		Location:       loc,
+		IncludeReplies: importReplies,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import %s export: %w", importFormat, err)
+	}
+
+	outputPath := importOutput
+	if outputPath == "" {
+		outputPath = filepath.Join(cfg.Data.InputDir, "messages.jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if importAppend {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	output, err := os.OpenFile(outputPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer output.Close()
+
+	if err := importers.WriteJSONL(output, messages); err != nil {
+		return fmt.Errorf("failed to write messages: %w", err)
+	}
+
+	fmt.Printf("Imported %d messages from %s into %s\n", len(messages), importInput, outputPath)
+	return nil
+}
+
+// runCacheStats prints the embedding cache usage per model
+func runCacheStats() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Embeddings.CacheDir == "" {
+		return fmt.Errorf("embeddings.cache_dir is not set")
+	}
+
+	stats, err := embeddings.InspectCache(cfg.Embeddings.CacheDir)
+	if err != nil {
+		return err
+	}
+	total := embeddings.CacheStats{Model: "total"}
+	for _, s := range stats {
+		fmt.Printf("%-40s %8d entries %12d bytes\n", s.Model, s.Entries, s.Bytes)
+		total.Entries += s.Entries
+		total.Bytes += s.Bytes
+	}
+	fmt.Printf("%-40s %8d entries %12d bytes\n", total.Model, total.Entries, total.Bytes)
+	return nil
+}
+
+// runCachePrune removes embedding cache entries
+func runCachePrune() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Embeddings.CacheDir == "" {
+		return fmt.Errorf("embeddings.cache_dir is not set")
+	}
+	if !cacheAll && cacheModel == "" && cacheOlderThan == 0 {
+		return fmt.Errorf("specify --model, --older-than or --all")
+	}
+
+	removed, freed, err := embeddings.PruneCache(cfg.Embeddings.CacheDir, embeddings.PruneOptions{
+		Model:     cacheModel,
+		OlderThan: cacheOlderThan,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d cached embeddings (%d bytes)\n", removed, freed)
+	return nil
+}
+
+// runCollection runs a collection management action
+func runCollection(action string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if (action == "drop" || action == "recreate") && !collectionYes {
+		return fmt.Errorf("%s deletes every point of collection %s; pass --yes to confirm", action, cfg.Qdrant.CollectionName)
+	}
+
+	db, err := vector_db.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	defer db.Close()
+	manager, ok := db.(vector_db.CollectionManager)
+	if !ok {
+		return fmt.Errorf("the %s backend has no collection to manage", cfg.Vector.Backend)
+	}
+
+	switch action {
+	case "drop", "recreate":
+		if err := manager.DropCollection(); err != nil {
+			return err
+		}
+		fmt.Printf("Dropped collection %s\n", cfg.Qdrant.CollectionName)
+		if action == "drop" {
+			return nil
+		}
+		fallthrough
+	case "create":
+		if err := manager.CreateCollection(); err != nil {
+			return err
+		}
+		fmt.Printf("Collection %s is ready\n", cfg.Qdrant.CollectionName)
+		return nil
+	}
+
+	info, err := manager.DescribeCollection()
+	if err != nil {
+		return err
+	}
+	if !info.Exists {
+		fmt.Printf("Collection %s does not exist\n", info.Name)
+		return nil
+	}
+	fmt.Printf("Collection: %s\nStatus:     %s\nVectors:    %d dimensions, %s distance\nPoints:     %d\n",
+		info.Name, info.Status, info.VectorSize, info.Distance, info.Points)
+	return nil
+}
+
+// runSnapshotCreate saves the vector store as a snapshot
+func runSnapshotCreate(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	manifest, err := snapshot.Create(cfg, name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d points of collection %s to snapshot %s\n", manifest.Points, manifest.Collection, manifest.Name)
+	return nil
+}
+
+// runSnapshotList prints the saved snapshots, oldest first
+func runSnapshotList() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	manifests, err := snapshot.List(cfg)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		fmt.Printf("No snapshots in %s\n", snapshot.Dir(cfg))
+		return nil
+	}
+	fmt.Printf("%-24s %-20s %-7s %8s  %s\n", "NAME", "CREATED", "BACKEND", "POINTS", "MODEL")
+	for _, m := range manifests {
+		fmt.Printf("%-24s %-20s %-7s %8d  %s (%d)\n",
+			m.Name, m.CreatedAt.Local().Format("2006-01-02 15:04:05"), m.Backend, m.Points, m.EmbeddingModel, m.Dimension)
+	}
+	return nil
+}
+
+// runSnapshotRestore replaces the vector store with a snapshot
+func runSnapshotRestore(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !snapshotYes {
+		return fmt.Errorf("restore replaces every point of collection %s; pass --yes to confirm", cfg.Qdrant.CollectionName)
+	}
+	manifest, previous, err := snapshot.Restore(cfg, name, snapshotBackend, snapshotForce)
+	if err != nil {
+		return err
+	}
+	backend := snapshotBackend
+	if backend == "" {
+		backend = cfg.Vector.Backend
+	}
+	if backend == "" {
+		backend = vector_db.BackendQdrant
+	}
+	fmt.Printf("Restored %d points of snapshot %s into the %s backend\n", manifest.Points, manifest.Name, backend)
+	if previous != "" {
+		fmt.Printf("The replaced points were saved to snapshot %s\n", previous)
+	}
+	fmt.Println("The graph was left as it was; rerun graph construction to match it to the restored points")
+	return nil
+}
+
+// runForget removes messages from every store
+func runForget(ctx context.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	req := forget.Request{
+		IDs:    forgetIDs,
+		Text:   forgetText,
+		Regex:  forgetRegex,
+		Reason: forgetReason,
+		DryRun: forgetDryRun,
+	}
+	if req.From, err = parseTimeFlag("from", forgetFrom); err != nil {
+		return err
+	}
+	if req.To, err = parseTimeFlag("to", forgetTo); err != nil {
+		return err
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	if !forgetDryRun && !forgetYes {
+		return fmt.Errorf("forget permanently removes the selected messages; pass --dry-run to list them or --yes to confirm")
+	}
+
+	vectorDB, err := vector_db.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	defer vectorDB.Close()
+	graphDB, err := graphdb.NewGraphDB(cfg, vectorDB)
+	if err != nil {
+		return fmt.Errorf("failed to initialize graph database: %w", err)
+	}
+	defer graphDB.Close()
+
+	forgetter, err := forget.New(cfg, vectorDB, graphDB)
+	if err != nil {
+		return err
+	}
+	record, err := forgetter.Forget(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if forgetDryRun {
+		for _, id := range record.MessageIDs {
+			fmt.Println(id)
+		}
+		fmt.Printf("%d messages selected\n", len(record.MessageIDs))
+		return nil
+	}
+	fmt.Printf("Forgot %d messages: %d vector points, %d graph nodes, %d edges, %d evidence strings redacted\n",
+		len(record.MessageIDs), record.VectorPoints, record.Graph.Nodes, record.Graph.Edges, record.Graph.Evidence)
+	fmt.Printf("Removed %d embeddings, %d input messages, %d snapshot points and %d cached embeddings; redacted %d log files\n",
+		record.EmbeddingLines, record.InputLines, record.SnapshotPoints, record.CacheEntries, record.LogFiles)
+	if record.UnredactedTexts > 0 {
+		fmt.Printf("%d texts were too short to redact from the logs\n", record.UnredactedTexts)
+	}
+	fmt.Printf("Audit record appended to %s\n", forgetter.AuditPath())
+	return nil
+}
+
+// parseTimeFlag parses an RFC3339 time or a UTC date; empty values are
+// unset. A --to date includes the whole day.
+func parseTimeFlag(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if name == "to" {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid --%s %q (expected RFC3339 or YYYY-MM-DD)", name, value)
+}
+
+// runMigrateIDs rewrites stored points and graph nodes to canonical message IDs
+func runMigrateIDs(ctx context.Context) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	vectorDB, err := vector_db.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	defer vectorDB.Close()
+
+	// Only Qdrant keys points by anything but the message ID
+	if qdrantDB, ok := vectorDB.(*vector_db.QdrantDB); ok {
+		migrated, err := qdrantDB.MigrateIDs(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added the message ID to %d Qdrant points\n", migrated)
+	}
+
+	graphDB, err := graphdb.NewGraphDB(cfg, vectorDB)
+	if err != nil {
+		return fmt.Errorf("failed to initialize graph database: %w", err)
+	}
+	defer graphDB.Close()
+
+	stats, err := graphDB.MigrateIDs(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Renamed %d graph nodes and merged %d duplicates\n", stats.Renamed, stats.Merged)
 	return nil
 }
 
@@ -1,27 +1,79 @@
 package main
 
 import (
+	"container/list"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/forget"
 	"github.com/yourusername/psagents/internal/graphdb"
 	"github.com/yourusername/psagents/internal/inference"
+	"github.com/yourusername/psagents/internal/vector"
 	"github.com/yourusername/psagents/internal/vector_db"
 )
 
 type Server struct {
+	backendMu sync.RWMutex
+	backend   *personaBackend // backend of the configuration the server started with
+	cfg       *config.Config
+
+	// forgetMu serializes forget requests, which rewrite files in place
+	forgetMu sync.Mutex
+
+	// personas holds the backends of the personas served under
+	// /api/v1/personas/{id}/, created on first use. At most
+	// server.max_personas are kept open; the least recently used idle one
+	// is retired to make room for another.
+	personasMu   sync.Mutex
+	personas     map[string]*list.Element
+	personaOrder *list.List // *personaEntry, most recently used first
+}
+
+// defaultMaxPersonas is the number of persona backends kept open when
+// server.max_personas is not set
+const defaultMaxPersonas = 8
+
+// personaEntry is a persona backend in Server.personaOrder
+type personaEntry struct {
+	id      string
+	backend *personaBackend
+}
+
+// personaBackend is the inference engine, graph database and vector
+// database of one persona
+type personaBackend struct {
 	inferenceEngine *inference.Engine
-	graphDB        *graphdb.GraphDB
-	cfg            *config.Config
+	graphDB         *graphdb.GraphDB
+	vectorDB        vector_db.DB
+	cfg             *config.Config
+
+	// refs counts the requests using the backend, so that a replaced
+	// backend is only closed once the last of them is done
+	mu      sync.Mutex
+	refs    int
+	retired bool
 }
 
 type ChatCompletionRequest struct {
 	Prompt            string `json:"prompt"`
 	InferenceStrategy string `json:"inferenceStrategy"`
+	// Filter restricts the similarity anchors by payload field
+	Filter *vector.Filter `json:"filter,omitempty"`
+}
+
+// ForgetRequest is a forget.Request with the confirmation ingest forget
+// asks for with --yes, required unless it is a dry run
+type ForgetRequest struct {
+	forget.Request
+	Confirm bool `json:"confirm"`
 }
 
 var (
@@ -64,16 +116,19 @@ func runServer() error {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
 
-	// Set up API routes with CORS
+	// Set up API routes with CORS. Forget endpoints get none, so that no
+	// other origin can delete messages.
 	http.HandleFunc("/api/v1/chat/completions", enableCORS(server.handleChatCompletions))
 	http.HandleFunc("/api/v1/message/id", enableCORS(server.handleMessageById))
+	http.HandleFunc("/api/v1/forget", server.handleForget)
+	http.HandleFunc("/api/v1/personas/", server.routePersona)
 
 	// Serve static files
 	fs := http.FileServer(http.Dir(webDir))
 	http.Handle("/", fs)
 
 	// Start server
-	addr := fmt.Sprintf(":%d", cfg.Server.Port)
+	addr := cfg.Server.Address()
 	log.Printf("Starting server on %s", addr)
 	log.Printf("Web interface available at http://localhost:%d", cfg.Server.Port)
 	if err := http.ListenAndServe(addr, nil); err != nil {
@@ -84,8 +139,22 @@ func runServer() error {
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
+	backend, err := newPersonaBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		backend:      backend,
+		cfg:          cfg,
+		personas:     make(map[string]*list.Element),
+		personaOrder: list.New(),
+	}, nil
+}
+
+func newPersonaBackend(cfg *config.Config) (*personaBackend, error) {
 	// Initialize vector database (needed for graphdb)
-	vectorDB, err := vector_db.NewQdrantDB(cfg)
+	vectorDB, err := vector_db.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize vector DB: %w", err)
 	}
@@ -93,22 +162,142 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	// Initialize graph database
 	graphDB, err := graphdb.NewGraphDB(cfg, vectorDB)
 	if err != nil {
+		vectorDB.Close()
 		return nil, fmt.Errorf("failed to initialize graph DB: %w", err)
 	}
 
-	// Initialize inference engine
-	inferenceEngine, err := inference.NewEngine(cfg)
+	// Initialize inference engine on the same stores
+	inferenceEngine, err := inference.NewEngine(cfg, vectorDB, graphDB.Store())
 	if err != nil {
+		graphDB.Close()
+		vectorDB.Close()
 		return nil, fmt.Errorf("failed to initialize inference engine: %w", err)
 	}
 
-	return &Server{
+	return &personaBackend{
 		inferenceEngine: inferenceEngine,
-		graphDB:        graphDB,
-		cfg:            cfg,
+		graphDB:         graphDB,
+		vectorDB:        vectorDB,
+		cfg:             cfg,
 	}, nil
 }
 
+// acquire marks the backend in use by a request, which must release it
+func (b *personaBackend) acquire() *personaBackend {
+	b.mu.Lock()
+	b.refs++
+	b.mu.Unlock()
+	return b
+}
+
+// release ends a request's use of the backend, closing it if it was
+// replaced and this was the last request using it
+func (b *personaBackend) release() {
+	b.mu.Lock()
+	b.refs--
+	done := b.retired && b.refs == 0
+	b.mu.Unlock()
+	if done {
+		b.close()
+	}
+}
+
+// idle reports whether no request uses the backend
+func (b *personaBackend) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refs == 0
+}
+
+// retire closes the backend once no request uses it anymore
+func (b *personaBackend) retire() {
+	b.mu.Lock()
+	b.retired = true
+	done := b.refs == 0
+	b.mu.Unlock()
+	if done {
+		b.close()
+	}
+}
+
+// close closes the inference engine before the stores it shares
+func (b *personaBackend) close() {
+	if err := b.inferenceEngine.Close(); err != nil {
+		log.Printf("Error closing inference engine: %v", err)
+	}
+	if err := b.graphDB.Close(); err != nil {
+		log.Printf("Error closing graph DB: %v", err)
+	}
+	if err := b.vectorDB.Close(); err != nil {
+		log.Printf("Error closing vector DB: %v", err)
+	}
+}
+
+// persona returns the backend of a persona, creating it on first use. The
+// caller must release it.
+func (s *Server) persona(id string) (*personaBackend, error) {
+	s.personasMu.Lock()
+	defer s.personasMu.Unlock()
+
+	if elem, ok := s.personas[id]; ok {
+		s.personaOrder.MoveToFront(elem)
+		return elem.Value.(*personaEntry).backend.acquire(), nil
+	}
+
+	cfg, err := s.cfg.WithPersona(id)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := newPersonaBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Acquired first, so that making room doesn't close it
+	backend.acquire()
+	s.addPersona(id, backend)
+	return backend, nil
+}
+
+// addPersona keeps the backend of a persona open, retiring the least
+// recently used idle backends beyond server.max_personas. The caller must
+// hold personasMu.
+func (s *Server) addPersona(id string, backend *personaBackend) {
+	s.personas[id] = s.personaOrder.PushFront(&personaEntry{id: id, backend: backend})
+
+	max := s.cfg.Server.MaxPersonas
+	if max <= 0 {
+		max = defaultMaxPersonas
+	}
+	// Only idle backends are closed: one still in use keeps its stores
+	// locked, so the persona could not be reopened until it is done
+	for elem := s.personaOrder.Back(); elem != nil && s.personaOrder.Len() > max; {
+		prev := elem.Prev()
+		if entry := elem.Value.(*personaEntry); entry.backend.idle() {
+			s.personaOrder.Remove(elem)
+			delete(s.personas, entry.id)
+			entry.backend.retire()
+		}
+		elem = prev
+	}
+}
+
+// defaultBackend returns the backend of the configuration the server started
+// with. The caller must release it.
+func (s *Server) defaultBackend() *personaBackend {
+	s.backendMu.RLock()
+	defer s.backendMu.RUnlock()
+	return s.backend.acquire()
+}
+
+// backendFor returns the backend of a persona, or the default backend for
+// an empty ID. The caller must release it.
+func (s *Server) backendFor(id string) (*personaBackend, error) {
+	if id == "" {
+		return s.defaultBackend(), nil
+	}
+	return s.persona(id)
+}
+
 func enableCORS(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -138,7 +327,158 @@ func parseInferenceStrategy(cfg *config.Config, strategy string) (inference.Infe
 	}
 }
 
+// routePersona serves the persona endpoints with CORS, except forget
+func (s *Server) routePersona(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/forget") {
+		s.handlePersona(w, r)
+		return
+	}
+	enableCORS(s.handlePersona)(w, r)
+}
+
+// handlePersona routes /api/v1/personas/{id}/chat/completions,
+// /api/v1/personas/{id}/message/id and /api/v1/personas/{id}/forget to the
+// backend of persona {id}
+func (s *Server) handlePersona(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/personas/")
+	id, endpoint, ok := strings.Cut(rest, "/")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := config.ValidatePersonaID(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(s.cfg.Personas) > 0 && !s.cfg.HasPersona(id) {
+		http.Error(w, "Persona not found", http.StatusNotFound)
+		return
+	}
+
+	var handler func(*personaBackend, http.ResponseWriter, *http.Request)
+	switch endpoint {
+	case "forget":
+		s.forget(id, w, r)
+		return
+	case "chat/completions":
+		handler = handleChatCompletions
+	case "message/id":
+		handler = handleMessageById
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	backend, err := s.persona(id)
+	if err != nil {
+		log.Printf("Error initializing persona %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer backend.release()
+	handler(backend, w, r)
+}
+
 func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	backend := s.defaultBackend()
+	defer backend.release()
+	handleChatCompletions(backend, w, r)
+}
+
+func (s *Server) handleMessageById(w http.ResponseWriter, r *http.Request) {
+	backend := s.defaultBackend()
+	defer backend.release()
+	handleMessageById(backend, w, r)
+}
+
+// forgetAuthorized reports whether a request carries server.forget_token as
+// its bearer token, or no token is configured
+func (s *Server) forgetAuthorized(r *http.Request) bool {
+	token := s.cfg.Server.ForgetToken
+	if token == "" {
+		return true
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func (s *Server) handleForget(w http.ResponseWriter, r *http.Request) {
+	s.forget("", w, r)
+}
+
+// forget removes the messages selected by a ForgetRequest from every store
+// of a persona and responds with the audit record. The stores are the ones
+// the persona's engine searches, so the messages are gone from them at
+// once; the engine's in-memory lexical index is then dropped so that it is
+// read again without them.
+// Requests must be JSON, which browsers only send cross-origin after a
+// preflight the endpoint doesn't allow.
+func (s *Server) forget(id string, w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.Server.ForgetEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.forgetAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var fr ForgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&fr); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req := fr.Request
+	if err := req.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid forget request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.DryRun && !fr.Confirm {
+		http.Error(w, "Forget permanently removes the selected messages; set dry_run to list them or confirm to remove them", http.StatusBadRequest)
+		return
+	}
+
+	s.forgetMu.Lock()
+	defer s.forgetMu.Unlock()
+
+	b, err := s.backendFor(id)
+	if err != nil {
+		log.Printf("Error initializing persona %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer b.release()
+	forgetter, err := forget.New(b.cfg, b.vectorDB, b.graphDB)
+	if err != nil {
+		log.Printf("Error creating forgetter: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	record, err := forgetter.Forget(r.Context(), req)
+	if err != nil {
+		log.Printf("Error forgetting messages: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !req.DryRun && len(record.MessageIDs) > 0 {
+		b.inferenceEngine.ReloadLexical()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+func handleChatCompletions(b *personaBackend, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -150,19 +490,25 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inferenceParams, err := parseInferenceStrategy(s.cfg, req.InferenceStrategy)
+	inferenceParams, err := parseInferenceStrategy(b.cfg, req.InferenceStrategy)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Invalid inference strategy: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	if err := req.Filter.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+	inferenceParams.Filter = req.Filter
+
 	inferenceParams.Query = inference.Query{
 		Question: req.Prompt,
 	}
 
-	inferenceParams.SystemPrompt = s.cfg.LLM.InferenceSystemPrompt
+	inferenceParams.SystemPrompt = b.cfg.LLM.InferenceSystemPrompt
 
-	response, err := s.inferenceEngine.Infer(inferenceParams)
+	response, err := b.inferenceEngine.Infer(inferenceParams)
 
 	if err != nil {
 		log.Printf("Error processing chat completion: %v", err)
@@ -174,7 +520,7 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleMessageById(w http.ResponseWriter, r *http.Request) {
+func handleMessageById(b *personaBackend, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -186,10 +532,7 @@ func (s *Server) handleMessageById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := s.graphDB.GetSession()
-	defer session.Close()
-
-	message, err := s.graphDB.GetMessageByID(session, messageID)
+	message, err := b.graphDB.GetMessageByID(r.Context(), messageID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get message: %v", err), http.StatusInternalServerError)
 		return
@@ -202,4 +545,4 @@ func (s *Server) handleMessageById(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(message)
-}
\ No newline at end of file
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -11,6 +14,8 @@ import (
 
 // Config represents the main configuration structure
 type Config struct {
+	Persona    string           `mapstructure:"persona"`
+	Personas   []string         `mapstructure:"personas"`
 	Server     ServerConfig     `mapstructure:"server"`
 	Pipeline   PipelineConfig   `mapstructure:"pipeline"`
 	GraphDB    GraphDBConfig    `mapstructure:"graphdb"`
@@ -20,8 +25,13 @@ type Config struct {
 	Logging    LoggingConfig    `mapstructure:"logging"`
 	DevMode    DevModeConfig    `mapstructure:"devmode"`
 	Qdrant     QdrantConfig     `mapstructure:"qdrant"`
+	Vector     VectorConfig     `mapstructure:"vector"`
 	Ingestion  IngestionConfig  `mapstructure:"ingestion"`
+	Dedup      DedupConfig      `mapstructure:"dedup"`
 	Inference  InferenceConfig  `mapstructure:"inference"`
+
+	// unscoped is the configuration WithPersona derived this one from
+	unscoped *Config
 }
 
 // InferenceConfig represents inference-related configuration
@@ -31,6 +41,19 @@ type InferenceConfig struct {
 	MinConfidence float64 `mapstructure:"min_confidence"`
 	MaxRelatedMessages int `mapstructure:"max_related_messages"`
 	MaxRelatedDepth int `mapstructure:"max_related_depth"`
+	// AnchorRetrieval is how similarity anchors are found: dense (vector
+	// search, the default), lexical (BM25) or hybrid (both, fused with
+	// reciprocal rank fusion)
+	AnchorRetrieval string `mapstructure:"anchor_retrieval"`
+	// StrategyAnchorRetrieval overrides AnchorRetrieval per inference
+	// strategy, keyed by hybrid, similarity or semantic
+	StrategyAnchorRetrieval map[string]string `mapstructure:"strategy_anchor_retrieval"`
+	// RRFK is the reciprocal rank fusion constant (default 60)
+	RRFK int `mapstructure:"rrf_k"`
+	// MMRLambda diversifies similarity anchors with maximal marginal
+	// relevance: 1 ranks by relevance alone, lower values push
+	// near-duplicates down. 0 disables the reranking.
+	MMRLambda float64 `mapstructure:"mmr_lambda"`
 }
 
 // ServerConfig represents server-related configuration
@@ -38,6 +61,33 @@ type ServerConfig struct {
 	Host      string `mapstructure:"host"`
 	Port      int    `mapstructure:"port"`
 	GRPCPort  int    `mapstructure:"grpc_port"`
+	// MaxPersonas caps the persona backends the server keeps open, closing
+	// the least recently used one beyond it (0 for the default of 8)
+	MaxPersonas int `mapstructure:"max_personas"`
+	// EnableForget serves the forget endpoints, which are off by default
+	EnableForget bool `mapstructure:"enable_forget"`
+	// ForgetToken, if set, serves the forget endpoints to requests carrying
+	// it as a bearer token only
+	ForgetToken string `mapstructure:"forget_token"`
+}
+
+// DefaultServerHost is the address the server listens on when server.host
+// is unset, so that it is only reachable from the same machine
+const DefaultServerHost = "127.0.0.1"
+
+// Address returns the host:port the server listens on
+func (c ServerConfig) Address() string {
+	host := c.Host
+	if host == "" {
+		host = DefaultServerHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.Port))
+}
+
+// ForgetEnabled reports whether the server serves the forget endpoints:
+// when server.enable_forget is set or a server.forget_token is configured
+func (c ServerConfig) ForgetEnabled() bool {
+	return c.EnableForget || c.ForgetToken != ""
 }
 
 // PipelineConfig represents pipeline-related configuration
@@ -45,10 +95,21 @@ type PipelineConfig struct {
 	BatchSize  int    `mapstructure:"batch_size"`
 	MaxWorkers int    `mapstructure:"max_workers"`
 	Timeout    string `mapstructure:"timeout"`
+	// Incremental only embeds, injects and links messages that are not
+	// already stored instead of rebuilding everything
+	Incremental bool `mapstructure:"incremental"`
+	// Resume skips the messages an earlier second pass already classified,
+	// to continue a pass that was interrupted
+	Resume bool `mapstructure:"resume"`
+	// ForceReclassify classifies every message again, discarding the
+	// progress recorded by earlier second passes
+	ForceReclassify bool `mapstructure:"force_reclassify"`
 }
 
 // GraphDBConfig represents graph database configuration
 type GraphDBConfig struct {
+	// Type selects the graph database: "neo4j" (default) or "embedded", the
+	// in-process store kept in files under Path
 	Type     string `mapstructure:"type"`
 	Host     string `mapstructure:"host"`
 	Port     int    `mapstructure:"port"`
@@ -56,6 +117,50 @@ type GraphDBConfig struct {
 	Password string `mapstructure:"password"`
 	SimilarityAnchors int `mapstructure:"similarity_anchors"`
 	SemanticFrontier int `mapstructure:"semantic_frontier"`
+	Label    string `mapstructure:"label"`
+	// Path is the directory of the embedded store (default data/graph)
+	Path string `mapstructure:"path"`
+	// WriteBatchSize is the number of nodes or edges the passes buffer and
+	// Neo4j writes per UNWIND statement (default 500)
+	WriteBatchSize int `mapstructure:"write_batch_size"`
+	// MaxDegree caps the edges graph_compression keeps per message (0 for
+	// no cap)
+	MaxDegree int `mapstructure:"max_degree"`
+}
+
+// DefaultGraphPath is the directory of the embedded graph store when
+// graphdb.path is unset
+const DefaultGraphPath = "data/graph"
+
+// StoreDir returns the directory of the embedded graph store
+func (c GraphDBConfig) StoreDir() string {
+	if c.Path == "" {
+		return DefaultGraphPath
+	}
+	return c.Path
+}
+
+// DefaultWriteBatchSize is the graph write batch size when
+// graphdb.write_batch_size is unset
+const DefaultWriteBatchSize = 500
+
+// WriteBatch returns the number of nodes or edges written at once
+func (c GraphDBConfig) WriteBatch() int {
+	if c.WriteBatchSize <= 0 {
+		return DefaultWriteBatchSize
+	}
+	return c.WriteBatchSize
+}
+
+// DefaultMessageLabel is the Neo4j label of message nodes outside a persona
+const DefaultMessageLabel = "Message"
+
+// MessageLabel returns the Neo4j label used for message nodes
+func (c GraphDBConfig) MessageLabel() string {
+	if c.Label == "" {
+		return DefaultMessageLabel
+	}
+	return c.Label
 }
 
 // DevModeConfig represents development mode configuration
@@ -69,9 +174,15 @@ type EmbeddingsConfig struct {
 	Model              string  `mapstructure:"model"`
 	Dimension          int     `mapstructure:"dimension"`
 	CacheSize          int     `mapstructure:"cache_size"`
+	CacheDir           string  `mapstructure:"cache_dir"`
 	SimilarityThreshold float64 `mapstructure:"similarity_threshold"`
 	Endpoint           string  `mapstructure:"endpoint"`
+	Provider           string  `mapstructure:"provider"`
 	RequestFormat      string  `mapstructure:"request_format"`
+	BatchSize          int     `mapstructure:"batch_size"`
+	APIKey             string  `mapstructure:"api_key"`
+	MaxRetries         int     `mapstructure:"max_retries"`
+	RetryBackoff       string  `mapstructure:"retry_backoff"`
 }
 
 // LLMConfig represents LLM-related configuration
@@ -97,11 +208,22 @@ type ProviderConfig struct {
 	Endpoint  string `mapstructure:"endpoint"`
 	Model     string `mapstructure:"model"`
 	APIKey    string `mapstructure:"api_key"`
+	// RequestsPerMinute caps the requests sent to the provider across all
+	// workers; 0 sends them as fast as they come
+	RequestsPerMinute int `mapstructure:"requests_per_minute"`
 }
 
 // QdrantConfig represents Qdrant-related configuration
 type QdrantConfig struct {
 	Enabled             bool   `mapstructure:"enabled"`
+	// Host and Port locate the Qdrant gRPC endpoint (default localhost:6334)
+	Host                string `mapstructure:"host"`
+	Port                int    `mapstructure:"port"`
+	// TLS secures the connection, as remote and cloud servers require
+	TLS                 bool   `mapstructure:"tls"`
+	// APIKey is sent with every request when set; ${VAR} reads it from the
+	// environment
+	APIKey              string `mapstructure:"api_key"`
 	Path                string `mapstructure:"path"`
 	CollectionName      string `mapstructure:"collection_name"`
 	VectorSize          int    `mapstructure:"vector_size"`
@@ -110,6 +232,31 @@ type QdrantConfig struct {
 	OptimizeForDiskAccess bool   `mapstructure:"optimize_for_disk_access"`
 }
 
+// VectorConfig represents vector store configuration
+type VectorConfig struct {
+	Provider  string `mapstructure:"provider"`
+	Model     string `mapstructure:"model"`
+	BatchSize int    `mapstructure:"batch_size"`
+	// ChunkSize is the length in characters above which a message is split
+	// into chunks that are embedded separately; 0 embeds messages whole
+	ChunkSize int `mapstructure:"chunk_size"`
+	// ChunkOverlap is the number of characters consecutive chunks share
+	ChunkOverlap int `mapstructure:"chunk_overlap"`
+	// Backend selects the vector database: "qdrant" (default) or "hnsw",
+	// the embedded store kept in a file under qdrant.path
+	Backend            string `mapstructure:"backend"`
+	HNSWM              int    `mapstructure:"hnsw_m"`
+	HNSWEfConstruction int    `mapstructure:"hnsw_ef_construction"`
+	HNSWEfSearch       int    `mapstructure:"hnsw_ef_search"`
+	// Quantization compresses the stored vectors: "none" (default), "int8"
+	// or "binary". Searches rescore the best candidates with the exact
+	// vectors, which the hnsw store keeps on disk and Qdrant keeps as well.
+	Quantization string `mapstructure:"quantization"`
+	// RescoreOversampling is how many candidates per requested result a
+	// quantized search rescores (default 4)
+	RescoreOversampling int `mapstructure:"rescore_oversampling"`
+}
+
 // ThresholdConfig represents threshold configuration
 type ThresholdConfig struct {
 	Min float64 `mapstructure:"min"`
@@ -121,6 +268,8 @@ type DataConfig struct {
 	InputDir  string `mapstructure:"input_dir"`
 	OutputDir string `mapstructure:"output_dir"`
 	TempDir   string `mapstructure:"temp_dir"`
+	// BackupDir holds vector store snapshots (default index_backups)
+	BackupDir string `mapstructure:"backup_dir"`
 }
 
 // LoggingConfig represents logging-related configuration
@@ -130,6 +279,16 @@ type LoggingConfig struct {
 	Output string `mapstructure:"output"`
 }
 
+// DedupConfig configures near-duplicate detection in the dedup stage
+type DedupConfig struct {
+	// Threshold is the embedding cosine similarity from which two messages
+	// are duplicates (default 0.97)
+	Threshold float64 `mapstructure:"threshold"`
+	// Neighbors is how many nearest neighbours of each message are compared
+	// against the threshold (default 10)
+	Neighbors int `mapstructure:"neighbors"`
+}
+
 // IngestionConfig represents ingestion-related configuration
 type IngestionConfig struct {
 	Stages []map[string]interface{} `mapstructure:"stages"`
@@ -242,9 +401,79 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	// Expand ${VAR} in the embeddings API key
+	if apiKey := config.Embeddings.APIKey; strings.HasPrefix(apiKey, "${") && strings.HasSuffix(apiKey, "}") {
+		config.Embeddings.APIKey = os.Getenv(strings.TrimSuffix(strings.TrimPrefix(apiKey, "${"), "}"))
+	}
+
+	// Expand ${VAR} in the Qdrant API key
+	if apiKey := config.Qdrant.APIKey; strings.HasPrefix(apiKey, "${") && strings.HasSuffix(apiKey, "}") {
+		config.Qdrant.APIKey = os.Getenv(strings.TrimSuffix(strings.TrimPrefix(apiKey, "${"), "}"))
+	}
+
+	// Scope the configuration to the persona set in the file, if any
+	if config.Persona != "" {
+		return config.WithPersona(config.Persona)
+	}
+
 	return config, nil
 }
 
+// personaIDPattern restricts persona IDs to characters that are valid in
+// directory names, Qdrant collection names and Neo4j labels alike
+var personaIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// ValidatePersonaID checks that a persona ID can be used to scope storage
+func ValidatePersonaID(id string) error {
+	if !personaIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid persona ID %q: must be 1-64 lowercase letters, digits or underscores", id)
+	}
+	return nil
+}
+
+// WithPersona returns a copy of the configuration scoped to a single persona.
+// Every persona gets its own data directories, Qdrant collection and storage
+// path, and Neo4j message label, so many personas can share one Qdrant and
+// one Neo4j instance without seeing each other's messages. Scoping an
+// already scoped configuration re-scopes it from the original values.
+func (c *Config) WithPersona(id string) (*Config, error) {
+	if err := ValidatePersonaID(id); err != nil {
+		return nil, err
+	}
+	if len(c.Personas) > 0 && !c.HasPersona(id) {
+		return nil, fmt.Errorf("persona %q is not listed in personas", id)
+	}
+
+	base := c
+	if c.unscoped != nil {
+		base = c.unscoped
+	}
+
+	scoped := *base
+	scoped.unscoped = base
+	scoped.Persona = id
+	scoped.Data.InputDir = filepath.Join(base.Data.InputDir, id)
+	scoped.Data.OutputDir = filepath.Join(base.Data.OutputDir, id)
+	scoped.Data.TempDir = filepath.Join(base.Data.TempDir, id)
+	if base.Data.BackupDir != "" {
+		scoped.Data.BackupDir = filepath.Join(base.Data.BackupDir, id)
+	}
+	scoped.Qdrant.Path = filepath.Join(base.Qdrant.Path, id)
+	scoped.Qdrant.CollectionName = fmt.Sprintf("%s_%s", base.Qdrant.CollectionName, id)
+	scoped.GraphDB.Label = fmt.Sprintf("%s_%s", base.GraphDB.MessageLabel(), id)
+	return &scoped, nil
+}
+
+// HasPersona reports whether a persona ID is listed in personas
+func (c *Config) HasPersona(id string) bool {
+	for _, persona := range c.Personas {
+		if persona == id {
+			return true
+		}
+	}
+	return false
+}
+
 // LoadDefaultConfig loads the default configuration from config.example.yaml
 func LoadDefaultConfig() (*Config, error) {
 	// Get the current working directory
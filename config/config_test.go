@@ -129,6 +129,21 @@ func TestLoadDefaultConfig(t *testing.T) {
 		t.Fatalf("Failed to write test config: %v", err)
 	}
 
+	// Copy the prompt files the example config refers to
+	promptsDir := filepath.Join(tmpDir, "data", "prompts")
+	if err := os.MkdirAll(promptsDir, 0755); err != nil {
+		t.Fatalf("Failed to create prompts directory: %v", err)
+	}
+	for _, name := range []string{"system.json", "inference_system.json", "evaluation_system.json"} {
+		prompt, err := os.ReadFile(filepath.Join(originalDir, "..", "data", "prompts", name))
+		if err != nil {
+			t.Fatalf("Failed to read prompt %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(promptsDir, name), prompt, 0644); err != nil {
+			t.Fatalf("Failed to write prompt %s: %v", name, err)
+		}
+	}
+
 	// Try to load the default config
 	cfg, err := LoadDefaultConfig()
 	if err != nil {
@@ -148,4 +163,73 @@ func TestLoadDefaultConfig(t *testing.T) {
 	if cfg.LLM.Provider == "" {
 		t.Error("Expected llm provider to be set")
 	}
-} 
\ No newline at end of file
+} 
+
+func TestServerConfig(t *testing.T) {
+	if addr := (ServerConfig{Port: 8080}).Address(); addr != "127.0.0.1:8080" {
+		t.Errorf("Expected the server to listen on localhost by default, got %s", addr)
+	}
+	if addr := (ServerConfig{Host: "0.0.0.0", Port: 8080}).Address(); addr != "0.0.0.0:8080" {
+		t.Errorf("Expected the configured host, got %s", addr)
+	}
+	if (ServerConfig{}).ForgetEnabled() {
+		t.Error("Expected forget to be disabled by default")
+	}
+	if !(ServerConfig{ForgetToken: "secret"}).ForgetEnabled() || !(ServerConfig{EnableForget: true}).ForgetEnabled() {
+		t.Error("Expected forget to be enabled by a token or enable_forget")
+	}
+}
+
+func TestWithPersona(t *testing.T) {
+	base := &Config{
+		Data: DataConfig{
+			InputDir:  "data/input",
+			OutputDir: "data/output",
+			TempDir:   "data/temp",
+		},
+		Qdrant: QdrantConfig{
+			Path:           "data/qdrant",
+			CollectionName: "embeddings",
+		},
+	}
+
+	cfg, err := base.WithPersona("jane")
+	if err != nil {
+		t.Fatalf("Failed to scope config: %v", err)
+	}
+	if cfg.Persona != "jane" {
+		t.Errorf("Expected persona to be 'jane', got '%s'", cfg.Persona)
+	}
+	if cfg.Data.InputDir != filepath.Join("data", "input", "jane") || cfg.Data.OutputDir != filepath.Join("data", "output", "jane") {
+		t.Errorf("Expected persona data directories, got '%s' and '%s'", cfg.Data.InputDir, cfg.Data.OutputDir)
+	}
+	if cfg.Qdrant.CollectionName != "embeddings_jane" || cfg.Qdrant.Path != filepath.Join("data", "qdrant", "jane") {
+		t.Errorf("Expected persona collection, got '%s' at '%s'", cfg.Qdrant.CollectionName, cfg.Qdrant.Path)
+	}
+	if cfg.GraphDB.MessageLabel() != "Message_jane" {
+		t.Errorf("Expected persona label 'Message_jane', got '%s'", cfg.GraphDB.MessageLabel())
+	}
+	if base.Qdrant.CollectionName != "embeddings" || base.GraphDB.MessageLabel() != DefaultMessageLabel {
+		t.Error("Expected the base config to be left unchanged")
+	}
+
+	// Re-scoping starts from the unscoped values
+	other, err := cfg.WithPersona("bob")
+	if err != nil {
+		t.Fatalf("Failed to re-scope config: %v", err)
+	}
+	if other.Qdrant.CollectionName != "embeddings_bob" || other.GraphDB.MessageLabel() != "Message_bob" {
+		t.Errorf("Expected bob's collection and label, got '%s' and '%s'", other.Qdrant.CollectionName, other.GraphDB.MessageLabel())
+	}
+
+	for _, id := range []string{"", "Jane", "jane doe", "../jane", "jane-doe"} {
+		if _, err := base.WithPersona(id); err == nil {
+			t.Errorf("Expected persona ID %q to be rejected", id)
+		}
+	}
+
+	base.Personas = []string{"jane"}
+	if _, err := base.WithPersona("bob"); err == nil {
+		t.Error("Expected unlisted persona to be rejected")
+	}
+}
@@ -0,0 +1,180 @@
+// Package dedup finds near-duplicate messages: texts that are equal once
+// case, punctuation and whitespace are normalized away, and texts whose
+// embeddings are nearly identical, such as copies with a typo fixed. Each
+// cluster of duplicates gets a canonical message; the others are recorded as
+// its duplicates so the graph can hang them off it instead of linking every
+// copy to every other.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/yourusername/psagents/internal/hnsw"
+)
+
+// Reasons a message is a duplicate of its canonical message
+const (
+	// ReasonText means the normalized texts are equal
+	ReasonText = "normalized_text"
+	// ReasonEmbedding means the embeddings are within the threshold
+	ReasonEmbedding = "embedding"
+)
+
+// Defaults for the config values left unset
+const (
+	DefaultThreshold = 0.97
+	DefaultNeighbors = 10
+)
+
+// Item is a message considered for deduplication
+type Item struct {
+	ID        string
+	Text      string
+	Embedding []float32
+}
+
+// Duplicate records that a message duplicates a canonical one
+type Duplicate struct {
+	ID          string  `json:"id"`
+	CanonicalID string  `json:"canonical_id"`
+	Reason      string  `json:"reason"`
+	Score       float64 `json:"score"`
+}
+
+// NormalizeText lowercases text and reduces every run of characters that
+// are not letters or digits to a single space
+func NormalizeText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// normalizedHash is the SHA-256 of a text's normalized form
+func normalizedHash(text string) string {
+	sum := sha256.Sum256([]byte(NormalizeText(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Find clusters the items into near-duplicates and returns a Duplicate for
+// every item that is not the canonical one of its cluster, in item order.
+// Items with the same normalized text are clustered. A cluster is then
+// folded into an earlier one, found among its closest neighbors, when the
+// embedding of every one of its items has a cosine similarity of at least
+// threshold to the earlier cluster's canonical item. Clusters are only
+// folded into clusters that were not folded themselves, so a chain of
+// messages each close to the next never joins messages that are not close
+// to the canonical one. The canonical item of a cluster is its first one, so
+// it stays the same as messages are added.
+func Find(items []Item, threshold float64, neighbors int) []Duplicate {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	if neighbors <= 0 {
+		neighbors = DefaultNeighbors
+	}
+
+	// Equal normalized texts, in order of their first item
+	var groups [][]int
+	group := make([]int, len(items)) // item -> group
+	byHash := make(map[string]int, len(items))
+	for i, item := range items {
+		hash := normalizedHash(item.Text)
+		g, ok := byHash[hash]
+		if !ok {
+			g = len(groups)
+			byHash[hash] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+		group[i] = g
+	}
+
+	// Close embeddings, found through an approximate nearest neighbour index
+	ix := hnsw.NewIndex(hnsw.Params{})
+	nodes := make([]int, 0, len(items)) // index node -> item
+	dimension := 0
+	for i, item := range items {
+		if len(item.Embedding) == 0 {
+			continue
+		}
+		if dimension == 0 {
+			dimension = len(item.Embedding)
+		}
+		if len(item.Embedding) != dimension {
+			continue
+		}
+		ix.Add(item.Embedding)
+		nodes = append(nodes, i)
+	}
+	into := make([]int, len(groups)) // group -> group it was folded into
+	for g := range groups {
+		into[g] = g
+	}
+	for node, i := range nodes {
+		g := group[i]
+		if groups[g][0] != i {
+			continue // searched from the group's first item
+		}
+		best, bestScore := -1, 0.0
+		for _, r := range ix.Search(items[i].Embedding, neighbors+1) {
+			other := group[nodes[r.Node]]
+			if r.Node == node || other >= g || into[other] != other {
+				continue
+			}
+			if score := cosine(items[groups[other][0]].Embedding, items[i].Embedding); score >= threshold && score > bestScore {
+				best, bestScore = other, score
+			}
+		}
+		if best >= 0 && closeToAll(items, groups[g], items[groups[best][0]].Embedding, threshold) {
+			into[g] = best
+		}
+	}
+
+	var duplicates []Duplicate
+	for i, item := range items {
+		canonical := groups[into[group[i]]][0]
+		if canonical == i {
+			continue
+		}
+		d := Duplicate{ID: item.ID, CanonicalID: items[canonical].ID, Reason: ReasonText, Score: 1}
+		if into[group[i]] != group[i] {
+			d.Reason = ReasonEmbedding
+			d.Score = cosine(item.Embedding, items[canonical].Embedding)
+		}
+		duplicates = append(duplicates, d)
+	}
+	return duplicates
+}
+
+// closeToAll reports whether every item of members has an embedding with a
+// cosine similarity of at least threshold to embedding
+func closeToAll(items []Item, members []int, embedding []float32, threshold float64) bool {
+	for _, i := range members {
+		if cosine(items[i].Embedding, embedding) < threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// cosine is the cosine similarity of two vectors, 0 when they can't be
+// compared
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,110 @@
+package dedup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/message"
+)
+
+func TestNormalizeText(t *testing.T) {
+	if got := NormalizeText("  Hello,\tWORLD!!  how's it going? "); got != "hello world how s it going" {
+		t.Errorf("Unexpected normalized text %q", got)
+	}
+}
+
+func TestFind(t *testing.T) {
+	items := []Item{
+		{ID: "a", Text: "See you at 5pm", Embedding: []float32{1, 0, 0}},
+		{ID: "b", Text: "Something else entirely", Embedding: []float32{0, 1, 0}},
+		{ID: "a-space", Text: "see you  at 5pm!", Embedding: []float32{0.6, 0.8, 0}},
+		{ID: "a-typo", Text: "See yuo at 5pm", Embedding: []float32{0.999, 0.01, 0}},
+		{ID: "c", Text: "Unembedded"},
+	}
+
+	duplicates := Find(items, 0.99, 5)
+	if len(duplicates) != 2 {
+		t.Fatalf("Expected 2 duplicates, got %+v", duplicates)
+	}
+	if d := duplicates[0]; d.ID != "a-space" || d.CanonicalID != "a" || d.Reason != ReasonText || d.Score != 1 {
+		t.Errorf("Expected a-space to duplicate a by text, got %+v", d)
+	}
+	if d := duplicates[1]; d.ID != "a-typo" || d.CanonicalID != "a" || d.Reason != ReasonEmbedding || d.Score < 0.99 {
+		t.Errorf("Expected a-typo to duplicate a by embedding, got %+v", d)
+	}
+
+	if duplicates := Find(items, 0.99999, 5); len(duplicates) != 1 {
+		t.Errorf("Expected only the text duplicate above a strict threshold, got %+v", duplicates)
+	}
+}
+
+func TestFindDoesNotChain(t *testing.T) {
+	// Each message is 0.995 similar to the next but the ends are not close
+	items := []Item{
+		{ID: "a", Text: "one", Embedding: []float32{1, 0}},
+		{ID: "b", Text: "two", Embedding: []float32{0.995, 0.0999}},
+		{ID: "c", Text: "three", Embedding: []float32{0.98, 0.1990}},
+		{ID: "d", Text: "four", Embedding: []float32{0.955, 0.2966}},
+	}
+
+	// c is close to b but not to a, the canonical item of b
+	duplicates := Find(items, 0.99, 5)
+	if len(duplicates) != 2 || duplicates[0].ID != "b" || duplicates[0].CanonicalID != "a" ||
+		duplicates[1].ID != "d" || duplicates[1].CanonicalID != "c" {
+		t.Fatalf("Expected b to duplicate a and d to duplicate c, got %+v", duplicates)
+	}
+	for _, d := range duplicates {
+		if d.Reason != ReasonEmbedding || d.Score < 0.99 {
+			t.Errorf("Expected %s to be within the threshold of %s, got %+v", d.ID, d.CanonicalID, d)
+		}
+	}
+}
+
+func TestRunAndLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Data:  config.DataConfig{OutputDir: tmpDir},
+		Dedup: config.DedupConfig{Threshold: 0.99},
+	}
+
+	loaded, err := Load(cfg)
+	if err != nil || len(loaded) != 0 {
+		t.Fatalf("Expected no duplicates before the stage ran, got %v (%v)", loaded, err)
+	}
+
+	file, err := os.Create(filepath.Join(tmpDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create embeddings file: %v", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, msg := range []embeddings.MessageEmbeddingOut{
+		{ID: "long#0", Text: "hello", Embedding: []float32{1, 0}, Chunk: message.Chunk{ParentID: "long"}},
+		{ID: "long", Text: "hello there", Embedding: []float32{1, 0}, Chunk: message.Chunk{ChunkCount: 1}},
+		{ID: "copy", Text: "Hello there.", Embedding: []float32{0, 1}},
+	} {
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("Failed to write embedding: %v", err)
+		}
+	}
+	file.Close()
+
+	duplicates, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("Failed to run dedup: %v", err)
+	}
+	if len(duplicates) != 1 || duplicates[0].ID != "copy" || duplicates[0].CanonicalID != "long" {
+		t.Errorf("Expected copy to duplicate long, chunks left out, got %+v", duplicates)
+	}
+
+	loaded, err = Load(cfg)
+	if err != nil {
+		t.Fatalf("Failed to load duplicates: %v", err)
+	}
+	if d, ok := loaded["copy"]; !ok || d.CanonicalID != "long" || len(loaded) != 1 {
+		t.Errorf("Expected the recorded duplicate, got %+v", loaded)
+	}
+}
@@ -0,0 +1,116 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// Path returns the file the duplicates of a configuration are recorded in
+func Path(cfg *config.Config) string {
+	return filepath.Join(cfg.Data.OutputDir, "duplicates.jsonl")
+}
+
+// Run finds the near-duplicates among the messages of the embeddings file
+// and records them, replacing the previous record. Chunks are left out; a
+// chunked message is compared by its mean embedding.
+func Run(cfg *config.Config) ([]Duplicate, error) {
+	filePath := filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl")
+	var items []Item
+	seen := make(map[string]bool)
+	err := embeddings.ScanEmbeddings(filePath, func(msg embeddings.MessageEmbeddingOut) error {
+		if msg.IsChunk() || seen[msg.ID] {
+			return nil
+		}
+		seen[msg.ID] = true
+		items = append(items, Item{ID: msg.ID, Text: msg.Text, Embedding: msg.Embedding})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := Find(items, cfg.Dedup.Threshold, cfg.Dedup.Neighbors)
+	if err := save(Path(cfg), duplicates); err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// save writes the duplicates as JSON lines
+func save(path string, duplicates []Duplicate) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create duplicates file: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, d := range duplicates {
+		if err := encoder.Encode(d); err != nil {
+			return fmt.Errorf("failed to write duplicate: %w", err)
+		}
+	}
+	return nil
+}
+
+// Load reads the recorded duplicates keyed by the duplicate's ID. Without a
+// record, as when the dedup stage has not run, nothing is a duplicate.
+func Load(cfg *config.Config) (map[string]Duplicate, error) {
+	duplicates := make(map[string]Duplicate)
+	file, err := os.Open(Path(cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return duplicates, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open duplicates file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var d Duplicate
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, fmt.Errorf("failed to parse duplicate: %w", err)
+		}
+		duplicates[d.ID] = d
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading duplicates file: %w", err)
+	}
+	return duplicates, nil
+}
+
+// Forget drops the recorded duplicates that involve any of the given
+// message IDs, either as the duplicate or as its canonical message, and
+// returns how many were dropped. The dedup stage recomputes the rest on its
+// next run.
+func Forget(cfg *config.Config, ids map[string]bool) (int, error) {
+	recorded, err := Load(cfg)
+	if err != nil || len(recorded) == 0 {
+		return 0, err
+	}
+	kept := make([]Duplicate, 0, len(recorded))
+	for _, d := range recorded {
+		if ids[d.ID] || ids[d.CanonicalID] {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	dropped := len(recorded) - len(kept)
+	if dropped == 0 {
+		return 0, nil
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ID < kept[j].ID })
+	return dropped, save(Path(cfg), kept)
+}
@@ -0,0 +1,295 @@
+package embeddings
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a content-addressed embedding cache keyed by model name and the
+// SHA-256 of the text. Vectors are stored on disk under
+// <dir>/<escaped model>/<hash[:2]>/<hash>.vec as little-endian float32s,
+// fronted by an in-memory LRU. Either layer can be disabled: an empty dir
+// keeps the cache in memory only and a size of 0 disables the LRU.
+type Cache struct {
+	dir      string
+	capacity int
+
+	mu     sync.Mutex
+	items  map[string]*list.Element
+	order  *list.List
+	hits   int64
+	misses int64
+}
+
+// cacheEntry is an element of the LRU list
+type cacheEntry struct {
+	key       string
+	embedding []float32
+}
+
+// CacheStats describes the on-disk cache entries of one model
+type CacheStats struct {
+	Model   string
+	Entries int
+	Bytes   int64
+}
+
+// PruneOptions selects the on-disk entries PruneCache removes. Entries match
+// when they belong to Model (any model if empty), were last used before
+// OlderThan ago (any time if zero) and embed one of Texts (any text if nil).
+type PruneOptions struct {
+	Model     string
+	OlderThan time.Duration
+	Texts     []string
+}
+
+// NewCache creates a cache in dir with an in-memory LRU of size entries
+func NewCache(dir string, size int) (*Cache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return &Cache{
+		dir:      dir,
+		capacity: size,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}, nil
+}
+
+// Get returns the cached embedding of text for model. The slice is the
+// caller's own, so changing it, as normalizing does, leaves the cache intact.
+func (c *Cache) Get(model, text string) ([]float32, bool) {
+	key := cacheKey(model, text)
+
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		c.hits++
+		embedding := append([]float32(nil), elem.Value.(*cacheEntry).embedding...)
+		c.mu.Unlock()
+		return embedding, true
+	}
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		path := c.path(model, text)
+		if embedding, err := readVector(path); err == nil {
+			// Record the use so pruning by age keeps hot entries
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			c.mu.Lock()
+			c.hits++
+			c.remember(key, embedding)
+			c.mu.Unlock()
+			return embedding, true
+		}
+	}
+
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+// Put stores the embedding of text for model
+func (c *Cache) Put(model, text string, embedding []float32) error {
+	c.mu.Lock()
+	c.remember(cacheKey(model, text), embedding)
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil
+	}
+	return writeVector(c.path(model, text), embedding)
+}
+
+// Counts returns the number of cache hits and misses so far
+func (c *Cache) Counts() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// remember adds a copy of an entry to the LRU, evicting the least recently
+// used one when full. The caller must hold c.mu.
+func (c *Cache) remember(key string, embedding []float32) {
+	if c.capacity <= 0 {
+		return
+	}
+	embedding = append([]float32(nil), embedding...)
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).embedding = embedding
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, embedding: embedding})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// path returns the file of an entry
+func (c *Cache) path(model, text string) string {
+	hash := TextHash(text)
+	return filepath.Join(c.dir, url.PathEscape(model), hash[:2], hash+".vec")
+}
+
+// cacheKey is the in-memory key of an entry
+func cacheKey(model, text string) string {
+	return model + "\x00" + TextHash(text)
+}
+
+// readVector reads a vector file
+func readVector(path string) ([]float32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, fmt.Errorf("corrupt cache entry %s", path)
+	}
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return embedding, nil
+}
+
+// writeVector writes a vector file atomically so concurrent readers never
+// see a partial entry
+func writeVector(path string, embedding []float32) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	data := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// InspectCache returns the number of entries and bytes per model in a cache directory
+func InspectCache(dir string) ([]CacheStats, error) {
+	var stats []CacheStats
+	err := walkCache(dir, "", func(model, path string, info fs.FileInfo) error {
+		if len(stats) == 0 || stats[len(stats)-1].Model != model {
+			stats = append(stats, CacheStats{Model: model})
+		}
+		stats[len(stats)-1].Entries++
+		stats[len(stats)-1].Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Model < stats[j].Model })
+	return stats, nil
+}
+
+// PruneCache removes the on-disk entries selected by opts and returns how
+// many entries and bytes were removed
+func PruneCache(dir string, opts PruneOptions) (int, int64, error) {
+	cutoff := time.Time{}
+	if opts.OlderThan > 0 {
+		cutoff = time.Now().Add(-opts.OlderThan)
+	}
+
+	var hashes map[string]bool
+	if opts.Texts != nil {
+		hashes = make(map[string]bool, len(opts.Texts))
+		for _, text := range opts.Texts {
+			hashes[TextHash(text)+".vec"] = true
+		}
+	}
+
+	removed := 0
+	var freed int64
+	err := walkCache(dir, opts.Model, func(model, path string, info fs.FileInfo) error {
+		if !cutoff.IsZero() && info.ModTime().After(cutoff) {
+			return nil
+		}
+		if hashes != nil && !hashes[filepath.Base(path)] {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove cache entry: %w", err)
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// walkCache calls fn for every entry in a cache directory, optionally limited
+// to one model
+func walkCache(dir, onlyModel string, fn func(model, path string, info fs.FileInfo) error) error {
+	models, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	for _, entry := range models {
+		if !entry.IsDir() {
+			continue
+		}
+		model, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			model = entry.Name()
+		}
+		if onlyModel != "" && model != onlyModel {
+			continue
+		}
+		err = filepath.WalkDir(filepath.Join(dir, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(d.Name(), ".vec") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return fn(model, path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
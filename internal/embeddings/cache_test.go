@@ -0,0 +1,136 @@
+package embeddings
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourusername/psagents/config"
+)
+
+func TestCacheMemoryLRU(t *testing.T) {
+	cache, err := NewCache("", 2)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	cache.Put("m", "one", []float32{1})
+	cache.Put("m", "two", []float32{2})
+	cache.Get("m", "one") // one is now the most recently used
+	cache.Put("m", "three", []float32{3})
+
+	if _, ok := cache.Get("m", "two"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if v, ok := cache.Get("m", "one"); !ok || v[0] != 1 {
+		t.Errorf("Expected entry one to be cached, got %v", v)
+	}
+	if _, ok := cache.Get("other-model", "one"); ok {
+		t.Error("Expected entries to be keyed by model")
+	}
+
+	// Changing a vector after Put or Get leaves the cached one intact
+	put := []float32{4}
+	cache.Put("m", "four", put)
+	put[0] = 0
+	got, _ := cache.Get("m", "four")
+	got[0] = 0
+	if v, ok := cache.Get("m", "four"); !ok || v[0] != 4 {
+		t.Errorf("Expected the cached vector to be unchanged, got %v", v)
+	}
+}
+
+func TestCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewCache(dir, 0)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := cache.Put("nomic/embed:latest", "hello", []float32{0.5, -1.25}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := cache.Put("mxbai", "hello", []float32{3}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// A new cache over the same directory sees the entries
+	reopened, err := NewCache(dir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	v, ok := reopened.Get("nomic/embed:latest", "hello")
+	if !ok || len(v) != 2 || v[0] != 0.5 || v[1] != -1.25 {
+		t.Errorf("Expected the stored vector, got %v", v)
+	}
+
+	stats, err := InspectCache(dir)
+	if err != nil {
+		t.Fatalf("Failed to inspect cache: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Model != "mxbai" || stats[1].Model != "nomic/embed:latest" || stats[1].Entries != 1 || stats[1].Bytes != 8 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Entries used recently survive pruning by age
+	removed, _, err := PruneCache(dir, PruneOptions{OlderThan: time.Hour})
+	if err != nil || removed != 0 {
+		t.Errorf("Expected no entries older than an hour, removed %d (%v)", removed, err)
+	}
+	removed, freed, err := PruneCache(dir, PruneOptions{Model: "mxbai"})
+	if err != nil || removed != 1 || freed != 4 {
+		t.Errorf("Expected to prune the mxbai entry, removed %d (%d bytes, %v)", removed, freed, err)
+	}
+	if _, ok := reopened.Get("nomic/embed:latest", "hello"); !ok {
+		t.Error("Expected other models to be kept")
+	}
+
+	// Pruning by text only removes the entries of those texts
+	if err := cache.Put("nomic/embed:latest", "bye", []float32{1}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	removed, _, err = PruneCache(dir, PruneOptions{Texts: []string{"hello"}})
+	if err != nil || removed != 1 {
+		t.Errorf("Expected to prune the hello entry, removed %d (%v)", removed, err)
+	}
+	if stats, _ := InspectCache(dir); len(stats) != 1 || stats[0].Entries != 1 {
+		t.Errorf("Expected the bye entry to be kept, got %+v", stats)
+	}
+}
+
+func TestGeneratorUsesCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	var requests int64
+	server := newOllamaStub(t, 4, &requests)
+
+	cfg := &config.Config{
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{
+			Model:     "test-model",
+			Endpoint:  server.URL,
+			CacheDir:  filepath.Join(tmpDir, "cache"),
+			CacheSize: 10,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		// A fresh generator only has the disk cache to go by
+		gen, err := NewGenerator(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create generator: %v", err)
+		}
+		for j := 0; j < 2; j++ {
+			if _, err := gen.GenerateEmbedding("who am i?"); err != nil {
+				t.Fatalf("Failed to generate embedding: %v", err)
+			}
+		}
+	}
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("Expected 1 embedding request, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Embeddings.CacheDir, "test-model")); err != nil {
+		t.Errorf("Expected a cache directory for the model: %v", err)
+	}
+}
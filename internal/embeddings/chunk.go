@@ -0,0 +1,100 @@
+package embeddings
+
+import (
+	"fmt"
+	"math"
+	"unicode"
+)
+
+// ChunkText splits text into chunks of at most size characters, consecutive
+// chunks sharing about overlap characters. Chunks end at whitespace where
+// there is some in their second half. A text of at most size characters, or
+// any text when size is 0, is returned whole.
+func ChunkText(text string, size, overlap int) []string {
+	runes := []rune(text)
+	if size <= 0 || len(runes) <= size {
+		return []string{text}
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			chunks = append(chunks, string(runes[start:]))
+			break
+		}
+		// Prefer to cut after whitespace
+		for cut := end; cut > start+size/2; cut-- {
+			if unicode.IsSpace(runes[cut-1]) {
+				end = cut
+				break
+			}
+		}
+		chunks = append(chunks, string(runes[start:end]))
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		// Start the next chunk at a word boundary
+		for next < end && next > start && !unicode.IsSpace(runes[next-1]) {
+			next++
+		}
+		start = next
+	}
+	return chunks
+}
+
+// chunkMessage cuts a message into chunk messages if it is longer than
+// vector.chunk_size, returning nil otherwise
+func (g *Generator) chunkMessage(msg MessageEmbeddingOut) []MessageEmbeddingOut {
+	texts := ChunkText(msg.Text, g.cfg.Vector.ChunkSize, g.cfg.Vector.ChunkOverlap)
+	if len(texts) < 2 {
+		return nil
+	}
+	chunks := make([]MessageEmbeddingOut, len(texts))
+	for i, text := range texts {
+		chunks[i] = MessageEmbeddingOut{
+			ID:       ChunkID(msg.ID, i),
+			Text:     text,
+			Metadata: msg.Metadata,
+		}
+		chunks[i].ParentID = msg.ID
+		chunks[i].ChunkIndex = i
+	}
+	return chunks
+}
+
+// ChunkID returns the ID of a chunk of a message
+func ChunkID(parentID string, index int) string {
+	return TextHash(fmt.Sprintf("%s#%d", parentID, index))
+}
+
+// meanEmbedding averages the chunk embeddings into a unit vector that stands
+// for the whole message
+func meanEmbedding(chunks []MessageEmbeddingOut) []float32 {
+	sum := make([]float64, len(chunks[0].Embedding))
+	for _, chunk := range chunks {
+		for i, v := range chunk.Embedding {
+			if i < len(sum) {
+				sum[i] += float64(v)
+			}
+		}
+	}
+	var norm float64
+	for _, v := range sum {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	mean := make([]float32, len(sum))
+	for i, v := range sum {
+		if norm > 0 {
+			mean[i] = float32(v / norm)
+		}
+	}
+	return mean
+}
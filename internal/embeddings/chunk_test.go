@@ -0,0 +1,120 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/message"
+)
+
+func TestChunkText(t *testing.T) {
+	if chunks := ChunkText("short message", 100, 20); len(chunks) != 1 || chunks[0] != "short message" {
+		t.Errorf("Expected a short text to be kept whole, got %q", chunks)
+	}
+	if chunks := ChunkText(strings.Repeat("a", 500), 0, 0); len(chunks) != 1 {
+		t.Errorf("Expected chunking to be disabled with size 0, got %d chunks", len(chunks))
+	}
+
+	words := make([]string, 200)
+	for i := range words {
+		words[i] = "word"
+	}
+	text := strings.Join(words, " ")
+	chunks := ChunkText(text, 100, 20)
+	if len(chunks) < 10 {
+		t.Fatalf("Expected about 12 chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := len([]rune(chunk)); n > 100 {
+			t.Errorf("Chunk %d: expected at most 100 characters, got %d", i, n)
+		}
+		if strings.HasPrefix(chunk, "ord") || strings.HasSuffix(chunk, "wor") {
+			t.Errorf("Chunk %d: expected to start and end at word boundaries, got %q", i, chunk)
+		}
+	}
+	// Consecutive chunks overlap
+	for i := 1; i < len(chunks); i++ {
+		tail := strings.TrimSpace(chunks[i-1][len(chunks[i-1])-15:])
+		if !strings.Contains(chunks[i], tail) {
+			t.Errorf("Chunk %d: expected to overlap with the previous chunk", i)
+		}
+	}
+	if !strings.HasSuffix(chunks[len(chunks)-1], "word") {
+		t.Error("Expected the last chunk to reach the end of the text")
+	}
+}
+
+func TestGenerateEmbeddingsChunksLongMessages(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Provider: ProviderLocal, Dimension: 16},
+		Vector:     config.VectorConfig{ChunkSize: 40, ChunkOverlap: 10},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	long := strings.Repeat("a long pasted paragraph ", 6)
+	input, _ := json.Marshal(map[string]string{"text": long, "sender": "me"})
+	input = append(input, []byte("\n"+`{"text":"short"}`+"\n")...)
+	if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), input, 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+
+	out, err := ReadEmbeddings(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read embeddings: %v", err)
+	}
+
+	parentID := MessageID(long, message.Metadata{Sender: "me"})
+	var chunks []MessageEmbeddingOut
+	var parent *MessageEmbeddingOut
+	for i, emb := range out {
+		switch {
+		case emb.ParentID == parentID:
+			if emb.ChunkIndex != len(chunks) || emb.Sender != "me" {
+				t.Errorf("Unexpected chunk %+v", emb)
+			}
+			chunks = append(chunks, emb)
+		case emb.ID == parentID:
+			parent = &out[i]
+		}
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("Expected the long message to be chunked, got %d chunks", len(chunks))
+	}
+	if parent == nil || parent.Text != long || parent.ChunkCount != len(chunks) || len(parent.Embedding) != 16 {
+		t.Fatalf("Expected the whole message with its chunk count, got %+v", parent)
+	}
+	if len(out) != len(chunks)+2 {
+		t.Errorf("Expected the chunks, the long and the short message, got %d records", len(out))
+	}
+}
+
+func TestChunkOverlapMustBeSmallerThanSize(t *testing.T) {
+	cfg := &config.Config{
+		Data:    config.DataConfig{OutputDir: t.TempDir()},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:  config.VectorConfig{ChunkSize: 100, ChunkOverlap: 100},
+	}
+	if _, err := NewGenerator(cfg); err == nil {
+		t.Error("Expected an error for chunk_overlap >= chunk_size")
+	}
+}
@@ -0,0 +1,52 @@
+package embeddings
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+)
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	// Embed returns one embedding per text, in the order of texts
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model names the embedding model; embeddings are cached per model
+	Model() string
+	// BatchSize is the largest number of texts to pass to Embed at once
+	BatchSize() int
+}
+
+// Embedding providers selected by embeddings.provider
+const (
+	// ProviderHTTP requests embeddings from embeddings.endpoint
+	ProviderHTTP = "http"
+	// ProviderLocal embeds offline with the deterministic LocalEmbedder
+	ProviderLocal = "local"
+)
+
+// NewEmbedder creates the embedder selected by embeddings.provider
+func NewEmbedder(cfg *config.Config, logger *logrus.Logger) (Embedder, error) {
+	switch cfg.Embeddings.Provider {
+	case "", ProviderHTTP:
+		return NewHTTPEmbedder(cfg, logger)
+	case ProviderLocal:
+		return NewLocalEmbedder(cfg.Embeddings.Dimension), nil
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q (expected %s or %s)",
+			cfg.Embeddings.Provider, ProviderHTTP, ProviderLocal)
+	}
+}
+
+// EmbedText embeds a single text
+func EmbedText(ctx context.Context, embedder Embedder, text string) ([]float32, error) {
+	embeddings, err := embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, fmt.Errorf("embedder returned %d embeddings for 1 text", len(embeddings))
+	}
+	return embeddings[0], nil
+}
@@ -2,106 +2,439 @@ package embeddings
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/message"
 )
 
 // MessageEmbeddingIn represents an input text message without embedding
 type MessageEmbeddingIn struct {
 	Text string `json:"text"`
+	message.Metadata
 }
 
 // MessageEmbeddingOut represents a text message with its embedding and ID
 type MessageEmbeddingOut struct {
 	ID        string    `json:"id"`   // SHA hash of text
 	Text      string    `json:"text"`
-	Embedding []float32 `json:"embedding"`
+	Embedding []float32 `json:"embedding,omitempty"`
+	// Vector is the index of the embedding in the vectors sidecar of an
+	// embeddings file; ScanEmbeddings resolves it into Embedding
+	Vector *int `json:"vector,omitempty"`
+	message.Metadata
+	message.Chunk
 }
 
 
-// Generator handles embedding generation
+// Generator handles embedding generation. It embeds through an Embedder,
+// caching the vectors if configured, and is itself an Embedder.
 type Generator struct {
-	cfg    *config.Config
-	logger *logrus.Logger
+	cfg      *config.Config
+	logger   *logrus.Logger
+	embedder Embedder
+	cache    *Cache // nil when caching is disabled
 }
 
-// NewGenerator creates a new embedding generator
+// NewGenerator creates a new embedding generator using the embedder selected
+// by embeddings.provider
 func NewGenerator(cfg *config.Config) (*Generator, error) {
-	// Setup logging
-	logger := logrus.New()
-	if cfg.Logging.Format == "json" {
-		logger.SetFormatter(&logrus.JSONFormatter{})
+	logger, err := newLogger(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	embedder, err := NewEmbedder(cfg, logger)
 	if err != nil {
-		return nil, fmt.Errorf("invalid log level: %w", err)
+		return nil, err
+	}
+	return newGenerator(cfg, logger, embedder)
+}
+
+// NewGeneratorWithEmbedder creates an embedding generator using embedder
+func NewGeneratorWithEmbedder(cfg *config.Config, embedder Embedder) (*Generator, error) {
+	logger, err := newLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newGenerator(cfg, logger, embedder)
+}
+
+func newGenerator(cfg *config.Config, logger *logrus.Logger, embedder Embedder) (*Generator, error) {
+	if cfg.Vector.ChunkSize > 0 && (cfg.Vector.ChunkOverlap < 0 || cfg.Vector.ChunkOverlap >= cfg.Vector.ChunkSize) {
+		return nil, fmt.Errorf("vector.chunk_overlap must be between 0 and vector.chunk_size (%d), got %d",
+			cfg.Vector.ChunkSize, cfg.Vector.ChunkOverlap)
 	}
-	logger.SetLevel(level)
 
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	// Cache embeddings on disk and/or in memory if configured
+	var cache *Cache
+	if cfg.Embeddings.CacheDir != "" || cfg.Embeddings.CacheSize > 0 {
+		var err error
+		cache, err = NewCache(cfg.Embeddings.CacheDir, cfg.Embeddings.CacheSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Generator{
-		cfg:    cfg,
-		logger: logger,
+		cfg:      cfg,
+		logger:   logger,
+		embedder: embedder,
+		cache:    cache,
 	}, nil
 }
 
+// newLogger sets up logging as configured
+func newLogger(cfg *config.Config) (*logrus.Logger, error) {
+	logger := logrus.New()
+	if cfg.Logging.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	logger.SetLevel(level)
+	return logger, nil
+}
+
 // GenerateEmbeddings reads messages from the input directory and generates embeddings
-func (g *Generator) GenerateEmbeddings() error {
+// using pipeline.max_workers concurrent requests. The output keeps the input order.
+// In incremental mode messages whose ID already has an embedding in the output
+// file are skipped and the new embeddings are appended to it; if ctx is cancelled
+// the embeddings completed so far are still appended so a rerun picks up the rest.
+func (g *Generator) GenerateEmbeddings(ctx context.Context) error {
 	// Read messages from input directory
 	messages, err := g.readMessages()
 	if err != nil {
 		return fmt.Errorf("failed to read messages: %w", err)
 	}
 
-	// Generate embeddings
-	embeddings := make([]MessageEmbeddingOut, 0, len(messages))
-	for _, msg := range messages {
-		embedding, err := g.GenerateEmbedding(msg)
+	outputPath := filepath.Join(g.cfg.Data.OutputDir, "messages_embeddings.jsonl")
+
+	// Collect the IDs that already have an embedding
+	existing := make(map[string]bool)
+	if g.cfg.Pipeline.Incremental {
+		existing, err = readEmbeddingIDs(outputPath)
 		if err != nil {
-			g.logger.WithError(err).WithField("message", msg).Error("Failed to generate embedding")
+			return fmt.Errorf("failed to read existing embeddings: %w", err)
+		}
+	}
+
+	// Select the messages to embed
+	pending := make([]MessageEmbeddingOut, 0, len(messages))
+	skipped := 0
+	for _, msg := range messages {
+		id := MessageID(msg.Text, msg.Metadata)
+		if existing[id] {
+			skipped++
 			continue
 		}
-		id := sha256.Sum256([]byte(msg))
-		embeddings = append(embeddings, MessageEmbeddingOut{
-			ID:        hex.EncodeToString(id[:]),
-			Text:      msg,
-			Embedding: embedding,
+		if g.cfg.Pipeline.Incremental {
+			// Also skip repeats of the same text within this run
+			existing[id] = true
+		}
+		pending = append(pending, MessageEmbeddingOut{
+			ID:       id,
+			Text:     msg.Text,
+			Metadata: msg.Metadata,
 		})
 	}
 
+	// Split long messages into chunks; those are embedded instead of the
+	// whole text, which gets the mean of its chunk embeddings
+	items := make([]MessageEmbeddingOut, 0, len(pending))
+	spans := make([][2]int, len(pending))
+	chunked := 0
+	for i, msg := range pending {
+		start := len(items)
+		if chunks := g.chunkMessage(msg); chunks != nil {
+			items = append(items, chunks...)
+			chunked++
+		} else {
+			items = append(items, msg)
+		}
+		spans[i] = [2]int{start, len(items)}
+	}
+
+	// Generate embeddings
+	embedded := g.embedAll(ctx, items)
+	embeddings := make([]MessageEmbeddingOut, 0, len(items))
+	done := 0
+	for i, msg := range pending {
+		span := items[spans[i][0]:spans[i][1]]
+		ok := true
+		for j := spans[i][0]; j < spans[i][1]; j++ {
+			ok = ok && embedded[j]
+		}
+		if !ok {
+			continue
+		}
+		if span[0].IsChunk() {
+			// Chunks first so the message follows its chunks in the output
+			embeddings = append(embeddings, span...)
+			msg.Embedding = meanEmbedding(span)
+			msg.ChunkCount = len(span)
+			embeddings = append(embeddings, msg)
+		} else {
+			embeddings = append(embeddings, span[0])
+		}
+		done++
+	}
+
+	if ctx.Err() != nil && !g.cfg.Pipeline.Incremental {
+		return fmt.Errorf("embedding generation cancelled: %w", ctx.Err())
+	}
+
 	// Save embeddings to output file
-	outputPath := filepath.Join(g.cfg.Data.OutputDir, "messages_embeddings.jsonl")
-	if err := g.saveEmbeddings(outputPath, embeddings); err != nil {
+	if err := g.saveEmbeddings(outputPath, embeddings, g.cfg.Pipeline.Incremental); err != nil {
 		return fmt.Errorf("failed to save embeddings: %w", err)
 	}
 
-	g.logger.WithFields(logrus.Fields{
-		"input_count":  len(messages),
-		"output_count": len(embeddings),
-		"output_file":  outputPath,
-	}).Info("Successfully generated embeddings")
+	if ctx.Err() != nil {
+		return fmt.Errorf("embedding generation cancelled after %d of %d messages: %w", done, len(pending), ctx.Err())
+	}
+
+	fields := logrus.Fields{
+		"input_count":   len(messages),
+		"output_count":  done,
+		"chunked_count": chunked,
+		"chunk_count":   len(items) - len(pending) + chunked,
+		"skipped_count": skipped,
+		"incremental":   g.cfg.Pipeline.Incremental,
+		"output_file":   outputPath,
+	}
+	if g.cache != nil {
+		fields["cache_hits"], fields["cache_misses"] = g.cache.Counts()
+	}
+	g.logger.WithFields(fields).Info("Successfully generated embeddings")
 
 	return nil
 }
 
-// readMessages reads messages from the input directory
-func (g *Generator) readMessages() ([]string, error) {
+// embedAll fills in the embeddings of msgs with a bounded pool of workers, each
+// sending embeddings.batch_size texts per request, and reports which messages
+// were embedded. Messages that fail after all retries are logged and left out;
+// cancelling ctx stops dispatching new batches.
+func (g *Generator) embedAll(ctx context.Context, msgs []MessageEmbeddingOut) []bool {
+	workers := g.cfg.Pipeline.MaxWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	embedded := make([]bool, len(msgs))
+	progress := newProgress(g.logger, len(msgs), g.cfg.Pipeline.BatchSize)
+	jobs := make(chan []int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				for _, i := range g.embedBatch(ctx, msgs, batch) {
+					embedded[i] = true
+				}
+				progress.add(len(batch), len(batch)-countTrue(embedded, batch))
+			}
+		}()
+	}
+
+	size := g.BatchSize()
+dispatch:
+	for start := 0; start < len(msgs); start += size {
+		end := start + size
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		batch := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, i)
+		}
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	progress.finish()
+
+	return embedded
+}
+
+// embedBatch embeds the messages at the given indices and returns the indices
+// that succeeded. When a batch request fails the messages are retried one by
+// one so a single bad text does not drop the whole batch.
+func (g *Generator) embedBatch(ctx context.Context, msgs []MessageEmbeddingOut, batch []int) []int {
+	texts := make([]string, len(batch))
+	for j, i := range batch {
+		texts[j] = msgs[i].Text
+	}
+
+	embeddings, err := g.Embed(ctx, texts)
+	if err == nil {
+		for j, i := range batch {
+			msgs[i].Embedding = embeddings[j]
+		}
+		return batch
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if len(batch) == 1 {
+		g.logger.WithError(err).WithField("message", texts[0]).Error("Failed to generate embedding")
+		return nil
+	}
+
+	g.logger.WithError(err).WithField("batch_size", len(batch)).Warn("Batch embedding failed, embedding messages one by one")
+	var done []int
+	for _, i := range batch {
+		done = append(done, g.embedBatch(ctx, msgs, []int{i})...)
+	}
+	return done
+}
+
+// countTrue counts the indices set in flags
+func countTrue(flags []bool, indices []int) int {
+	n := 0
+	for _, i := range indices {
+		if flags[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// progress periodically logs how far embedding generation got
+type progress struct {
+	mu       sync.Mutex
+	logger   *logrus.Logger
+	total    int
+	done     int
+	failed   int
+	interval int
+	start    time.Time
+}
+
+// newProgress creates a progress indicator that logs every interval messages
+func newProgress(logger *logrus.Logger, total, interval int) *progress {
+	if interval < 1 {
+		interval = 100
+	}
+	return &progress{
+		logger:   logger,
+		total:    total,
+		interval: interval,
+		start:    time.Now(),
+	}
+}
+
+// add records n finished messages of which failed failed
+func (p *progress) add(n, failed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := p.done / p.interval
+	p.done += n
+	p.failed += failed
+	if p.done/p.interval > before && p.done < p.total {
+		p.log("Embedding messages")
+	}
+}
+
+// finish logs the final counts
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.log("Finished embedding messages")
+}
+
+func (p *progress) log(msg string) {
+	elapsed := time.Since(p.start)
+	rate := float64(p.done) / elapsed.Seconds()
+	fields := logrus.Fields{
+		"done":    p.done,
+		"total":   p.total,
+		"failed":  p.failed,
+		"per_sec": fmt.Sprintf("%.1f", rate),
+	}
+	if rate > 0 && p.done < p.total {
+		fields["eta"] = (time.Duration(float64(p.total-p.done)/rate) * time.Second).Round(time.Second).String()
+	}
+	p.logger.WithFields(fields).Info(msg)
+}
+
+// MessageID returns the ID of a message: the hex SHA-256 of its text and of
+// its sender, recipient, timestamp, channel and thread. The same text sent by
+// different people, in different threads or at different times is a
+// separate message, so that filters and forget requests by sender or time
+// act on the right one. A message without such metadata is identified by
+// the hash of its text alone (see TextHash), as before metadata existed.
+func MessageID(text string, meta message.Metadata) string {
+	identity := []string{meta.Sender, meta.Recipient, meta.Timestamp, meta.Channel, meta.ThreadID}
+	if strings.Join(identity, "") == "" {
+		return TextHash(text)
+	}
+	return TextHash(text + "\x00" + strings.Join(identity, "\x00"))
+}
+
+// TextHash returns the hex SHA-256 of a text
+func TextHash(text string) string {
+	id := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(id[:])
+}
+
+// readEmbeddingIDs returns the IDs in an embeddings file. A missing file has
+// no IDs.
+func readEmbeddingIDs(path string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		var emb struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &emb); err != nil {
+			return nil, fmt.Errorf("failed to parse embedding JSON at line %d: %w", lineNum, err)
+		}
+		ids[emb.ID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// readMessages reads messages and their metadata from the input directory
+func (g *Generator) readMessages() ([]MessageEmbeddingIn, error) {
 	inputFile := "messages.jsonl"
 	if g.cfg.DevMode.Enabled {
 		inputFile = "messages_dev.jsonl"
@@ -116,18 +449,21 @@ func (g *Generator) readMessages() ([]string, error) {
 	}
 	defer file.Close()
 
-	var messages []string
+	var messages []MessageEmbeddingIn
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		// Parse the JSON line to extract just the text
-		var msg struct {
-			Text string `json:"text"`
+		lineNum++
+		// Parse the JSON line into text plus optional metadata
+		var msg MessageEmbeddingIn
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse message JSON at line %d: %w", lineNum, err)
 		}
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
-			return nil, fmt.Errorf("failed to parse message JSON: %w", err)
+		if err := msg.Metadata.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid metadata at line %d: %w", lineNum, err)
 		}
-		messages = append(messages, msg.Text)
+		messages = append(messages, msg)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -140,85 +476,74 @@ func (g *Generator) readMessages() ([]string, error) {
 
 // GenerateEmbedding generates an embedding for a single message
 func (g *Generator) GenerateEmbedding(text string) ([]float32, error) {
-	// Create HTTP client
-	client := &http.Client{
-		Timeout: time.Duration(g.cfg.LLM.Timeout) * time.Second,
-	}
-
-	// Prepare request body
-	reqBody := map[string]interface{}{
-		"model":  g.cfg.Embeddings.Model,
-		"prompt": text,
-	}
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
+	return EmbedText(context.Background(), g, text)
+}
 
-	// Use the embeddings-specific endpoint
-	req, err := http.NewRequest("POST", g.cfg.Embeddings.Endpoint, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+// Model returns the model name of the underlying embedder
+func (g *Generator) Model() string {
+	return g.embedder.Model()
+}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
+// BatchSize returns the batch size of the underlying embedder
+func (g *Generator) BatchSize() int {
+	return g.embedder.BatchSize()
+}
 
-	// Make request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+// Embed returns the embeddings of texts, taking the cached ones from the
+// cache and embedding the rest in a single call
+func (g *Generator) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	var missing []int
+	for i, text := range texts {
+		if g.cache != nil {
+			if embedding, ok := g.cache.Get(g.embedder.Model(), text); ok {
+				embeddings[i] = embedding
+				continue
+			}
+		}
+		missing = append(missing, i)
 	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if len(missing) == 0 {
+		return embeddings, nil
 	}
 
-	// Parse Ollama response format
-	var result struct {
-		Embedding []float32 `json:"embedding"`
-		Error    string    `json:"error"`
+	request := make([]string, len(missing))
+	for j, i := range missing {
+		request[j] = texts[i]
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	requested, err := g.embedder.Embed(ctx, request)
+	if err != nil {
+		return nil, err
 	}
-
-	if result.Error != "" {
-		return nil, fmt.Errorf("API error: %s", result.Error)
+	if len(requested) != len(request) {
+		return nil, fmt.Errorf("embedder returned %d embeddings for %d texts", len(requested), len(request))
 	}
 
-	if result.Embedding == nil || len(result.Embedding) == 0 {
-		return nil, fmt.Errorf("no embedding in response")
+	for j, i := range missing {
+		embeddings[i] = requested[j]
+		if g.cache != nil {
+			if err := g.cache.Put(g.embedder.Model(), texts[i], requested[j]); err != nil {
+				g.logger.WithError(err).Warn("Failed to cache embedding")
+			}
+		}
 	}
-
-	g.logger.WithFields(logrus.Fields{
-		"text_length":         len(text),
-		"embedding_dimension": len(result.Embedding),
-		"model":              g.cfg.Embeddings.Model,
-	}).Debug("Generated embedding")
-
-	return result.Embedding, nil
+	return embeddings, nil
 }
 
-// saveEmbeddings saves embeddings to a JSONL file
-func (g *Generator) saveEmbeddings(path string, embeddings []MessageEmbeddingOut) error {
-	file, err := os.Create(path)
+// saveEmbeddings saves embeddings to an embeddings file and its vectors
+// sidecar, appending to them if requested
+func (g *Generator) saveEmbeddings(path string, embeddings []MessageEmbeddingOut, appendToFile bool) error {
+	writer, err := CreateEmbeddings(path, appendToFile)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
 	for _, emb := range embeddings {
-		if err := encoder.Encode(emb); err != nil {
-			return fmt.Errorf("failed to encode embedding: %w", err)
+		if err := writer.Write(emb); err != nil {
+			writer.Close()
+			return err
 		}
 	}
-
-	return nil
+	return writer.Close()
 }
 
 // CreateDevFile creates a development file with the first N messages from messages.jsonl
@@ -1,17 +1,54 @@
 package embeddings
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/message"
 )
 
+// newOllamaStub starts a stand-in for Ollama's /api/embeddings endpoint that
+// returns a constant vector of the given dimension
+func newOllamaStub(t *testing.T, dimension int, requests *int64) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			atomic.AddInt64(requests, 1)
+		}
+		var req struct {
+			Model  string `json:"model"`
+			Prompt string `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		embedding := make([]float32, dimension)
+		for i := range embedding {
+			embedding[i] = float32(len(req.Prompt)+i) / float32(dimension)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": embedding})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
 func TestGenerateEmbeddings(t *testing.T) {
 	// Create a temporary directory for testing
 	tmpDir := t.TempDir()
+	server := newOllamaStub(t, 1536, nil)
 
 	// Create test configuration
 	testCfg := &config.Config{
@@ -30,6 +67,7 @@ func TestGenerateEmbeddings(t *testing.T) {
 			Dimension:         1536,
 			CacheSize:         1000,
 			SimilarityThreshold: 0.8,
+			Endpoint:          server.URL + "/api/embeddings",
 		},
 	}
 
@@ -37,6 +75,10 @@ func TestGenerateEmbeddings(t *testing.T) {
 	if err := os.MkdirAll(testCfg.Data.InputDir, 0755); err != nil {
 		t.Fatalf("Failed to create input directory: %v", err)
 	}
+	input := `{"text":"Lunch at noon?","sender":"me","recipient":"alice","timestamp":"2023-04-01T12:00:00Z","channel":"whatsapp","thread_id":"chat-1"}` + "\n"
+	if err := os.WriteFile(filepath.Join(testCfg.Data.InputDir, "messages.jsonl"), []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
 
 	// Create generator
 	gen, err := NewGenerator(testCfg)
@@ -45,7 +87,7 @@ func TestGenerateEmbeddings(t *testing.T) {
 	}
 
 	// Generate embeddings
-	if err := gen.GenerateEmbeddings(); err != nil {
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
 		t.Fatalf("Failed to generate embeddings: %v", err)
 	}
 
@@ -55,18 +97,12 @@ func TestGenerateEmbeddings(t *testing.T) {
 		t.Fatal("Output file was not created")
 	}
 
-	// Read and verify the output file
-	file, err := os.Open(outputPath)
-	if err != nil {
-		t.Fatalf("Failed to open output file: %v", err)
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	var embedding MessageEmbedding
-	if err := decoder.Decode(&embedding); err != nil {
-		t.Fatalf("Failed to decode embedding: %v", err)
+	// Read and verify the output file and its vectors
+	out, err := ReadEmbeddings(outputPath)
+	if err != nil || len(out) == 0 {
+		t.Fatalf("Failed to read embeddings: %v", err)
 	}
+	embedding := out[0]
 
 	// Verify embedding structure
 	if embedding.Text == "" {
@@ -76,4 +112,246 @@ func TestGenerateEmbeddings(t *testing.T) {
 		t.Errorf("Expected embedding dimension %d, got %d",
 			testCfg.Embeddings.Dimension, len(embedding.Embedding))
 	}
-}
\ No newline at end of file
+
+	// Verify metadata is carried through to the output
+	if embedding.Sender != "me" || embedding.Recipient != "alice" {
+		t.Errorf("Expected sender/recipient me/alice, got %s/%s", embedding.Sender, embedding.Recipient)
+	}
+	if embedding.Timestamp != "2023-04-01T12:00:00Z" {
+		t.Errorf("Expected timestamp to be preserved, got %q", embedding.Timestamp)
+	}
+	if embedding.Channel != "whatsapp" || embedding.ThreadID != "chat-1" {
+		t.Errorf("Expected channel/thread whatsapp/chat-1, got %s/%s", embedding.Channel, embedding.ThreadID)
+	}
+}
+
+func TestReadMessagesRejectsBadTimestamp(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Data:    config.DataConfig{InputDir: tmpDir, OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+	}
+	input := `{"text":"hello","timestamp":"yesterday"}` + "\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "messages.jsonl"), []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if _, err := gen.readMessages(); err == nil {
+		t.Error("Expected an error for a non-RFC3339 timestamp")
+	}
+}
+
+func TestGenerateEmbeddingsIncremental(t *testing.T) {
+	tmpDir := t.TempDir()
+	var requests int64
+	server := newOllamaStub(t, 8, &requests)
+
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "info", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Model: "test-model", Endpoint: server.URL},
+		Pipeline:   config.PipelineConfig{Incremental: true},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	inputPath := filepath.Join(cfg.Data.InputDir, "messages.jsonl")
+	outputPath := filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl")
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	// First run embeds everything, skipping the repeated text
+	if err := os.WriteFile(inputPath, []byte(`{"text":"one"}`+"\n"+`{"text":"two"}`+"\n"+`{"text":"one"}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+	if atomic.LoadInt64(&requests) != 2 {
+		t.Errorf("Expected 2 embedding requests, got %d", atomic.LoadInt64(&requests))
+	}
+	first, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	// Second run only embeds the new message and appends it
+	if err := os.WriteFile(inputPath, []byte(`{"text":"one"}`+"\n"+`{"text":"two"}`+"\n"+`{"text":"three"}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+	if atomic.LoadInt64(&requests) != 3 {
+		t.Errorf("Expected 1 more embedding request, got %d in total", atomic.LoadInt64(&requests))
+	}
+	second, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.HasPrefix(string(second), string(first)) {
+		t.Error("Expected existing embeddings to be kept as is")
+	}
+	lines := strings.Split(strings.TrimSpace(string(second)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 embeddings, got %d", len(lines))
+	}
+	var last MessageEmbeddingOut
+	if err := json.Unmarshal([]byte(lines[2]), &last); err != nil {
+		t.Fatalf("Failed to decode embedding: %v", err)
+	}
+	if last.Text != "three" || last.ID != MessageID("three", message.Metadata{}) {
+		t.Errorf("Expected the new message to be appended, got %+v", last)
+	}
+}
+
+func TestGenerateEmbeddingsConcurrentWithRetry(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Fail the first request for every text and answer out of order
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		attempts[req.Prompt]++
+		first := attempts[req.Prompt] == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(time.Duration(len(req.Prompt)%3) * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": []float32{float32(len(req.Prompt)), 1}})
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Model: "test-model", Endpoint: server.URL, MaxRetries: 2, RetryBackoff: "1ms"},
+		Pipeline:   config.PipelineConfig{MaxWorkers: 4, BatchSize: 5},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	var input strings.Builder
+	var texts []string
+	for i := 0; i < 20; i++ {
+		text := fmt.Sprintf("message %s", strings.Repeat("x", i))
+		texts = append(texts, text)
+		fmt.Fprintf(&input, "{\"text\":%q}\n", text)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), []byte(input.String()), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+
+	out, err := ReadEmbeddings(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read embeddings: %v", err)
+	}
+	if len(out) != len(texts) {
+		t.Fatalf("Expected %d embeddings, got %d", len(texts), len(out))
+	}
+	for i, emb := range out {
+		if emb.Text != texts[i] {
+			t.Errorf("Embedding %d: expected input order text %q, got %q", i, texts[i], emb.Text)
+		}
+		if len(emb.Embedding) != 2 || emb.Embedding[0] != float32(len(texts[i])) {
+			t.Errorf("Embedding %d: unexpected vector %v", i, emb.Embedding)
+		}
+	}
+}
+
+func TestGenerateEmbeddingDoesNotRetryClientErrors(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Data:       config.DataConfig{OutputDir: t.TempDir()},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Endpoint: server.URL, MaxRetries: 3, RetryBackoff: "1ms"},
+	}
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if _, err := gen.GenerateEmbedding("hello"); err == nil {
+		t.Fatal("Expected an error for a 404 response")
+	}
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+}
+
+func TestGenerateEmbeddingsCancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	server := newOllamaStub(t, 4, nil)
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Endpoint: server.URL},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), []byte(`{"text":"one"}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gen.GenerateEmbeddings(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	if MessageID("ok", message.Metadata{}) != TextHash("ok") {
+		t.Error("Expected a message without metadata to be identified by its text")
+	}
+	jane := MessageID("ok", message.Metadata{Sender: "jane", ThreadID: "t1"})
+	bob := MessageID("ok", message.Metadata{Sender: "bob", ThreadID: "t1"})
+	later := MessageID("ok", message.Metadata{Sender: "jane", ThreadID: "t1", Timestamp: "2024-06-01T10:00:00Z"})
+	if jane == bob || jane == later || jane == TextHash("ok") {
+		t.Error("Expected the same text from different senders or times to be separate messages")
+	}
+	if MessageID("ok", message.Metadata{Sender: "jane", ThreadID: "t1", Context: "reply"}) != jane {
+		t.Error("Expected the context not to change the ID")
+	}
+}
@@ -0,0 +1,279 @@
+package embeddings
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// An embeddings file keeps one JSON line per message with everything but
+// its vector, which is stored in a binary sidecar next to it: the path with
+// ".jsonl" replaced by ".vec". The "vector" field of a line is the index of
+// its vector in the sidecar, which is little-endian binary:
+//
+//	magic "PSEV" | version u32 | dimension u32 | vectors × dimension × f32
+//
+// Lines that carry an inline "embedding" array, as the files written before
+// the sidecar did, are still read.
+var vectorsMagic = [4]byte{'P', 'S', 'E', 'V'}
+
+const (
+	vectorsVersion    = 1
+	vectorsHeaderSize = 12
+)
+
+// VectorsPath returns the sidecar holding the vectors of an embeddings file
+func VectorsPath(path string) string {
+	return strings.TrimSuffix(path, ".jsonl") + ".vec"
+}
+
+// EmbeddingWriter writes messages to an embeddings file and their vectors
+// to its sidecar
+type EmbeddingWriter struct {
+	path      string
+	lines     *os.File
+	vectors   *os.File
+	lineBuf   *bufio.Writer
+	vectorBuf *bufio.Writer
+	encoder   *json.Encoder
+	dimension int
+	next      int
+}
+
+// CreateEmbeddings opens an embeddings file for writing, truncating it and
+// its sidecar or appending to them
+func CreateEmbeddings(path string, appendToFile bool) (*EmbeddingWriter, error) {
+	flags := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	if appendToFile {
+		flags = os.O_CREATE | os.O_RDWR | os.O_APPEND
+	}
+	lines, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	vectors, err := os.OpenFile(VectorsPath(path), flags, 0644)
+	if err != nil {
+		lines.Close()
+		return nil, fmt.Errorf("failed to create vectors file: %w", err)
+	}
+
+	w := &EmbeddingWriter{path: path, lines: lines, vectors: vectors}
+	if appendToFile {
+		dimension, count, err := vectorsHeader(vectors)
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		w.dimension, w.next = dimension, count
+	}
+	w.lineBuf = bufio.NewWriter(lines)
+	w.vectorBuf = bufio.NewWriter(vectors)
+	w.encoder = json.NewEncoder(w.lineBuf)
+	return w, nil
+}
+
+// Write appends a message. Every vector of a file has the same dimension.
+func (w *EmbeddingWriter) Write(msg MessageEmbeddingOut) error {
+	if len(msg.Embedding) == 0 {
+		return fmt.Errorf("message %s has no embedding", msg.ID)
+	}
+	if w.dimension == 0 {
+		w.dimension = len(msg.Embedding)
+		var header [vectorsHeaderSize]byte
+		copy(header[:4], vectorsMagic[:])
+		binary.LittleEndian.PutUint32(header[4:], vectorsVersion)
+		binary.LittleEndian.PutUint32(header[8:], uint32(w.dimension))
+		if _, err := w.vectorBuf.Write(header[:]); err != nil {
+			return fmt.Errorf("failed to write vectors file: %w", err)
+		}
+	}
+	if len(msg.Embedding) != w.dimension {
+		return fmt.Errorf("message %s has dimension %d, %s has %d", msg.ID, len(msg.Embedding), w.path, w.dimension)
+	}
+
+	var buf [4]byte
+	for _, v := range msg.Embedding {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+		if _, err := w.vectorBuf.Write(buf[:]); err != nil {
+			return fmt.Errorf("failed to write vectors file: %w", err)
+		}
+	}
+	index := w.next
+	w.next++
+	msg.Embedding, msg.Vector = nil, &index
+	if err := w.encoder.Encode(msg); err != nil {
+		return fmt.Errorf("failed to encode embedding: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the files. Vectors are flushed before the lines
+// referring to them, so an interrupted write never leaves a line without
+// its vector.
+func (w *EmbeddingWriter) Close() error {
+	err := w.vectorBuf.Flush()
+	if err == nil {
+		err = w.lineBuf.Flush()
+	}
+	if closeErr := w.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", w.path, err)
+	}
+	return nil
+}
+
+func (w *EmbeddingWriter) close() error {
+	err := w.vectors.Close()
+	if lineErr := w.lines.Close(); err == nil {
+		err = lineErr
+	}
+	return err
+}
+
+// vectorsHeader reads the dimension of a sidecar and the number of vectors
+// it holds; an empty sidecar has neither
+func vectorsHeader(file *os.File) (int, int, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat vectors file: %w", err)
+	}
+	if info.Size() == 0 {
+		return 0, 0, nil
+	}
+	var header [vectorsHeaderSize]byte
+	if _, err := file.ReadAt(header[:], 0); err != nil {
+		return 0, 0, fmt.Errorf("failed to read vectors file header: %w", err)
+	}
+	if [4]byte(header[:4]) != vectorsMagic {
+		return 0, 0, fmt.Errorf("%s is not a vectors file", file.Name())
+	}
+	if version := binary.LittleEndian.Uint32(header[4:]); version != vectorsVersion {
+		return 0, 0, fmt.Errorf("unsupported vectors file version %d", version)
+	}
+	dimension := int(binary.LittleEndian.Uint32(header[8:]))
+	if dimension == 0 {
+		return 0, 0, fmt.Errorf("%s has dimension 0", file.Name())
+	}
+	return dimension, int((info.Size() - vectorsHeaderSize) / int64(4*dimension)), nil
+}
+
+// vectorReader reads vectors from a sidecar by index
+type vectorReader struct {
+	file      *os.File
+	dimension int
+	count     int
+	buf       []byte
+}
+
+// openVectors opens the sidecar of an embeddings file; a missing one yields
+// a nil reader
+func openVectors(path string) (*vectorReader, error) {
+	file, err := os.Open(VectorsPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vectors file: %w", err)
+	}
+	dimension, count, err := vectorsHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &vectorReader{file: file, dimension: dimension, count: count, buf: make([]byte, 4*dimension)}, nil
+}
+
+// read returns the vector at an index
+func (r *vectorReader) read(index int) ([]float32, error) {
+	if r == nil || index < 0 || index >= r.count {
+		return nil, fmt.Errorf("vector %d is missing from the vectors file", index)
+	}
+	if _, err := r.file.ReadAt(r.buf, vectorsHeaderSize+int64(index)*int64(len(r.buf))); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read vector %d: %w", index, err)
+	}
+	vector := make([]float32, r.dimension)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(r.buf[4*i:]))
+	}
+	return vector, nil
+}
+
+// zero overwrites the vectors at the given indexes with zeros
+func (r *vectorReader) zero(path string, indexes []int) error {
+	file, err := os.OpenFile(VectorsPath(path), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open vectors file: %w", err)
+	}
+	zeros := make([]byte, 4*r.dimension)
+	for _, index := range indexes {
+		if _, err := file.WriteAt(zeros, vectorsHeaderSize+int64(index)*int64(len(zeros))); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to clear vector %d: %w", index, err)
+		}
+	}
+	return file.Close()
+}
+
+func (r *vectorReader) close() {
+	if r != nil {
+		r.file.Close()
+	}
+}
+
+// ScanEmbeddings calls fn with every message of an embeddings file, in
+// order and with its vector, stopping at the first error fn returns. An
+// error opening the file wraps the one from os.Open.
+func ScanEmbeddings(path string, fn func(MessageEmbeddingOut) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open embeddings file: %w", err)
+	}
+	defer file.Close()
+	vectors, err := openVectors(path)
+	if err != nil {
+		return err
+	}
+	defer vectors.close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		var msg MessageEmbeddingOut
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("failed to parse message JSON at line %d of %s: %w", lineNum, path, err)
+		}
+		if msg.Vector != nil {
+			if msg.Embedding, err = vectors.read(*msg.Vector); err != nil {
+				return fmt.Errorf("line %d of %s: %w", lineNum, path, err)
+			}
+			msg.Vector = nil
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading embeddings file: %w", err)
+	}
+	return nil
+}
+
+// ReadEmbeddings returns every message of an embeddings file with its vector
+func ReadEmbeddings(path string) ([]MessageEmbeddingOut, error) {
+	var messages []MessageEmbeddingOut
+	err := ScanEmbeddings(path, func(msg MessageEmbeddingOut) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
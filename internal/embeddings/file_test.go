@@ -0,0 +1,88 @@
+package embeddings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddingsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages_embeddings.jsonl")
+
+	write := func(appendToFile bool, messages ...MessageEmbeddingOut) {
+		t.Helper()
+		writer, err := CreateEmbeddings(path, appendToFile)
+		if err != nil {
+			t.Fatalf("Failed to create embeddings file: %v", err)
+		}
+		for _, msg := range messages {
+			if err := writer.Write(msg); err != nil {
+				t.Fatalf("Failed to write embedding: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close embeddings file: %v", err)
+		}
+	}
+	write(false,
+		MessageEmbeddingOut{ID: "a", Text: "first", Embedding: []float32{1, 2, 3}},
+		MessageEmbeddingOut{ID: "b", Text: "second", Embedding: []float32{4, 5, 6}},
+	)
+	write(true, MessageEmbeddingOut{ID: "c", Text: "third", Embedding: []float32{7, 8, 9}})
+
+	// Vectors go to the sidecar, not into the JSON lines
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read embeddings file: %v", err)
+	}
+	if strings.Contains(string(data), "embedding") || !strings.Contains(string(data), `"vector":2`) {
+		t.Errorf("Expected vector indexes instead of inline embeddings, got %s", data)
+	}
+	if info, err := os.Stat(VectorsPath(path)); err != nil || info.Size() != vectorsHeaderSize+3*3*4 {
+		t.Errorf("Expected a sidecar with 3 vectors, got %v (%v)", info, err)
+	}
+
+	// Lines with inline embeddings, as written before the sidecar, still read
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open embeddings file: %v", err)
+	}
+	file.WriteString(`{"id":"d","text":"legacy","embedding":[0,1,0]}` + "\n")
+	file.Close()
+
+	messages, err := ReadEmbeddings(path)
+	if err != nil {
+		t.Fatalf("Failed to read embeddings: %v", err)
+	}
+	if len(messages) != 4 || messages[1].Text != "second" || messages[1].Embedding[2] != 6 ||
+		messages[2].Embedding[0] != 7 || messages[3].Embedding[1] != 1 || messages[0].Vector != nil {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+
+	writer, err := CreateEmbeddings(path, true)
+	if err != nil {
+		t.Fatalf("Failed to reopen embeddings file: %v", err)
+	}
+	if err := writer.Write(MessageEmbeddingOut{ID: "e", Embedding: []float32{1, 2}}); err == nil {
+		t.Error("Expected an error for a vector of another dimension")
+	}
+	writer.Close()
+
+	// Removed lines take their vectors with them
+	removed, err := RemoveMessages(path, func(msg MessageEmbeddingOut) bool { return msg.ID == "b" })
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected one removed message, got %d (%v)", removed, err)
+	}
+	vectors, err := openVectors(path)
+	if err != nil {
+		t.Fatalf("Failed to open vectors: %v", err)
+	}
+	defer vectors.close()
+	if v, _ := vectors.read(1); v[0] != 0 || v[1] != 0 || v[2] != 0 {
+		t.Errorf("Expected the removed vector to be zeroed, got %v", v)
+	}
+	if messages, _ := ReadEmbeddings(path); len(messages) != 3 || messages[1].ID != "c" || messages[1].Embedding[0] != 7 {
+		t.Errorf("Expected the other vectors to be kept, got %+v", messages)
+	}
+}
@@ -0,0 +1,149 @@
+package embeddings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Request formats selected by embeddings.request_format
+const (
+	// FormatOllama is Ollama's legacy /api/embeddings endpoint:
+	// {model, prompt} -> {embedding}. It embeds one text per call.
+	FormatOllama = "ollama"
+	// FormatOllamaEmbed is Ollama's /api/embed endpoint:
+	// {model, input: [...]} -> {embeddings: [[...]]}
+	FormatOllamaEmbed = "ollama-embed"
+	// FormatOpenAI is the OpenAI /v1/embeddings schema, also served by
+	// vLLM, LiteLLM, LM Studio and others:
+	// {model, input: [...]} -> {data: [{index, embedding}]}
+	FormatOpenAI = "openai"
+	// FormatTEI is the text-embeddings-inference /embed endpoint:
+	// {inputs: [...]} -> [[...]]
+	FormatTEI = "tei"
+)
+
+// defaultBatchSize is the number of texts per call for the batching formats
+// when embeddings.batch_size is not set
+const defaultBatchSize = 32
+
+// requestFormat returns the configured request format, defaulting to Ollama
+func requestFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatOllama, nil
+	case FormatOllama, FormatOllamaEmbed, FormatOpenAI, FormatTEI:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown embeddings request_format %q (expected %s, %s, %s or %s)",
+			format, FormatOllama, FormatOllamaEmbed, FormatOpenAI, FormatTEI)
+	}
+}
+
+// batchSize returns how many texts a format embeds per call
+func batchSize(format string, configured int) int {
+	if format == FormatOllama {
+		return 1
+	}
+	if configured < 1 {
+		return defaultBatchSize
+	}
+	return configured
+}
+
+// encodeEmbeddingRequest builds the request body for texts
+func encodeEmbeddingRequest(format, model string, texts []string) ([]byte, error) {
+	var body interface{}
+	switch format {
+	case FormatOllama:
+		if len(texts) != 1 {
+			return nil, fmt.Errorf("%s format embeds one text per request, got %d", format, len(texts))
+		}
+		body = map[string]interface{}{"model": model, "prompt": texts[0]}
+	case FormatOllamaEmbed, FormatOpenAI:
+		body = map[string]interface{}{"model": model, "input": texts}
+	case FormatTEI:
+		body = map[string]interface{}{"inputs": texts}
+	default:
+		return nil, fmt.Errorf("unknown request format %q", format)
+	}
+	return json.Marshal(body)
+}
+
+// decodeEmbeddingResponse extracts n embeddings, in request order, from a
+// response body. Embeddings that are not dimension long are rejected, so that
+// a model that doesn't match embeddings.dimension is caught before its
+// vectors are cached or stored; 0 skips the check.
+func decodeEmbeddingResponse(format string, body []byte, n, dimension int) ([][]float32, error) {
+	var embeddings [][]float32
+	switch format {
+	case FormatOllama:
+		var result struct {
+			Embedding []float32 `json:"embedding"`
+			Error     string    `json:"error"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if result.Error != "" {
+			return nil, fmt.Errorf("API error: %s", result.Error)
+		}
+		embeddings = [][]float32{result.Embedding}
+
+	case FormatOllamaEmbed:
+		var result struct {
+			Embeddings [][]float32 `json:"embeddings"`
+			Error      string      `json:"error"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if result.Error != "" {
+			return nil, fmt.Errorf("API error: %s", result.Error)
+		}
+		embeddings = result.Embeddings
+
+	case FormatOpenAI:
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if result.Error != nil {
+			return nil, fmt.Errorf("API error: %s", result.Error.Message)
+		}
+		// The data is not guaranteed to be in input order
+		sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+		for _, d := range result.Data {
+			embeddings = append(embeddings, d.Embedding)
+		}
+
+	case FormatTEI:
+		if err := json.Unmarshal(body, &embeddings); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown request format %q", format)
+	}
+
+	if len(embeddings) != n {
+		return nil, fmt.Errorf("expected %d embeddings in response, got %d", n, len(embeddings))
+	}
+	for i, embedding := range embeddings {
+		if len(embedding) == 0 {
+			return nil, fmt.Errorf("no embedding in response for input %d", i)
+		}
+		if dimension > 0 && len(embedding) != dimension {
+			return nil, fmt.Errorf("embedding for input %d has dimension %d, expected %d (embeddings.dimension)", i, len(embedding), dimension)
+		}
+	}
+	return embeddings, nil
+}
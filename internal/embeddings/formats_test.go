@@ -0,0 +1,239 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+)
+
+// fakeEmbedding is the vector the batch stubs return for a text
+func fakeEmbedding(text string) []float32 {
+	return []float32{float32(len(text)), 1}
+}
+
+// newBatchStub starts a stand-in for a batching embedding endpoint in the
+// given request format. Every request's batch size is recorded in sizes.
+func newBatchStub(t *testing.T, format string, sizes chan<- int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model  string   `json:"model"`
+			Input  []string `json:"input"`
+			Inputs []string `json:"inputs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		texts := req.Input
+		if format == FormatTEI {
+			texts = req.Inputs
+		}
+		if sizes != nil {
+			sizes <- len(texts)
+		}
+
+		switch format {
+		case FormatOllamaEmbed:
+			var embeddings [][]float32
+			for _, text := range texts {
+				embeddings = append(embeddings, fakeEmbedding(text))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"model": req.Model, "embeddings": embeddings})
+		case FormatOpenAI:
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+				return
+			}
+			// Answer in reverse to check the index is honoured
+			var data []map[string]interface{}
+			for i := len(texts) - 1; i >= 0; i-- {
+				data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": fakeEmbedding(texts[i])})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+		case FormatTEI:
+			var embeddings [][]float32
+			for _, text := range texts {
+				embeddings = append(embeddings, fakeEmbedding(text))
+			}
+			json.NewEncoder(w).Encode(embeddings)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRequestFormats(t *testing.T) {
+	for _, format := range []string{FormatOllamaEmbed, FormatOpenAI, FormatTEI} {
+		t.Run(format, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			sizes := make(chan int, 100)
+			server := newBatchStub(t, format, sizes)
+
+			cfg := &config.Config{
+				Data: config.DataConfig{
+					InputDir:  filepath.Join(tmpDir, "input"),
+					OutputDir: filepath.Join(tmpDir, "output"),
+				},
+				Logging: config.LoggingConfig{Level: "error", Format: "text"},
+				Embeddings: config.EmbeddingsConfig{
+					Model:         "test-model",
+					Endpoint:      server.URL,
+					RequestFormat: format,
+					BatchSize:     4,
+					APIKey:        "secret",
+				},
+				Pipeline: config.PipelineConfig{MaxWorkers: 2},
+			}
+			if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+				t.Fatalf("Failed to create input directory: %v", err)
+			}
+			var input strings.Builder
+			var texts []string
+			for i := 0; i < 10; i++ {
+				text := fmt.Sprintf("message %s", strings.Repeat("x", i))
+				texts = append(texts, text)
+				fmt.Fprintf(&input, "{\"text\":%q}\n", text)
+			}
+			if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), []byte(input.String()), 0644); err != nil {
+				t.Fatalf("Failed to write input file: %v", err)
+			}
+
+			gen, err := NewGenerator(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create generator: %v", err)
+			}
+			if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+				t.Fatalf("Failed to generate embeddings: %v", err)
+			}
+
+			// 10 texts in batches of 4 take 3 requests
+			close(sizes)
+			requests, total := 0, 0
+			for size := range sizes {
+				requests++
+				total += size
+				if size > 4 {
+					t.Errorf("Expected at most 4 texts per request, got %d", size)
+				}
+			}
+			if requests != 3 || total != len(texts) {
+				t.Errorf("Expected 3 requests for %d texts, got %d for %d", len(texts), requests, total)
+			}
+
+			out, err := ReadEmbeddings(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+			if err != nil {
+				t.Fatalf("Failed to read embeddings: %v", err)
+			}
+			if len(out) != len(texts) {
+				t.Fatalf("Expected %d embeddings, got %d", len(texts), len(out))
+			}
+			for i, emb := range out {
+				if emb.Text != texts[i] || len(emb.Embedding) != 2 || emb.Embedding[0] != float32(len(texts[i])) {
+					t.Errorf("Embedding %d: unexpected %q -> %v", i, emb.Text, emb.Embedding)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchFailureFallsBackToSingleTexts(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Reject any batch containing the bad text
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var embeddings [][]float32
+		for _, text := range req.Input {
+			if text == "bad" {
+				http.Error(w, `{"error":"input too long"}`, http.StatusBadRequest)
+				return
+			}
+			embeddings = append(embeddings, fakeEmbedding(text))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": embeddings})
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "fatal", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Endpoint: server.URL, RequestFormat: FormatOllamaEmbed, BatchSize: 3},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	input := `{"text":"one"}` + "\n" + `{"text":"bad"}` + "\n" + `{"text":"three"}` + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+
+	// One failed batch, then one request per text
+	if n := atomic.LoadInt64(&requests); n != 4 {
+		t.Errorf("Expected 4 requests, got %d", n)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the 2 good messages to be embedded, got %d", len(lines))
+	}
+}
+
+func TestDimensionMismatch(t *testing.T) {
+	server := newBatchStub(t, FormatTEI, nil)
+	cfg := &config.Config{
+		Embeddings: config.EmbeddingsConfig{Endpoint: server.URL, RequestFormat: FormatTEI, Dimension: 2},
+	}
+	embedder, err := NewHTTPEmbedder(cfg, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create embedder: %v", err)
+	}
+	if _, err := embedder.Embed(context.Background(), []string{"hello"}); err != nil {
+		t.Fatalf("Expected vectors of the configured dimension to be accepted: %v", err)
+	}
+
+	cfg.Embeddings.Dimension = 1024
+	if _, err := embedder.Embed(context.Background(), []string{"hello"}); err == nil {
+		t.Error("Expected an error for vectors that don't match embeddings.dimension")
+	}
+}
+
+func TestUnknownRequestFormat(t *testing.T) {
+	cfg := &config.Config{
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{RequestFormat: "grpc"},
+	}
+	if _, err := NewGenerator(cfg); err == nil {
+		t.Error("Expected an error for an unknown request format")
+	}
+}
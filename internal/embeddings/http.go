@@ -0,0 +1,171 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+)
+
+// HTTPEmbedder requests embeddings from a model server in one of the
+// request formats in formats.go
+type HTTPEmbedder struct {
+	cfg       *config.Config
+	logger    *logrus.Logger
+	client    *http.Client
+	format    string
+	batchSize int
+}
+
+// defaultRetryBackoff is the initial retry backoff when none is configured
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// retryableError marks a failed request that may succeed when retried
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// NewHTTPEmbedder creates an embedder for embeddings.endpoint
+func NewHTTPEmbedder(cfg *config.Config, logger *logrus.Logger) (*HTTPEmbedder, error) {
+	format, err := requestFormat(cfg.Embeddings.RequestFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share one client, keeping a connection per worker alive
+	workers := cfg.Pipeline.MaxWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	client := &http.Client{
+		Timeout: time.Duration(cfg.LLM.Timeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: workers,
+		},
+	}
+
+	return &HTTPEmbedder{
+		cfg:       cfg,
+		logger:    logger,
+		client:    client,
+		format:    format,
+		batchSize: batchSize(format, cfg.Embeddings.BatchSize),
+	}, nil
+}
+
+// Model returns the configured model name
+func (e *HTTPEmbedder) Model() string {
+	return e.cfg.Embeddings.Model
+}
+
+// BatchSize returns the number of texts sent per request
+func (e *HTTPEmbedder) BatchSize() int {
+	return e.batchSize
+}
+
+// Embed requests the embeddings of texts, retrying transient failures with
+// exponential backoff up to embeddings.max_retries times
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	backoff := defaultRetryBackoff
+	if e.cfg.Embeddings.RetryBackoff != "" {
+		parsed, err := time.ParseDuration(e.cfg.Embeddings.RetryBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_backoff: %w", err)
+		}
+		backoff = parsed
+	}
+
+	for attempt := 0; ; attempt++ {
+		embeddings, err := e.requestEmbeddings(ctx, texts)
+		if err == nil {
+			return embeddings, nil
+		}
+
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt >= e.cfg.Embeddings.MaxRetries {
+			return nil, err
+		}
+
+		e.logger.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"backoff": backoff.String(),
+		}).Warn("Embedding request failed, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// requestEmbeddings makes a single embedding request for texts in the
+// configured request format
+func (e *HTTPEmbedder) requestEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	// Prepare request body
+	jsonBody, err := encodeEmbeddingRequest(e.format, e.cfg.Embeddings.Model, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Use the embeddings-specific endpoint
+	req, err := http.NewRequestWithContext(ctx, "POST", e.cfg.Embeddings.Endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	if e.cfg.Embeddings.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Embeddings.APIKey)
+	}
+
+	// Make request
+	resp, err := e.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &retryableError{fmt.Errorf("failed to make request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
+
+	embeddings, err := decodeEmbeddingResponse(e.format, body, len(texts), e.cfg.Embeddings.Dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"texts":               len(texts),
+		"embedding_dimension": len(embeddings[0]),
+		"model":               e.cfg.Embeddings.Model,
+		"format":              e.format,
+	}).Debug("Generated embeddings")
+
+	return embeddings, nil
+}
@@ -0,0 +1,103 @@
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// defaultLocalDimension is the LocalEmbedder dimension when none is configured
+const defaultLocalDimension = 256
+
+// localBatchSize is the number of texts a LocalEmbedder worker embeds at once
+const localBatchSize = 64
+
+// LocalEmbedder is a deterministic, offline embedder. It hashes the word
+// unigrams and character trigrams of a text into a fixed number of signed
+// buckets (the "hashing trick"), weights the counts sublinearly and
+// L2-normalises the result. Texts sharing words and spellings end up close in
+// cosine similarity, which is enough to run the whole pipeline in tests and
+// on machines without a model server. It does not capture meaning.
+type LocalEmbedder struct {
+	dimension int
+}
+
+// NewLocalEmbedder creates a local embedder producing vectors of the given
+// dimension
+func NewLocalEmbedder(dimension int) *LocalEmbedder {
+	if dimension <= 0 {
+		dimension = defaultLocalDimension
+	}
+	return &LocalEmbedder{dimension: dimension}
+}
+
+// Model names the embedder and its dimension, so its vectors are never mixed
+// up with a real model's in the cache
+func (e *LocalEmbedder) Model() string {
+	return fmt.Sprintf("local-ngram-%d", e.dimension)
+}
+
+// BatchSize returns the number of texts embedded per call
+func (e *LocalEmbedder) BatchSize() int {
+	return localBatchSize
+}
+
+// Embed returns the embeddings of texts
+func (e *LocalEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		embeddings[i] = e.embed(text)
+	}
+	return embeddings, nil
+}
+
+func (e *LocalEmbedder) embed(text string) []float32 {
+	counts := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		counts["w:"+word]++
+		// Pad so prefixes and suffixes get their own trigrams
+		runes := []rune(" " + word + " ")
+		for j := 0; j+3 <= len(runes); j++ {
+			counts["c:"+string(runes[j:j+3])]++
+		}
+	}
+
+	vector := make([]float64, e.dimension)
+	for feature, count := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		weight := 1 + math.Log(float64(count))
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vector[sum%uint64(e.dimension)] += weight
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	embedding := make([]float32, e.dimension)
+	if norm == 0 {
+		// Nothing to hash (empty or punctuation only): use a fixed unit
+		// vector so cosine similarity stays defined
+		embedding[0] = 1
+		return embedding
+	}
+	for i, v := range vector {
+		embedding[i] = float32(v / norm)
+	}
+	return embedding
+}
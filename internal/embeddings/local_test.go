@@ -0,0 +1,106 @@
+package embeddings
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func TestLocalEmbedder(t *testing.T) {
+	e := NewLocalEmbedder(64)
+	texts := []string{
+		"Shall we have lunch at noon?",
+		"shall we have LUNCH at noon",
+		"The quarterly report is due on Friday",
+		"",
+	}
+	embeddings, err := e.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Failed to embed: %v", err)
+	}
+
+	for i, embedding := range embeddings {
+		if len(embedding) != 64 {
+			t.Fatalf("Embedding %d: expected dimension 64, got %d", i, len(embedding))
+		}
+		var norm float64
+		for _, v := range embedding {
+			norm += float64(v) * float64(v)
+		}
+		if math.Abs(norm-1) > 1e-5 {
+			t.Errorf("Embedding %d: expected a unit vector, got norm² %f", i, norm)
+		}
+	}
+
+	// Same words, different case and punctuation
+	if sim := cosine(embeddings[0], embeddings[1]); sim < 0.99 {
+		t.Errorf("Expected near-identical texts to match, got similarity %f", sim)
+	}
+	if cosine(embeddings[0], embeddings[2]) >= cosine(embeddings[0], embeddings[1]) {
+		t.Error("Expected an unrelated text to be further away")
+	}
+
+	// Deterministic across instances
+	again, err := NewLocalEmbedder(64).Embed(context.Background(), texts[:1])
+	if err != nil {
+		t.Fatalf("Failed to embed: %v", err)
+	}
+	for i := range again[0] {
+		if again[0][i] != embeddings[0][i] {
+			t.Fatal("Expected the same embedding for the same text")
+		}
+	}
+}
+
+func TestGeneratorWithLocalProvider(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Data: config.DataConfig{
+			InputDir:  filepath.Join(tmpDir, "input"),
+			OutputDir: filepath.Join(tmpDir, "output"),
+		},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Embeddings: config.EmbeddingsConfig{Provider: ProviderLocal, Dimension: 32, Endpoint: "http://127.0.0.1:1"},
+		Pipeline:   config.PipelineConfig{MaxWorkers: 2},
+	}
+	if err := os.MkdirAll(cfg.Data.InputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input directory: %v", err)
+	}
+	input := `{"text":"one"}` + "\n" + `{"text":"two"}` + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.Data.InputDir, "messages.jsonl"), []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	gen, err := NewGenerator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if err := gen.GenerateEmbeddings(context.Background()); err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("Expected 2 embeddings, got %d", len(lines))
+	}
+	if gen.Model() != "local-ngram-32" {
+		t.Errorf("Expected the local model name, got %q", gen.Model())
+	}
+}
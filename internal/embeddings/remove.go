@@ -0,0 +1,100 @@
+package embeddings
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// RemoveMessages rewrites a JSONL file of messages, in the input or the
+// embeddings format, without the lines drop selects and returns how many
+// were removed. Input lines have no ID; drop gets them with the ID derived
+// from their text. Kept lines are copied as they are; the vectors of removed
+// ones are zeroed in the sidecar, so that they don't linger there. A missing
+// file has nothing to remove.
+func RemoveMessages(path string, drop func(MessageEmbeddingOut) bool) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	writer := bufio.NewWriter(tmp)
+
+	var cleared []int
+	removed, lineNum := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lineNum++
+		var msg MessageEmbeddingOut
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return 0, fmt.Errorf("failed to parse message JSON at line %d of %s: %w", lineNum, path, err)
+		}
+		if msg.ID == "" {
+			msg.ID = MessageID(msg.Text, msg.Metadata)
+		}
+		if drop(msg) {
+			removed++
+			if msg.Vector != nil {
+				cleared = append(cleared, *msg.Vector)
+			}
+			continue
+		}
+		writer.Write(scanner.Bytes())
+		writer.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	if removed == 0 {
+		os.Remove(tmpPath)
+		return 0, nil
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	if len(cleared) > 0 {
+		vectors, err := openVectors(path)
+		if err != nil {
+			return 0, err
+		}
+		defer vectors.close()
+		if vectors != nil {
+			if err := vectors.zero(path, cleared); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,48 @@
+// Package filelock takes exclusive advisory locks on the files of the
+// embedded stores, so that a second process (or a second open in the same
+// process) fails fast instead of overwriting the first one's writes.
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned by Acquire when the file is already locked
+var ErrLocked = errors.New("already locked by another process")
+
+// Lock is an exclusive lock held on a file
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes an exclusive lock on path, creating the file if needed. It
+// doesn't wait: if the file is locked it returns an error wrapping ErrLocked.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lock(file); err != nil {
+		file.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%s is %w", path, err)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release releases the lock. The lock file is left in place.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlock(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package filelock
+
+import "os"
+
+// Stores are not locked where flock is unavailable
+func lock(file *os.File) error { return nil }
+
+func unlock(file *os.File) error { return nil }
@@ -0,0 +1,27 @@
+package filelock
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.lock")
+	first, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	if _, err := Acquire(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected a second lock to fail with ErrLocked, got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+
+	second, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Expected the lock to be free after release: %v", err)
+	}
+	second.Release()
+}
@@ -0,0 +1,360 @@
+// Package forget removes messages from everything the pipeline derived from
+// them, for right-to-be-forgotten requests: the vector store, the graph, the
+// lexical index, the ingest files, snapshots, the embedding cache and the
+// logs. Each request leaves an audit record listing the forgotten message
+// IDs, which are hashes of the text and never the text itself.
+package forget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/dedup"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/graphdb"
+	"github.com/yourusername/psagents/internal/lexical"
+	"github.com/yourusername/psagents/internal/snapshot"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// DefaultLogsDir is where the pipeline writes its logs
+const DefaultLogsDir = "data/logs"
+
+// Redacted replaces forgotten text in log files
+const Redacted = "[forgotten]"
+
+// Request selects the messages to forget: the listed IDs, plus the messages
+// matching every filter that is set among Text, Regex, From and To.
+type Request struct {
+	IDs []string `json:"ids,omitempty"`
+	// Text matches messages containing it, ignoring case
+	Text string `json:"text,omitempty"`
+	// Regex matches messages with a match of this regular expression
+	Regex string `json:"regex,omitempty"`
+	// From and To bound the message timestamps; either end may be open
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+	// DryRun resolves the messages without removing anything
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Selector records how the messages of a request were selected, without the
+// text or pattern used
+type Selector struct {
+	IDs   []string   `json:"ids,omitempty"`
+	Text  bool       `json:"text,omitempty"`
+	Regex bool       `json:"regex,omitempty"`
+	From  *time.Time `json:"from,omitempty"`
+	To    *time.Time `json:"to,omitempty"`
+}
+
+// Record is the audit record of a request: what was forgotten and how much
+// was removed from each store
+type Record struct {
+	Time       time.Time `json:"time"`
+	Persona    string    `json:"persona,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Selector   Selector  `json:"selector"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+	MessageIDs []string  `json:"message_ids"`
+
+	VectorPoints   int                 `json:"vector_points"`
+	Graph          graphdb.ForgetStats `json:"graph"`
+	LexicalDocs    int                 `json:"lexical_docs"`
+	EmbeddingLines int                 `json:"embedding_lines"`
+	InputLines     int                 `json:"input_lines"`
+	Duplicates     int                 `json:"duplicates"`
+	SnapshotPoints int                 `json:"snapshot_points"`
+	CacheEntries   int                 `json:"cache_entries"`
+	LogFiles       int                 `json:"log_files"`
+	// UnredactedTexts counts the forgotten texts too short to be redacted
+	// from the logs without redacting unrelated lines
+	UnredactedTexts int `json:"unredacted_texts,omitempty"`
+}
+
+// Graph is the part of the graph database forgetting needs; *graphdb.GraphDB
+// implements it
+type Graph interface {
+	Texts(ctx context.Context, ids []string) (map[string]string, error)
+	Forget(ctx context.Context, ids []string, texts []string) (graphdb.ForgetStats, error)
+}
+
+// Forgetter carries out forget requests for the persona a configuration is
+// scoped to
+type Forgetter struct {
+	cfg    *config.Config
+	db     vector.DB
+	graph  Graph
+	logger *logrus.Logger
+
+	// LogsDir is the directory of log files to redact and of the audit log
+	LogsDir string
+}
+
+// New creates a forgetter over a vector database and a graph database. A
+// nil graph leaves the graph alone.
+func New(cfg *config.Config, db vector.DB, graph Graph) (*Forgetter, error) {
+	logger := logrus.New()
+	if cfg.Logging.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	logger.SetLevel(level)
+
+	return &Forgetter{
+		cfg:     cfg,
+		db:      db,
+		graph:   graph,
+		logger:  logger,
+		LogsDir: DefaultLogsDir,
+	}, nil
+}
+
+// AuditPath returns the file audit records are appended to
+func (f *Forgetter) AuditPath() string {
+	return filepath.Join(f.LogsDir, "forget", "audit.jsonl")
+}
+
+// Validate checks that a request selects something and that its pattern
+// compiles
+func (r Request) Validate() error {
+	if len(r.IDs) == 0 && r.Text == "" && r.Regex == "" && r.From == nil && r.To == nil {
+		return errors.New("select messages by ID, text, regex or time range")
+	}
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	if r.From != nil && r.To != nil && r.To.Before(*r.From) {
+		return errors.New("the time range ends before it starts")
+	}
+	return nil
+}
+
+// hasFilter reports whether the request selects by content or time
+func (r Request) hasFilter() bool {
+	return r.Text != "" || r.Regex != "" || r.From != nil || r.To != nil
+}
+
+// matcher returns a function reporting whether a stored message matches the
+// filters of the request
+func (r Request) matcher(persona string) func(vector.Message) bool {
+	var pattern *regexp.Regexp
+	if r.Regex != "" {
+		pattern = regexp.MustCompile(r.Regex)
+	}
+	text := strings.ToLower(r.Text)
+	var when *vector.Filter
+	if r.From != nil || r.To != nil {
+		var from, to time.Time
+		if r.From != nil {
+			from = *r.From
+		}
+		if r.To != nil {
+			to = *r.To
+		}
+		when = &vector.Filter{Must: []vector.Condition{vector.Between(from, to)}}
+	}
+
+	return func(msg vector.Message) bool {
+		if text != "" && !strings.Contains(strings.ToLower(msg.Text), text) {
+			return false
+		}
+		if pattern != nil && !pattern.MatchString(msg.Text) {
+			return false
+		}
+		if when != nil && !when.Matches(vector.PayloadFields(msg.Text, msg.Metadata, msg.Chunk, persona)) {
+			return false
+		}
+		return true
+	}
+}
+
+// Forget removes the messages a request selects, and the chunks cut from
+// them, from every store and appends an audit record. The vector store goes
+// last: a request that fails halfway can be retried, since the messages are
+// still found by the same selection.
+func (f *Forgetter) Forget(ctx context.Context, req Request) (Record, error) {
+	if err := req.Validate(); err != nil {
+		return Record{}, err
+	}
+	record := Record{
+		Time:    time.Now().UTC(),
+		Persona: f.cfg.Persona,
+		Reason:  req.Reason,
+		Selector: Selector{
+			IDs:   req.IDs,
+			Text:  req.Text != "",
+			Regex: req.Regex != "",
+			From:  req.From,
+			To:    req.To,
+		},
+		DryRun: req.DryRun,
+	}
+
+	ids, texts, err := f.resolve(ctx, req)
+	if err != nil {
+		return record, err
+	}
+	record.MessageIDs = make([]string, 0, len(ids))
+	for id := range ids {
+		record.MessageIDs = append(record.MessageIDs, id)
+	}
+	sort.Strings(record.MessageIDs)
+	if req.DryRun {
+		return record, nil
+	}
+	if len(ids) == 0 {
+		return record, f.audit(record)
+	}
+
+	if f.graph != nil {
+		if record.Graph, err = f.graph.Forget(ctx, record.MessageIDs, texts); err != nil {
+			return record, err
+		}
+	}
+	if record.LexicalDocs, err = lexical.Forget(f.cfg, ids); err != nil {
+		return record, fmt.Errorf("failed to update lexical index: %w", err)
+	}
+
+	dropStored := func(msg embeddings.MessageEmbeddingOut) bool {
+		return ids[msg.ID] || ids[msg.ParentID]
+	}
+	outputPath := filepath.Join(f.cfg.Data.OutputDir, "messages_embeddings.jsonl")
+	if record.EmbeddingLines, err = embeddings.RemoveMessages(outputPath, dropStored); err != nil {
+		return record, err
+	}
+	for _, name := range []string{"messages.jsonl", "messages_dev.jsonl"} {
+		removed, err := embeddings.RemoveMessages(filepath.Join(f.cfg.Data.InputDir, name), dropStored)
+		if err != nil {
+			return record, err
+		}
+		record.InputLines += removed
+	}
+	if record.Duplicates, err = dedup.Forget(f.cfg, ids); err != nil {
+		return record, err
+	}
+	if record.SnapshotPoints, err = snapshot.Forget(f.cfg, ids); err != nil {
+		return record, err
+	}
+
+	if f.cfg.Embeddings.CacheDir != "" {
+		if record.CacheEntries, _, err = embeddings.PruneCache(f.cfg.Embeddings.CacheDir, embeddings.PruneOptions{Texts: texts}); err != nil {
+			return record, err
+		}
+	}
+	if record.LogFiles, record.UnredactedTexts, err = f.redactLogs(texts); err != nil {
+		return record, err
+	}
+
+	if record.VectorPoints, err = f.db.Delete(record.MessageIDs); err != nil {
+		return record, err
+	}
+
+	f.logger.WithFields(logrus.Fields{
+		"messages":      len(record.MessageIDs),
+		"vector_points": record.VectorPoints,
+		"graph_nodes":   record.Graph.Nodes,
+		"log_files":     record.LogFiles,
+	}).Info("Forgot messages")
+	return record, f.audit(record)
+}
+
+// resolve returns the IDs of the messages a request selects, and the texts
+// of those messages and of their chunks. Listed IDs the vector store doesn't
+// have are looked up in the graph.
+func (f *Forgetter) resolve(ctx context.Context, req Request) (map[string]bool, []string, error) {
+	ids := make(map[string]bool)
+	for _, id := range req.IDs {
+		ids[id] = true
+	}
+
+	stored, err := f.db.GetAllMessages()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the vector store: %w", err)
+	}
+	if req.hasFilter() {
+		matches := req.matcher(f.cfg.Persona)
+		for _, msg := range stored {
+			if !msg.IsChunk() && matches(msg) {
+				ids[msg.ID] = true
+			}
+		}
+	}
+
+	texts := make(map[string]string)
+	for _, msg := range stored {
+		switch {
+		case ids[msg.ID]:
+			texts[msg.ID] = msg.Text
+		case msg.IsChunk() && ids[msg.ParentID]:
+			texts[msg.ID] = msg.Text
+		}
+	}
+
+	if f.graph != nil {
+		var missing []string
+		for id := range ids {
+			if _, ok := texts[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			found, err := f.graph.Texts(ctx, missing)
+			if err != nil {
+				return nil, nil, err
+			}
+			for id, text := range found {
+				texts[id] = text
+			}
+		}
+	}
+
+	// Longest first, so that redacting a chunk doesn't split up the
+	// message it was cut from
+	list := make([]string, 0, len(texts))
+	for _, text := range texts {
+		if text != "" {
+			list = append(list, text)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	return ids, list, nil
+}
+
+// audit appends a record to the audit log
+func (f *Forgetter) audit(record Record) error {
+	path := f.AuditPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
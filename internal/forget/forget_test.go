@@ -0,0 +1,272 @@
+package forget
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/graphdb"
+	"github.com/yourusername/psagents/internal/hnsw"
+	"github.com/yourusername/psagents/internal/lexical"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/snapshot"
+)
+
+// fakeGraph records what it was asked to forget
+type fakeGraph struct {
+	texts  map[string]string
+	ids    []string
+	quoted []string
+}
+
+func (g *fakeGraph) Texts(ctx context.Context, ids []string) (map[string]string, error) {
+	found := make(map[string]string)
+	for _, id := range ids {
+		if text, ok := g.texts[id]; ok {
+			found[id] = text
+		}
+	}
+	return found, nil
+}
+
+func (g *fakeGraph) Forget(ctx context.Context, ids []string, texts []string) (graphdb.ForgetStats, error) {
+	g.ids, g.quoted = ids, texts
+	return graphdb.ForgetStats{Nodes: len(ids)}, nil
+}
+
+func writeLines(t *testing.T, path string, values ...interface{}) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, v := range values {
+		if err := encoder.Encode(v); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestForget(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:     config.QdrantConfig{Path: filepath.Join(tmpDir, "vectors"), CollectionName: "messages"},
+		Data:       config.DataConfig{InputDir: filepath.Join(tmpDir, "input"), OutputDir: filepath.Join(tmpDir, "output"), BackupDir: filepath.Join(tmpDir, "backups")},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:     config.VectorConfig{Backend: "hnsw"},
+		Embeddings: config.EmbeddingsConfig{Provider: embeddings.ProviderLocal, Dimension: 2, CacheDir: filepath.Join(tmpDir, "cache")},
+	}
+
+	secret := "My address is 1 Elm Street"
+	chunk := "1 Elm Street"
+	other := "See you tomorrow"
+	old := "Happy new year"
+	otherMeta, oldMeta := message.Metadata{Timestamp: "2024-06-01T10:00:00Z"}, message.Metadata{Timestamp: "2020-01-01T00:00:00Z"}
+	secretID := embeddings.MessageID(secret, message.Metadata{})
+	chunkID := embeddings.ChunkID(secretID, 0)
+	otherID, oldID := embeddings.MessageID(other, otherMeta), embeddings.MessageID(old, oldMeta)
+
+	writeLines(t, filepath.Join(cfg.Data.InputDir, "messages.jsonl"),
+		embeddings.MessageEmbeddingIn{Text: secret},
+		embeddings.MessageEmbeddingIn{Text: other, Metadata: otherMeta},
+		embeddings.MessageEmbeddingIn{Text: old, Metadata: oldMeta},
+	)
+	writeLines(t, filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"),
+		embeddings.MessageEmbeddingOut{ID: chunkID, Text: chunk, Embedding: []float32{1, 0}, Chunk: message.Chunk{ParentID: secretID}},
+		embeddings.MessageEmbeddingOut{ID: secretID, Text: secret, Embedding: []float32{1, 0.1}, Chunk: message.Chunk{ChunkCount: 1}},
+		embeddings.MessageEmbeddingOut{ID: otherID, Text: other, Embedding: []float32{0, 1}, Metadata: message.Metadata{Timestamp: "2024-06-01T10:00:00Z"}},
+		embeddings.MessageEmbeddingOut{ID: oldID, Text: old, Embedding: []float32{0.5, 0.5}, Metadata: message.Metadata{Timestamp: "2020-01-01T00:00:00Z"}},
+	)
+
+	store, err := hnsw.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+	store.Close()
+	if _, err := lexical.Build(cfg); err != nil {
+		t.Fatalf("Failed to build lexical index: %v", err)
+	}
+	if _, err := snapshot.Create(cfg, "before"); err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if store, err = hnsw.Open(cfg); err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	cache, err := embeddings.NewCache(cfg.Embeddings.CacheDir, 0)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	for _, text := range []string{secret, chunk, other} {
+		if err := cache.Put("model", text, []float32{1}); err != nil {
+			t.Fatalf("Failed to cache embedding: %v", err)
+		}
+	}
+
+	logsDir := filepath.Join(tmpDir, "logs")
+	writeLines(t, filepath.Join(logsDir, "graphdb", "llminference_0000.log"), map[string]string{"text": secret})
+	if err := os.WriteFile(filepath.Join(logsDir, "inference.log"), []byte("Question about "+other+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	graph := &fakeGraph{texts: map[string]string{"graph-only": "only in the graph"}}
+	f, err := New(cfg, store, graph)
+	if err != nil {
+		t.Fatalf("Failed to create forgetter: %v", err)
+	}
+	f.LogsDir = logsDir
+
+	// A dry run only resolves the selection
+	record, err := f.Forget(context.Background(), Request{Text: "elm street", DryRun: true})
+	if err != nil || len(record.MessageIDs) != 1 || record.MessageIDs[0] != secretID {
+		t.Fatalf("Expected the dry run to select the secret, got %+v (%v)", record, err)
+	}
+	if messages, _ := store.GetAllMessages(); len(messages) != 4 {
+		t.Errorf("Expected a dry run to keep every point, got %d", len(messages))
+	}
+
+	record, err = f.Forget(context.Background(), Request{Text: "elm street", IDs: []string{"graph-only"}, Reason: "erasure request"})
+	if err != nil {
+		t.Fatalf("Failed to forget: %v", err)
+	}
+	if len(record.MessageIDs) != 2 || record.VectorPoints != 2 || record.Graph.Nodes != 2 || record.LexicalDocs != 1 ||
+		record.EmbeddingLines != 2 || record.InputLines != 1 || record.SnapshotPoints != 2 || record.CacheEntries != 2 || record.LogFiles != 1 {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if len(graph.quoted) != 3 || graph.quoted[0] != secret {
+		t.Errorf("Expected the graph to get the message, chunk and graph-only texts longest first, got %q", graph.quoted)
+	}
+
+	messages, _ := store.GetAllMessages()
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 points left, got %+v", messages)
+	}
+	ix, err := lexical.Open(cfg)
+	if err != nil || ix.Has(secretID) || !ix.Has(otherID) {
+		t.Errorf("Expected the secret to be gone from the lexical index (%v)", err)
+	}
+	if n := countLines(t, filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl")); n != 2 {
+		t.Errorf("Expected 2 embeddings left, got %d", n)
+	}
+	if n := countLines(t, filepath.Join(cfg.Data.InputDir, "messages.jsonl")); n != 2 {
+		t.Errorf("Expected 2 input messages left, got %d", n)
+	}
+	if manifests, _ := snapshot.List(cfg); len(manifests) != 1 || manifests[0].Points != 2 {
+		t.Errorf("Expected the snapshot to keep 2 points, got %+v", manifests)
+	}
+	if _, ok := cache.Get("model", secret); ok {
+		t.Error("Expected the cached embedding of the secret to be gone")
+	}
+	if _, ok := cache.Get("model", other); !ok {
+		t.Error("Expected other cached embeddings to be kept")
+	}
+	data, _ := os.ReadFile(filepath.Join(logsDir, "graphdb", "llminference_0000.log"))
+	if strings.Contains(string(data), "Elm") || !strings.Contains(string(data), Redacted) {
+		t.Errorf("Expected the log to be redacted, got %s", data)
+	}
+
+	// Select by time range
+	to := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	record, err = f.Forget(context.Background(), Request{To: &to})
+	if err != nil || len(record.MessageIDs) != 1 || record.MessageIDs[0] != oldID || record.VectorPoints != 1 {
+		t.Errorf("Expected the old message to be forgotten, got %+v (%v)", record, err)
+	}
+
+	// Every request is audited, without the text it selected by
+	audit, err := os.ReadFile(f.AuditPath())
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit records, got %d", len(lines))
+	}
+	var first Record
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+	if !first.Selector.Text || first.Reason != "erasure request" || len(first.MessageIDs) != 2 {
+		t.Errorf("Unexpected audit record %+v", first)
+	}
+	if strings.Contains(strings.ToLower(string(audit)), "elm") {
+		t.Error("Expected the audit log not to contain forgotten text")
+	}
+}
+
+func TestRedactLogs(t *testing.T) {
+	dir := t.TempDir()
+	secret := "My address is 1 Elm Street"
+	writeLines(t, filepath.Join(dir, "prompts.log"),
+		map[string]string{"text": secret},
+		map[string]string{"text": secret + ", flat 2"},
+		map[string]string{"status": "ok"},
+	)
+	plain := "Question:\n" + secret + "\nQuestion about " + secret + "?\n"
+	if err := os.WriteFile(filepath.Join(dir, "inference.log"), []byte(plain), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	f := &Forgetter{LogsDir: dir}
+	changed, skipped, err := f.redactLogs([]string{secret, "ok"})
+	if err != nil || changed != 2 || skipped != 1 {
+		t.Fatalf("Expected 2 files redacted and the short text skipped, got %d, %d (%v)", changed, skipped, err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "prompts.log"))
+	want := `{"text":"` + Redacted + `"}` + "\n" + `{"text":"` + secret + `, flat 2"}` + "\n" + `{"status":"ok"}` + "\n"
+	if string(data) != want {
+		t.Errorf("Expected only the whole JSON value to be redacted, got %s", data)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "inference.log"))
+	if want := "Question:\n" + Redacted + "\nQuestion about " + secret + "?\n"; string(data) != want {
+		t.Errorf("Expected only the whole line to be redacted, got %q", data)
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(-time.Hour)
+	for _, req := range []Request{
+		{},
+		{Reason: "no selector"},
+		{Regex: "("},
+		{From: &from, To: &to},
+	} {
+		if err := req.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", req)
+		}
+	}
+	if err := (Request{Regex: `\bElm\b`}).Validate(); err != nil {
+		t.Errorf("Expected a valid regex request, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package forget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// minRedactLength is the shortest text, in characters, redacted from the
+// logs. Shorter texts such as "ok" would redact unrelated lines and fields
+// that happen to read the same.
+const minRedactLength = 8
+
+// redactLogs redacts the forgotten texts in every log file under LogsDir and
+// returns how many files changed and how many texts were too short to be
+// redacted. Only whole values are replaced: a text as a complete quoted JSON
+// string, as in logged prompts, or as a complete line, so that a text never
+// redacts the middle of a longer one. The audit log holds no text and is
+// left alone.
+func (f *Forgetter) redactLogs(texts []string) (int, int, error) {
+	var redactable []string
+	for _, text := range texts {
+		if utf8.RuneCountInString(strings.TrimSpace(text)) >= minRedactLength {
+			redactable = append(redactable, text)
+		}
+	}
+	skipped := len(texts) - len(redactable)
+	if len(redactable) == 0 {
+		return 0, skipped, nil
+	}
+	pairs := make([]string, 0, 2*len(redactable))
+	for _, text := range redactable {
+		pairs = append(pairs, `"`+jsonEscape(text)+`"`, `"`+Redacted+`"`)
+	}
+	quoted := strings.NewReplacer(pairs...)
+	redact := func(data string) string {
+		data = quoted.Replace(data)
+		for _, text := range redactable {
+			data = replaceLines(data, text, Redacted)
+		}
+		return data
+	}
+
+	audit := f.AuditPath()
+	changed := 0
+	err := filepath.WalkDir(f.LogsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path == audit {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		redacted := redact(string(data))
+		if redacted == string(data) {
+			return nil
+		}
+		if err := os.WriteFile(path, []byte(redacted), info.Mode().Perm()); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return changed, skipped, nil
+	}
+	if err != nil {
+		return changed, skipped, fmt.Errorf("failed to redact logs: %w", err)
+	}
+	return changed, skipped, nil
+}
+
+// replaceLines replaces the occurrences of old in data that span whole
+// lines: they start at the beginning of a line and end at the end of one
+func replaceLines(data, old, new string) string {
+	var b strings.Builder
+	rest := data
+	for {
+		i := strings.Index(rest, old)
+		if i < 0 {
+			break
+		}
+		start := len(data) - len(rest) + i
+		end := start + len(old)
+		if (start == 0 || data[start-1] == '\n') && (end == len(data) || data[end] == '\n' || data[end] == '\r') {
+			b.WriteString(rest[:i])
+			b.WriteString(new)
+		} else {
+			b.WriteString(rest[:i+len(old)])
+		}
+		rest = rest[i+len(old):]
+	}
+	if b.Len() == 0 {
+		return data
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// jsonEscape returns text as it appears inside a JSON string
+func jsonEscape(text string) string {
+	data, err := json.Marshal(text)
+	if err != nil {
+		return text
+	}
+	return string(data[1 : len(data)-1])
+}
@@ -0,0 +1,180 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+)
+
+// Edge types of the message graph
+const (
+	// EdgeSimilar links a message to one of its top K most similar
+	// messages, with the similarity as Score
+	EdgeSimilar = "IS_SIMILAR"
+	// EdgeRelated is a relationship classified by the LLM, with its class
+	// as Relation
+	EdgeRelated = "RELATED_TO"
+	// EdgeDuplicate links a near-duplicate to its canonical message
+	EdgeDuplicate = "DUPLICATE_OF"
+)
+
+// EdgeTypes lists every edge type
+var EdgeTypes = []string{EdgeSimilar, EdgeRelated, EdgeDuplicate}
+
+// ValidEdgeType reports whether t is one of EdgeTypes
+func ValidEdgeType(t string) bool {
+	for _, known := range EdgeTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Node is a message node: its ID, text and properties. Property values are
+// strings, booleans, float64s or string lists (see NormalizeValue).
+type Node struct {
+	ID         string                 `json:"id"`
+	Text       string                 `json:"text,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Edge is a directed edge between two message nodes. An edge is identified
+// by its endpoints, type and relation; the other fields are properties whose
+// use depends on the type.
+type Edge struct {
+	Source     string  `json:"source"`
+	Target     string  `json:"target"`
+	Type       string  `json:"type"`
+	Relation   string  `json:"relation,omitempty"`   // class of a RELATED_TO edge
+	Score      float64 `json:"score,omitempty"`      // similarity of an IS_SIMILAR or DUPLICATE_OF edge
+	Confidence float64 `json:"confidence,omitempty"` // confidence of a RELATED_TO edge
+	Evidence   string  `json:"evidence,omitempty"`   // evidence of a RELATED_TO edge
+	Reason     string  `json:"reason,omitempty"`     // why a DUPLICATE_OF edge was drawn
+}
+
+// Weight is the strength of an edge: its confidence for RELATED_TO, its
+// score otherwise
+func (e Edge) Weight() float64 {
+	if e.Type == EdgeRelated {
+		return e.Confidence
+	}
+	return e.Score
+}
+
+// Direction selects the edges of a node by which end the node is on
+type Direction int
+
+const (
+	Outgoing Direction = iota
+	Incoming
+	Both
+)
+
+// Neighbor is a node adjacent to another along with the edge joining them
+type Neighbor struct {
+	Node Node
+	Edge Edge
+}
+
+// ExpandOptions bound a path expansion
+type ExpandOptions struct {
+	// Types restricts the edges walked; empty walks every type
+	Types []string
+	// MaxDepth is the largest number of edges in a path
+	MaxDepth int
+	// MinConfidence is the least confidence of every edge on a path.
+	// Edges without one, which are all but RELATED_TO, count as 0.
+	MinConfidence float64
+	// Limit is the number of paths returned, 0 for all
+	Limit int
+}
+
+// Path is a walk from the node an expansion started at, following edges in
+// either direction without revisiting a node
+type Path struct {
+	IDs        []string // node IDs from the start node to End
+	End        Node
+	Edges      []Edge  // edges in walk order, as stored
+	Confidence float64 // product of the edge confidences
+}
+
+// Stats counts the nodes and edges of a graph
+type Stats struct {
+	Nodes int            `json:"nodes"`
+	Edges map[string]int `json:"edges"` // by type
+}
+
+// TotalEdges returns the number of edges of every type
+func (s Stats) TotalEdges() int {
+	total := 0
+	for _, n := range s.Edges {
+		total += n
+	}
+	return total
+}
+
+// Store defines the interface for graph database operations. Every node of
+// a store carries the same message label, so a store only ever sees the
+// messages of one persona.
+type Store interface {
+	// UpsertNodes creates the nodes that don't exist and updates the others:
+	// a non-empty Text replaces theirs, and Properties are merged into
+	// theirs, a nil value removing the property
+	UpsertNodes(ctx context.Context, nodes []Node) error
+	// UpsertEdges creates the edges that don't exist, along with any
+	// missing endpoint, and updates the properties of the others
+	UpsertEdges(ctx context.Context, edges []Edge) error
+	// Nodes returns the nodes with the given IDs that exist, or every node
+	// for nil IDs
+	Nodes(ctx context.Context, ids []string) ([]Node, error)
+	// Edges returns every edge of a type, or of every type for ""
+	Edges(ctx context.Context, edgeType string) ([]Edge, error)
+	// Neighbors returns the nodes joined to a node by edges of a type in a
+	// direction, strongest edge first, at most limit of them (0 for all)
+	Neighbors(ctx context.Context, id string, edgeType string, dir Direction, limit int) ([]Neighbor, error)
+	// Expand returns the paths from a node within the bounds of opts,
+	// highest confidence first
+	Expand(ctx context.Context, id string, opts ExpandOptions) ([]Path, error)
+	// DeleteNodes removes the nodes with the given IDs with all their
+	// edges, and returns how many nodes and edges were removed
+	DeleteNodes(ctx context.Context, ids []string) (int, int, error)
+	// DeleteEdges removes the given edges, matched by identity, and
+	// returns how many were removed
+	DeleteEdges(ctx context.Context, edges []Edge) (int, error)
+	Stats(ctx context.Context) (Stats, error)
+	Close() error
+}
+
+// NormalizeValue converts a property value to one of the types a store
+// keeps: string, bool, float64 or []string. Integers become float64 and
+// lists of strings []string; nil, which removes a property, stays nil.
+func NormalizeValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, string, bool, float64, []string:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported list item %T", item)
+			}
+			list[i] = s
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported property value %T", v)
+	}
+}
+
+// StringList returns a list property of a node, nil when it is missing
+func (n Node) StringList(key string) []string {
+	list, _ := n.Properties[key].([]string)
+	return list
+}
@@ -0,0 +1,203 @@
+package graphdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/yourusername/psagents/internal/graph"
+	"github.com/yourusername/psagents/internal/message"
+)
+
+// CompressionStats reports the graph before and after Compress and why the
+// edges it removed were removed
+type CompressionStats struct {
+	Before graph.Stats
+	After  graph.Stats
+	// BelowThreshold counts IS_SIMILAR edges scored below
+	// embeddings.similarity_threshold
+	BelowThreshold int
+	// TopicSwitches counts RELATED_TO edges classified as a topic switch
+	TopicSwitches int
+	// LowConfidence counts RELATED_TO edges below inference.min_confidence
+	LowConfidence int
+	// Mutual counts the weaker edges of pairs joining two messages both ways
+	Mutual int
+	// OverDegree counts the edges dropped to cap graphdb.max_degree
+	OverDegree int
+}
+
+// Removed returns the number of edges Compress removed
+func (s CompressionStats) Removed() int {
+	return s.BelowThreshold + s.TopicSwitches + s.LowConfidence + s.Mutual + s.OverDegree
+}
+
+// Compress prunes the edges that keep the graph from staying sparse, in
+// order: IS_SIMILAR edges below embeddings.similarity_threshold, RELATED_TO
+// edges that are a topic switch or below inference.min_confidence, the
+// weaker edge of each pair of the same type and relation joining two
+// messages both ways, and the weakest edges of the messages with more than
+// graphdb.max_degree edges. Nodes are never removed. The targets of the
+// removed IS_SIMILAR edges are recorded on their source node, so that the
+// incremental first pass doesn't see its anchors as changed and add them
+// back.
+func (db *GraphDB) Compress(ctx context.Context) (CompressionStats, error) {
+	var stats CompressionStats
+	var err error
+	if stats.Before, err = db.store.Stats(ctx); err != nil {
+		return stats, fmt.Errorf("failed to get graph stats: %w", err)
+	}
+	edges, err := db.store.Edges(ctx, "")
+	if err != nil {
+		return stats, fmt.Errorf("failed to get edges: %w", err)
+	}
+
+	threshold := db.cfg.Embeddings.SimilarityThreshold
+	minConfidence := db.cfg.Inference.MinConfidence
+	var removed, kept []graph.Edge
+	for _, e := range edges {
+		switch {
+		case e.Type == graph.EdgeSimilar && e.Score < threshold:
+			stats.BelowThreshold++
+		case e.Type == graph.EdgeRelated && e.Relation == string(message.RelationTopicSwitch):
+			stats.TopicSwitches++
+		case e.Type == graph.EdgeRelated && e.Confidence < minConfidence:
+			stats.LowConfidence++
+		default:
+			kept = append(kept, e)
+			continue
+		}
+		removed = append(removed, e)
+	}
+
+	kept, mutual := collapseMutual(kept)
+	stats.Mutual = len(mutual)
+	removed = append(removed, mutual...)
+
+	_, over := capDegree(kept, db.cfg.GraphDB.MaxDegree)
+	stats.OverDegree = len(over)
+	removed = append(removed, over...)
+
+	if len(removed) > 0 {
+		if _, err := db.store.DeleteEdges(ctx, removed); err != nil {
+			return stats, fmt.Errorf("failed to delete edges: %w", err)
+		}
+		if err := db.recordPruned(ctx, removed); err != nil {
+			return stats, err
+		}
+	}
+	if stats.After, err = db.store.Stats(ctx); err != nil {
+		return stats, fmt.Errorf("failed to get graph stats: %w", err)
+	}
+	return stats, nil
+}
+
+// recordPruned adds the targets of the removed IS_SIMILAR edges to the
+// pruned anchors of their source nodes
+func (db *GraphDB) recordPruned(ctx context.Context, removed []graph.Edge) error {
+	targets := make(map[string][]string)
+	var sources []string
+	for _, e := range removed {
+		if e.Type != graph.EdgeSimilar {
+			continue
+		}
+		if _, ok := targets[e.Source]; !ok {
+			sources = append(sources, e.Source)
+		}
+		targets[e.Source] = append(targets[e.Source], e.Target)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	pruned, err := db.prunedAnchors(ctx, sources)
+	if err != nil {
+		return err
+	}
+	nodes := make([]graph.Node, len(sources))
+	for i, id := range sources {
+		list := pruned[id]
+		for _, target := range targets[id] {
+			if !contains(list, target) {
+				list = append(list, target)
+			}
+		}
+		nodes[i] = graph.Node{ID: id, Properties: map[string]interface{}{propPrunedAnchors: list}}
+	}
+	if err := db.store.UpsertNodes(ctx, nodes); err != nil {
+		return fmt.Errorf("failed to record pruned anchors: %w", err)
+	}
+	return nil
+}
+
+// collapseMutual keeps one edge of each pair of edges of the same type and
+// relation joining two messages both ways: the stronger one, or the one from
+// the lower ID when they are equally strong
+func collapseMutual(edges []graph.Edge) (kept, dropped []graph.Edge) {
+	type key struct{ source, target, edgeType, relation string }
+	index := make(map[key]int, len(edges))
+	for i, e := range edges {
+		index[key{e.Source, e.Target, e.Type, e.Relation}] = i
+	}
+	for _, e := range edges {
+		j, ok := index[key{e.Target, e.Source, e.Type, e.Relation}]
+		if ok && e.Source != e.Target && !stronger(e, edges[j]) {
+			dropped = append(dropped, e)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept, dropped
+}
+
+func stronger(a, b graph.Edge) bool {
+	if a.Weight() != b.Weight() {
+		return a.Weight() > b.Weight()
+	}
+	return a.Source < b.Source
+}
+
+// capDegree keeps at most maxDegree edges per message, 0 meaning no cap.
+// Edges are taken strongest first, RELATED_TO edges ahead of IS_SIMILAR ones
+// as they cost an LLM request to find again, and one is kept only if both
+// its messages have room left. DUPLICATE_OF edges are always kept and don't
+// count towards the cap.
+func capDegree(edges []graph.Edge, maxDegree int) (kept, dropped []graph.Edge) {
+	if maxDegree <= 0 {
+		return edges, nil
+	}
+	order := make([]int, len(edges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := edges[order[i]], edges[order[j]]
+		if ra, rb := a.Type == graph.EdgeRelated, b.Type == graph.EdgeRelated; ra != rb {
+			return ra
+		}
+		return a.Weight() > b.Weight()
+	})
+
+	keep := make([]bool, len(edges))
+	degree := make(map[string]int)
+	for _, i := range order {
+		e := edges[i]
+		if e.Type == graph.EdgeDuplicate {
+			keep[i] = true
+			continue
+		}
+		if degree[e.Source] < maxDegree && degree[e.Target] < maxDegree {
+			keep[i] = true
+			degree[e.Source]++
+			degree[e.Target]++
+		}
+	}
+	for i, e := range edges {
+		if keep[i] {
+			kept = append(kept, e)
+		} else {
+			dropped = append(dropped, e)
+		}
+	}
+	return kept, dropped
+}
@@ -0,0 +1,115 @@
+package graphdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+// RedactedEvidence replaces RELATED_TO evidence that quotes a forgotten message
+const RedactedEvidence = "[redacted]"
+
+// ForgetStats counts what Forget removed from the graph
+type ForgetStats struct {
+	Nodes    int `json:"nodes"`    // message nodes deleted
+	Edges    int `json:"edges"`    // edges deleted along with them
+	Evidence int `json:"evidence"` // evidence strings of remaining edges redacted
+	Aliases  int `json:"aliases"`  // nodes that listed a forgotten message as an alias
+}
+
+// Texts returns the text of the message nodes with the given IDs, keyed by ID
+func (db *GraphDB) Texts(ctx context.Context, ids []string) (map[string]string, error) {
+	nodes, err := db.store.Nodes(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message nodes: %w", err)
+	}
+	texts := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		texts[n.ID] = n.Text
+	}
+	return texts, nil
+}
+
+// Forget deletes the nodes of the given message IDs with all their edges,
+// drops the IDs from the aliases of their canonical nodes and from the
+// pruned anchors of the nodes Compress pruned them from, and redacts the
+// evidence of remaining RELATED_TO edges that quotes any of texts, compared
+// case-insensitively. Every step can be repeated, so a request that fails
+// midway can simply be run again.
+func (db *GraphDB) Forget(ctx context.Context, ids []string, texts []string) (ForgetStats, error) {
+	var stats ForgetStats
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	var err error
+	stats.Nodes, stats.Edges, err = db.store.DeleteNodes(ctx, ids)
+	if err != nil {
+		return ForgetStats{}, fmt.Errorf("failed to forget message nodes: %w", err)
+	}
+
+	forgotten := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		forgotten[id] = true
+	}
+	nodes, err := db.store.Nodes(ctx, nil)
+	if err != nil {
+		return stats, fmt.Errorf("failed to forget message nodes: %w", err)
+	}
+	var updates []graph.Node
+	for _, n := range nodes {
+		props := make(map[string]interface{})
+		for _, key := range []string{propAliases, propPrunedAnchors} {
+			list := n.StringList(key)
+			kept := make([]string, 0, len(list))
+			for _, id := range list {
+				if !forgotten[id] {
+					kept = append(kept, id)
+				}
+			}
+			if len(kept) < len(list) {
+				props[key] = kept
+			}
+		}
+		if _, ok := props[propAliases]; ok {
+			stats.Aliases++
+		}
+		if len(props) > 0 {
+			updates = append(updates, graph.Node{ID: n.ID, Properties: props})
+		}
+	}
+	if err := db.store.UpsertNodes(ctx, updates); err != nil {
+		return stats, fmt.Errorf("failed to forget message nodes: %w", err)
+	}
+
+	related, err := db.store.Edges(ctx, graph.EdgeRelated)
+	if err != nil {
+		return stats, fmt.Errorf("failed to forget message nodes: %w", err)
+	}
+	var redacted []graph.Edge
+	for _, edge := range related {
+		if edge.Evidence != RedactedEvidence && quotes(edge.Evidence, texts) {
+			edge.Evidence = RedactedEvidence
+			redacted = append(redacted, edge)
+		}
+	}
+	if err := db.store.UpsertEdges(ctx, redacted); err != nil {
+		return stats, fmt.Errorf("failed to forget message nodes: %w", err)
+	}
+	stats.Evidence = len(redacted)
+	return stats, nil
+}
+
+// quotes reports whether evidence contains any of the non-empty texts,
+// ignoring case
+func quotes(evidence string, texts []string) bool {
+	evidence = strings.ToLower(evidence)
+	for _, text := range texts {
+		if text != "" && strings.Contains(evidence, strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
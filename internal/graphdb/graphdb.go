@@ -3,15 +3,22 @@ package graphdb
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/dedup"
+	"github.com/yourusername/psagents/internal/graph"
 	"github.com/yourusername/psagents/internal/llm"
+	"github.com/yourusername/psagents/internal/memgraph"
 	"github.com/yourusername/psagents/internal/message"
 	"github.com/yourusername/psagents/internal/vector"
 )
@@ -26,14 +33,53 @@ type Relationship struct {
 	Evidence   string  `json:"evidence"`
 }
 
+// Graph database types selected by graphdb.type
+const (
+	TypeNeo4j    = "neo4j"
+	TypeEmbedded = "embedded"
+)
+
+// errMalformedReply is returned by processBatch when the LLM reply can't be
+// parsed
+var errMalformedReply = errors.New("malformed LLM reply")
+
+// Node properties the passes keep besides the message metadata
+const (
+	// propAliases lists the IDs of the near-duplicates of a canonical node
+	propAliases = "aliases"
+	// propNeedsClassification flags the nodes the incremental second pass
+	// classifies
+	propNeedsClassification = "needs_classification"
+	// propClassifiedAt records when the second pass wrote the relationships
+	// of a node, so that a resumed pass can skip it
+	propClassifiedAt = "classified_at"
+	// propPrunedAnchors lists the targets of the isSimilar edges of a node
+	// that Compress removed, so that the first pass doesn't add them back
+	propPrunedAnchors = "pruned_anchors"
+)
+
 // GraphDB handles graph database operations
 type GraphDB struct {
 	cfg          *config.Config
-	driver       neo4j.Driver
+	store        graph.Store
 	vectorDB     vector.DB
 	inputSchema  string
 	outputSchema string
 	logFile      *os.File  // Log file for the current run
+	logMu        sync.Mutex // Serializes writes to logFile
+	duplicates   map[string]dedup.Duplicate // Near-duplicates by ID, loaded by FirstPass
+}
+
+// NewStore opens the graph store selected by graphdb.type
+func NewStore(cfg *config.Config) (graph.Store, error) {
+	switch cfg.GraphDB.Type {
+	case "", TypeNeo4j:
+		return NewNeo4jStore(cfg)
+	case TypeEmbedded:
+		return memgraph.Open(cfg)
+	default:
+		return nil, fmt.Errorf("unknown graph database type %q (expected %s or %s)", cfg.GraphDB.Type, TypeNeo4j, TypeEmbedded)
+	}
 }
 
 // loadSchema loads a schema from a JSON file
@@ -60,16 +106,6 @@ func loadSchema(filePath string) (string, error) {
 
 // NewGraphDB creates a new graph database connection
 func NewGraphDB(cfg *config.Config, vectorDB vector.DB) (*GraphDB, error) {
-	// Initialize Neo4j driver
-	auth := neo4j.BasicAuth(cfg.GraphDB.Username, cfg.GraphDB.Password, "")
-	driver, err := neo4j.NewDriver(
-		fmt.Sprintf("neo4j://%s:%d", cfg.GraphDB.Host, cfg.GraphDB.Port),
-		auth,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Neo4j driver: %w", err)
-	}
-
 	// Load schemas from prompt files
 	inputSchema, err := loadSchema(filepath.Join("data", "prompts", "inputschema.json"))
 	if err != nil {
@@ -104,11 +140,13 @@ func NewGraphDB(cfg *config.Config, vectorDB vector.DB) (*GraphDB, error) {
 			// Dump configuration
 			fmt.Fprintf(logFile, "=== Configuration ===\n")
 			fmt.Fprintf(logFile, "GraphDB Settings:\n")
+			fmt.Fprintf(logFile, "  Type: %s\n", cfg.GraphDB.Type)
 			fmt.Fprintf(logFile, "  Host: %s\n", cfg.GraphDB.Host)
 			fmt.Fprintf(logFile, "  Port: %d\n", cfg.GraphDB.Port)
 			fmt.Fprintf(logFile, "  Username: %s\n", cfg.GraphDB.Username)
 			fmt.Fprintf(logFile, "  SimilarityAnchors: %d\n", cfg.GraphDB.SimilarityAnchors)
 			fmt.Fprintf(logFile, "  SemanticFrontier: %d\n", cfg.GraphDB.SemanticFrontier)
+			fmt.Fprintf(logFile, "  Label: %s\n", cfg.GraphDB.MessageLabel())
 			
 			fmt.Fprintf(logFile, "\nLLM Settings:\n")
 			fmt.Fprintf(logFile, "  Provider: %s\n", cfg.LLM.Provider)
@@ -124,9 +162,15 @@ func NewGraphDB(cfg *config.Config, vectorDB vector.DB) (*GraphDB, error) {
 		return nil, fmt.Errorf("no available log file names (reached limit of 9999)")
 	}
 
+	store, err := NewStore(cfg)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
 	return &GraphDB{
 		cfg:          cfg,
-		driver:       driver,
+		store:        store,
 		vectorDB:     vectorDB,
 		inputSchema:  inputSchema,
 		outputSchema: outputSchema,
@@ -134,18 +178,18 @@ func NewGraphDB(cfg *config.Config, vectorDB vector.DB) (*GraphDB, error) {
 	}, nil
 }
 
-// Close closes the graph database connection and log file
+// Close closes the graph store and log file
 func (db *GraphDB) Close() error {
 	if db.logFile != nil {
 		fmt.Fprintf(db.logFile, "\nEnded at: %s\n", time.Now().Format(time.RFC3339))
 		db.logFile.Close()
 	}
-	return db.driver.Close()
+	return db.store.Close()
 }
 
-// GetSession returns a new Neo4j session
-func (db *GraphDB) GetSession() neo4j.Session {
-	return db.driver.NewSession(neo4j.SessionConfig{})
+// Store returns the graph store the passes write to
+func (db *GraphDB) Store() graph.Store {
+	return db.store
 }
 
 // GetLLMPrompt generates the LLM prompt for relationship classification
@@ -208,116 +252,463 @@ func (db *GraphDB) GetLLMPrompt(pairs []struct {
 
 // FirstPass performs the first pass of graph population:
 // For each entry in vector database, find up to similarity_anchors count
-// top K similar messages and connect them using isSimilar edge
+// top K similar messages and connect them using isSimilar edge.
+// In incremental mode only new entries and the existing nodes whose top K
+// changed are processed (see incrementalFirstPass).
 func (db *GraphDB) FirstPass(ctx context.Context) error {
-	session := db.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	// Create index for messages if it doesn't exist
-	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		_, err := tx.Run(
-			"CREATE INDEX message_id IF NOT EXISTS FOR (m:Message) ON (m.id)",
-			nil,
-		)
-		return nil, err
-	})
+	// Near-duplicates are linked to their canonical message rather than
+	// anchored themselves
+	var err error
+	db.duplicates, err = dedup.Load(db.cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create index: %w", err)
+		return fmt.Errorf("failed to load duplicates: %w", err)
+	}
+
+	var duplicates []vector.Message
+	if db.cfg.Pipeline.Incremental {
+		if duplicates, err = db.incrementalFirstPass(ctx); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("Processing the messages from vector database")
+
+		// Process each message, writing the anchors in batches
+		w := db.newWriter()
+		processed := 0
+		duplicates, err = db.listMessages(func(page []vector.Message) error {
+			messages, err := db.vectorDB.GetMessages(messageIDs(page))
+			if err != nil {
+				return fmt.Errorf("failed to get messages: %w", err)
+			}
+			pruned, err := db.prunedAnchors(ctx, messageIDs(messages))
+			if err != nil {
+				return err
+			}
+			for _, msg := range messages {
+				// Find top K similar messages
+				similar, err := db.searchAnchors(msg, pruned[msg.ID])
+				if err != nil {
+					return fmt.Errorf("failed to search similar messages: %w", err)
+				}
+
+				processed++
+				fmt.Printf("Message %d: Found %d similar messages for ID %s\n", processed, len(similar), msg.ID)
+
+				if err := db.anchorMessage(ctx, w, msg, similar); err != nil {
+					return fmt.Errorf("failed to create relationships: %w", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := w.flush(ctx); err != nil {
+			return fmt.Errorf("failed to create relationships: %w", err)
+		}
+	}
+
+	if err := db.linkDuplicates(ctx, duplicates); err != nil {
+		return err
 	}
 
-	// Get all messages from vector database
-	messages, err := db.vectorDB.GetAllMessages()
+	// Verify all nodes have text property
+	nodes, err := db.store.Nodes(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get messages: %w", err)
+		return fmt.Errorf("failed to verify nodes: %w", err)
+	}
+	missingText := 0
+	for _, n := range nodes {
+		if n.Text == "" {
+			missingText++
+		}
 	}
+	if missingText > 0 {
+		fmt.Printf("Warning: Found %d nodes with missing text property\n", missingText)
+	} else {
+		fmt.Println("All nodes have text property set correctly")
+	}
+
+	return nil
+}
 
-	fmt.Printf("Processing %d messages from vector database\n", len(messages))
+// messagePageSize is how many messages the first pass lists and fetches the
+// embeddings of at a time
+const messagePageSize = 100
+
+// listMessages calls fn with the messages of the vector database a page at
+// a time and without their embeddings, so that the vectors of only one page
+// are held at once. Chunks are left out, as they are only search targets,
+// and near-duplicates are collected and returned instead.
+func (db *GraphDB) listMessages(fn func(page []vector.Message) error) ([]vector.Message, error) {
+	var duplicates []vector.Message
+	err := db.vectorDB.ListMessages(messagePageSize, func(page []vector.Message) error {
+		canonical := page[:0]
+		for _, msg := range page {
+			if msg.IsChunk() {
+				continue
+			}
+			if _, ok := db.duplicates[msg.ID]; ok {
+				duplicates = append(duplicates, msg)
+			} else {
+				canonical = append(canonical, msg)
+			}
+		}
+		if len(canonical) == 0 {
+			return nil
+		}
+		return fn(canonical)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return duplicates, nil
+}
 
-	// Process each message
+func messageIDs(messages []vector.Message) []string {
+	ids := make([]string, len(messages))
 	for i, msg := range messages {
-		// Find top K similar messages
-		similar, err := db.vectorDB.Search(msg.Embedding, db.cfg.GraphDB.SimilarityAnchors)
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+// searchAnchors finds the top K similar messages of a message, leaving out
+// near-duplicates: those hang off their canonical message instead, so that
+// the copies of a message don't fill each other's anchor lists. The pruned
+// anchors of the message are then dropped from its top K rather than
+// replaced, so that a compressed node keeps exactly the anchors Compress
+// left it until new messages show up next to it.
+func (db *GraphDB) searchAnchors(msg vector.Message, pruned []string) ([]vector.Message, error) {
+	k := db.cfg.GraphDB.SimilarityAnchors
+	fetch := k
+	if len(db.duplicates) > 0 {
+		fetch = 2 * k
+	}
+	similar, err := db.vectorDB.Search(msg.Embedding, fetch, nil)
+	if err != nil {
+		return nil, err
+	}
+	anchors := similar[:0]
+	for _, sim := range similar {
+		if _, ok := db.duplicates[sim.ID]; !ok {
+			anchors = append(anchors, sim)
+		}
+	}
+	if len(anchors) > k {
+		anchors = anchors[:k]
+	}
+	kept := anchors[:0]
+	for _, sim := range anchors {
+		if !contains(pruned, sim.ID) {
+			kept = append(kept, sim)
+		}
+	}
+	return kept, nil
+}
+
+// prunedAnchors returns the pruned anchors of the nodes of ids, keyed by ID
+func (db *GraphDB) prunedAnchors(ctx context.Context, ids []string) (map[string][]string, error) {
+	nodes, err := db.store.Nodes(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pruned anchors: %w", err)
+	}
+	pruned := make(map[string][]string)
+	for _, n := range nodes {
+		if list := n.StringList(propPrunedAnchors); len(list) > 0 {
+			pruned[n.ID] = list
+		}
+	}
+	return pruned, nil
+}
+
+// messageNode returns the node of a message
+func messageNode(msg vector.Message) graph.Node {
+	return graph.Node{ID: msg.ID, Text: msg.Text, Properties: msg.Metadata.Properties()}
+}
+
+// linkDuplicates creates the nodes of near-duplicate messages with a
+// DUPLICATE_OF edge to their canonical message, removes any isSimilar edges
+// they had from earlier runs and lists them as aliases of the canonical node
+func (db *GraphDB) linkDuplicates(ctx context.Context, duplicates []vector.Message) error {
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	var stale, edges []graph.Edge
+	var nodes []graph.Node
+	aliases := make(map[string][]string)
+	var canonicalIDs []string
+	for _, msg := range duplicates {
+		similar, err := db.store.Neighbors(ctx, msg.ID, graph.EdgeSimilar, graph.Both, 0)
 		if err != nil {
-			return fmt.Errorf("failed to search similar messages: %w", err)
+			return fmt.Errorf("failed to link duplicates: %w", err)
+		}
+		for _, sim := range similar {
+			stale = append(stale, sim.Edge)
+		}
+
+		d := db.duplicates[msg.ID]
+		nodes = append(nodes, messageNode(msg))
+		edges = append(edges, graph.Edge{
+			Source: msg.ID,
+			Target: d.CanonicalID,
+			Type:   graph.EdgeDuplicate,
+			Score:  d.Score,
+			Reason: d.Reason,
+		})
+		if _, ok := aliases[d.CanonicalID]; !ok {
+			canonicalIDs = append(canonicalIDs, d.CanonicalID)
+		}
+		aliases[d.CanonicalID] = append(aliases[d.CanonicalID], msg.ID)
+	}
+
+	// Add the duplicates to the aliases the canonical nodes already have
+	existing, err := db.store.Nodes(ctx, canonicalIDs)
+	if err != nil {
+		return fmt.Errorf("failed to link duplicates: %w", err)
+	}
+	current := make(map[string][]string, len(existing))
+	for _, n := range existing {
+		current[n.ID] = n.StringList(propAliases)
+	}
+	for _, id := range canonicalIDs {
+		list := current[id]
+		for _, alias := range aliases[id] {
+			if !contains(list, alias) {
+				list = append(list, alias)
+			}
+		}
+		nodes = append(nodes, graph.Node{ID: id, Properties: map[string]interface{}{propAliases: list}})
+	}
+
+	if _, err := db.store.DeleteEdges(ctx, stale); err != nil {
+		return fmt.Errorf("failed to link duplicates: %w", err)
+	}
+	if err := db.store.UpsertNodes(ctx, nodes); err != nil {
+		return fmt.Errorf("failed to link duplicates: %w", err)
+	}
+	if err := db.store.UpsertEdges(ctx, edges); err != nil {
+		return fmt.Errorf("failed to link duplicates: %w", err)
+	}
+	fmt.Printf("Linked %d near-duplicate messages to their canonical message\n", len(duplicates))
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
+	}
+	return false
+}
 
-		fmt.Printf("Message %d/%d: Found %d similar messages for ID %s\n", i+1, len(messages), len(similar), msg.ID)
+// anchorMessage creates or updates the node of a message and connects it to
+// its similar messages using isSimilar edges, through the writer
+func (db *GraphDB) anchorMessage(ctx context.Context, w *writer, msg vector.Message, similar []vector.Message) error {
+	nodes := []graph.Node{messageNode(msg)}
+	var edges []graph.Edge
+	for _, sim := range similar {
+		if sim.ID == msg.ID {
+			continue // Skip self-relationships
+		}
+		nodes = append(nodes, messageNode(sim))
+		edges = append(edges, graph.Edge{Source: msg.ID, Target: sim.ID, Type: graph.EdgeSimilar, Score: float64(sim.Score)})
+	}
+	return w.add(ctx, nodes, edges)
+}
+
+// incrementalFirstPass anchors only the messages that have no node yet, then
+// re-anchors the existing nodes that the new messages showed up next to when
+// their top K similar set changed. New and re-anchored nodes are flagged with
+// needs_classification so the second pass only classifies them. It returns
+// the near-duplicates it came across.
+func (db *GraphDB) incrementalFirstPass(ctx context.Context) ([]vector.Message, error) {
+	existing, err := db.nodeIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing nodes: %w", err)
+	}
 
-		// Create relationships in Neo4j
-		_, err = session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-			// Create or merge source node
-			_, err := tx.Run(
-				"MERGE (m:Message {id: $id}) SET m.text = $text",
-				map[string]interface{}{
-					"id":   msg.ID,
-					"text": msg.Text,
-				},
-			)
+	// Anchor the new messages and collect the existing ones they are similar to
+	w := db.newWriter()
+	touched := make(map[string]bool)
+	var changed []string
+	listed := 0
+	duplicates, err := db.listMessages(func(page []vector.Message) error {
+		listed += len(page)
+		var ids []string
+		for _, msg := range page {
+			if !existing[msg.ID] {
+				ids = append(ids, msg.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		newMessages, err := db.vectorDB.GetMessages(ids)
+		if err != nil {
+			return fmt.Errorf("failed to get messages: %w", err)
+		}
+		for _, msg := range newMessages {
+			similar, err := db.searchAnchors(msg, nil)
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("failed to search similar messages: %w", err)
 			}
 
-			// Create relationships to similar messages
-			for _, sim := range similar {
-				if sim.ID == msg.ID {
-					continue // Skip self-relationships
-				}
+			fmt.Printf("New message %d: Found %d similar messages for ID %s\n", len(changed)+1, len(similar), msg.ID)
 
-				// Create or merge source node and target node with text properties
-				_, err := tx.Run(
-					`MERGE (m:Message {id: $sourceId})
-					 SET m.text = $sourceText
-					 MERGE (n:Message {id: $targetId})
-					 SET n.text = $targetText
-					 MERGE (m)-[r:IS_SIMILAR {score: $score}]->(n)`,
-					map[string]interface{}{
-						"sourceId":   msg.ID,
-						"sourceText": msg.Text,
-						"targetId":   sim.ID,
-						"targetText": sim.Text,
-						"score":      sim.Score,
-					},
-				)
-				if err != nil {
-					return nil, err
+			if err := db.anchorMessage(ctx, w, msg, similar); err != nil {
+				return fmt.Errorf("failed to create relationships: %w", err)
+			}
+			changed = append(changed, msg.ID)
+			for _, sim := range similar {
+				if existing[sim.ID] {
+					touched[sim.ID] = true
 				}
 			}
-			return nil, nil
-		})
-		if err != nil {
-			return fmt.Errorf("failed to create relationships: %w", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.flush(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create relationships: %w", err)
 	}
 
-	// Verify all nodes have text property
-	_, err = session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		result, err := tx.Run(
-			`MATCH (m:Message)
-			 WHERE m.text IS NULL
-			 RETURN count(m) as missing_text`,
-			nil,
-		)
+	fmt.Printf("Processed %d new of %d messages from vector database\n", len(changed), listed)
+
+	// Re-anchor the existing messages whose similar set changed, fetching
+	// their embeddings a page at a time
+	ids := make([]string, 0, len(touched))
+	for id := range touched {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	reanchored := 0
+	for start := 0; start < len(ids); start += messagePageSize {
+		messages, err := db.vectorDB.GetMessages(ids[start:min(start+messagePageSize, len(ids))])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages: %w", err)
+		}
+		pruned, err := db.prunedAnchors(ctx, messageIDs(messages))
 		if err != nil {
 			return nil, err
 		}
-
-		if result.Next() {
-			missingText, _ := result.Record().Get("missing_text")
-			if missingText.(int64) > 0 {
-				fmt.Printf("Warning: Found %d nodes with missing text property\n", missingText)
-			} else {
-				fmt.Println("All nodes have text property set correctly")
+		for _, msg := range messages {
+			similar, err := db.searchAnchors(msg, pruned[msg.ID])
+			if err != nil {
+				return nil, fmt.Errorf("failed to search similar messages: %w", err)
 			}
+			current, err := db.store.Neighbors(ctx, msg.ID, graph.EdgeSimilar, graph.Outgoing, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get similar messages of %s: %w", msg.ID, err)
+			}
+			if sameAnchors(current, similar, msg.ID) {
+				continue
+			}
+			if err := db.reanchorMessage(ctx, w, msg, current, similar); err != nil {
+				return nil, fmt.Errorf("failed to re-anchor message %s: %w", msg.ID, err)
+			}
+			changed = append(changed, msg.ID)
+			reanchored++
 		}
-		return nil, nil
-	})
+	}
+	if err := w.flush(ctx); err != nil {
+		return nil, fmt.Errorf("failed to re-anchor messages: %w", err)
+	}
+
+	fmt.Printf("Re-anchored %d existing messages\n", reanchored)
+
+	return duplicates, db.markForClassification(ctx, changed)
+}
+
+// nodeIDs returns the IDs of all message nodes
+func (db *GraphDB) nodeIDs(ctx context.Context) (map[string]bool, error) {
+	nodes, err := db.store.Nodes(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to verify nodes: %w", err)
+		return nil, err
+	}
+	ids := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		ids[n.ID] = true
+	}
+	return ids, nil
+}
+
+// sameAnchors reports whether the search results match the current similar set
+func sameAnchors(current []graph.Neighbor, similar []vector.Message, selfID string) bool {
+	ids := make(map[string]bool, len(current))
+	for _, n := range current {
+		ids[n.Node.ID] = true
+	}
+	count := 0
+	for _, sim := range similar {
+		if sim.ID == selfID {
+			continue
+		}
+		if !ids[sim.ID] {
+			return false
+		}
+		count++
+	}
+	return count == len(ids)
+}
+
+// reanchorMessage replaces the isSimilar edges of an existing message node.
+// The stale edges are deleted right away and the new ones buffered in the
+// writer; as each message only has its own outgoing edges replaced, no
+// buffered edge is ever one that gets deleted.
+func (db *GraphDB) reanchorMessage(ctx context.Context, w *writer, msg vector.Message, current []graph.Neighbor, similar []vector.Message) error {
+	stale := make([]graph.Edge, len(current))
+	for i, n := range current {
+		stale[i] = n.Edge
+	}
+	if _, err := db.store.DeleteEdges(ctx, stale); err != nil {
+		return err
 	}
+	return db.anchorMessage(ctx, w, msg, similar)
+}
+
+// markForClassification flags message nodes for the incremental second pass,
+// discarding any earlier classification
+func (db *GraphDB) markForClassification(ctx context.Context, ids []string) error {
+	err := db.setProperties(ctx, ids, map[string]interface{}{propNeedsClassification: true, propClassifiedAt: nil})
+	if err != nil {
+		return fmt.Errorf("failed to mark messages for classification: %w", err)
+	}
+	return nil
+}
+
+// markClassified records that the second pass has handled a message,
+// removing its needs_classification flag
+func (db *GraphDB) markClassified(ctx context.Context, ids []string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := db.setProperties(ctx, ids, map[string]interface{}{propNeedsClassification: nil, propClassifiedAt: now})
+	if err != nil {
+		return fmt.Errorf("failed to record classified messages: %w", err)
+	}
+	return nil
+}
 
+// resetClassified discards the progress recorded by earlier second passes
+func (db *GraphDB) resetClassified(ctx context.Context, ids []string) error {
+	if err := db.setProperties(ctx, ids, map[string]interface{}{propClassifiedAt: nil}); err != nil {
+		return fmt.Errorf("failed to reset classified messages: %w", err)
+	}
 	return nil
 }
 
+// setProperties sets the same properties on every node in ids
+func (db *GraphDB) setProperties(ctx context.Context, ids []string, props map[string]interface{}) error {
+	nodes := make([]graph.Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = graph.Node{ID: id, Properties: props}
+	}
+	return db.store.UpsertNodes(ctx, nodes)
+}
+
 // parseLLMResponse parses the LLM response into structured relationships
 func (db *GraphDB) parseLLMResponse(llmResponse string) ([]Relationship, error) {
 	// Clean the response to ensure it's valid JSON
@@ -358,125 +749,223 @@ func (db *GraphDB) parseLLMResponse(llmResponse string) ([]Relationship, error)
 // SecondPass performs the second pass of graph population:
 // For each message in the graph, get its similar connections,
 // then for each connection get semantic_frontier count neighbors
-// and do pairwise LLM classification
-func (db *GraphDB) SecondPass(ctx context.Context, llm llm.LLM) error {
-	session := db.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
+// and do pairwise LLM classification.
+// In incremental mode only the messages flagged by FirstPass are classified.
+// Each message is stamped once its relationships are written, so that with
+// pipeline.resume a rerun skips the messages an interrupted pass finished;
+// pipeline.force_reclassify clears the stamps and classifies everything.
+// Batches are classified by pipeline.max_workers concurrent workers within
+// the provider's rate limit, and their relationships stored by a single
+// writer. Cancelling ctx stops sending requests; the relationships of the
+// batches already classified are still written.
+func (db *GraphDB) SecondPass(ctx context.Context, client llm.LLM) error {
 	// Get all messages with their similar connections
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		result, err := tx.Run(
-			`MATCH (m:Message)-[r:IS_SIMILAR]->(n:Message)
-			 WITH m, n, r
-			 ORDER BY r.score DESC
-			 RETURN m.id, m.text, collect({id: n.id, text: n.text, score: r.score}) as similar`,
-			nil,
-		)
-		if err != nil {
-			return nil, err
+	nodes, err := db.store.Nodes(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get messages: %w", err)
+	}
+	pipeline := db.cfg.Pipeline
+	var sources []graph.Node
+	var stamped []string
+	skipped := 0
+	for _, n := range nodes {
+		if n.Properties[propClassifiedAt] != nil {
+			stamped = append(stamped, n.ID)
 		}
-
-		var messages []struct {
-			ID      string
-			Text    string
-			Similar []message.Message
+		switch {
+		case pipeline.ForceReclassify:
+		case pipeline.Incremental && n.Properties[propNeedsClassification] != true:
+			continue
+		case pipeline.Resume && n.Properties[propClassifiedAt] != nil:
+			skipped++
+			continue
+		}
+		sources = append(sources, n)
+	}
+	if pipeline.ForceReclassify {
+		// Clear the stamps first, so that resuming an interrupted forced
+		// pass does not skip what it has not reached yet
+		if err := db.resetClassified(ctx, stamped); err != nil {
+			return err
 		}
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipping %d messages already classified\n", skipped)
+	}
 
-		for result.Next() {
-			record := result.Record()
-			id, _ := record.Get("m.id")
-			text, _ := record.Get("m.text")
-			similarInterface, _ := record.Get("similar")
-			similar := make([]message.Message, 0)
+	workers := db.cfg.Pipeline.MaxWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	fmt.Printf("Classifying %d messages with %d workers\n", len(sources), workers)
+
+	// The first failure cancels the rest of the pass
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failOnce sync.Once
+	var failure error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
 
-			if similarArray, ok := similarInterface.([]interface{}); ok {
-				for _, s := range similarArray {
-					if simMap, ok := s.(map[string]interface{}); ok {
-						similar = append(similar, message.Message{
-							ID:    simMap["id"].(string),
-							Text:  simMap["text"].(string),
-							Score: float32(simMap["score"].(float64)),
-						})
+	jobs := make(chan classificationJob)
+	results := make(chan classification)
+
+	// Batches with a malformed reply are skipped, leaving their sources
+	// unclassified for a resumed pass to retry
+	var malformed atomic.Int32
+	limiter := llm.NewLimiter(db.cfg)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var edges []graph.Edge
+				if len(job.batch) > 0 {
+					if err := limiter.Wait(ctx); err != nil {
+						continue
+					}
+					var err error
+					edges, err = db.processBatch(ctx, client, job.batch)
+					if errors.Is(err, errMalformedReply) {
+						malformed.Add(1)
+						results <- classification{handled: job.unbatched()}
+						continue
+					}
+					if err != nil {
+						if ctx.Err() == nil {
+							fail(fmt.Errorf("failed to process batch: %w", err))
+						}
+						continue
 					}
 				}
+				results <- classification{edges: edges, handled: job.handled}
 			}
+		}()
+	}
 
-			messages = append(messages, struct {
-				ID      string
-				Text    string
-				Similar []message.Message
-			}{
-				ID:      id.(string),
-				Text:    text.(string),
-				Similar: similar,
-			})
+	// A single writer stores the relationships and marks the sources
+	// classified once their relationships are written. It outlives
+	// a cancellation so that no classified batch is lost.
+	written := make(chan error, 1)
+	go func() {
+		writeCtx := context.WithoutCancel(ctx)
+		var handled []string
+		w := db.newWriter()
+		w.afterFlush = func(ctx context.Context) error {
+			err := db.markClassified(ctx, handled)
+			handled = nil
+			return err
 		}
+		var err error
+		for r := range results {
+			if err != nil {
+				continue
+			}
+			handled = append(handled, r.handled...)
+			if err = w.add(writeCtx, nil, r.edges); err != nil {
+				fail(err)
+			}
+		}
+		if err == nil {
+			err = w.flush(writeCtx)
+		}
+		written <- err
+	}()
 
-		return messages, nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to get messages: %w", err)
+	if err := db.dispatchClassification(ctx, sources, jobs); err != nil {
+		fail(err)
 	}
+	close(jobs)
+	wg.Wait()
+	close(results)
 
-	messages := result.([]struct {
-		ID      string
-		Text    string
-		Similar []message.Message
-	})
+	if err := <-written; err != nil {
+		return fmt.Errorf("failed to write relationships: %w", err)
+	}
+	if n := malformed.Load(); n > 0 {
+		fmt.Printf("Skipped %d batches with a malformed LLM reply; rerun with --resume to retry them\n", n)
+	}
+	if failure != nil {
+		return failure
+	}
+	return parent.Err()
+}
 
-	// Process messages in batches
-	var batch []struct {
+// classificationJob is one LLM request's worth of source messages with their
+// frontiers, along with the sources it completes, which include those
+// skipped for having no frontier
+type classificationJob struct {
+	batch []struct {
 		SourceMessage    message.Message
 		FrontierMessages []message.Message
 	}
+	handled []string
+}
+
+// unbatched returns the handled sources that are not in the batch
+func (j classificationJob) unbatched() []string {
+	batched := make(map[string]bool, len(j.batch))
+	for _, pair := range j.batch {
+		batched[pair.SourceMessage.ID] = true
+	}
+	var ids []string
+	for _, id := range j.handled {
+		if !batched[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// classification is the outcome of a classificationJob
+type classification struct {
+	edges   []graph.Edge
+	handled []string
+}
+
+// dispatchClassification gathers the frontiers of the sources into batches
+// of llm.inference_batch_size and sends them to the workers until ctx is done
+func (db *GraphDB) dispatchClassification(ctx context.Context, sources []graph.Node, jobs chan<- classificationJob) error {
 	batchSize := db.cfg.LLM.InferenceBatchSize
+	var job classificationJob
+	send := func() bool {
+		select {
+		case jobs <- job:
+			job = classificationJob{}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for _, msg := range sources {
+		if ctx.Err() != nil {
+			return nil
+		}
+		similar, err := db.store.Neighbors(ctx, msg.ID, graph.EdgeSimilar, graph.Outgoing, 0)
+		if err != nil {
+			return fmt.Errorf("failed to get similar messages: %w", err)
+		}
+		if len(similar) == 0 {
+			continue
+		}
 
-	for _, msg := range messages {
 		// Get all frontier messages for this source message
 		var allFrontierMsgs []message.Message
 
 		// For each similar message, get its frontier
-		for _, sim := range msg.Similar {
-			frontier, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-				result, err := tx.Run(
-					`MATCH (n:Message {id: $id})-[r:IS_SIMILAR]->(f:Message)
-					 WHERE f.id <> $sourceId
-					 RETURN f.id, f.text, r.score
-					 ORDER BY r.score DESC
-					 LIMIT $limit`,
-					map[string]interface{}{
-						"id":       sim.ID,
-						"sourceId": msg.ID,
-						"limit":    db.cfg.GraphDB.SemanticFrontier,
-					},
-				)
-				if err != nil {
-					return nil, err
-				}
-
-				var frontier []message.Message
-				for result.Next() {
-					record := result.Record()
-					id, _ := record.Get("f.id")
-					text, _ := record.Get("f.text")
-					score, _ := record.Get("r.score")
-
-					frontier = append(frontier, message.Message{
-						ID:    id.(string),
-						Text:  text.(string),
-						Score: float32(score.(float64)),
-					})
-				}
-
-				return frontier, nil
-			})
+		for _, sim := range similar {
+			frontier, err := db.frontier(ctx, sim.Node.ID, msg.ID)
 			if err != nil {
 				return fmt.Errorf("failed to get frontier: %w", err)
 			}
-
-			frontierMsgs := frontier.([]message.Message)
-			allFrontierMsgs = append(allFrontierMsgs, frontierMsgs...)
+			allFrontierMsgs = append(allFrontierMsgs, frontier...)
 		}
 		// Remove duplicate frontier messages by using a map
 		seen := make(map[string]bool)
@@ -489,43 +978,61 @@ func (db *GraphDB) SecondPass(ctx context.Context, llm llm.LLM) error {
 		}
 		allFrontierMsgs = uniqueFrontier
 
+		job.handled = append(job.handled, msg.ID)
 		if len(allFrontierMsgs) == 0 {
 			fmt.Printf("Skipping message %s: no frontier messages found\n", msg.ID)
 			continue
 		}
 
 		// Add to batch
-		batch = append(batch, struct {
+		job.batch = append(job.batch, struct {
 			SourceMessage    message.Message
 			FrontierMessages []message.Message
 		}{
-			SourceMessage:    message.Message{ID: msg.ID, Text: msg.Text},
+			SourceMessage:    message.Message{ID: msg.ID, Text: msg.Text, Metadata: message.MetadataFromProperties(msg.Properties)},
 			FrontierMessages: allFrontierMsgs,
 		})
 
-		// Process batch when it reaches the desired size
-		if len(batch) >= batchSize {
-			if err := db.processBatch(ctx, llm, session, batch); err != nil {
-				return fmt.Errorf("failed to process batch: %w", err)
-			}
-			batch = nil // Clear the batch
+		// Send batch when it reaches the desired size
+		if len(job.batch) >= batchSize && !send() {
+			return nil
 		}
 	}
 
-	// Process any remaining messages in the final batch
-	if len(batch) > 0 {
-		if err := db.processBatch(ctx, llm, session, batch); err != nil {
-			return fmt.Errorf("failed to process final batch: %w", err)
-		}
+	// Send any remaining messages in the final batch
+	if len(job.handled) > 0 {
+		send()
 	}
-
 	return nil
 }
 
+// frontier returns the semantic_frontier messages a similar message of a
+// source is most similar to, leaving out the source itself
+func (db *GraphDB) frontier(ctx context.Context, similarID, sourceID string) ([]message.Message, error) {
+	limit := db.cfg.GraphDB.SemanticFrontier
+	neighbors, err := db.store.Neighbors(ctx, similarID, graph.EdgeSimilar, graph.Outgoing, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	var frontier []message.Message
+	for _, n := range neighbors {
+		if n.Node.ID == sourceID || len(frontier) == limit {
+			continue
+		}
+		frontier = append(frontier, message.Message{
+			ID:       n.Node.ID,
+			Text:     n.Node.Text,
+			Score:    float32(n.Edge.Score),
+			Metadata: message.MetadataFromProperties(n.Node.Properties),
+		})
+	}
+	return frontier, nil
+}
+
 
 // validateRelationshipsAgainstBatch checks if relationships match their batch entries
 // some validation that the LLM is upto some good standard
-func (db *GraphDB) validateRelationshipsAgainstBatch(relationships []Relationship, batch []struct {
+func (db *GraphDB) validateRelationshipsAgainstBatch(log io.Writer, relationships []Relationship, batch []struct {
 	SourceMessage    message.Message
 	FrontierMessages []message.Message
 }) {
@@ -543,7 +1050,7 @@ func (db *GraphDB) validateRelationshipsAgainstBatch(relationships []Relationshi
 		}
 
 		if batchEntry == nil {
-			fmt.Fprintf(db.logFile, "Warning: Relationship contains source message %s not found in current batch\n", rel.SourceID)
+			fmt.Fprintf(log, "Warning: Relationship contains source message %s not found in current batch\n", rel.SourceID)
 			continue
 		}
 
@@ -557,170 +1064,140 @@ func (db *GraphDB) validateRelationshipsAgainstBatch(relationships []Relationshi
 		}
 
 		if !targetInFrontier {
-			fmt.Fprintf(db.logFile, "Warning: Relationship target %s not in frontier for source %s\n", 
+			fmt.Fprintf(log, "Warning: Relationship target %s not in frontier for source %s\n", 
 				rel.TargetID, rel.SourceID)
 		}
 	}
 }
 
 
-// processBatch handles the LLM inference and relationship creation for a batch of messages
-func (db *GraphDB) processBatch(ctx context.Context, llm llm.LLM, session neo4j.Session, batch []struct {
+// processBatch handles the LLM inference for a batch of messages and returns
+// the relationships to create between existing nodes. Workers run it
+// concurrently, so its log entry is written in one piece at the end.
+func (db *GraphDB) processBatch(ctx context.Context, client llm.LLM, batch []struct {
 	SourceMessage    message.Message
 	FrontierMessages []message.Message
-}) error {
+}) ([]graph.Edge, error) {
+	log := &strings.Builder{}
+	defer db.writeLog(log)
+
 	// Get LLM prompt for the batch
 	llmPrompt, err := db.GetLLMPrompt(batch)
 	if err != nil {
-		return fmt.Errorf("failed to generate LLM prompt: %w", err)
+		return nil, fmt.Errorf("failed to generate LLM prompt: %w", err)
 	}
 
 	// Write prompt with timestamp and separator
-	fmt.Fprintf(db.logFile, "\n=== Batch Processing at %s ===\n", time.Now().Format(time.RFC3339))
-	fmt.Fprintf(db.logFile, "Batch Size: %d\n\n", len(batch))
+	fmt.Fprintf(log, "\n=== Batch Processing at %s ===\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(log, "Batch Size: %d\n\n", len(batch))
 
-	fmt.Fprintf(db.logFile, "=== Source Messages ===\n")
+	fmt.Fprintf(log, "=== Source Messages ===\n")
 	for _, pair := range batch {
-		fmt.Fprintf(db.logFile, "Source ID: %s\n", pair.SourceMessage.ID)
-		fmt.Fprintf(db.logFile, "Source Text: %s\n", pair.SourceMessage.Text)
-		fmt.Fprintf(db.logFile, "Frontier Count: %d\n\n", len(pair.FrontierMessages))
+		fmt.Fprintf(log, "Source ID: %s\n", pair.SourceMessage.ID)
+		fmt.Fprintf(log, "Source Text: %s\n", pair.SourceMessage.Text)
+		fmt.Fprintf(log, "Frontier Count: %d\n\n", len(pair.FrontierMessages))
 	}
 
-	fmt.Fprintf(db.logFile, "=== LLM Prompt ===\n")
-	fmt.Fprintf(db.logFile, "%s\n\n", llmPrompt.Instructions)
+	fmt.Fprintf(log, "=== LLM Prompt ===\n")
+	fmt.Fprintf(log, "%s\n\n", llmPrompt.Instructions)
 
 	// Get LLM inference
-	llmResponse, err := llm.GetInference(llmPrompt.Instructions, db.cfg.LLM.SystemPrompt)
+	llmResponse, err := llm.Infer(ctx, client, llmPrompt.Instructions, db.cfg.LLM.SystemPrompt)
 	if err != nil {
-		fmt.Fprintf(db.logFile, "=== Error ===\n")
-		fmt.Fprintf(db.logFile, "Failed to get LLM response: %v\n", err)
-		return fmt.Errorf("failed to get LLM response: %w", err)
+		fmt.Fprintf(log, "=== Error ===\n")
+		fmt.Fprintf(log, "Failed to get LLM response: %v\n", err)
+		return nil, fmt.Errorf("failed to get LLM response: %w", err)
 	}
 
 	// Log the response
-	fmt.Fprintf(db.logFile, "=== LLM Response ===\n")
-	fmt.Fprintf(db.logFile, "%s\n\n", llmResponse)
+	fmt.Fprintf(log, "=== LLM Response ===\n")
+	fmt.Fprintf(log, "%s\n\n", llmResponse)
 
 	// Parse the LLM response
 	relationships, err := db.parseLLMResponse(llmResponse)
 	if err != nil {
-		fmt.Fprintf(db.logFile, "=== Parse Error ===\n")
-		fmt.Fprintf(db.logFile, "Failed to parse response: %v\n", err)
-		return nil
+		fmt.Fprintf(log, "=== Parse Error ===\n")
+		fmt.Fprintf(log, "Failed to parse response: %v\n", err)
+		return nil, fmt.Errorf("%w: %v", errMalformedReply, err)
 	}
 
 	// some validation on parsed relationships
-	db.validateRelationshipsAgainstBatch(relationships, batch)
+	db.validateRelationshipsAgainstBatch(log, relationships, batch)
 
-	fmt.Fprintf(db.logFile, "=== Parsed Relationships ===\n")
+	fmt.Fprintf(log, "=== Parsed Relationships ===\n")
 	for _, rel := range relationships {
-		fmt.Fprintf(db.logFile, "Source: %s, Target: %s, Type: %s, Confidence: %.2f\n",
+		fmt.Fprintf(log, "Source: %s, Target: %s, Type: %s, Confidence: %.2f\n",
 			rel.SourceID, rel.TargetID, rel.Relation, rel.Confidence)
 	}
 
-	// Create relationships in Neo4j
-	_, err = session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		for _, rel := range relationships {
-			// Skip relationships with empty source or target IDs
-			if rel.SourceID == "" || rel.TargetID == "" {
-				fmt.Fprintf(db.logFile, "Warning: Skipping relationship with empty ID - Source: '%s', Target: '%s'\n",
-					rel.SourceID, rel.TargetID)
-				continue
-			}
-
-			// Check if both nodes exist
-			result, err := tx.Run(
-				`MATCH (m:Message {id: $sourceId})
-				 MATCH (n:Message {id: $targetId})
-				 RETURN count(*) > 0 as exists`,
-				map[string]interface{}{
-					"sourceId": rel.SourceID,
-					"targetId": rel.TargetID,
-				},
-			)
-			if err != nil {
-				return nil, err
-			}
-
-			exists := false
-			if result.Next() {
-				existsInterface, _ := result.Record().Get("exists")
-				exists = existsInterface.(bool)
-			}
-
-			if !exists {
-				fmt.Fprintf(db.logFile, "Warning: Skipping relationship - nodes not found - Source: '%s', Target: '%s'\n",
-					rel.SourceID, rel.TargetID)
-				continue
-			}
-
-			// Log the relationship being created
-			fmt.Fprintf(db.logFile, "Creating relationship: Source: '%s', Target: '%s', Type: '%s', Confidence: %.2f\n",
-				rel.SourceID, rel.TargetID, rel.Relation, rel.Confidence)
-
-			// Create the relationship
-			_, err = tx.Run(
-				`MATCH (m:Message {id: $sourceId})
-				 MATCH (n:Message {id: $targetId})
-				 MERGE (m)-[r:RELATED_TO {type: $relationType, confidence: $confidence, evidence: $evidence}]->(n)`,
-				map[string]interface{}{
-					"sourceId":     rel.SourceID,
-					"targetId":     rel.TargetID,
-					"relationType": rel.Relation,
-					"confidence":   rel.Confidence,
-					"evidence":     rel.Evidence,
-				},
-			)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return nil, nil
-	})
-
+	// Create relationships between existing nodes only
+	ids := make([]string, 0, 2*len(relationships))
+	for _, rel := range relationships {
+		ids = append(ids, rel.SourceID, rel.TargetID)
+	}
+	nodes, err := db.store.Nodes(ctx, ids)
 	if err != nil {
-		fmt.Fprintf(db.logFile, "\n=== Database Error ===\n")
-		fmt.Fprintf(db.logFile, "Failed to create relationships: %v\n", err)
+		fmt.Fprintf(log, "\n=== Database Error ===\n")
+		fmt.Fprintf(log, "Failed to read nodes: %v\n", err)
+		return nil, err
+	}
+	exists := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		exists[n.ID] = true
 	}
 
-	fmt.Fprintf(db.logFile, "\n=== End of Batch ===\n")
-	return err
-}
-
-// GetMessageByID retrieves a message from Neo4j by its ID
-func (db *GraphDB) GetMessageByID(session neo4j.Session, id string) (*message.Message, error) {
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		result, err := tx.Run(
-			"MATCH (m:Message {id: $id}) RETURN m.id, m.text",
-			map[string]interface{}{
-				"id": id,
-			},
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute query: %w", err)
+	var edges []graph.Edge
+	for _, rel := range relationships {
+		// Skip relationships with empty source or target IDs
+		if rel.SourceID == "" || rel.TargetID == "" {
+			fmt.Fprintf(log, "Warning: Skipping relationship with empty ID - Source: '%s', Target: '%s'\n",
+				rel.SourceID, rel.TargetID)
+			continue
 		}
 
-		if !result.Next() {
-			return nil, nil
+		if !exists[rel.SourceID] || !exists[rel.TargetID] {
+			fmt.Fprintf(log, "Warning: Skipping relationship - nodes not found - Source: '%s', Target: '%s'\n",
+				rel.SourceID, rel.TargetID)
+			continue
 		}
 
-		record := result.Record()
-		id, _ := record.Get("m.id")
-		text, _ := record.Get("m.text")
+		// Log the relationship being created
+		fmt.Fprintf(log, "Creating relationship: Source: '%s', Target: '%s', Type: '%s', Confidence: %.2f\n",
+			rel.SourceID, rel.TargetID, rel.Relation, rel.Confidence)
 
-		return &message.Message{
-			ID:   id.(string),
-			Text: text.(string),
-		}, nil
-	})
+		edges = append(edges, graph.Edge{
+			Source:     rel.SourceID,
+			Target:     rel.TargetID,
+			Type:       graph.EdgeRelated,
+			Relation:   rel.Relation,
+			Confidence: rel.Confidence,
+			Evidence:   rel.Evidence,
+		})
+	}
+
+	fmt.Fprintf(log, "\n=== End of Batch ===\n")
+	return edges, nil
+}
+
+// writeLog appends a log entry to the run log
+func (db *GraphDB) writeLog(entry *strings.Builder) {
+	db.logMu.Lock()
+	defer db.logMu.Unlock()
+	io.WriteString(db.logFile, entry.String())
+}
 
+// GetMessageByID retrieves a message node by its ID, or nil if there is none
+func (db *GraphDB) GetMessageByID(ctx context.Context, id string) (*message.Message, error) {
+	nodes, err := db.store.Nodes(ctx, []string{id})
 	if err != nil {
 		return nil, err
 	}
-
-	if result == nil {
+	if len(nodes) == 0 {
 		return nil, nil
 	}
-
-	return result.(*message.Message), nil
+	return &message.Message{
+		ID:       nodes[0].ID,
+		Text:     nodes[0].Text,
+		Metadata: message.MetadataFromProperties(nodes[0].Properties),
+	}, nil
 }
@@ -2,12 +2,16 @@ package graphdb
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/graph"
 	"github.com/yourusername/psagents/internal/message"
 	"github.com/yourusername/psagents/internal/vector"
 )
@@ -35,42 +39,125 @@ func (m *MockVectorDB) GetAllMessages() ([]vector.Message, error) {
 	return messages, nil
 }
 
-func (m *MockVectorDB) Search(embedding []float32, limit int) ([]vector.Message, error) {
-	// For testing, just return all messages up to the limit
-	messages := make([]vector.Message, 0, limit)
-	count := 0
-	for _, msg := range m.messages {
-		if count >= limit {
-			break
+func (m *MockVectorDB) ListMessages(pageSize int, fn func(page []vector.Message) error) error {
+	messages, _ := m.GetAllMessages()
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	for start := 0; start < len(messages); start += pageSize {
+		page := make([]vector.Message, 0, pageSize)
+		for _, msg := range messages[start:min(start+pageSize, len(messages))] {
+			msg.Embedding = nil
+			page = append(page, msg)
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockVectorDB) GetMessages(ids []string) ([]vector.Message, error) {
+	var messages []vector.Message
+	for _, id := range ids {
+		if msg, ok := m.messages[id]; ok {
+			messages = append(messages, msg)
 		}
-		messages = append(messages, msg)
-		count++
 	}
 	return messages, nil
 }
 
+func (m *MockVectorDB) Search(embedding []float32, limit int, filter *vector.Filter) ([]vector.Message, error) {
+	// For testing, just return all messages up to the limit, in ID order
+	messages, _ := m.GetAllMessages()
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (m *MockVectorDB) Delete(ids []string) (int, error) {
+	removed := 0
+	for _, id := range ids {
+		if _, ok := m.messages[id]; ok {
+			delete(m.messages, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// MockLLM answers every prompt with the same relationships
+type MockLLM struct {
+	response string
+	prompts  atomic.Int32
+}
+
+func (m *MockLLM) GetInference(prompt string, systemPrompt string) (string, error) {
+	m.prompts.Add(1)
+	return m.response, nil
+}
+
+func (m *MockLLM) HealthCheck() error {
+	return nil
+}
+
+func (m *MockLLM) Close() error {
+	return nil
+}
+
+// chdirWithPrompts switches to dir for the rest of the test, with the prompt
+// schemas NewGraphDB loads copied under data/prompts
+func chdirWithPrompts(t *testing.T, dir string) {
+	t.Helper()
+	promptsDir := filepath.Join(dir, "data", "prompts")
+	if err := os.MkdirAll(promptsDir, 0755); err != nil {
+		t.Fatalf("Failed to create prompts directory: %v", err)
+	}
+	for _, name := range []string{"inputschema.json", "outputschema.json"} {
+		data, err := os.ReadFile(filepath.Join("..", "..", "data", "prompts", name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(promptsDir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
 func TestGraphDB(t *testing.T) {
 	// Create a temporary directory for testing
 	tmpDir := t.TempDir()
+	chdirWithPrompts(t, tmpDir)
 
 	// Create test configuration with test-specific values
 	testCfg := &config.Config{
 		GraphDB: config.GraphDBConfig{
-			Type:              "neo4j",
-			Host:             "localhost",
-			Port:             7687,
-			Username:         "neo4j",
-			Password:         "testpassword",
-			SimilarityAnchors: 2, // Use smaller values for testing
+			Type:              TypeEmbedded,
+			Path:              filepath.Join(tmpDir, "graph"),
+			SimilarityAnchors: 3, // Use smaller values for testing
 			SemanticFrontier:  2,
+			WriteBatchSize:    4, // Flush the anchors of every message
 		},
+		LLM: config.LLMConfig{
+			InferenceBatchSize: 2,
+		},
+		Logging:  config.LoggingConfig{Level: "error"},
+		Pipeline: config.PipelineConfig{MaxWorkers: 2},
 		Qdrant: config.QdrantConfig{
 			Enabled:        true,
 			Path:          filepath.Join(tmpDir, "qdrant"),
 			CollectionName: "test_embeddings",
 			VectorSize:     384, // Use smaller vector size for testing
 			Distance:       "Cosine",
-			TestMode:       true,
 		},
 	}
 
@@ -106,34 +193,30 @@ func TestGraphDB(t *testing.T) {
 			},
 		}
 
-		prompt, err := graphDB.GetLLMPrompt(sourceMsg, frontierMsgs)
+		prompt, err := graphDB.GetLLMPrompt([]struct {
+			SourceMessage    message.Message
+			FrontierMessages []message.Message
+		}{{SourceMessage: sourceMsg, FrontierMessages: frontierMsgs}})
 		if err != nil {
 			t.Fatalf("Failed to get LLM prompt: %v", err)
 		}
 
-		// Verify prompt structure
+		// The schemas and input are all part of the JSON instructions
 		if prompt.Instructions == "" {
 			t.Error("Expected non-empty instructions")
 		}
-		if prompt.InputSchema == "" {
-			t.Error("Expected non-empty input schema")
-		}
-		if prompt.OutputSchema == "" {
-			t.Error("Expected non-empty output schema")
-		}
-		if prompt.Input == "" {
-			t.Error("Expected non-empty input")
+		for _, key := range []string{`"input_schema"`, `"output_schema"`, `"input"`} {
+			if !strings.Contains(prompt.Instructions, key) {
+				t.Errorf("Expected instructions to contain %s", key)
+			}
 		}
 
 		// Verify prompt content
-		if !strings.Contains(prompt.Instructions, "Classify the relationships") {
-			t.Error("Expected instructions to contain relationship classification guidance")
-		}
-		if !strings.Contains(prompt.Input, sourceMsg.Text) {
+		if !strings.Contains(prompt.Instructions, sourceMsg.Text) {
 			t.Error("Expected input to contain source message text")
 		}
 		for _, msg := range frontierMsgs {
-			if !strings.Contains(prompt.Input, msg.Text) {
+			if !strings.Contains(prompt.Instructions, msg.Text) {
 				t.Error("Expected input to contain frontier message text")
 			}
 		}
@@ -166,10 +249,252 @@ func TestGraphDB(t *testing.T) {
 		if err := graphDB.FirstPass(ctx); err != nil {
 			t.Fatalf("Failed to execute first pass: %v", err)
 		}
+		stats, err := graphDB.Store().Stats(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get graph stats: %v", err)
+		}
+		if stats.Nodes != 3 || stats.Edges[graph.EdgeSimilar] != 6 {
+			t.Errorf("Expected 3 nodes each similar to the 2 others, got %+v", stats)
+		}
 
 		// Test SecondPass
-		if err := graphDB.SecondPass(ctx); err != nil {
+		mockLLM := &MockLLM{response: `[{"source_id": "test1", "target_id": "test3", "relation": "Elaboration", "confidence": 0.9, "evidence": "both are test messages"},
+			{"source_id": "test1", "target_id": "missing", "relation": "Elaboration", "confidence": 0.9, "evidence": "no such node"}]`}
+		// A cancelled pass sends nothing
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := graphDB.SecondPass(cancelled, mockLLM); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected a cancelled second pass, got %v", err)
+		}
+		if n := mockLLM.prompts.Load(); n != 0 {
+			t.Errorf("Expected no prompts after cancellation, got %d", n)
+		}
+
+		if err := graphDB.SecondPass(ctx, mockLLM); err != nil {
+			t.Fatalf("Failed to execute second pass: %v", err)
+		}
+		if n := mockLLM.prompts.Load(); n != 2 {
+			t.Errorf("Expected 2 batches of at most 2 messages, got %d", n)
+		}
+		related, err := graphDB.Store().Edges(ctx, graph.EdgeRelated)
+		if err != nil {
+			t.Fatalf("Failed to get relationships: %v", err)
+		}
+		if len(related) != 1 || related[0].Source != "test1" || related[0].Target != "test3" || related[0].Confidence != 0.9 {
+			t.Errorf("Expected one relationship between existing nodes, got %+v", related)
+		}
+
+		// A resumed pass only classifies the messages without a stamp
+		nodes, err := graphDB.Store().Nodes(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to get nodes: %v", err)
+		}
+		for _, n := range nodes {
+			if n.Properties[propClassifiedAt] == nil {
+				t.Errorf("Expected message %s to be marked classified", n.ID)
+			}
+		}
+		err = graphDB.Store().UpsertNodes(ctx, []graph.Node{{ID: "test3", Properties: map[string]interface{}{propClassifiedAt: nil}}})
+		if err != nil {
+			t.Fatalf("Failed to reset a classified message: %v", err)
+		}
+		testCfg.Pipeline.Resume = true
+		mockLLM.prompts.Store(0)
+		if err := graphDB.SecondPass(ctx, mockLLM); err != nil {
+			t.Fatalf("Failed to resume second pass: %v", err)
+		}
+		if n := mockLLM.prompts.Load(); n != 1 {
+			t.Errorf("Expected only the unclassified message to be sent, got %d prompts", n)
+		}
+
+		// Forcing classifies everything again
+		testCfg.Pipeline.Resume, testCfg.Pipeline.ForceReclassify = false, true
+		mockLLM.prompts.Store(0)
+		if err := graphDB.SecondPass(ctx, mockLLM); err != nil {
+			t.Fatalf("Failed to force second pass: %v", err)
+		}
+		if n := mockLLM.prompts.Load(); n != 2 {
+			t.Errorf("Expected every message to be sent again, got %d prompts", n)
+		}
+
+		// Messages whose batch got a malformed reply stay unclassified
+		malformedLLM := &MockLLM{response: "no relationships here"}
+		if err := graphDB.SecondPass(ctx, malformedLLM); err != nil {
 			t.Fatalf("Failed to execute second pass: %v", err)
 		}
+		nodes, err = graphDB.Store().Nodes(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to get nodes: %v", err)
+		}
+		for _, n := range nodes {
+			if n.Properties[propClassifiedAt] != nil {
+				t.Errorf("Expected message %s to stay unclassified after a malformed reply", n.ID)
+			}
+		}
+		testCfg.Pipeline.ForceReclassify = false
+
+		msg, err := graphDB.GetMessageByID(ctx, "test2")
+		if err != nil || msg == nil || msg.Text != "Second test message" {
+			t.Errorf("Expected the second test message, got %+v (%v)", msg, err)
+		}
+		if msg, err := graphDB.GetMessageByID(ctx, "missing"); err != nil || msg != nil {
+			t.Errorf("Expected no message for an unknown ID, got %+v (%v)", msg, err)
+		}
 	})
-}
\ No newline at end of file
+}
+
+func TestCompress(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		GraphDB:    config.GraphDBConfig{Type: TypeEmbedded, Path: filepath.Join(t.TempDir(), "graph"), MaxDegree: 2},
+		Embeddings: config.EmbeddingsConfig{SimilarityThreshold: 0.8},
+		Inference:  config.InferenceConfig{MinConfidence: 0.7},
+		Logging:    config.LoggingConfig{Level: "error"},
+	}
+	store, err := NewStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	err = store.UpsertEdges(ctx, []graph.Edge{
+		{Source: "a", Target: "b", Type: graph.EdgeSimilar, Score: 0.95},
+		{Source: "b", Target: "a", Type: graph.EdgeSimilar, Score: 0.95}, // mutual
+		{Source: "a", Target: "c", Type: graph.EdgeSimilar, Score: 0.5},  // below threshold
+		{Source: "c", Target: "a", Type: graph.EdgeSimilar, Score: 0.9},
+		{Source: "a", Target: "d", Type: graph.EdgeSimilar, Score: 0.85}, // over degree
+		{Source: "a", Target: "c", Type: graph.EdgeRelated, Relation: string(message.RelationTopicSwitch), Confidence: 0.9},
+		{Source: "b", Target: "c", Type: graph.EdgeRelated, Relation: "Elaboration", Confidence: 0.4},
+		{Source: "b", Target: "d", Type: graph.EdgeRelated, Relation: "Elaboration", Confidence: 0.9},
+		{Source: "d", Target: "c", Type: graph.EdgeDuplicate, Score: 0.99},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert edges: %v", err)
+	}
+
+	graphDB := &GraphDB{cfg: cfg, store: store}
+	stats, err := graphDB.Compress(ctx)
+	if err != nil {
+		t.Fatalf("Failed to compress graph: %v", err)
+	}
+	if stats.BelowThreshold != 1 || stats.TopicSwitches != 1 || stats.LowConfidence != 1 ||
+		stats.Mutual != 1 || stats.OverDegree != 1 || stats.Removed() != 5 {
+		t.Errorf("Unexpected compression stats %+v", stats)
+	}
+	if stats.Before.TotalEdges() != 9 || stats.After.TotalEdges() != 4 || stats.After.Nodes != 4 {
+		t.Errorf("Expected 9 edges compressed to 4 on 4 nodes, got %+v and %+v", stats.Before, stats.After)
+	}
+
+	edges, err := store.Edges(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to get edges: %v", err)
+	}
+	var kept []string
+	for _, e := range edges {
+		kept = append(kept, e.Source+"-"+e.Target+":"+e.Type)
+	}
+	sort.Strings(kept)
+	want := []string{"a-b:IS_SIMILAR", "b-d:RELATED_TO", "c-a:IS_SIMILAR", "d-c:DUPLICATE_OF"}
+	if strings.Join(kept, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected edges %v, got %v", want, kept)
+	}
+}
+
+func TestCompressThenIncrementalFirstPass(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	chdirWithPrompts(t, tmpDir)
+	cfg := &config.Config{
+		GraphDB:    config.GraphDBConfig{Type: TypeEmbedded, Path: filepath.Join(tmpDir, "graph"), SimilarityAnchors: 3, MaxDegree: 2},
+		Embeddings: config.EmbeddingsConfig{SimilarityThreshold: 0.8},
+		Logging:    config.LoggingConfig{Level: "error"},
+		Pipeline:   config.PipelineConfig{Incremental: true},
+	}
+	vectorDB := NewMockVectorDB()
+	for id, score := range map[string]float32{"m1": 0.9, "m2": 0.95, "m3": 0.5, "m4": 0.9, "m5": 0.9} {
+		vectorDB.messages[id] = vector.Message{ID: id, Text: id, Score: score}
+	}
+	graphDB, err := NewGraphDB(cfg, vectorDB)
+	if err != nil {
+		t.Fatalf("Failed to create graph database: %v", err)
+	}
+	defer graphDB.Close()
+
+	if err := graphDB.FirstPass(ctx); err != nil {
+		t.Fatalf("Failed to execute first pass: %v", err)
+	}
+	ids := []string{"m1", "m2", "m3", "m4", "m5"}
+	if err := graphDB.markClassified(ctx, ids); err != nil {
+		t.Fatalf("Failed to mark messages classified: %v", err)
+	}
+	stats, err := graphDB.Compress(ctx)
+	if err != nil {
+		t.Fatalf("Failed to compress graph: %v", err)
+	}
+	if stats.BelowThreshold == 0 || stats.Mutual == 0 || stats.OverDegree == 0 {
+		t.Fatalf("Expected edges removed for every IS_SIMILAR reason, got %+v", stats)
+	}
+	before, err := graphDB.Store().Edges(ctx, graph.EdgeSimilar)
+	if err != nil {
+		t.Fatalf("Failed to get edges: %v", err)
+	}
+
+	// m9 is anchored to m1, m2 and m3 without entering their top K, so
+	// their anchors are unchanged once the pruned ones are left out and only
+	// m9 is sent to the second pass
+	vectorDB.messages["m9"] = vector.Message{ID: "m9", Text: "m9", Score: 0.9}
+	if err := graphDB.FirstPass(ctx); err != nil {
+		t.Fatalf("Failed to execute incremental first pass: %v", err)
+	}
+	nodes, err := graphDB.Store().Nodes(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get nodes: %v", err)
+	}
+	for _, n := range nodes {
+		if flagged := n.Properties[propNeedsClassification] != nil; flagged != (n.ID == "m9") {
+			t.Errorf("Expected only m9 to need classification, %s has needs_classification %v", n.ID, flagged)
+		}
+	}
+	after, err := graphDB.Store().Edges(ctx, graph.EdgeSimilar)
+	if err != nil {
+		t.Fatalf("Failed to get edges: %v", err)
+	}
+	if len(after) != len(before)+3 {
+		t.Errorf("Expected the %d compressed IS_SIMILAR edges and m9's 3, got %d", len(before), len(after))
+	}
+
+	// A new message re-anchors the nodes it is similar to, still without
+	// their pruned anchors
+	vectorDB.messages["m0"] = vector.Message{ID: "m0", Text: "m0", Score: 0.9}
+	if err := graphDB.FirstPass(ctx); err != nil {
+		t.Fatalf("Failed to execute incremental first pass: %v", err)
+	}
+	pruned, err := graphDB.prunedAnchors(ctx, ids)
+	if err != nil {
+		t.Fatalf("Failed to get pruned anchors: %v", err)
+	}
+	for _, id := range ids {
+		neighbors, err := graphDB.Store().Neighbors(ctx, id, graph.EdgeSimilar, graph.Outgoing, 0)
+		if err != nil {
+			t.Fatalf("Failed to get neighbors of %s: %v", id, err)
+		}
+		for _, n := range neighbors {
+			if contains(pruned[id], n.Node.ID) {
+				t.Errorf("Expected the pruned anchor %s of %s not to be added back", n.Node.ID, id)
+			}
+		}
+	}
+
+	// Forgetting a message drops it from the pruned anchors too
+	if _, err := graphDB.Forget(ctx, []string{"m1"}, nil); err != nil {
+		t.Fatalf("Failed to forget m1: %v", err)
+	}
+	pruned, err = graphDB.prunedAnchors(ctx, ids)
+	if err != nil {
+		t.Fatalf("Failed to get pruned anchors: %v", err)
+	}
+	for id, list := range pruned {
+		if contains(list, "m1") {
+			t.Errorf("Expected m1 to be dropped from the pruned anchors of %s, got %v", id, list)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package graphdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/graph"
+	"github.com/yourusername/psagents/internal/message"
+)
+
+// MigrationStats counts what MigrateIDs changed
+type MigrationStats struct {
+	Renamed int // nodes whose ID was rewritten in place
+	Merged  int // nodes folded into an existing node with the canonical ID
+}
+
+// MigrateIDs rewrites message nodes whose ID is not their canonical message
+// ID (the SHA-256 of their text and metadata), such as the point UUIDs older
+// Qdrant ingests stored or the text hashes of graphs built before metadata
+// was part of the ID. When a node with the canonical ID already exists, the
+// edges of the old node are moved onto it and the old node is deleted.
+func (db *GraphDB) MigrateIDs(ctx context.Context) (MigrationStats, error) {
+	var stats MigrationStats
+	nodes, err := db.store.Nodes(ctx, nil)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read message nodes: %w", err)
+	}
+	existing := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		existing[n.ID] = true
+	}
+
+	for _, n := range nodes {
+		if n.Text == "" {
+			continue
+		}
+		canonical := embeddings.MessageID(n.Text, message.MetadataFromProperties(n.Properties))
+		if n.ID == canonical {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		if !existing[canonical] {
+			if err := db.moveNode(ctx, n, canonical, true); err != nil {
+				return stats, fmt.Errorf("failed to rename node %s: %w", n.ID, err)
+			}
+			existing[canonical] = true
+			stats.Renamed++
+			continue
+		}
+
+		if err := db.moveNode(ctx, n, canonical, false); err != nil {
+			return stats, fmt.Errorf("failed to merge node %s into %s: %w", n.ID, canonical, err)
+		}
+		stats.Merged++
+	}
+
+	return stats, nil
+}
+
+// moveNode moves the edges of a node onto the node with another ID, copying
+// its text and properties over first when rename is set, then deletes it.
+// Edges between the two nodes are dropped.
+func (db *GraphDB) moveNode(ctx context.Context, n graph.Node, newID string, rename bool) error {
+	neighbors, err := db.store.Neighbors(ctx, n.ID, "", graph.Both, 0)
+	if err != nil {
+		return err
+	}
+	var moved []graph.Edge
+	for _, nb := range neighbors {
+		edge := nb.Edge
+		if edge.Source == n.ID {
+			edge.Source = newID
+		}
+		if edge.Target == n.ID {
+			edge.Target = newID
+		}
+		if edge.Source == edge.Target {
+			continue
+		}
+		moved = append(moved, edge)
+	}
+
+	if rename {
+		if err := db.store.UpsertNodes(ctx, []graph.Node{{ID: newID, Text: n.Text, Properties: n.Properties}}); err != nil {
+			return err
+		}
+	}
+	if err := db.store.UpsertEdges(ctx, moved); err != nil {
+		return err
+	}
+	_, _, err = db.store.DeleteNodes(ctx, []string{n.ID})
+	return err
+}
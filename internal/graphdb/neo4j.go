@@ -0,0 +1,524 @@
+package graphdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+// Neo4jStore keeps the graph in a Neo4j server: message nodes carry the
+// persona scoped label and edges are relationships named after their type.
+// The relation of a RELATED_TO edge is its type property. Nodes and edges
+// are written with UNWIND statements of batchSize rows each.
+type Neo4jStore struct {
+	driver    neo4j.Driver
+	label     string
+	batchSize int
+
+	// The uniqueness constraint on message IDs is created before the first
+	// write
+	constraintOnce sync.Once
+	constraintErr  error
+}
+
+// NewNeo4jStore creates a store for the configured Neo4j server. The server
+// is only contacted by the first query.
+func NewNeo4jStore(cfg *config.Config) (*Neo4jStore, error) {
+	auth := neo4j.BasicAuth(cfg.GraphDB.Username, cfg.GraphDB.Password, "")
+	driver, err := neo4j.NewDriver(
+		fmt.Sprintf("neo4j://%s:%d", cfg.GraphDB.Host, cfg.GraphDB.Port),
+		auth,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Neo4j driver: %w", err)
+	}
+	return &Neo4jStore{driver: driver, label: cfg.GraphDB.MessageLabel(), batchSize: cfg.GraphDB.WriteBatch()}, nil
+}
+
+func (s *Neo4jStore) read(work neo4j.TransactionWork) (interface{}, error) {
+	session := s.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	return session.ReadTransaction(work)
+}
+
+func (s *Neo4jStore) write(work neo4j.TransactionWork) (interface{}, error) {
+	s.constraintOnce.Do(func() { s.constraintErr = s.createConstraint() })
+	if s.constraintErr != nil {
+		return nil, s.constraintErr
+	}
+
+	session := s.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	return session.WriteTransaction(work)
+}
+
+// createConstraint makes message IDs unique, which also indexes them. The
+// plain index earlier versions created on the same property is dropped
+// first, as Neo4j refuses a constraint alongside it. Schema changes can't
+// share a transaction, so each runs in its own.
+func (s *Neo4jStore) createConstraint() error {
+	name := strings.ToLower(s.label) + "_id"
+	session := s.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	for _, step := range []struct {
+		query string
+		what  string
+	}{
+		{fmt.Sprintf("DROP INDEX %s IF EXISTS", name), "drop the message ID index"},
+		{fmt.Sprintf("CREATE CONSTRAINT %s_unique IF NOT EXISTS FOR (m:%s) REQUIRE m.id IS UNIQUE", name, s.label), "create the message ID constraint"},
+	} {
+		_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			_, err := tx.Run(step.query, nil)
+			return nil, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to %s: %w", step.what, err)
+		}
+	}
+	return nil
+}
+
+// unwind runs a query once per chunk of at most batchSize rows, each chunk
+// in its own transaction and bound to $rows, and returns the sum of the
+// counts the query returns, if any
+func (s *Neo4jStore) unwind(query string, rows []interface{}) (int, error) {
+	total := 0
+	for start := 0; start < len(rows); start += s.batchSize {
+		chunk := rows[start:min(start+s.batchSize, len(rows))]
+		count, err := s.write(func(tx neo4j.Transaction) (interface{}, error) {
+			result, err := tx.Run(query, map[string]interface{}{"rows": chunk})
+			if err != nil {
+				return 0, err
+			}
+			if result.Next() {
+				n, _ := result.Record().Values[0].(int64)
+				return int(n), nil
+			}
+			return 0, result.Err()
+		})
+		if err != nil {
+			return total, err
+		}
+		total += count.(int)
+	}
+	return total, nil
+}
+
+// typePattern returns the relationship type part of a pattern, such as
+// ":IS_SIMILAR" or ":IS_SIMILAR|RELATED_TO", checking that the types are
+// known as they can't be passed as parameters
+func typePattern(types ...string) (string, error) {
+	if len(types) == 0 || (len(types) == 1 && types[0] == "") {
+		return "", nil
+	}
+	for _, t := range types {
+		if !graph.ValidEdgeType(t) {
+			return "", fmt.Errorf("unknown edge type %q", t)
+		}
+	}
+	return ":" + strings.Join(types, "|"), nil
+}
+
+// edgeProperties returns the relationship properties of an edge
+func edgeProperties(e graph.Edge) map[string]interface{} {
+	switch e.Type {
+	case graph.EdgeRelated:
+		return map[string]interface{}{"type": e.Relation, "confidence": e.Confidence, "evidence": e.Evidence}
+	case graph.EdgeDuplicate:
+		return map[string]interface{}{"score": e.Score, "reason": e.Reason}
+	default:
+		return map[string]interface{}{"score": e.Score}
+	}
+}
+
+// edgeFromProperties builds an edge from a relationship
+func edgeFromProperties(source, target, edgeType string, props map[string]interface{}) graph.Edge {
+	e := graph.Edge{Source: source, Target: target, Type: edgeType}
+	e.Score = toFloat(props["score"])
+	e.Confidence = toFloat(props["confidence"])
+	e.Evidence, _ = props["evidence"].(string)
+	e.Reason, _ = props["reason"].(string)
+	if edgeType == graph.EdgeRelated {
+		e.Relation, _ = props["type"].(string)
+	}
+	return e
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// nodeFromRecord builds a node from its ID, text and properties
+func nodeFromRecord(id, text, props interface{}) graph.Node {
+	n := graph.Node{}
+	n.ID, _ = id.(string)
+	n.Text, _ = text.(string)
+	if nodeProps, ok := props.(map[string]interface{}); ok {
+		for key, value := range nodeProps {
+			if key == "id" || key == "text" {
+				continue
+			}
+			if v, err := graph.NormalizeValue(value); err == nil && v != nil {
+				if n.Properties == nil {
+					n.Properties = make(map[string]interface{})
+				}
+				n.Properties[key] = v
+			}
+		}
+	}
+	return n
+}
+
+// UpsertNodes merges the nodes, batchSize per statement. An empty text is
+// sent as null so that the stored text is kept.
+func (s *Neo4jStore) UpsertNodes(ctx context.Context, nodes []graph.Node) error {
+	rows := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		props := make(map[string]interface{}, len(n.Properties))
+		for key, value := range n.Properties {
+			v, err := graph.NormalizeValue(value)
+			if err != nil {
+				return fmt.Errorf("property %s of node %s: %w", key, n.ID, err)
+			}
+			props[key] = v
+		}
+		var text interface{}
+		if n.Text != "" {
+			text = n.Text
+		}
+		rows[i] = map[string]interface{}{"id": n.ID, "text": text, "props": props}
+	}
+	_, err := s.unwind(fmt.Sprintf(`UNWIND $rows AS row
+		 MERGE (m:%s {id: row.id})
+		 SET m += row.props, m.text = coalesce(row.text, m.text)`, s.label), rows)
+	if err != nil {
+		return fmt.Errorf("failed to upsert nodes: %w", err)
+	}
+	return nil
+}
+
+// edgeRows groups the edges by type, as relationship types can't be
+// parameters, in the order of graph.EdgeTypes
+func edgeRows(edges []graph.Edge, row func(graph.Edge) map[string]interface{}) (map[string][]interface{}, error) {
+	byType := make(map[string][]interface{})
+	for _, e := range edges {
+		if !graph.ValidEdgeType(e.Type) {
+			return nil, fmt.Errorf("unknown edge type %q", e.Type)
+		}
+		byType[e.Type] = append(byType[e.Type], row(e))
+	}
+	return byType, nil
+}
+
+// relationIdentity is the pattern part that tells RELATED_TO edges of
+// different relations apart
+func relationIdentity(edgeType string) string {
+	if edgeType == graph.EdgeRelated {
+		return " {type: row.relation}"
+	}
+	return ""
+}
+
+// UpsertEdges merges the edges and their endpoints, one statement per type
+// and batchSize edges
+func (s *Neo4jStore) UpsertEdges(ctx context.Context, edges []graph.Edge) error {
+	byType, err := edgeRows(edges, func(e graph.Edge) map[string]interface{} {
+		return map[string]interface{}{"source": e.Source, "target": e.Target, "relation": e.Relation, "props": edgeProperties(e)}
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range graph.EdgeTypes {
+		if len(byType[t]) == 0 {
+			continue
+		}
+		_, err := s.unwind(fmt.Sprintf(`UNWIND $rows AS row
+			 MERGE (m:%[1]s {id: row.source})
+			 MERGE (n:%[1]s {id: row.target})
+			 MERGE (m)-[r:%[2]s%[3]s]->(n)
+			 SET r += row.props`, s.label, t, relationIdentity(t)), byType[t])
+		if err != nil {
+			return fmt.Errorf("failed to upsert %s edges: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// Nodes returns the nodes with the given IDs, or all of them sorted by ID
+func (s *Neo4jStore) Nodes(ctx context.Context, ids []string) ([]graph.Node, error) {
+	query := "MATCH (m:%s) RETURN m.id, m.text, properties(m) ORDER BY m.id"
+	if ids != nil {
+		query = "MATCH (m:%s) WHERE m.id IN $ids RETURN m.id, m.text, properties(m)"
+	}
+	result, err := s.read(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(fmt.Sprintf(query, s.label), map[string]interface{}{"ids": ids})
+		if err != nil {
+			return nil, err
+		}
+		var nodes []graph.Node
+		for result.Next() {
+			values := result.Record().Values
+			nodes = append(nodes, nodeFromRecord(values[0], values[1], values[2]))
+		}
+		return nodes, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nodes: %w", err)
+	}
+	return result.([]graph.Node), nil
+}
+
+// Edges returns the edges of a type, or all of them
+func (s *Neo4jStore) Edges(ctx context.Context, edgeType string) ([]graph.Edge, error) {
+	types, err := typePattern(edgeType)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.read(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(
+			fmt.Sprintf(`MATCH (m:%[1]s)-[r%[2]s]->(n:%[1]s)
+			 RETURN m.id, n.id, type(r), properties(r)
+			 ORDER BY m.id, n.id`, s.label, types),
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		var edges []graph.Edge
+		for result.Next() {
+			values := result.Record().Values
+			source, _ := values[0].(string)
+			target, _ := values[1].(string)
+			edgeType, _ := values[2].(string)
+			props, _ := values[3].(map[string]interface{})
+			edges = append(edges, edgeFromProperties(source, target, edgeType, props))
+		}
+		return edges, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read edges: %w", err)
+	}
+	return result.([]graph.Edge), nil
+}
+
+// Neighbors returns the neighbours of a node, strongest edge first
+func (s *Neo4jStore) Neighbors(ctx context.Context, id string, edgeType string, dir graph.Direction, limit int) ([]graph.Neighbor, error) {
+	types, err := typePattern(edgeType)
+	if err != nil {
+		return nil, err
+	}
+	pattern := "-[r%[2]s]-"
+	switch dir {
+	case graph.Outgoing:
+		pattern = "-[r%[2]s]->"
+	case graph.Incoming:
+		pattern = "<-[r%[2]s]-"
+	}
+	query := fmt.Sprintf(`MATCH (m:%[1]s {id: $id})`+pattern+`(n:%[1]s)
+		 RETURN n.id, n.text, properties(n), startNode(r).id, endNode(r).id, type(r), properties(r)
+		 ORDER BY CASE type(r) WHEN 'RELATED_TO' THEN r.confidence ELSE r.score END DESC, n.id`, s.label, types)
+	if limit > 0 {
+		query += " LIMIT $limit"
+	}
+
+	result, err := s.read(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(query, map[string]interface{}{"id": id, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		var neighbors []graph.Neighbor
+		for result.Next() {
+			values := result.Record().Values
+			source, _ := values[3].(string)
+			target, _ := values[4].(string)
+			edgeType, _ := values[5].(string)
+			props, _ := values[6].(map[string]interface{})
+			neighbors = append(neighbors, graph.Neighbor{
+				Node: nodeFromRecord(values[0], values[1], values[2]),
+				Edge: edgeFromProperties(source, target, edgeType, props),
+			})
+		}
+		return neighbors, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbors of %s: %w", id, err)
+	}
+	return result.([]graph.Neighbor), nil
+}
+
+// Expand walks the paths from a node in one query. Neo4j does not support
+// parameterized relationship pattern lengths (e.g. -[r*1..$maxDepth]-), so
+// the depth is formatted into the query like the label and types; none of
+// them come from user input.
+func (s *Neo4jStore) Expand(ctx context.Context, id string, opts graph.ExpandOptions) ([]graph.Path, error) {
+	if opts.MaxDepth < 1 {
+		return nil, nil
+	}
+	types, err := typePattern(opts.Types...)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`MATCH path = (m:%[1]s {id: $id})-[rels%[2]s*1..%[3]d]-(n:%[1]s)
+		WHERE ALL(rel IN rels WHERE coalesce(rel.confidence, 0.0) >= $minConfidence)
+			AND ALL(node IN nodes(path) WHERE single(x IN nodes(path) WHERE x = node))
+		RETURN [node IN nodes(path) | node.id] AS ids,
+			n.id, n.text, properties(n),
+			[rel IN rels | [startNode(rel).id, endNode(rel).id, type(rel), properties(rel)]] AS edges,
+			REDUCE(acc = 1.0, rel IN rels | acc * coalesce(rel.confidence, 0.0)) AS confidence
+		ORDER BY confidence DESC`, s.label, types, opts.MaxDepth)
+	if opts.Limit > 0 {
+		query += " LIMIT $limit"
+	}
+
+	result, err := s.read(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(query, map[string]interface{}{
+			"id":            id,
+			"minConfidence": opts.MinConfidence,
+			"limit":         opts.Limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var paths []graph.Path
+		for result.Next() {
+			values := result.Record().Values
+			p := graph.Path{End: nodeFromRecord(values[1], values[2], values[3]), Confidence: toFloat(values[5])}
+			ids, _ := values[0].([]interface{})
+			for _, id := range ids {
+				nodeID, _ := id.(string)
+				p.IDs = append(p.IDs, nodeID)
+			}
+			rels, _ := values[4].([]interface{})
+			for _, rel := range rels {
+				fields, _ := rel.([]interface{})
+				if len(fields) != 4 {
+					continue
+				}
+				source, _ := fields[0].(string)
+				target, _ := fields[1].(string)
+				edgeType, _ := fields[2].(string)
+				props, _ := fields[3].(map[string]interface{})
+				p.Edges = append(p.Edges, edgeFromProperties(source, target, edgeType, props))
+			}
+			paths = append(paths, p)
+		}
+		return paths, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand paths from %s: %w", id, err)
+	}
+	return result.([]graph.Path), nil
+}
+
+// DeleteNodes detaches and deletes the nodes in one transaction
+func (s *Neo4jStore) DeleteNodes(ctx context.Context, ids []string) (int, int, error) {
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	var nodes, edges int
+	_, err := s.write(func(tx neo4j.Transaction) (interface{}, error) {
+		for _, step := range []struct {
+			query string
+			count *int
+		}{
+			{`MATCH (m:%s)-[r]-() WHERE m.id IN $ids RETURN count(DISTINCT r)`, &edges},
+			{`MATCH (m:%s) WHERE m.id IN $ids DETACH DELETE m RETURN count(m)`, &nodes},
+		} {
+			result, err := tx.Run(fmt.Sprintf(step.query, s.label), map[string]interface{}{"ids": ids})
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+			if n, ok := record.Values[0].(int64); ok {
+				*step.count = int(n)
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete nodes: %w", err)
+	}
+	return nodes, edges, nil
+}
+
+// DeleteEdges deletes the edges, one statement per type and batchSize edges
+func (s *Neo4jStore) DeleteEdges(ctx context.Context, edges []graph.Edge) (int, error) {
+	byType, err := edgeRows(edges, func(e graph.Edge) map[string]interface{} {
+		return map[string]interface{}{"source": e.Source, "target": e.Target, "relation": e.Relation}
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, t := range graph.EdgeTypes {
+		if len(byType[t]) == 0 {
+			continue
+		}
+		n, err := s.unwind(fmt.Sprintf(`UNWIND $rows AS row
+			 MATCH (m:%[1]s {id: row.source})-[r:%[2]s%[3]s]->(n:%[1]s {id: row.target})
+			 DELETE r
+			 RETURN count(r)`, s.label, t, relationIdentity(t)), byType[t])
+		removed += n
+		if err != nil {
+			return removed, fmt.Errorf("failed to delete %s edges: %w", t, err)
+		}
+	}
+	return removed, nil
+}
+
+// Stats counts the nodes of the label and the edges between them by type
+func (s *Neo4jStore) Stats(ctx context.Context) (graph.Stats, error) {
+	result, err := s.read(func(tx neo4j.Transaction) (interface{}, error) {
+		stats := graph.Stats{Edges: make(map[string]int)}
+		for _, t := range graph.EdgeTypes {
+			stats.Edges[t] = 0
+		}
+		result, err := tx.Run(fmt.Sprintf("MATCH (m:%s) RETURN count(m)", s.label), nil)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := record.Values[0].(int64); ok {
+			stats.Nodes = int(n)
+		}
+
+		result, err = tx.Run(fmt.Sprintf("MATCH (:%[1]s)-[r]->(:%[1]s) RETURN type(r), count(r)", s.label), nil)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			values := result.Record().Values
+			edgeType, _ := values[0].(string)
+			if n, ok := values[1].(int64); ok {
+				stats.Edges[edgeType] = int(n)
+			}
+		}
+		return stats, result.Err()
+	})
+	if err != nil {
+		return graph.Stats{}, fmt.Errorf("failed to count the graph: %w", err)
+	}
+	return result.(graph.Stats), nil
+}
+
+// Close closes the driver
+func (s *Neo4jStore) Close() error {
+	return s.driver.Close()
+}
@@ -0,0 +1,64 @@
+package graphdb
+
+import (
+	"context"
+
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+// writer buffers the nodes and edges the passes produce and writes them to
+// the store once graphdb.write_batch_size of them have accumulated, so that
+// each store call carries a full batch instead of one message's worth
+type writer struct {
+	store graph.Store
+	size  int
+	nodes []graph.Node
+	edges []graph.Edge
+	// index locates buffered nodes by ID, so that a node added again
+	// replaces its earlier copy
+	index map[string]int
+	// afterFlush runs once buffered writes have reached the store
+	afterFlush func(ctx context.Context) error
+}
+
+func (db *GraphDB) newWriter() *writer {
+	return &writer{store: db.store, size: db.cfg.GraphDB.WriteBatch(), index: make(map[string]int)}
+}
+
+// add buffers nodes and edges, flushing when the buffer is full
+func (w *writer) add(ctx context.Context, nodes []graph.Node, edges []graph.Edge) error {
+	for _, n := range nodes {
+		if i, ok := w.index[n.ID]; ok {
+			w.nodes[i] = n
+			continue
+		}
+		w.index[n.ID] = len(w.nodes)
+		w.nodes = append(w.nodes, n)
+	}
+	w.edges = append(w.edges, edges...)
+	if len(w.nodes)+len(w.edges) >= w.size {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+// flush writes the buffered nodes, then the edges between them
+func (w *writer) flush(ctx context.Context) error {
+	if len(w.nodes) > 0 {
+		if err := w.store.UpsertNodes(ctx, w.nodes); err != nil {
+			return err
+		}
+		w.nodes = nil
+		w.index = make(map[string]int)
+	}
+	if len(w.edges) > 0 {
+		if err := w.store.UpsertEdges(ctx, w.edges); err != nil {
+			return err
+		}
+		w.edges = nil
+	}
+	if w.afterFlush != nil {
+		return w.afterFlush(ctx)
+	}
+	return nil
+}
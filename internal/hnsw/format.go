@@ -0,0 +1,297 @@
+package hnsw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// The store file is little-endian binary:
+//
+//	magic "PSVS" | version u32 | dimension u32 | count u32
+//	M u32 | efConstruction u32 | efSearch u32 | entry i32 | maxLevel u32
+//	quantization u32
+//	count × point:
+//	    id str | payload entries u32 | entries × (key str, value str)
+//	    vector
+//	    layers u32 | layers × (neighbours u32 | neighbours × u32)
+//	count × dimension × f32, when quantized
+//
+// where str is a u32 length followed by UTF-8 bytes and a vector is stored
+// as the index holds it: normalised, dimension × f32 unquantized (0); its
+// scale f32 and dimension × i8 with int8 (1); its sign bits in
+// ⌈dimension/64⌉ u64 words with binary (2). A quantized index is followed by
+// the exact normalised vectors, which are not loaded but read from the file
+// to rescore search candidates. Version 1 files have no quantization field
+// and are read as unquantized.
+var fileMagic = [4]byte{'P', 'S', 'V', 'S'}
+
+const fileVersion = 2
+
+// exactSource returns the exact normalised vector of a node
+type exactSource func(node int) ([]float32, error)
+
+// point is a stored message: its ID and payload. Its vector lives in the
+// index under the same node number.
+type point struct {
+	ID      string
+	Payload map[string]string
+}
+
+// save writes the points and index to path, replacing it atomically, and
+// returns the offset of the exact vectors of a quantized index, which are
+// taken from exact
+func save(path string, points []point, ix *Index, dimension int, exact exactSource) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	exactAt, err := encode(w, points, ix, dimension, exact)
+	if err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write store file: %w", err)
+	}
+	return exactAt, os.Rename(tmp.Name(), path)
+}
+
+// load reads a store file written by save, returning the offset of its
+// exact vectors like save
+func load(path string) ([]point, *Index, int, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	defer file.Close()
+
+	d := &decoder{r: bufio.NewReader(file)}
+	points, ix, dimension, err := decode(d)
+	if err != nil {
+		return nil, nil, 0, 0, fmt.Errorf("failed to read store file %s: %w", path, err)
+	}
+	if ix.params.Quantization != vector.QuantizationNone {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, nil, 0, 0, fmt.Errorf("failed to stat store file %s: %w", path, err)
+		}
+		if info.Size() < d.n+int64(len(points))*int64(4*dimension) {
+			return nil, nil, 0, 0, fmt.Errorf("store file %s is missing exact vectors", path)
+		}
+	}
+	return points, ix, dimension, d.n, nil
+}
+
+func encode(w io.Writer, points []point, ix *Index, dimension int, exact exactSource) (int64, error) {
+	e := &encoder{w: w}
+	e.bytes(fileMagic[:])
+	e.u32(fileVersion)
+	e.u32(uint32(dimension))
+	e.u32(uint32(len(points)))
+	e.u32(uint32(ix.params.M))
+	e.u32(uint32(ix.params.EfConstruction))
+	e.u32(uint32(ix.params.EfSearch))
+	e.u32(uint32(int32(ix.entry)))
+	e.u32(uint32(ix.maxLevel))
+	e.u32(quantizationCode(ix.params.Quantization))
+
+	for node, p := range points {
+		e.str(p.ID)
+		// Sorted keys keep the file byte-for-byte reproducible
+		keys := make([]string, 0, len(p.Payload))
+		for key := range p.Payload {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.u32(uint32(len(keys)))
+		for _, key := range keys {
+			e.str(key)
+			e.str(p.Payload[key])
+		}
+
+		ix.vectors.encode(e, node, dimension)
+
+		e.u32(uint32(len(ix.neighbors[node])))
+		for _, layer := range ix.neighbors[node] {
+			e.u32(uint32(len(layer)))
+			for _, nb := range layer {
+				e.u32(nb)
+			}
+		}
+	}
+	if ix.params.Quantization == vector.QuantizationNone {
+		return e.n, e.err
+	}
+
+	exactAt := e.n
+	for node := range points {
+		if e.err != nil {
+			break
+		}
+		v, err := exact(node)
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; i < dimension; i++ {
+			e.u32(math.Float32bits(v[i]))
+		}
+	}
+	return exactAt, e.err
+}
+
+func decode(d *decoder) ([]point, *Index, int, error) {
+	var magic [4]byte
+	d.bytes(magic[:])
+	if d.err == nil && magic != fileMagic {
+		return nil, nil, 0, errors.New("not a vector store file")
+	}
+	version := d.u32()
+	if d.err == nil && version != 1 && version != fileVersion {
+		return nil, nil, 0, fmt.Errorf("unsupported store file version %d", version)
+	}
+	dimension := int(d.u32())
+	count := int(d.u32())
+	params := Params{M: int(d.u32()), EfConstruction: int(d.u32()), EfSearch: int(d.u32())}
+	entry, maxLevel := int(int32(d.u32())), int(d.u32())
+	if version >= 2 {
+		q, err := quantizationFromCode(d.u32())
+		if err != nil {
+			d.fail(err)
+		}
+		params.Quantization = q
+	}
+	if d.err != nil {
+		return nil, nil, 0, d.err
+	}
+	ix := NewIndex(params)
+	ix.entry, ix.maxLevel = entry, maxLevel
+
+	points := make([]point, count)
+	ix.neighbors = make([][][]uint32, count)
+	for node := 0; node < count && d.err == nil; node++ {
+		p := point{ID: d.str(), Payload: make(map[string]string)}
+		for n := d.u32(); n > 0 && d.err == nil; n-- {
+			key := d.str()
+			p.Payload[key] = d.str()
+		}
+		points[node] = p
+
+		ix.vectors.decode(d, dimension)
+
+		layers := make([][]uint32, d.u32())
+		for l := range layers {
+			layer := make([]uint32, d.u32())
+			for i := range layer {
+				layer[i] = d.u32()
+				if int(layer[i]) >= count {
+					d.fail(fmt.Errorf("node %d links to missing node %d", node, layer[i]))
+				}
+			}
+			layers[l] = layer
+		}
+		ix.neighbors[node] = layers
+	}
+	if d.err != nil {
+		return nil, nil, 0, d.err
+	}
+	if ix.entry >= count || (count > 0 && ix.entry < 0) {
+		return nil, nil, 0, fmt.Errorf("invalid entry point %d for %d nodes", ix.entry, count)
+	}
+	return points, ix, dimension, nil
+}
+
+// encoder writes little-endian values, keeping the first error
+type encoder struct {
+	w   io.Writer
+	buf [4]byte
+	n   int64 // bytes written
+	err error
+}
+
+func (e *encoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+		e.n += int64(len(b))
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:], v)
+	e.bytes(e.buf[:])
+}
+
+func (e *encoder) str(s string) {
+	e.u32(uint32(len(s)))
+	e.bytes([]byte(s))
+}
+
+// decoder reads little-endian values, keeping the first error
+type decoder struct {
+	r   io.Reader
+	buf [4]byte
+	n   int64 // bytes read
+	err error
+}
+
+// maxString bounds string lengths so a corrupt file can't allocate wildly
+const maxString = 64 << 20
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) bytes(b []byte) {
+	if d.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+		return
+	}
+	d.n += int64(len(b))
+}
+
+func (d *decoder) u32() uint32 {
+	d.bytes(d.buf[:])
+	if d.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(d.buf[:])
+}
+
+func (d *decoder) str() string {
+	n := d.u32()
+	if n > maxString {
+		d.fail(fmt.Errorf("string of %d bytes exceeds the limit", n))
+	}
+	if d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	d.bytes(b)
+	return string(b)
+}
@@ -0,0 +1,387 @@
+// Package hnsw is an embedded, in-process vector store. Vectors are indexed
+// with a Hierarchical Navigable Small World graph (Malkov & Yashunin, 2016)
+// for approximate nearest neighbour search by cosine similarity, and the
+// store persists to a compact binary file.
+package hnsw
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// Default index parameters
+const (
+	DefaultM              = 16
+	DefaultEfConstruction = 200
+	DefaultEfSearch       = 64
+)
+
+// Params tunes the index. M is the number of neighbours kept per node and
+// layer (twice that on the bottom layer); EfConstruction and EfSearch are the
+// candidate list sizes used when inserting and searching. Quantization
+// selects how the vectors are held in memory.
+type Params struct {
+	M              int
+	EfConstruction int
+	EfSearch       int
+	Quantization   vector.Quantization
+}
+
+// withDefaults fills in unset parameters
+func (p Params) withDefaults() Params {
+	if p.M < 2 {
+		p.M = DefaultM
+	}
+	if p.EfConstruction < 1 {
+		p.EfConstruction = DefaultEfConstruction
+	}
+	if p.EfSearch < 1 {
+		p.EfSearch = DefaultEfSearch
+	}
+	if p.Quantization == "" {
+		p.Quantization = vector.QuantizationNone
+	}
+	return p
+}
+
+// Result is a search hit: a node and its cosine similarity to the query
+type Result struct {
+	Node  int
+	Score float32
+}
+
+// Index is an HNSW graph over unit vectors. Nodes are numbered in insertion
+// order. It is not safe for concurrent use; Store adds the locking.
+type Index struct {
+	params    Params
+	levelMult float64
+	rng       *rand.Rand
+
+	vectors   storage
+	neighbors [][][]uint32 // node -> layer -> neighbour nodes
+	entry     int          // entry point node, -1 when empty
+	maxLevel  int
+
+	visited sync.Pool // *visitedSet, reused across searches
+}
+
+// visitedSet marks the nodes a layer search has seen
+type visitedSet struct {
+	seen    []bool
+	touched []int
+}
+
+// visit marks a node and reports whether it was already marked
+func (v *visitedSet) visit(node int) bool {
+	if v.seen[node] {
+		return true
+	}
+	v.seen[node] = true
+	v.touched = append(v.touched, node)
+	return false
+}
+
+// acquireVisited returns a cleared visited set sized for the index
+func (ix *Index) acquireVisited() *visitedSet {
+	v, _ := ix.visited.Get().(*visitedSet)
+	if v == nil {
+		v = &visitedSet{}
+	}
+	if n := ix.vectors.len(); len(v.seen) < n {
+		v.seen = make([]bool, n+n/2)
+	}
+	return v
+}
+
+// releaseVisited clears a visited set and returns it to the pool
+func (ix *Index) releaseVisited(v *visitedSet) {
+	for _, node := range v.touched {
+		v.seen[node] = false
+	}
+	v.touched = v.touched[:0]
+	ix.visited.Put(v)
+}
+
+// NewIndex creates an empty index
+func NewIndex(params Params) *Index {
+	params = params.withDefaults()
+	return &Index{
+		params:    params,
+		levelMult: 1 / math.Log(float64(params.M)),
+		rng:       rand.New(rand.NewSource(1)),
+		vectors:   newStorage(params.Quantization),
+		entry:     -1,
+	}
+}
+
+// Len returns the number of nodes
+func (ix *Index) Len() int {
+	return ix.vectors.len()
+}
+
+// Vector returns the normalised vector of a node, as far as quantization
+// preserved it
+func (ix *Index) Vector(node int) []float32 {
+	return ix.vectors.vector(node)
+}
+
+// Add inserts a vector and returns its node
+func (ix *Index) Add(vector []float32) int {
+	q := normalize(vector)
+	node := ix.vectors.len()
+	level := int(math.Floor(-math.Log(1-ix.rng.Float64()) * ix.levelMult))
+
+	ix.vectors.add(q)
+	ix.neighbors = append(ix.neighbors, make([][]uint32, level+1))
+
+	if ix.entry < 0 {
+		ix.entry, ix.maxLevel = node, level
+		return node
+	}
+
+	// Greedily descend to the node's top layer, then link it on every layer
+	// below with the best of EfConstruction candidates
+	ep := ix.entry
+	for l := ix.maxLevel; l > level; l-- {
+		ep = ix.greedy(q, ep, l)
+	}
+	for l := min(level, ix.maxLevel); l >= 0; l-- {
+		candidates := ix.searchLayer(q, ep, ix.params.EfConstruction, l, nil)
+		selected := ix.selectNeighbors(candidates, ix.maxNeighbors(l))
+		ix.neighbors[node][l] = selected
+		for _, nb := range selected {
+			ix.link(int(nb), node, l)
+		}
+		ep = candidates[0].node
+	}
+
+	if level > ix.maxLevel {
+		ix.entry, ix.maxLevel = node, level
+	}
+	return node
+}
+
+// Search returns the k nodes most similar to vector, best first
+func (ix *Index) Search(vector []float32, k int) []Result {
+	return ix.SearchFunc(vector, k, nil)
+}
+
+// SearchFunc is Search restricted to the nodes accept returns true for; a
+// nil accept takes every node. Rejected nodes are still traversed, so the
+// graph stays connected, but they never enter the results.
+func (ix *Index) SearchFunc(vector []float32, k int, accept func(node int) bool) []Result {
+	if ix.entry < 0 || k <= 0 {
+		return nil
+	}
+	q := normalize(vector)
+	ep := ix.entry
+	for l := ix.maxLevel; l > 0; l-- {
+		ep = ix.greedy(q, ep, l)
+	}
+	ef := ix.params.EfSearch
+	if ef < k {
+		ef = k
+	}
+	candidates := ix.searchLayer(q, ep, ef, 0, accept)
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = Result{Node: c.node, Score: 1 - c.dist}
+	}
+	return results
+}
+
+// maxNeighbors is the number of neighbours kept on a layer
+func (ix *Index) maxNeighbors(level int) int {
+	if level == 0 {
+		return 2 * ix.params.M
+	}
+	return ix.params.M
+}
+
+// link adds an edge from node to target on a layer. When node has too many
+// neighbours the furthest are dropped; running the diversity heuristic here
+// as well would dominate insertion time for little gain in recall.
+func (ix *Index) link(node, target, level int) {
+	neighbors := append(ix.neighbors[node][level], uint32(target))
+	if limit := ix.maxNeighbors(level); len(neighbors) > limit {
+		v := ix.vectors.vector(node)
+		candidates := make([]candidate, len(neighbors))
+		for i, nb := range neighbors {
+			candidates[i] = candidate{node: int(nb), dist: ix.distance(v, int(nb))}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+		neighbors = neighbors[:0]
+		for _, c := range candidates[:limit] {
+			neighbors = append(neighbors, uint32(c.node))
+		}
+	}
+	ix.neighbors[node][level] = neighbors
+}
+
+// selectNeighbors picks up to m of the candidates (sorted closest first),
+// preferring ones that are closer to the query than to any already picked so
+// the links spread out in different directions, then fills up with the
+// closest remaining ones
+func (ix *Index) selectNeighbors(candidates []candidate, m int) []uint32 {
+	selected := make([]uint32, 0, m)
+	picked := make([]bool, len(candidates))
+	for i, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		var v []float32
+		for _, s := range selected {
+			if v == nil {
+				v = ix.vectors.vector(c.node)
+			}
+			if ix.distance(v, int(s)) < c.dist {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, uint32(c.node))
+			picked[i] = true
+		}
+	}
+	for i, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		if !picked[i] {
+			selected = append(selected, uint32(c.node))
+		}
+	}
+	return selected
+}
+
+// greedy walks a layer towards q from ep and returns the closest node found
+func (ix *Index) greedy(q []float32, ep, level int) int {
+	best, bestDist := ep, ix.distance(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, nb := range ix.layer(best, level) {
+			if d := ix.distance(q, int(nb)); d < bestDist {
+				best, bestDist, changed = int(nb), d, true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns up to ef nodes of a layer closest to q, closest first,
+// among those accept returns true for (every node when accept is nil)
+func (ix *Index) searchLayer(q []float32, ep, ef, level int, accept func(node int) bool) []candidate {
+	visited := ix.acquireVisited()
+	defer ix.releaseVisited(visited)
+	visited.visit(ep)
+	first := candidate{node: ep, dist: ix.distance(q, ep)}
+	frontier := &minHeap{first}
+	results := &maxHeap{}
+	if accept == nil || accept(ep) {
+		heap.Push(results, first)
+	}
+
+	for frontier.Len() > 0 {
+		c := heap.Pop(frontier).(candidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		for _, nb := range ix.layer(c.node, level) {
+			n := int(nb)
+			if visited.visit(n) {
+				continue
+			}
+			d := ix.distance(q, n)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(frontier, candidate{node: n, dist: d})
+				if accept != nil && !accept(n) {
+					continue
+				}
+				heap.Push(results, candidate{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]candidate, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(candidate)
+	}
+	return sorted
+}
+
+// layer returns the neighbours of a node on a layer it may not reach
+func (ix *Index) layer(node, level int) []uint32 {
+	if level >= len(ix.neighbors[node]) {
+		return nil
+	}
+	return ix.neighbors[node][level]
+}
+
+// distance is the cosine distance between q and a node
+func (ix *Index) distance(q []float32, node int) float32 {
+	return 1 - ix.vectors.dot(q, node)
+}
+
+// normalize returns a unit-length copy of v
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i, x := range v {
+		out[i] = x * scale
+	}
+	return out
+}
+
+// candidate is a node and its distance to the query
+type candidate struct {
+	node int
+	dist float32
+}
+
+// minHeap pops the closest candidate first
+type minHeap []candidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// maxHeap pops the furthest candidate first
+type maxHeap []candidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,205 @@
+package hnsw
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+func randomVectors(n, dimension int, seed int64) [][]float32 {
+	rng := rand.New(rand.NewSource(seed))
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		v := make([]float32, dimension)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		vectors[i] = v
+	}
+	return vectors
+}
+
+// bruteForce returns the k nodes most similar to q by exhaustive search
+func bruteForce(ix *Index, q []float32, k int) []int {
+	q = normalize(q)
+	nodes := make([]int, ix.Len())
+	for i := range nodes {
+		nodes[i] = i
+	}
+	sort.Slice(nodes, func(i, j int) bool { return ix.distance(q, nodes[i]) < ix.distance(q, nodes[j]) })
+	return nodes[:k]
+}
+
+func TestIndexRecall(t *testing.T) {
+	ix := NewIndex(Params{})
+	for _, v := range randomVectors(2000, 32, 1) {
+		ix.Add(v)
+	}
+
+	const k = 10
+	queries := randomVectors(50, 32, 2)
+	found := 0
+	for _, q := range queries {
+		want := make(map[int]bool)
+		for _, node := range bruteForce(ix, q, k) {
+			want[node] = true
+		}
+		results := ix.Search(q, k)
+		if len(results) != k {
+			t.Fatalf("Expected %d results, got %d", k, len(results))
+		}
+		for i, r := range results {
+			if want[r.Node] {
+				found++
+			}
+			if i > 0 && r.Score > results[i-1].Score {
+				t.Fatal("Expected results ordered by descending score")
+			}
+		}
+	}
+	if recall := float64(found) / float64(k*len(queries)); recall < 0.95 {
+		t.Errorf("Expected recall@10 of at least 0.95, got %.3f", recall)
+	}
+}
+
+func TestIndexSearchFunc(t *testing.T) {
+	ix := NewIndex(Params{})
+	for _, v := range randomVectors(1000, 16, 4) {
+		ix.Add(v)
+	}
+	even := func(node int) bool { return node%2 == 0 }
+
+	q := randomVectors(1, 16, 5)[0]
+	results := ix.SearchFunc(q, 10, even)
+	if len(results) != 10 {
+		t.Fatalf("Expected 10 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if !even(r.Node) {
+			t.Errorf("Expected only accepted nodes, got %d", r.Node)
+		}
+	}
+	if results := ix.SearchFunc(q, 10, func(int) bool { return false }); len(results) != 0 {
+		t.Errorf("Expected no results when nothing is accepted, got %v", results)
+	}
+}
+
+func TestIndexExactMatch(t *testing.T) {
+	ix := NewIndex(Params{M: 4})
+	if results := ix.Search([]float32{1, 0}, 3); len(results) != 0 {
+		t.Errorf("Expected no results from an empty index, got %v", results)
+	}
+	vectors := randomVectors(100, 8, 3)
+	for _, v := range vectors {
+		ix.Add(v)
+	}
+	results := ix.Search(vectors[42], 1)
+	if len(results) != 1 || results[0].Node != 42 || results[0].Score < 0.9999 {
+		t.Errorf("Expected node 42 with score 1, got %v", results)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	ix := NewIndex(Params{M: 8, EfConstruction: 50, EfSearch: 20})
+	vectors := randomVectors(300, 16, 4)
+	points := make([]point, len(vectors))
+	for i, v := range vectors {
+		ix.Add(v)
+		points[i] = point{ID: string(rune('a' + i%26)), Payload: map[string]string{"text": "message", "sender": "me"}}
+	}
+
+	var buf bytes.Buffer
+	if _, err := encode(&buf, points, ix, 16, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	size := buf.Len()
+	decodedPoints, decoded, dimension, err := decode(&decoder{r: &buf})
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if dimension != 16 || len(decodedPoints) != len(points) || decodedPoints[7].Payload["sender"] != "me" {
+		t.Fatalf("Unexpected decoded store: dimension %d, %d points", dimension, len(decodedPoints))
+	}
+	if decoded.params != ix.params || decoded.entry != ix.entry || decoded.maxLevel != ix.maxLevel {
+		t.Errorf("Expected the index parameters to round-trip")
+	}
+	for _, q := range randomVectors(10, 16, 5) {
+		a, b := ix.Search(q, 5), decoded.Search(q, 5)
+		for i := range a {
+			if a[i] != b[i] {
+				t.Fatalf("Expected identical results after reload, got %v and %v", a, b)
+			}
+		}
+	}
+
+	// Corrupt input is rejected
+	if _, _, _, err := decode(&decoder{r: bytes.NewReader([]byte("nope"))}); err == nil {
+		t.Error("Expected an error for a bad magic number")
+	}
+	var again bytes.Buffer
+	encode(&again, points, ix, 16, nil)
+	if _, _, _, err := decode(&decoder{r: bytes.NewReader(again.Bytes()[:size/2])}); err == nil {
+		t.Error("Expected an error for a truncated file")
+	}
+}
+
+func TestQuantizedIndex(t *testing.T) {
+	vectors := randomVectors(500, 64, 6)
+	for _, q := range []vector.Quantization{vector.QuantizationInt8, vector.QuantizationBinary} {
+		ix := NewIndex(Params{Quantization: q})
+		points := make([]point, len(vectors))
+		for i, v := range vectors {
+			ix.Add(v)
+			points[i] = point{ID: string(rune('a' + i%26))}
+		}
+
+		// Quantized vectors stay close to the exact ones
+		exact := normalize(vectors[3])
+		if sim := dot(exact, normalize(ix.Vector(3))); sim < 0.75 {
+			t.Errorf("%s: expected the decoded vector to resemble the exact one, got similarity %.3f", q, sim)
+		}
+		if results := ix.Search(vectors[3], 10); len(results) == 0 || results[0].Node != 3 {
+			t.Errorf("%s: expected node 3 among the candidates first, got %v", q, results)
+		}
+
+		exactVectors := func(node int) ([]float32, error) { return normalize(vectors[node]), nil }
+		var buf bytes.Buffer
+		exactAt, err := encode(&buf, points, ix, 64, exactVectors)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", q, err)
+		}
+		if size := int64(buf.Len()) - exactAt; size != int64(len(vectors)*64*4) {
+			t.Errorf("%s: expected the exact vectors at the end, got %d bytes after offset %d", q, size, exactAt)
+		}
+		d := &decoder{r: &buf}
+		_, decoded, _, err := decode(d)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", q, err)
+		}
+		if decoded.params.Quantization != q || d.n != exactAt {
+			t.Errorf("%s: expected the quantization to round-trip and decoding to stop at the exact vectors, got %s at %d", q, decoded.params.Quantization, d.n)
+		}
+		query := randomVectors(1, 64, 7)[0]
+		a, b := ix.Search(query, 5), decoded.Search(query, 5)
+		for i := range a {
+			if a[i] != b[i] {
+				t.Fatalf("%s: expected identical results after reload, got %v and %v", q, a, b)
+			}
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	ix := NewIndex(Params{})
+	for _, v := range randomVectors(10000, 128, 1) {
+		ix.Add(v)
+	}
+	queries := randomVectors(100, 128, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix.Search(queries[i%len(queries)], 10)
+	}
+}
@@ -0,0 +1,258 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// storage holds the unit vectors of an index, as they are or quantized.
+// Quantized vectors are only approximations; Store rescores the best
+// candidates of a search with the exact vectors it keeps on disk.
+type storage interface {
+	add(v []float32)
+	len() int
+	// dot approximates the dot product of q with a stored vector
+	dot(q []float32, node int) float32
+	// vector decodes a stored vector
+	vector(node int) []float32
+	// encode writes a stored vector, decode reads one and appends it
+	encode(e *encoder, node, dimension int)
+	decode(d *decoder, dimension int)
+}
+
+// newStorage returns an empty storage for a quantization
+func newStorage(q vector.Quantization) storage {
+	switch q {
+	case vector.QuantizationInt8:
+		return &int8Storage{}
+	case vector.QuantizationBinary:
+		return &binaryStorage{}
+	default:
+		return &floatStorage{}
+	}
+}
+
+// quantizationCodes number the quantizations in store files
+var quantizationCodes = []vector.Quantization{vector.QuantizationNone, vector.QuantizationInt8, vector.QuantizationBinary}
+
+func quantizationCode(q vector.Quantization) uint32 {
+	for code, known := range quantizationCodes {
+		if known == q {
+			return uint32(code)
+		}
+	}
+	return 0
+}
+
+func quantizationFromCode(code uint32) (vector.Quantization, error) {
+	if int(code) >= len(quantizationCodes) {
+		return "", fmt.Errorf("unknown quantization %d", code)
+	}
+	return quantizationCodes[code], nil
+}
+
+// floatStorage keeps the vectors exactly, 4 bytes per dimension
+type floatStorage struct {
+	vectors [][]float32
+}
+
+func (s *floatStorage) add(v []float32)                   { s.vectors = append(s.vectors, v) }
+func (s *floatStorage) len() int                          { return len(s.vectors) }
+func (s *floatStorage) dot(q []float32, node int) float32 { return dot(q, s.vectors[node]) }
+func (s *floatStorage) vector(node int) []float32         { return s.vectors[node] }
+
+func (s *floatStorage) encode(e *encoder, node, dimension int) {
+	v := s.vectors[node]
+	for i := 0; i < dimension; i++ {
+		var x float32
+		if i < len(v) {
+			x = v[i]
+		}
+		e.u32(math.Float32bits(x))
+	}
+}
+
+func (s *floatStorage) decode(d *decoder, dimension int) {
+	v := make([]float32, dimension)
+	for i := range v {
+		v[i] = math.Float32frombits(d.u32())
+	}
+	s.vectors = append(s.vectors, v)
+}
+
+// int8Storage keeps a byte per dimension: each vector is scaled so that its
+// largest component maps to ±127
+type int8Storage struct {
+	dimension int
+	scales    []float32
+	codes     []int8
+}
+
+func (s *int8Storage) add(v []float32) {
+	if s.dimension == 0 {
+		s.dimension = len(v)
+	}
+	var max float32
+	for _, x := range v {
+		if x < 0 {
+			x = -x
+		}
+		if x > max {
+			max = x
+		}
+	}
+	scale := max / 127
+	for i := 0; i < s.dimension; i++ {
+		var code int8
+		if i < len(v) && scale > 0 {
+			code = int8(math.Round(float64(v[i] / scale)))
+		}
+		s.codes = append(s.codes, code)
+	}
+	s.scales = append(s.scales, scale)
+}
+
+func (s *int8Storage) len() int { return len(s.scales) }
+
+func (s *int8Storage) dot(q []float32, node int) float32 {
+	codes := s.codes[node*s.dimension : (node+1)*s.dimension]
+	n := len(codes)
+	if len(q) < n {
+		n = len(q)
+	}
+	var d0, d1, d2, d3 float32
+	i := 0
+	for ; i+4 <= n; i += 4 {
+		d0 += q[i] * float32(codes[i])
+		d1 += q[i+1] * float32(codes[i+1])
+		d2 += q[i+2] * float32(codes[i+2])
+		d3 += q[i+3] * float32(codes[i+3])
+	}
+	for ; i < n; i++ {
+		d0 += q[i] * float32(codes[i])
+	}
+	return (d0 + d1 + d2 + d3) * s.scales[node]
+}
+
+func (s *int8Storage) vector(node int) []float32 {
+	v := make([]float32, s.dimension)
+	for i, code := range s.codes[node*s.dimension : (node+1)*s.dimension] {
+		v[i] = float32(code) * s.scales[node]
+	}
+	return v
+}
+
+func (s *int8Storage) encode(e *encoder, node, dimension int) {
+	e.u32(math.Float32bits(s.scales[node]))
+	codes := make([]byte, dimension)
+	for i := 0; i < dimension && i < s.dimension; i++ {
+		codes[i] = byte(s.codes[node*s.dimension+i])
+	}
+	e.bytes(codes)
+}
+
+func (s *int8Storage) decode(d *decoder, dimension int) {
+	s.dimension = dimension
+	s.scales = append(s.scales, math.Float32frombits(d.u32()))
+	codes := make([]byte, dimension)
+	d.bytes(codes)
+	for _, b := range codes {
+		s.codes = append(s.codes, int8(b))
+	}
+}
+
+// binaryStorage keeps the sign of each dimension as a bit, so a vector
+// decodes to ±1/√dimension in every component
+type binaryStorage struct {
+	dimension int
+	words     int // 64-bit words per vector
+	bits      []uint64
+	count     int
+}
+
+func (s *binaryStorage) add(v []float32) {
+	if s.dimension == 0 {
+		s.dimension = len(v)
+		s.words = (len(v) + 63) / 64
+	}
+	word := make([]uint64, s.words)
+	for i, x := range v {
+		if i < s.dimension && x >= 0 {
+			word[i/64] |= 1 << (i % 64)
+		}
+	}
+	s.bits = append(s.bits, word...)
+	s.count++
+}
+
+func (s *binaryStorage) len() int { return s.count }
+
+func (s *binaryStorage) dot(q []float32, node int) float32 {
+	words := s.bits[node*s.words : (node+1)*s.words]
+	n := s.dimension
+	if len(q) < n {
+		n = len(q)
+	}
+	var sum float32
+	for i := 0; i < n; i++ {
+		if words[i/64]&(1<<(i%64)) != 0 {
+			sum += q[i]
+		} else {
+			sum -= q[i]
+		}
+	}
+	return sum / float32(math.Sqrt(float64(s.dimension)))
+}
+
+func (s *binaryStorage) vector(node int) []float32 {
+	words := s.bits[node*s.words : (node+1)*s.words]
+	unit := float32(1 / math.Sqrt(float64(s.dimension)))
+	v := make([]float32, s.dimension)
+	for i := range v {
+		if words[i/64]&(1<<(i%64)) != 0 {
+			v[i] = unit
+		} else {
+			v[i] = -unit
+		}
+	}
+	return v
+}
+
+func (s *binaryStorage) encode(e *encoder, node, dimension int) {
+	for _, word := range s.bits[node*s.words : (node+1)*s.words] {
+		e.u32(uint32(word))
+		e.u32(uint32(word >> 32))
+	}
+}
+
+func (s *binaryStorage) decode(d *decoder, dimension int) {
+	s.dimension, s.words = dimension, (dimension+63)/64
+	for i := 0; i < s.words; i++ {
+		low := d.u32()
+		s.bits = append(s.bits, uint64(low)|uint64(d.u32())<<32)
+	}
+	s.count++
+}
+
+// dot is the dot product of two vectors, over the shorter one's length
+func dot(q, v []float32) float32 {
+	n := len(v)
+	if len(q) < n {
+		n = len(q)
+	}
+	q, v = q[:n], v[:n]
+	var d0, d1, d2, d3 float32
+	i := 0
+	for ; i+4 <= n; i += 4 {
+		d0 += q[i] * v[i]
+		d1 += q[i+1] * v[i+1]
+		d2 += q[i+2] * v[i+2]
+		d3 += q[i+3] * v[i+3]
+	}
+	for ; i < n; i++ {
+		d0 += q[i] * v[i]
+	}
+	return d0 + d1 + d2 + d3
+}
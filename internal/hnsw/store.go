@@ -0,0 +1,589 @@
+package hnsw
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/filelock"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// chunkOverfetch is how many more nodes Search asks the index for when
+// messages are chunked, so that several chunks of one message don't crowd
+// out the others
+const chunkOverfetch = 3
+
+// bruteForceLimit is the largest number of points matching a filter that
+// Search scores directly instead of walking the graph
+const bruteForceLimit = 4096
+
+// Store is an embedded vector database: an HNSW index plus the message
+// payloads, kept in memory and persisted to a single file under qdrant.path
+// named after the collection. With vector.quantization set the index holds
+// quantized vectors, and the exact ones are read from the file when needed.
+// Only one Store at a time can have a file open; it holds a lock on it until
+// it is closed.
+type Store struct {
+	cfg          *config.Config
+	logger       *logrus.Logger
+	path         string
+	lock         *filelock.Lock
+	quantization vector.Quantization
+	oversampling int
+	mu           sync.RWMutex
+	points       []point
+	byID         map[string]int
+	index        *Index
+	dimension    int
+
+	// file is the store file the exact vectors of a quantized index are
+	// read from, at exactAt; nodes from saved on were added since and
+	// have their exact vectors in added
+	file    *os.File
+	exactAt int64
+	saved   int
+	added   [][]float32
+}
+
+// StorePath returns the file a configuration's store lives in
+func StorePath(cfg *config.Config) string {
+	return filepath.Join(cfg.Qdrant.Path, cfg.Qdrant.CollectionName+".hnsw")
+}
+
+// Open opens the store of a configuration, loading it from disk if it exists
+func Open(cfg *config.Config) (*Store, error) {
+	logger := logrus.New()
+	if cfg.Logging.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	logger.SetLevel(level)
+
+	quantization, err := vector.ParseQuantization(cfg.Vector.Quantization)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		cfg:          cfg,
+		logger:       logger,
+		path:         StorePath(cfg),
+		quantization: quantization,
+		oversampling: vector.RescoreOversampling(cfg.Vector.RescoreOversampling),
+	}
+	s.reset()
+
+	if err := os.MkdirAll(cfg.Qdrant.Path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create vector store directory: %w", err)
+	}
+	if s.lock, err = filelock.Acquire(s.path + ".lock"); err != nil {
+		return nil, fmt.Errorf("failed to open vector store: %w", err)
+	}
+
+	points, ix, dimension, exactAt, err := load(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.WithField("path", s.path).Debug("No vector store file yet")
+	case err != nil:
+		s.lock.Release()
+		return nil, err
+	default:
+		// Keep the configured search parameters
+		ix.params.EfSearch = s.params().EfSearch
+		s.points, s.index, s.dimension = points, ix, dimension
+		for node, p := range points {
+			s.byID[p.ID] = node
+		}
+		if err := s.reopen(exactAt); err != nil {
+			s.lock.Release()
+			return nil, err
+		}
+		logger.WithFields(logrus.Fields{
+			"path":         s.path,
+			"points":       len(points),
+			"quantization": ix.params.Quantization,
+		}).Info("Loaded vector store")
+
+		if ix.params.Quantization != quantization {
+			if err := s.requantize(); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// params returns the index parameters of the configuration
+func (s *Store) params() Params {
+	return Params{
+		M:              s.cfg.Vector.HNSWM,
+		EfConstruction: s.cfg.Vector.HNSWEfConstruction,
+		EfSearch:       s.cfg.Vector.HNSWEfSearch,
+		Quantization:   s.quantization,
+	}.withDefaults()
+}
+
+// reset empties the store in memory
+func (s *Store) reset() {
+	s.points = nil
+	s.byID = make(map[string]int)
+	s.index = NewIndex(s.params())
+	s.dimension = 0
+	s.closeFile()
+	s.exactAt, s.saved, s.added = 0, 0, nil
+}
+
+// quantized reports whether the index holds quantized vectors
+func (s *Store) quantized() bool {
+	return s.index.params.Quantization != vector.QuantizationNone
+}
+
+// save writes the given state to the store file, taking the exact vectors
+// of a quantized index from exact, and makes it the current one
+func (s *Store) save(points []point, index *Index, dimension int, exact exactSource) error {
+	exactAt, err := save(s.path, points, index, dimension, exact)
+	if err != nil {
+		return err
+	}
+	s.points, s.index, s.dimension = points, index, dimension
+	return s.reopen(exactAt)
+}
+
+// reopen opens the store file just saved or loaded to read the exact
+// vectors of a quantized index from
+func (s *Store) reopen(exactAt int64) error {
+	s.closeFile()
+	s.exactAt, s.saved, s.added = exactAt, len(s.points), nil
+	if !s.quantized() {
+		return nil
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open vector store: %w", err)
+	}
+	s.file = file
+	return nil
+}
+
+func (s *Store) closeFile() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// exactVectors returns a source of the exact vectors of the current index.
+// It stays valid after the index is replaced, until the file is reopened.
+func (s *Store) exactVectors() exactSource {
+	if !s.quantized() {
+		vectors := s.index.vectors
+		return func(node int) ([]float32, error) {
+			return vectors.vector(node), nil
+		}
+	}
+	file, exactAt, saved, added := s.file, s.exactAt, s.saved, s.added
+	buf := make([]byte, 4*s.dimension)
+	return func(node int) ([]float32, error) {
+		if node >= saved {
+			return added[node-saved], nil
+		}
+		if _, err := file.ReadAt(buf, exactAt+int64(node)*int64(len(buf))); err != nil {
+			return nil, fmt.Errorf("failed to read the vector of node %d: %w", node, err)
+		}
+		v := make([]float32, len(buf)/4)
+		for i := range v {
+			v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+		}
+		return v, nil
+	}
+}
+
+// requantize converts the loaded index to the configured quantization,
+// keeping its graph, and saves it
+func (s *Store) requantize() error {
+	from := s.index.params.Quantization
+	exact := s.exactVectors()
+	vectors := newStorage(s.quantization)
+	for node := range s.points {
+		v, err := exact(node)
+		if err != nil {
+			return err
+		}
+		vectors.add(v)
+	}
+	s.index.params.Quantization = s.quantization
+	s.index.vectors = vectors
+	if err := s.save(s.points, s.index, s.dimension, exact); err != nil {
+		return err
+	}
+	s.logger.WithFields(logrus.Fields{
+		"path": s.path,
+		"from": from,
+		"to":   s.quantization,
+	}).Info("Requantized vector store")
+	return nil
+}
+
+// CreateCollection starts an empty store, or keeps the stored points in
+// incremental mode
+func (s *Store) CreateCollection() error {
+	if s.cfg.Pipeline.Incremental {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return s.save(s.points, s.index, s.dimension, nil)
+}
+
+// DropCollection empties the store and removes its file
+func (s *Store) DropCollection() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove vector store: %w", err)
+	}
+	return nil
+}
+
+// DescribeCollection returns the store's dimension and size
+func (s *Store) DescribeCollection() (vector.CollectionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info := vector.CollectionInfo{
+		Name:       s.cfg.Qdrant.CollectionName,
+		VectorSize: s.dimension,
+		Distance:   "Cosine",
+		Points:     uint64(len(s.points)),
+	}
+	if _, err := os.Stat(s.path); err == nil {
+		info.Exists = true
+		info.Status = "embedded"
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return info, fmt.Errorf("failed to stat vector store: %w", err)
+	}
+	return info, nil
+}
+
+// InjectMessages adds the embeddings file to the store and saves it. Points
+// that are already stored are skipped.
+func (s *Store) InjectMessages() error {
+	filePath := filepath.Join(s.cfg.Data.OutputDir, "messages_embeddings.jsonl")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, skipped := 0, 0
+	err := embeddings.ScanEmbeddings(filePath, func(msg embeddings.MessageEmbeddingOut) error {
+		if _, ok := s.byID[msg.ID]; ok {
+			skipped++
+			return nil
+		}
+		count++
+		return s.add(msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.save(s.points, s.index, s.dimension, s.exactVectors()); err != nil {
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"count":   count,
+		"skipped": skipped,
+		"total":   len(s.points),
+		"path":    s.path,
+	}).Info("Successfully injected messages into the vector store")
+	return nil
+}
+
+// add indexes a message; the caller holds the write lock
+func (s *Store) add(msg embeddings.MessageEmbeddingOut) error {
+	if len(msg.Embedding) == 0 {
+		return fmt.Errorf("message %s has no embedding", msg.ID)
+	}
+	if s.dimension == 0 {
+		s.dimension = len(msg.Embedding)
+	}
+	if len(msg.Embedding) != s.dimension {
+		return fmt.Errorf("message %s has dimension %d, the store has %d", msg.ID, len(msg.Embedding), s.dimension)
+	}
+
+	payload := vector.PayloadFields(msg.Text, msg.Metadata, msg.Chunk, s.cfg.Persona)
+
+	exact := normalize(msg.Embedding)
+	node := s.index.Add(exact)
+	if s.quantized() {
+		s.added = append(s.added, exact)
+	}
+	s.points = append(s.points, point{ID: msg.ID, Payload: payload})
+	s.byID[msg.ID] = node
+	return nil
+}
+
+// Delete removes the points of the given message IDs and of the chunks cut
+// from them. HNSW graphs don't support removing nodes, so the index is
+// rebuilt from the remaining vectors and saved.
+func (s *Store) Delete(ids []string) (int, error) {
+	forget := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		forget[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kept []int
+	for node, p := range s.points {
+		if forget[p.ID] || forget[p.Payload["parent_id"]] {
+			continue
+		}
+		kept = append(kept, node)
+	}
+	deleted := len(s.points) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	exact := s.exactVectors()
+	points := make([]point, len(kept))
+	byID := make(map[string]int, len(kept))
+	index := NewIndex(s.params())
+	for i, node := range kept {
+		v, err := exact(node)
+		if err != nil {
+			return 0, err
+		}
+		points[i] = s.points[node]
+		byID[points[i].ID] = index.Add(v)
+	}
+	dimension := s.dimension
+	if len(points) == 0 {
+		dimension = 0
+	}
+	keptExact := func(node int) ([]float32, error) { return exact(kept[node]) }
+	if err := s.save(points, index, dimension, keptExact); err != nil {
+		return 0, err
+	}
+	s.byID = byID
+
+	s.logger.WithFields(logrus.Fields{
+		"messages": len(ids),
+		"points":   deleted,
+		"total":    len(s.points),
+		"path":     s.path,
+	}).Info("Deleted points from the vector store")
+	return deleted, nil
+}
+
+// message converts a node to a vector message, with its exact embedding
+// when exact is set
+func (s *Store) message(node int, score float32, exact exactSource) (vector.Message, error) {
+	p := s.points[node]
+	msg := vector.Message{
+		ID:       p.ID,
+		Text:     p.Payload["text"],
+		Score:    score,
+		Metadata: message.MetadataFromFields(p.Payload),
+		Chunk:    message.ChunkFromFields(p.Payload),
+	}
+	if exact != nil {
+		v, err := exact(node)
+		if err != nil {
+			return msg, err
+		}
+		msg.Embedding = v
+	}
+	return msg, nil
+}
+
+// GetAllMessages returns every stored point, in insertion order
+func (s *Store) GetAllMessages() ([]vector.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exact := s.exactVectors()
+	messages := make([]vector.Message, len(s.points))
+	for node := range s.points {
+		msg, err := s.message(node, 0, exact)
+		if err != nil {
+			return nil, err
+		}
+		messages[node] = msg
+	}
+	return messages, nil
+}
+
+// ListMessages calls fn with the stored points in insertion order, pageSize
+// at a time and without embeddings. The store is only locked while a page is
+// copied, so fn may search it.
+func (s *Store) ListMessages(pageSize int, fn func(page []vector.Message) error) error {
+	if pageSize < 1 {
+		return fmt.Errorf("invalid page size %d", pageSize)
+	}
+	for start := 0; ; start += pageSize {
+		s.mu.RLock()
+		end := min(start+pageSize, len(s.points))
+		var page []vector.Message
+		for node := start; node < end; node++ {
+			msg, _ := s.message(node, 0, nil)
+			page = append(page, msg)
+		}
+		s.mu.RUnlock()
+
+		if len(page) == 0 {
+			return nil
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+}
+
+// GetMessages returns the stored points of ids with their exact embeddings
+func (s *Store) GetMessages(ids []string) ([]vector.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exact := s.exactVectors()
+	messages := make([]vector.Message, 0, len(ids))
+	for _, id := range ids {
+		node, ok := s.byID[id]
+		if !ok {
+			continue
+		}
+		msg, err := s.message(node, 0, exact)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Search returns the messages closest to embedding among the points
+// matching filter, collapsing chunk hits into their parent message
+func (s *Store) Search(embedding []float32, limit int, filter *vector.Filter) ([]vector.Message, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.dimension != 0 && len(embedding) != s.dimension {
+		return nil, fmt.Errorf("query has dimension %d, the store has %d", len(embedding), s.dimension)
+	}
+
+	fetch := limit
+	if s.cfg.Vector.ChunkSize > 0 {
+		fetch = limit * chunkOverfetch
+	}
+	exact := s.exactVectors()
+	results, err := s.search(embedding, fetch, filter, exact)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]vector.Message, len(results))
+	parents := make(map[string]vector.Message)
+	for i, r := range results {
+		if hits[i], err = s.message(r.Node, r.Score, exact); err != nil {
+			return nil, err
+		}
+		if hits[i].IsChunk() {
+			if node, ok := s.byID[hits[i].ParentID]; ok {
+				if parents[hits[i].ParentID], err = s.message(node, 0, exact); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	messages := vector.CollapseChunks(hits, parents)
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// search runs the index search for the points matching filter. When few
+// points match, scoring them directly is both exact and cheaper than
+// walking the graph past all the rejected ones. A quantized index is
+// searched for more candidates, which are rescored with the exact vectors.
+func (s *Store) search(embedding []float32, k int, filter *vector.Filter, exact exactSource) ([]Result, error) {
+	fetch := k
+	if s.quantized() {
+		fetch = k * s.oversampling
+	}
+
+	var results []Result
+	if filter.Empty() {
+		results = s.index.Search(embedding, fetch)
+	} else {
+		accepted := make([]bool, len(s.points))
+		var matching []int
+		for node, p := range s.points {
+			if filter.Matches(p.Payload) {
+				accepted[node] = true
+				matching = append(matching, node)
+			}
+		}
+		if len(matching) > bruteForceLimit {
+			results = s.index.SearchFunc(embedding, fetch, func(node int) bool { return accepted[node] })
+		} else {
+			q := normalize(embedding)
+			results = make([]Result, len(matching))
+			for i, node := range matching {
+				results[i] = Result{Node: node, Score: 1 - s.index.distance(q, node)}
+			}
+			sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+			if len(results) > fetch {
+				results = results[:fetch]
+			}
+		}
+	}
+
+	if s.quantized() {
+		q := normalize(embedding)
+		for i, r := range results {
+			v, err := exact(r.Node)
+			if err != nil {
+				return nil, err
+			}
+			results[i].Score = dot(q, v)
+		}
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	}
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// Close releases the store and its lock. Everything is saved as it is
+// injected.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeFile()
+	return s.lock.Release()
+}
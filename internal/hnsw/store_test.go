@@ -0,0 +1,400 @@
+package hnsw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/filelock"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+func writeEmbeddings(t *testing.T, dir string, messages ...embeddings.MessageEmbeddingOut) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	file, err := os.Create(filepath.Join(dir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create embeddings file: %v", err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("Failed to write embedding: %v", err)
+		}
+	}
+}
+
+func testConfig(t *testing.T) *config.Config {
+	tmpDir := t.TempDir()
+	return &config.Config{
+		Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "vectors"), CollectionName: "messages"},
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:  config.VectorConfig{Backend: "hnsw", ChunkSize: 10},
+	}
+}
+
+func TestStore(t *testing.T) {
+	cfg := testConfig(t)
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "a", Text: "about cats", Embedding: []float32{1, 0, 0}, Metadata: message.Metadata{Sender: "me"}},
+		embeddings.MessageEmbeddingOut{ID: "b", Text: "about dogs", Embedding: []float32{0, 1, 0}},
+		embeddings.MessageEmbeddingOut{ID: "long#0", Text: "birds", Embedding: []float32{0, 0, 1}, Chunk: message.Chunk{ParentID: "long"}},
+		embeddings.MessageEmbeddingOut{ID: "long", Text: "birds and more", Embedding: []float32{0.5, 0.5, 0.5}, Chunk: message.Chunk{ChunkCount: 1}},
+	)
+
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	results, err := store.Search([]float32{0.9, 0.1, 0}, 1, nil)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "a" || results[0].Sender != "me" {
+		t.Errorf("Expected message a, got %+v", results)
+	}
+	if len(results[0].Embedding) != 3 {
+		t.Errorf("Expected the stored embedding with the result, got %v", results[0].Embedding)
+	}
+
+	// Chunk hits collapse into their message
+	results, err = store.Search([]float32{0, 0, 1}, 2, nil)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "long" || results[0].Text != "birds and more" || results[0].Score < 0.99 {
+		t.Errorf("Expected the chunk hit to collapse into its message, got %+v", results)
+	}
+
+	if _, err := store.Search([]float32{1, 0}, 1, nil); err == nil {
+		t.Error("Expected an error for a query of the wrong dimension")
+	}
+
+	// A second open fails while the store is open
+	if _, err := Open(cfg); !errors.Is(err, filelock.ErrLocked) {
+		t.Fatalf("Expected a second open to fail while the store is open, got %v", err)
+	}
+
+	// Reopening loads the same store from disk
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+	reopened, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+	messages, err := reopened.GetAllMessages()
+	if err != nil {
+		t.Fatalf("Failed to get all messages: %v", err)
+	}
+	if len(messages) != 4 || messages[0].ID != "a" || messages[2].ParentID != "long" || len(messages[1].Embedding) != 3 {
+		t.Errorf("Expected the stored points after reload, got %+v", messages)
+	}
+	results, err = reopened.Search([]float32{0, 1, 0}, 1, nil)
+	if err != nil || len(results) != 1 || results[0].ID != "b" {
+		t.Errorf("Expected message b after reload, got %+v (%v)", results, err)
+	}
+}
+
+func TestStoreFilter(t *testing.T) {
+	cfg := testConfig(t)
+	var messages []embeddings.MessageEmbeddingOut
+	for i := 0; i < 20; i++ {
+		sender := "me"
+		if i%4 == 0 {
+			sender = "you"
+		}
+		messages = append(messages, embeddings.MessageEmbeddingOut{
+			ID:        string(rune('a' + i)),
+			Text:      "message",
+			Embedding: []float32{1, float32(i), 0},
+			Metadata:  message.Metadata{Sender: sender},
+		})
+	}
+	writeEmbeddings(t, cfg.Data.OutputDir, messages...)
+
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	filter := &vector.Filter{Must: []vector.Condition{vector.Equals("sender", "you")}}
+	results, err := store.Search([]float32{1, 0, 0}, 3, filter)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 3 || results[0].ID != "a" || results[1].ID != "e" || results[2].ID != "i" {
+		t.Errorf("Expected the closest messages from you, got %+v", results)
+	}
+
+	invalid := &vector.Filter{Must: []vector.Condition{{Field: "sender"}}}
+	if _, err := store.Search([]float32{1, 0, 0}, 3, invalid); err == nil {
+		t.Error("Expected an error for an invalid filter")
+	}
+}
+
+func TestStoreIncremental(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Pipeline.Incremental = true
+
+	inject := func(messages ...embeddings.MessageEmbeddingOut) int {
+		writeEmbeddings(t, cfg.Data.OutputDir, messages...)
+		store, err := Open(cfg)
+		if err != nil {
+			t.Fatalf("Failed to open store: %v", err)
+		}
+		defer store.Close()
+		if err := store.CreateCollection(); err != nil {
+			t.Fatalf("Failed to create collection: %v", err)
+		}
+		if err := store.InjectMessages(); err != nil {
+			t.Fatalf("Failed to inject messages: %v", err)
+		}
+		all, err := store.GetAllMessages()
+		if err != nil {
+			t.Fatalf("Failed to get all messages: %v", err)
+		}
+		return len(all)
+	}
+
+	one := embeddings.MessageEmbeddingOut{ID: "one", Text: "one", Embedding: []float32{1, 0}}
+	two := embeddings.MessageEmbeddingOut{ID: "two", Text: "two", Embedding: []float32{0, 1}}
+	if n := inject(one); n != 1 {
+		t.Fatalf("Expected 1 point, got %d", n)
+	}
+	if n := inject(one, two); n != 2 {
+		t.Errorf("Expected the stored point to be kept and the new one added, got %d points", n)
+	}
+
+	// A full rebuild starts over
+	cfg.Pipeline.Incremental = false
+	if n := inject(two); n != 1 {
+		t.Errorf("Expected a rebuild to drop the old points, got %d", n)
+	}
+}
+
+func TestStoreCollection(t *testing.T) {
+	cfg := testConfig(t)
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "a", Text: "a", Embedding: []float32{1, 0}},
+	)
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if info, err := store.DescribeCollection(); err != nil || info.Exists {
+		t.Fatalf("Expected no store file yet, got %+v (%v)", info, err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+	info, err := store.DescribeCollection()
+	if err != nil || !info.Exists || info.Points != 1 || info.VectorSize != 2 {
+		t.Errorf("Expected one 2-dimensional point, got %+v (%v)", info, err)
+	}
+
+	if err := store.DropCollection(); err != nil {
+		t.Fatalf("Failed to drop collection: %v", err)
+	}
+	if _, err := os.Stat(StorePath(cfg)); !os.IsNotExist(err) {
+		t.Errorf("Expected the store file to be removed, got %v", err)
+	}
+	if info, _ := store.DescribeCollection(); info.Exists || info.Points != 0 {
+		t.Errorf("Expected an empty store, got %+v", info)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	cfg := testConfig(t)
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "a", Text: "about cats", Embedding: []float32{1, 0, 0}},
+		embeddings.MessageEmbeddingOut{ID: "b", Text: "about dogs", Embedding: []float32{0, 1, 0}},
+		embeddings.MessageEmbeddingOut{ID: "long#0", Text: "birds", Embedding: []float32{0, 0, 1}, Chunk: message.Chunk{ParentID: "long"}},
+		embeddings.MessageEmbeddingOut{ID: "long", Text: "birds and more", Embedding: []float32{0.5, 0.5, 0.5}, Chunk: message.Chunk{ChunkCount: 1}},
+	)
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	deleted, err := store.Delete([]string{"long", "b"})
+	if err != nil || deleted != 3 {
+		t.Fatalf("Expected b, long and its chunk to be deleted, got %d (%v)", deleted, err)
+	}
+	results, err := store.Search([]float32{0, 0.5, 1}, 5, nil)
+	if err != nil || len(results) != 1 || results[0].ID != "a" {
+		t.Errorf("Expected only a to be found, got %+v (%v)", results, err)
+	}
+
+	// The rebuilt index is persisted
+	store.Close()
+	reopened, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+	messages, _ := reopened.GetAllMessages()
+	if len(messages) != 1 || messages[0].ID != "a" {
+		t.Errorf("Expected only a after reopening, got %+v", messages)
+	}
+
+	if deleted, err := reopened.Delete([]string{"a"}); err != nil || deleted != 1 {
+		t.Fatalf("Expected a to be deleted, got %d (%v)", deleted, err)
+	}
+	if info, _ := reopened.DescribeCollection(); info.Points != 0 || info.VectorSize != 0 {
+		t.Errorf("Expected an empty store, got %+v", info)
+	}
+}
+
+func TestStoreQuantized(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Vector.ChunkSize = 0
+	vectors := randomVectors(300, 32, 8)
+	var messages []embeddings.MessageEmbeddingOut
+	for i, v := range vectors {
+		messages = append(messages, embeddings.MessageEmbeddingOut{ID: fmt.Sprintf("m%d", i), Text: "message", Embedding: v})
+	}
+	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	writer, err := embeddings.CreateEmbeddings(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"), false)
+	if err != nil {
+		t.Fatalf("Failed to create embeddings file: %v", err)
+	}
+	for _, msg := range messages[:200] {
+		if err := writer.Write(msg); err != nil {
+			t.Fatalf("Failed to write embedding: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write embeddings file: %v", err)
+	}
+
+	// Every node is found first with its exact score, whatever the index holds
+	check := func(store *Store, label string, n int) {
+		t.Helper()
+		for _, i := range []int{0, 17, n - 1} {
+			results, err := store.Search(vectors[i], 3, nil)
+			if err != nil || len(results) != 3 || results[0].ID != messages[i].ID || results[0].Score < 0.9999 {
+				t.Fatalf("%s: expected %s first with score 1, got %+v (%v)", label, messages[i].ID, results, err)
+			}
+			if exact := normalize(vectors[i]); results[0].Embedding[5] != exact[5] {
+				t.Errorf("%s: expected the exact embedding with the result", label)
+			}
+		}
+		all, err := store.GetAllMessages()
+		if err != nil || len(all) != n {
+			t.Fatalf("%s: expected %d messages, got %d (%v)", label, n, len(all), err)
+		}
+		if exact := normalize(vectors[n-1]); all[n-1].Embedding[9] != exact[9] {
+			t.Errorf("%s: expected exact embeddings from GetAllMessages", label)
+		}
+
+		listed, pages := 0, 0
+		err = store.ListMessages(64, func(page []vector.Message) error {
+			for _, msg := range page {
+				if msg.ID != messages[listed].ID || msg.Embedding != nil {
+					return fmt.Errorf("unexpected message %+v at %d", msg, listed)
+				}
+				listed++
+			}
+			pages++
+			return nil
+		})
+		if err != nil || listed != n || pages != (n+63)/64 {
+			t.Fatalf("%s: expected %d messages in pages of 64, got %d in %d (%v)", label, n, listed, pages, err)
+		}
+		got, err := store.GetMessages([]string{messages[n-1].ID, "missing", messages[0].ID})
+		if err != nil || len(got) != 2 || got[0].ID != messages[n-1].ID || got[1].ID != messages[0].ID {
+			t.Fatalf("%s: expected the two stored messages in order, got %+v (%v)", label, got, err)
+		}
+		if exact := normalize(vectors[n-1]); got[0].Embedding[9] != exact[9] {
+			t.Errorf("%s: expected exact embeddings from GetMessages", label)
+		}
+	}
+
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+	check(store, "none", 200)
+	store.Close()
+
+	// Reopening with another quantization converts the stored index
+	for _, q := range []string{"int8", "binary"} {
+		cfg.Vector.Quantization = q
+		store, err = Open(cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to open store: %v", q, err)
+		}
+		if store.index.params.Quantization != vector.Quantization(q) {
+			t.Fatalf("%s: expected the index to be requantized, got %s", q, store.index.params.Quantization)
+		}
+		check(store, q, 200)
+		store.Close()
+	}
+
+	// New points are searchable before and after the store is reloaded
+	cfg.Pipeline.Incremental = true
+	writeEmbeddings(t, cfg.Data.OutputDir, messages...)
+	store, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+	check(store, "binary, incremental", 300)
+	if deleted, err := store.Delete([]string{"m0"}); err != nil || deleted != 1 {
+		t.Fatalf("Expected m0 to be deleted, got %d (%v)", deleted, err)
+	}
+	if results, _ := store.Search(vectors[299], 1, nil); len(results) != 1 || results[0].ID != "m299" {
+		t.Errorf("Expected m299 after a delete, got %+v", results)
+	}
+	store.Close()
+
+	cfg.Vector.Quantization = ""
+	store, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	if results, _ := store.Search(vectors[150], 1, nil); len(results) != 1 || results[0].ID != "m150" || results[0].Score < 0.9999 {
+		t.Errorf("Expected m150 after converting back, got %+v", results)
+	}
+	if all, _ := store.GetAllMessages(); len(all) != 299 {
+		t.Errorf("Expected 299 messages after converting back, got %d", len(all))
+	}
+
+	cfg.Vector.Quantization = "pq"
+	if _, err := Open(cfg); err == nil {
+		t.Error("Expected an error for an unknown quantization")
+	}
+}
@@ -0,0 +1,326 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// ChatGPTImporter parses the conversations.json file from a ChatGPT data
+// export. Each conversation is a tree of nodes (edited prompts and
+// regenerated answers create branches); every branch is walked and each user
+// turn becomes a message. The persona owner is the "user" role, so no owner
+// is needed; Options.Owner only sets the sender name.
+type ChatGPTImporter struct{}
+
+// OpenRouterImporter parses OpenAI-style chat transcripts as exported by
+// OpenRouter and similar chat frontends: a conversation object, or an array
+// of them, each holding a flat "messages" list of {role, content} turns.
+type OpenRouterImporter struct{}
+
+// chatgptConversation is a conversation in conversations.json
+type chatgptConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	Mapping        map[string]chatgptNode `json:"mapping"`
+}
+
+// chatgptNode is a node of the conversation tree
+type chatgptNode struct {
+	ID       string          `json:"id"`
+	Message  *chatgptMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+// chatgptMessage is the message held by a tree node
+type chatgptMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+// Import implements Importer
+func (i *ChatGPTImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	var conversations []chatgptConversation
+	if err := json.NewDecoder(r).Decode(&conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse chatgpt export: %w", err)
+	}
+
+	sender := opts.Owner
+	if sender == "" {
+		sender = "user"
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+	for _, conv := range conversations {
+		threadID := conv.ConversationID
+		if threadID == "" {
+			threadID = conv.ID
+		}
+
+		// Walk the tree depth first from its roots, visiting children in order
+		var visit func(nodeID string)
+		visit = func(nodeID string) {
+			node, ok := conv.Mapping[nodeID]
+			if !ok {
+				return
+			}
+			if node.Message != nil && node.Message.Author.Role == "user" {
+				if text := node.Message.text(); text != "" {
+					msg := embeddings.MessageEmbeddingIn{Text: text}
+					msg.Sender = sender
+					msg.Recipient = "assistant"
+					msg.Timestamp = formatTime(unixSeconds(node.Message.CreateTime, conv.CreateTime))
+					msg.Channel = "chatgpt"
+					msg.ThreadID = threadID
+					if reply := conv.reply(nodeID); reply != nil {
+						if reply.Metadata.ModelSlug != "" {
+							msg.Recipient = reply.Metadata.ModelSlug
+						}
+						if opts.IncludeReplies {
+							msg.Context = reply.text()
+						}
+					}
+					messages = append(messages, msg)
+				}
+			}
+			for _, child := range node.Children {
+				visit(child)
+			}
+		}
+		for _, root := range conv.roots() {
+			visit(root)
+		}
+	}
+
+	return messages, nil
+}
+
+// roots returns the IDs of the nodes without a parent in the mapping
+func (c chatgptConversation) roots() []string {
+	var roots []string
+	for id, node := range c.Mapping {
+		if _, ok := c.Mapping[node.Parent]; node.Parent == "" || !ok {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// reply finds the first assistant answer below a user turn, looking through
+// intermediate tool and system nodes but not past the next user turn
+func (c chatgptConversation) reply(nodeID string) *chatgptMessage {
+	for _, childID := range c.Mapping[nodeID].Children {
+		child := c.Mapping[childID]
+		if child.Message == nil {
+			continue
+		}
+		switch child.Message.Author.Role {
+		case "user":
+			continue
+		case "assistant":
+			if child.Message.text() != "" {
+				return child.Message
+			}
+		}
+		if reply := c.reply(childID); reply != nil {
+			return reply
+		}
+	}
+	return nil
+}
+
+// text joins the textual parts of a message, skipping images and other
+// non-text parts
+func (m *chatgptMessage) text() string {
+	if m.Content.ContentType != "text" && m.Content.ContentType != "multimodal_text" {
+		return ""
+	}
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var part string
+		if err := json.Unmarshal(raw, &part); err == nil && strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// openrouterConversation is a conversation in an OpenAI-style transcript
+type openrouterConversation struct {
+	ID        string              `json:"id"`
+	Title     string              `json:"title"`
+	Model     string              `json:"model"`
+	CreatedAt json.RawMessage     `json:"created_at"`
+	Messages  []openrouterMessage `json:"messages"`
+}
+
+// openrouterMessage is a single chat turn
+type openrouterMessage struct {
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	Model     string          `json:"model"`
+	CreatedAt json.RawMessage `json:"created_at"`
+}
+
+// Import implements Importer
+func (i *OpenRouterImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read openrouter export: %w", err)
+	}
+
+	var conversations []openrouterConversation
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var conv openrouterConversation
+		if err := json.Unmarshal(trimmed, &conv); err != nil {
+			return nil, fmt.Errorf("failed to parse openrouter export: %w", err)
+		}
+		conversations = append(conversations, conv)
+	} else if err := json.Unmarshal(trimmed, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse openrouter export: %w", err)
+	}
+
+	sender := opts.Owner
+	if sender == "" {
+		sender = "user"
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+	for n, conv := range conversations {
+		threadID := conv.ID
+		if threadID == "" {
+			threadID = fmt.Sprintf("%s-%d", opts.Thread, n)
+		}
+		convTime, err := parseFlexibleTime(conv.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_at in conversation %s: %w", threadID, err)
+		}
+
+		for j, turn := range conv.Messages {
+			if turn.Role != "user" {
+				continue
+			}
+			text, err := openrouterText(turn.Content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse content in conversation %s: %w", threadID, err)
+			}
+			if text == "" {
+				continue
+			}
+			turnTime, err := parseFlexibleTime(turn.CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid created_at in conversation %s: %w", threadID, err)
+			}
+			if turnTime.IsZero() {
+				turnTime = convTime
+			}
+
+			msg := embeddings.MessageEmbeddingIn{Text: text}
+			msg.Sender = sender
+			msg.Recipient = firstNonEmpty(conv.Model, "assistant")
+			msg.Timestamp = formatTime(turnTime)
+			msg.Channel = "openrouter"
+			msg.ThreadID = threadID
+
+			// The reply is the next assistant turn before the next user turn
+			for _, next := range conv.Messages[j+1:] {
+				if next.Role == "user" {
+					break
+				}
+				if next.Role != "assistant" {
+					continue
+				}
+				if next.Model != "" {
+					msg.Recipient = next.Model
+				}
+				if opts.IncludeReplies {
+					msg.Context, _ = openrouterText(next.Content)
+				}
+				break
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+// openrouterText flattens message content, which is either a string or an
+// array of typed parts of which only "text" parts are kept
+func openrouterText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return strings.TrimSpace(plain), nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(texts, "\n")), nil
+}
+
+// parseFlexibleTime parses a timestamp given either as an RFC3339 string or
+// as Unix seconds or milliseconds
+func parseFlexibleTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if s == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+	var n float64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return time.Time{}, err
+	}
+	if n > 1e12 {
+		return unixMillis(int64(n)), nil
+	}
+	return unixSeconds(n), nil
+}
+
+// unixSeconds converts the first non-zero fractional Unix timestamp to a time
+func unixSeconds(values ...float64) time.Time {
+	for _, v := range values {
+		if v > 0 {
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*1e9))
+		}
+	}
+	return time.Time{}
+}
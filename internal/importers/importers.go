@@ -0,0 +1,130 @@
+package importers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// Importer converts a chat export into the normalized ingest format
+// consumed by the embedding phase
+type Importer interface {
+	// Import parses the export read from r and returns the messages
+	// authored by the persona owner, in export order
+	Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error)
+}
+
+// Options controls how an export is imported
+type Options struct {
+	// Owner identifies the persona owner in the export: a display name,
+	// phone number, email address or user ID depending on the format
+	Owner string
+	// Thread is the thread ID used when the export carries none
+	// (e.g. a WhatsApp chat export covers a single chat)
+	Thread string
+	// Location is the time zone of exports that record local times
+	// without an offset. Defaults to UTC.
+	Location *time.Location
+	// IncludeReplies keeps the reply each message received (e.g. the
+	// assistant's answer) as its context. Only used by assistant exports.
+	IncludeReplies bool
+}
+
+// registry maps a format name to its importer constructor
+var registry = map[string]func() Importer{
+	"whatsapp":   func() Importer { return &WhatsAppImporter{} },
+	"telegram":   func() Importer { return &TelegramImporter{} },
+	"signal":     func() Importer { return &SignalImporter{} },
+	"sms-xml":    func() Importer { return &SMSBackupImporter{} },
+	"mbox":       func() Importer { return &MboxImporter{} },
+	"chatgpt":    func() Importer { return &ChatGPTImporter{} },
+	"openrouter": func() Importer { return &OpenRouterImporter{} },
+}
+
+// New returns the importer registered for the given format
+func New(format string) (Importer, error) {
+	newImporter, ok := registry[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported import format %q (supported: %s)", format, strings.Join(Formats(), ", "))
+	}
+	return newImporter(), nil
+}
+
+// Formats returns the supported format names in sorted order
+func Formats() []string {
+	formats := make([]string, 0, len(registry))
+	for format := range registry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// WriteJSONL writes messages in the ingest JSONL format, one per line
+func WriteJSONL(w io.Writer, messages []embeddings.MessageEmbeddingIn) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return fmt.Errorf("failed to encode message: %w", err)
+		}
+	}
+	return nil
+}
+
+// location returns the configured time zone, defaulting to UTC
+func (o Options) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// formatTime renders a timestamp in the RFC3339 form used by the ingest format
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// unixMillis converts a Unix timestamp in milliseconds to a time
+func unixMillis(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+var nonDigits = regexp.MustCompile(`[^0-9]`)
+var phoneLike = regexp.MustCompile(`^\+?[0-9 ()./-]{6,}$`)
+
+// normalizeIdentity folds an identity for comparison: phone numbers are
+// reduced to their digits, everything else is trimmed and lower-cased
+func normalizeIdentity(id string) string {
+	id = strings.TrimSpace(id)
+	if phoneLike.MatchString(id) {
+		return nonDigits.ReplaceAllString(id, "")
+	}
+	return strings.ToLower(id)
+}
+
+// isOwner reports whether any of the candidate identities matches the owner
+func isOwner(owner string, candidates ...string) bool {
+	if owner == "" {
+		return false
+	}
+	want := normalizeIdentity(owner)
+	for _, candidate := range candidates {
+		if candidate != "" && normalizeIdentity(candidate) == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// importFixture runs the importer for format over a file in testdata
+func importFixture(t *testing.T, format, fixture string, opts Options) []embeddings.MessageEmbeddingIn {
+	t.Helper()
+	importer, err := New(format)
+	if err != nil {
+		t.Fatalf("Failed to create %s importer: %v", format, err)
+	}
+	file, err := os.Open(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer file.Close()
+
+	messages, err := importer.Import(file, opts)
+	if err != nil {
+		t.Fatalf("Failed to import %s: %v", fixture, err)
+	}
+	return messages
+}
+
+// expectTexts checks the imported message texts in order
+func expectTexts(t *testing.T, messages []embeddings.MessageEmbeddingIn, want ...string) {
+	t.Helper()
+	if len(messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d: %+v", len(want), len(messages), messages)
+	}
+	for i, msg := range messages {
+		if msg.Text != want[i] {
+			t.Errorf("Message %d: expected text %q, got %q", i, want[i], msg.Text)
+		}
+		if err := msg.Metadata.Validate(); err != nil {
+			t.Errorf("Message %d: invalid metadata: %v", i, err)
+		}
+	}
+}
+
+func TestWhatsAppAndroid(t *testing.T) {
+	messages := importFixture(t, "whatsapp", "whatsapp_android.txt", Options{Owner: "Jane Doe", Thread: "alex"})
+	expectTexts(t, messages,
+		"Happy new year in advance!",
+		"Are we still on for brunch tomorrow?\nI can book the place near the station",
+	)
+	if messages[0].Timestamp != "2020-12-31T21:15:00Z" {
+		t.Errorf("Expected day-first timestamp, got %s", messages[0].Timestamp)
+	}
+	if messages[0].Channel != "whatsapp" || messages[0].ThreadID != "alex" || messages[0].Sender != "Jane Doe" {
+		t.Errorf("Unexpected metadata: %+v", messages[0].Metadata)
+	}
+}
+
+func TestWhatsAppIOS(t *testing.T) {
+	messages := importFixture(t, "whatsapp", "whatsapp_ios.txt", Options{Owner: "jane doe"})
+	expectTexts(t, messages, "Did you finish the report?", "Sending it now")
+	if messages[0].Timestamp != "2023-04-01T21:05:12Z" {
+		t.Errorf("Expected month-first 12h timestamp, got %s", messages[0].Timestamp)
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	messages := importFixture(t, "telegram", "telegram.json", Options{Owner: "user1001"})
+	expectTexts(t, messages,
+		"Have you read the paper I sent?",
+		"It is here: https://arxiv.org/abs/1706.03762 - the attention one",
+	)
+	if messages[0].Recipient != "Alice" || messages[0].ThreadID != "telegram-4242" {
+		t.Errorf("Unexpected metadata: %+v", messages[0].Metadata)
+	}
+	if messages[0].Timestamp != "2023-04-01T10:05:00Z" {
+		t.Errorf("Expected timestamp from date_unixtime, got %s", messages[0].Timestamp)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	messages := importFixture(t, "signal", "signal.jsonl", Options{Owner: "+1 555 000 1111"})
+	expectTexts(t, messages, "Running 10 minutes late", "Who is bringing snacks?")
+	if messages[0].Recipient != "+15550002222" || messages[0].ThreadID != "signal-+15550002222" {
+		t.Errorf("Unexpected metadata for direct message: %+v", messages[0].Metadata)
+	}
+	if messages[1].ThreadID != "signal-Z3JvdXAtb25l" || messages[1].Recipient != "" {
+		t.Errorf("Unexpected metadata for group message: %+v", messages[1].Metadata)
+	}
+}
+
+func TestSMSBackup(t *testing.T) {
+	messages := importFixture(t, "sms-xml", "sms.xml", Options{Owner: "Jane Doe"})
+	expectTexts(t, messages, "Can you pick up milk?", "Line one\nLine two", "Look at this")
+	if messages[0].Recipient != "Bob" || messages[0].ThreadID != "sms-15550002222" {
+		t.Errorf("Unexpected metadata: %+v", messages[0].Metadata)
+	}
+	if messages[1].Recipient != "+15550003333" {
+		t.Errorf("Expected address as recipient for unknown contact, got %s", messages[1].Recipient)
+	}
+	if messages[2].ThreadID != messages[0].ThreadID {
+		t.Errorf("Expected sms and mms to the same number to share a thread")
+	}
+}
+
+func TestMbox(t *testing.T) {
+	messages := importFixture(t, "mbox", "mailbox.mbox", Options{Owner: "jane@example.com"})
+	expectTexts(t, messages,
+		"Hi Bob,\n\nI think we should focus on the onboarding flow first.\nFrom what I saw last quarter it is the biggest drop-off.",
+		"Great, let's start on Monday — I'll draft the spec.",
+	)
+	if messages[0].ThreadID != "plan-1@example.com" || messages[1].ThreadID != "plan-1@example.com" {
+		t.Errorf("Expected both emails in thread plan-1, got %s and %s", messages[0].ThreadID, messages[1].ThreadID)
+	}
+	if messages[1].Recipient != "bob@example.com, carol@example.com" {
+		t.Errorf("Unexpected recipients: %s", messages[1].Recipient)
+	}
+}
+
+func TestChatGPT(t *testing.T) {
+	messages := importFixture(t, "chatgpt", "chatgpt_conversations.json", Options{IncludeReplies: true})
+	expectTexts(t, messages,
+		"Where should I go hiking near Seattle in November?",
+		"What about this trail?",
+		"How do I say thanks in Japanese?",
+	)
+	if messages[0].Timestamp != "2023-11-14T22:13:30Z" || messages[0].ThreadID != "conv-1" || messages[0].Channel != "chatgpt" {
+		t.Errorf("Unexpected metadata: %+v", messages[0].Metadata)
+	}
+	if messages[0].Sender != "user" || messages[0].Recipient != "gpt-4o" {
+		t.Errorf("Unexpected sender or recipient: %+v", messages[0].Metadata)
+	}
+	if messages[0].Context != "Try Rattlesnake Ledge or Mount Si." {
+		t.Errorf("Expected first branch reply as context, got %q", messages[0].Context)
+	}
+	if messages[1].Context != "That one is muddy this time of year." {
+		t.Errorf("Expected reply past the tool node as context, got %q", messages[1].Context)
+	}
+	if messages[2].Timestamp != "2023-11-20T17:06:40Z" || messages[2].ThreadID != "conv-2" || messages[2].Context != "" {
+		t.Errorf("Expected conversation time and no context, got %+v", messages[2].Metadata)
+	}
+
+	messages = importFixture(t, "chatgpt", "chatgpt_conversations.json", Options{Owner: "Jane Doe"})
+	if messages[0].Context != "" || messages[0].Sender != "Jane Doe" {
+		t.Errorf("Expected owner as sender and no context, got %+v", messages[0].Metadata)
+	}
+}
+
+func TestOpenRouter(t *testing.T) {
+	messages := importFixture(t, "openrouter", "openrouter.json", Options{IncludeReplies: true})
+	expectTexts(t, messages,
+		"Give me a quick dinner idea with lentils",
+		"Can I make it spicier?",
+		"Thanks!",
+	)
+	if messages[0].Timestamp != "2024-03-01T10:00:05Z" || messages[0].Recipient != "openai/gpt-4o-mini" {
+		t.Errorf("Unexpected metadata: %+v", messages[0].Metadata)
+	}
+	if messages[0].Context != "Try a red lentil dal with rice." || messages[1].Context != "" {
+		t.Errorf("Unexpected context: %q, %q", messages[0].Context, messages[1].Context)
+	}
+	if messages[1].Timestamp != "2024-03-01T10:01:40Z" || messages[1].Recipient != "anthropic/claude-3.5-sonnet" {
+		t.Errorf("Unexpected metadata: %+v", messages[1].Metadata)
+	}
+	if messages[2].Timestamp != "2024-03-02T10:00:00Z" || messages[2].ThreadID != "or-2" {
+		t.Errorf("Expected conversation time in milliseconds, got %+v", messages[2].Metadata)
+	}
+
+	single := `{"id": "solo", "messages": [{"role": "user", "content": "Hello"}]}`
+	messages, err := (&OpenRouterImporter{}).Import(strings.NewReader(single), Options{})
+	if err != nil {
+		t.Fatalf("Failed to import single conversation: %v", err)
+	}
+	expectTexts(t, messages, "Hello")
+}
+
+func TestOwnerRequired(t *testing.T) {
+	for _, format := range []string{"whatsapp", "telegram", "signal", "sms-xml", "mbox"} {
+		importer, err := New(format)
+		if err != nil {
+			t.Fatalf("Failed to create %s importer: %v", format, err)
+		}
+		if _, err := importer.Import(strings.NewReader(""), Options{}); err == nil {
+			t.Errorf("%s: expected an error without an owner", format)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New("icq"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	messages := importFixture(t, "sms-xml", "sms.xml", Options{Owner: "Jane Doe"})
+	var buf bytes.Buffer
+	if err := WriteJSONL(&buf, messages); err != nil {
+		t.Fatalf("Failed to write JSONL: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(messages) {
+		t.Fatalf("Expected %d lines, got %d", len(messages), len(lines))
+	}
+	var decoded embeddings.MessageEmbeddingIn
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatalf("Failed to decode line: %v", err)
+	}
+	if decoded != messages[0] {
+		t.Errorf("Round trip mismatch: %+v != %+v", decoded, messages[0])
+	}
+	if decoded.Sender != "Jane Doe" {
+		t.Errorf("Expected the owner as sender, got %s", decoded.Sender)
+	}
+}
@@ -0,0 +1,191 @@
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// MboxImporter parses an mbox mailbox, such as a Gmail Takeout export, and
+// keeps the emails whose From address or name matches the owner. Quoted
+// replies and signatures are stripped so only the owner's own words remain.
+type MboxImporter struct{}
+
+// Import implements Importer
+func (i *MboxImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	if opts.Owner == "" {
+		return nil, fmt.Errorf("mbox import requires the owner's email address or name")
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+	err := splitMbox(r, func(raw []byte) error {
+		email, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("failed to parse email: %w", err)
+		}
+
+		from, err := mail.ParseAddress(email.Header.Get("From"))
+		if err != nil || !isOwner(opts.Owner, from.Address, from.Name) {
+			return nil
+		}
+
+		body, err := plainTextBody(email.Header.Get("Content-Type"), email.Header.Get("Content-Transfer-Encoding"), email.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read body of %s: %w", email.Header.Get("Message-ID"), err)
+		}
+		text := stripQuotedReply(body)
+		if text == "" {
+			return nil
+		}
+
+		msg := embeddings.MessageEmbeddingIn{Text: text}
+		msg.Sender = from.Address
+		if to, err := email.Header.AddressList("To"); err == nil {
+			recipients := make([]string, len(to))
+			for i, addr := range to {
+				recipients[i] = addr.Address
+			}
+			msg.Recipient = strings.Join(recipients, ", ")
+		}
+		if date, err := email.Header.Date(); err == nil {
+			msg.Timestamp = formatTime(date)
+		}
+		msg.Channel = "email"
+		msg.ThreadID = emailThread(email.Header)
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// splitMbox calls fn with each raw message of the mailbox. Messages are
+// separated by "From " lines, and body lines escaped as ">From " are restored.
+func splitMbox(r io.Reader, fn func([]byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var current bytes.Buffer
+	started := false
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		defer current.Reset()
+		return fn(current.Bytes())
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			if err := flush(); err != nil {
+				return err
+			}
+			started = true
+			continue
+		}
+		if strings.HasPrefix(line, ">From ") {
+			line = line[1:]
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading mbox: %w", err)
+	}
+	return flush()
+}
+
+// plainTextBody returns the decoded text/plain content of a message body,
+// descending into multipart bodies
+func plainTextBody(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || contentType == "" {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := plainTextBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// stripQuotedReply removes quoted lines, the "On ... wrote:" attribution
+// and the signature from an email body
+func stripQuotedReply(body string) string {
+	var kept []string
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if line == "-- " {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	// Drop a trailing "On <date>, <name> wrote:" attribution
+	for len(kept) > 0 {
+		last := strings.TrimSpace(kept[len(kept)-1])
+		if last == "" || (strings.HasPrefix(last, "On ") && strings.HasSuffix(last, "wrote:")) {
+			kept = kept[:len(kept)-1]
+			continue
+		}
+		break
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// emailThread derives a thread ID from the root of the References chain,
+// falling back to In-Reply-To and the message's own Message-ID
+func emailThread(header mail.Header) string {
+	if refs := strings.Fields(header.Get("References")); len(refs) > 0 {
+		return strings.Trim(refs[0], "<>")
+	}
+	if reply := strings.TrimSpace(header.Get("In-Reply-To")); reply != "" {
+		return strings.Trim(reply, "<>")
+	}
+	return strings.Trim(strings.TrimSpace(header.Get("Message-ID")), "<>")
+}
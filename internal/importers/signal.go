@@ -0,0 +1,136 @@
+package importers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// SignalImporter parses the JSON lines written by `signal-cli receive
+// --output=json` (or `signal-cli -o json`). Messages the owner sent from a
+// linked device arrive as sync messages and are always kept with the owner as
+// sender; incoming data messages are kept when their source matches the
+// owner.
+type SignalImporter struct{}
+
+// signalGroup identifies the group a Signal message was sent to
+type signalGroup struct {
+	GroupID string `json:"groupId"`
+}
+
+// signalDataMessage is the content of a Signal message
+type signalDataMessage struct {
+	Timestamp int64        `json:"timestamp"`
+	Message   string       `json:"message"`
+	GroupInfo *signalGroup `json:"groupInfo"`
+}
+
+// signalSentMessage is a message the account sent from another device
+type signalSentMessage struct {
+	signalDataMessage
+	Destination       string `json:"destination"`
+	DestinationNumber string `json:"destinationNumber"`
+}
+
+// signalEnvelope is a single line of signal-cli output
+type signalEnvelope struct {
+	Envelope struct {
+		Source       string             `json:"source"`
+		SourceNumber string             `json:"sourceNumber"`
+		SourceUUID   string             `json:"sourceUuid"`
+		SourceName   string             `json:"sourceName"`
+		Timestamp    int64              `json:"timestamp"`
+		DataMessage  *signalDataMessage `json:"dataMessage"`
+		SyncMessage  *struct {
+			SentMessage *signalSentMessage `json:"sentMessage"`
+		} `json:"syncMessage"`
+	} `json:"envelope"`
+}
+
+// Import implements Importer
+func (i *SignalImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	if opts.Owner == "" {
+		return nil, fmt.Errorf("signal import requires the owner's name, number or UUID")
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var line signalEnvelope
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("failed to parse signal message at line %d: %w", lineNum, err)
+		}
+		env := line.Envelope
+
+		var data *signalDataMessage
+		var sender, recipient, thread string
+		switch {
+		case env.SyncMessage != nil && env.SyncMessage.SentMessage != nil:
+			sent := env.SyncMessage.SentMessage
+			data = &sent.signalDataMessage
+			sender = opts.Owner
+			recipient = firstNonEmpty(sent.Destination, sent.DestinationNumber)
+			thread = recipient
+		case env.DataMessage != nil && isOwner(opts.Owner, env.Source, env.SourceNumber, env.SourceUUID, env.SourceName):
+			data = env.DataMessage
+			sender = firstNonEmpty(env.SourceName, env.Source)
+		default:
+			continue
+		}
+
+		text := strings.TrimSpace(data.Message)
+		if text == "" {
+			continue
+		}
+		if data.GroupInfo != nil && data.GroupInfo.GroupID != "" {
+			thread = data.GroupInfo.GroupID
+			recipient = ""
+		}
+
+		msg := embeddings.MessageEmbeddingIn{Text: text}
+		msg.Sender = sender
+		msg.Recipient = recipient
+		msg.Timestamp = formatTime(unixMillis(firstNonZero(data.Timestamp, env.Timestamp)))
+		msg.Channel = "signal"
+		if thread != "" {
+			msg.ThreadID = "signal-" + thread
+		}
+		messages = append(messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading signal export: %w", err)
+	}
+
+	return messages, nil
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// firstNonZero returns the first non-zero value
+func firstNonZero(values ...int64) int64 {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package importers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// SMSBackupImporter parses the XML written by the Android "SMS Backup &
+// Restore" app. Only sent messages (sms type 2, mms msg_box 2) are kept, as
+// those are the ones the phone's owner authored. The backup doesn't name the
+// owner, so Options.Owner is required to set their sender.
+type SMSBackupImporter struct{}
+
+// smsRecord is an <sms> element
+type smsRecord struct {
+	Address     string `xml:"address,attr"`
+	Date        string `xml:"date,attr"`
+	Type        string `xml:"type,attr"`
+	Body        string `xml:"body,attr"`
+	ContactName string `xml:"contact_name,attr"`
+}
+
+// mmsRecord is an <mms> element
+type mmsRecord struct {
+	Address     string `xml:"address,attr"`
+	Date        string `xml:"date,attr"`
+	MsgBox      string `xml:"msg_box,attr"`
+	ContactName string `xml:"contact_name,attr"`
+	Parts       []struct {
+		ContentType string `xml:"ct,attr"`
+		Text        string `xml:"text,attr"`
+	} `xml:"parts>part"`
+}
+
+// Import implements Importer
+func (i *SMSBackupImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	if opts.Owner == "" {
+		return nil, fmt.Errorf("sms backup import requires the owner's name")
+	}
+	sender := opts.Owner
+
+	var messages []embeddings.MessageEmbeddingIn
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sms backup: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var address, date, contact, text string
+		switch start.Name.Local {
+		case "sms":
+			var sms smsRecord
+			if err := decoder.DecodeElement(&sms, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse sms element: %w", err)
+			}
+			if sms.Type != "2" {
+				continue
+			}
+			address, date, contact, text = sms.Address, sms.Date, sms.ContactName, sms.Body
+		case "mms":
+			var mms mmsRecord
+			if err := decoder.DecodeElement(&mms, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse mms element: %w", err)
+			}
+			if mms.MsgBox != "2" {
+				continue
+			}
+			var parts []string
+			for _, part := range mms.Parts {
+				if part.ContentType == "text/plain" && part.Text != "" && part.Text != "null" {
+					parts = append(parts, part.Text)
+				}
+			}
+			address, date, contact, text = mms.Address, mms.Date, mms.ContactName, strings.Join(parts, "\n")
+		default:
+			continue
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" || text == "null" {
+			continue
+		}
+		millis, err := strconv.ParseInt(date, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sms date %q: %w", date, err)
+		}
+
+		msg := embeddings.MessageEmbeddingIn{Text: text}
+		msg.Sender = sender
+		msg.Recipient = address
+		if contact != "" && contact != "(Unknown)" {
+			msg.Recipient = contact
+		}
+		msg.Timestamp = formatTime(unixMillis(millis))
+		msg.Channel = "sms"
+		if address != "" {
+			msg.ThreadID = "sms-" + normalizeIdentity(address)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
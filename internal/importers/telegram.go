@@ -0,0 +1,148 @@
+package importers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// TelegramImporter parses the machine-readable JSON (result.json) written by
+// Telegram Desktop's "Export chat history" or "Export Telegram data". Both
+// single-chat exports and full account exports are supported.
+type TelegramImporter struct{}
+
+// telegramChat is a single chat in a Telegram export
+type telegramChat struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	ID       int64             `json:"id"`
+	Messages []telegramMessage `json:"messages"`
+}
+
+// telegramExport is the top level of result.json
+type telegramExport struct {
+	telegramChat
+	Chats *struct {
+		List []telegramChat `json:"list"`
+	} `json:"chats"`
+}
+
+// telegramMessage is a message in a Telegram chat
+type telegramMessage struct {
+	ID           int64           `json:"id"`
+	Type         string          `json:"type"`
+	Date         string          `json:"date"`
+	DateUnixtime string          `json:"date_unixtime"`
+	From         string          `json:"from"`
+	FromID       string          `json:"from_id"`
+	Text         json.RawMessage `json:"text"`
+}
+
+// Import implements Importer
+func (i *TelegramImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	if opts.Owner == "" {
+		return nil, fmt.Errorf("telegram import requires the owner's name or user ID")
+	}
+
+	var export telegramExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("failed to parse telegram export: %w", err)
+	}
+
+	chats := []telegramChat{export.telegramChat}
+	if export.Chats != nil {
+		chats = export.Chats.List
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+	for _, chat := range chats {
+		for _, tm := range chat.Messages {
+			if tm.Type != "message" || !isOwner(opts.Owner, tm.From, tm.FromID, strings.TrimPrefix(tm.FromID, "user")) {
+				continue
+			}
+			text, err := telegramText(tm.Text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse text of telegram message %d: %w", tm.ID, err)
+			}
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			timestamp, err := tm.time(opts.location())
+			if err != nil {
+				return nil, err
+			}
+
+			msg := embeddings.MessageEmbeddingIn{Text: text}
+			msg.Sender = tm.From
+			if chat.Type == "personal_chat" {
+				msg.Recipient = chat.Name
+			}
+			msg.Timestamp = formatTime(timestamp)
+			msg.Channel = "telegram"
+			msg.ThreadID = fmt.Sprintf("telegram-%d", chat.ID)
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+// time returns when the message was sent, preferring the Unix timestamp
+// newer exports include over the zone-less local date
+func (m telegramMessage) time(loc *time.Location) (time.Time, error) {
+	if m.DateUnixtime != "" {
+		seconds, err := strconv.ParseInt(m.DateUnixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid telegram date_unixtime %q: %w", m.DateUnixtime, err)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	if m.Date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid telegram date %q: %w", m.Date, err)
+	}
+	return t, nil
+}
+
+// telegramText flattens a message text, which is either a plain string or
+// an array mixing strings and formatted entities such as links
+func telegramText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return plain, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, part := range parts {
+		var s string
+		if err := json.Unmarshal(part, &s); err == nil {
+			text.WriteString(s)
+			continue
+		}
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return "", err
+		}
+		text.WriteString(entity.Text)
+	}
+	return text.String(), nil
+}
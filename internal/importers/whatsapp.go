@@ -0,0 +1,160 @@
+package importers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// WhatsAppImporter parses the plain text "Export chat" file produced by the
+// WhatsApp Android and iOS apps. Each message starts with a line such as
+//
+//	12/31/20, 9:15 PM - Jane Doe: message     (Android)
+//	[31/12/2020, 21:15:03] Jane Doe: message  (iOS)
+//
+// and may continue over the following lines. The day/month order is inferred
+// from the dates in the file.
+type WhatsAppImporter struct{}
+
+var (
+	whatsappHeader = regexp.MustCompile(`^\[?(\d{1,2})[./-](\d{1,2})[./-](\d{2,4}),? (\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?\s?([AaPp]\.?[Mm]\.?)?\]?(?: -)? (.*)$`)
+	whatsappSender = regexp.MustCompile(`^([^:]+): (.*)$`)
+)
+
+// whatsappInvisible strips the direction marks and narrow spaces iOS exports
+// sprinkle through header lines
+var whatsappInvisible = strings.NewReplacer("\u200e", "", "\u200f", "", "\u202f", " ", "\ufeff", "")
+
+// whatsappMedia lists the placeholders WhatsApp writes instead of attachments
+var whatsappMedia = []string{"<Media omitted>", "image omitted", "video omitted", "audio omitted", "sticker omitted", "document omitted", "GIF omitted", "This message was deleted", "You deleted this message"}
+
+// whatsappEntry is a message as read from the export, before its date is resolved
+type whatsappEntry struct {
+	date   [3]int // first, second and year components as written
+	hour   int
+	minute int
+	second int
+	ampm   string
+	sender string
+	text   strings.Builder
+}
+
+// Import implements Importer
+func (i *WhatsAppImporter) Import(r io.Reader, opts Options) ([]embeddings.MessageEmbeddingIn, error) {
+	if opts.Owner == "" {
+		return nil, fmt.Errorf("whatsapp import requires the owner's display name")
+	}
+
+	var entries []*whatsappEntry
+	var current *whatsappEntry
+	dayFirst := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := whatsappInvisible.Replace(scanner.Text())
+
+		match := whatsappHeader.FindStringSubmatch(line)
+		if match == nil {
+			// Continuation of a multi-line message
+			if current != nil {
+				current.text.WriteString("\n")
+				current.text.WriteString(line)
+			}
+			continue
+		}
+
+		sender := whatsappSender.FindStringSubmatch(match[8])
+		if sender == nil {
+			// System message such as "Messages are end-to-end encrypted"
+			current = nil
+			continue
+		}
+
+		entry := &whatsappEntry{sender: strings.TrimSpace(sender[1]), ampm: match[7]}
+		entry.date[0], _ = strconv.Atoi(match[1])
+		entry.date[1], _ = strconv.Atoi(match[2])
+		entry.date[2], _ = strconv.Atoi(match[3])
+		entry.hour, _ = strconv.Atoi(match[4])
+		entry.minute, _ = strconv.Atoi(match[5])
+		entry.second, _ = strconv.Atoi(match[6])
+		entry.text.WriteString(sender[2])
+		if entry.date[0] > 12 {
+			dayFirst = true
+		}
+		entries = append(entries, entry)
+		current = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading whatsapp export: %w", err)
+	}
+
+	var messages []embeddings.MessageEmbeddingIn
+	for _, entry := range entries {
+		if !isOwner(opts.Owner, entry.sender) {
+			continue
+		}
+		text := strings.TrimSpace(entry.text.String())
+		if text == "" || isWhatsAppPlaceholder(text) {
+			continue
+		}
+		timestamp, err := entry.time(dayFirst, opts.location())
+		if err != nil {
+			return nil, err
+		}
+
+		msg := embeddings.MessageEmbeddingIn{Text: text}
+		msg.Sender = entry.sender
+		msg.Timestamp = formatTime(timestamp)
+		msg.Channel = "whatsapp"
+		msg.ThreadID = opts.Thread
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// time resolves the entry's date components into a time
+func (e *whatsappEntry) time(dayFirst bool, loc *time.Location) (time.Time, error) {
+	month, day := e.date[0], e.date[1]
+	if dayFirst {
+		day, month = e.date[0], e.date[1]
+	}
+	year := e.date[2]
+	if year < 100 {
+		year += 2000
+	}
+
+	hour := e.hour
+	switch strings.ToLower(strings.ReplaceAll(e.ampm, ".", "")) {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour != 12 {
+			hour += 12
+		}
+	}
+
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || e.minute > 59 || e.second > 59 {
+		return time.Time{}, fmt.Errorf("invalid whatsapp timestamp %d/%d/%d %d:%02d", e.date[0], e.date[1], e.date[2], e.hour, e.minute)
+	}
+	return time.Date(year, time.Month(month), day, hour, e.minute, e.second, 0, loc), nil
+}
+
+// isWhatsAppPlaceholder reports whether the text is an attachment or deletion placeholder
+func isWhatsAppPlaceholder(text string) bool {
+	for _, placeholder := range whatsappMedia {
+		if text == placeholder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package inference
+
+import (
+	"fmt"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/lexical"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// AnchorRetrieval selects how similarity anchors are found
+type AnchorRetrieval string
+
+const (
+	// AnchorsDense searches the vector database with the question's
+	// embedding
+	AnchorsDense AnchorRetrieval = "dense"
+	// AnchorsLexical searches the BM25 index with the question's words
+	AnchorsLexical AnchorRetrieval = "lexical"
+	// AnchorsHybrid runs both searches and fuses their rankings with
+	// reciprocal rank fusion
+	AnchorsHybrid AnchorRetrieval = "hybrid"
+)
+
+// hybridDepth is how many more candidates each retriever contributes to a
+// hybrid search than the anchors kept, so that a message ranked modestly by
+// both can still win
+const hybridDepth = 2
+
+// mmrOverfetch is how many more candidates are retrieved than anchors kept
+// when they are diversified, so that there is something to choose from
+const mmrOverfetch = 3
+
+// anchorRetrieval returns the anchor retrieval configured for a strategy:
+// its entry in inference.strategy_anchor_retrieval, else
+// inference.anchor_retrieval, else dense
+func anchorRetrieval(cfg *config.Config, strategy InferenceStrategy) AnchorRetrieval {
+	if mode, ok := cfg.Inference.StrategyAnchorRetrieval[strategy.String()]; ok && mode != "" {
+		return AnchorRetrieval(mode)
+	}
+	if cfg.Inference.AnchorRetrieval != "" {
+		return AnchorRetrieval(cfg.Inference.AnchorRetrieval)
+	}
+	return AnchorsDense
+}
+
+// getSimilarityAnchors finds the messages closest to the question the way
+// params.AnchorRetrieval asks for, then diversifies them if
+// params.MMRLambda is set. embedding is the question's and is unused by
+// lexical retrieval.
+func (e *Engine) getSimilarityAnchors(question string, embedding []float32, params InferenceParams) ([]vector.Message, error) {
+	if params.MMRLambda < 0 || params.MMRLambda > 1 {
+		return nil, fmt.Errorf("mmr_lambda must be between 0 and 1, got %g", params.MMRLambda)
+	}
+	limit := params.MaxSimilarityAnchors
+	diversify := params.MMRLambda > 0 && params.MMRLambda < 1
+	if diversify {
+		limit *= mmrOverfetch
+	}
+
+	var anchors []vector.Message
+	var err error
+	switch params.AnchorRetrieval {
+	case "", AnchorsDense:
+		anchors, err = e.denseAnchors(embedding, limit, params.Filter)
+	case AnchorsLexical:
+		anchors, err = e.lexicalAnchors(question, limit, params.Filter)
+	case AnchorsHybrid:
+		var dense, lexical []vector.Message
+		if dense, err = e.denseAnchors(embedding, limit*hybridDepth, params.Filter); err != nil {
+			return nil, err
+		}
+		if lexical, err = e.lexicalAnchors(question, limit*hybridDepth, params.Filter); err != nil {
+			return nil, err
+		}
+		anchors = vector.FuseRRF(e.cfg.Inference.RRFK, limit, dense, lexical)
+	default:
+		return nil, fmt.Errorf("unknown anchor retrieval %q (expected %s, %s or %s)", params.AnchorRetrieval, AnchorsDense, AnchorsLexical, AnchorsHybrid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if diversify {
+		anchors = vector.MMR(anchors, params.MMRLambda, params.MaxSimilarityAnchors)
+	}
+	return anchors, nil
+}
+
+// denseAnchors searches the vector database with the question's embedding
+func (e *Engine) denseAnchors(embedding []float32, limit int, filter *vector.Filter) ([]vector.Message, error) {
+	similar, err := e.vectorDB.Search(embedding, limit, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find closest message: %w", err)
+	}
+	return similar, nil
+}
+
+// lexicalAnchors searches the BM25 index built by the semantic_search phase
+func (e *Engine) lexicalAnchors(question string, limit int, filter *vector.Filter) ([]vector.Message, error) {
+	e.lexicalMu.Lock()
+	if e.lexicalIndex == nil && e.lexicalErr == nil {
+		e.lexicalIndex, e.lexicalErr = lexical.Open(e.cfg)
+	}
+	ix, err := e.lexicalIndex, e.lexicalErr
+	e.lexicalMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("lexical index unavailable (run the semantic_search phase): %w", err)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return ix.Search(question, limit, filter), nil
+}
@@ -1,21 +1,23 @@
 package inference
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 	"github.com/yourusername/psagents/config"
 	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/graph"
 	"github.com/yourusername/psagents/internal/graphdb"
+	"github.com/yourusername/psagents/internal/lexical"
 	"github.com/yourusername/psagents/internal/llm"
 	"github.com/yourusername/psagents/internal/message"
 	"github.com/yourusername/psagents/internal/vector"
-	"github.com/yourusername/psagents/internal/vector_db"
 )
 
 // evaluation
@@ -82,11 +84,13 @@ type InferencePrompt struct {
 			DirectMatch     []struct {
 				ID   string `json:"id"`
 				Text string `json:"text"`
+				message.Metadata
 			} `json:"direct_match"`
 			RelatedMessages []struct {
 				Message struct {
 					ID   string `json:"id"`
 					Text string `json:"text"`
+					message.Metadata
 				} `json:"message"`
 				Relation struct {
 					Type       string  `json:"type"`
@@ -154,6 +158,9 @@ func (l *Logger) LogInference(question string, embedding []float32, directMatche
 		l.Printf("\n%d. Match:", i+1)
 		l.Printf("\n   ID: %s", match.ID)
 		l.Printf("\n   Text: %s", match.Text)
+		if match.Timestamp != "" || match.Sender != "" {
+			l.Printf("\n   Sender: %s, Recipient: %s, Timestamp: %s", match.Sender, match.Recipient, match.Timestamp)
+		}
 	}
 
 	l.Printf("\n\n=== Related Messages ===")
@@ -210,59 +217,80 @@ func (l *Logger) LogEvaluation(question string, expectedAnswer string, evaluatio
 
 
 type Engine struct {
-	graphDB graphdb.GraphDB
+	graph graph.Store
 	llmClient llm.LLM
 	vectorDB vector.DB
-	embeddingsGen *embeddings.Generator
+	embedder embeddings.Embedder
 	logger *Logger
 	cfg *config.Config
+
+	// lexicalIndex is loaded on the first lexical or hybrid search, and
+	// again after ReloadLexical
+	lexicalMu    sync.Mutex
+	lexicalIndex *lexical.Index
+	lexicalErr   error
 }
 
 
 
-func NewEngine(cfg *config.Config) (*Engine, error) {
-	// Initialize vector database
-	vectorDB, err := vector_db.NewQdrantDB(cfg)
-	if err != nil {
-		fmt.Printf("Error initializing vector DB: %v\n", err)
-		os.Exit(1)
-	}
-	defer vectorDB.Close()
+// NewEngine creates an inference engine bound to the persona the
+// configuration is scoped to (see config.WithPersona). It searches vectorDB
+// and reads store, which stay owned by the caller: the engine shares them
+// instead of opening the stores a second time, and Close leaves them open.
+func NewEngine(cfg *config.Config, vectorDB vector.DB, store graph.Store) (*Engine, error) {
+	e := &Engine{vectorDB: vectorDB, graph: store, cfg: cfg}
 
-	// Initialize graph database
-	graphDB, err := graphdb.NewGraphDB(cfg, vectorDB)
-	if err != nil {
-		fmt.Printf("Error initializing graph DB: %v\n", err)
-		os.Exit(1)
-	}
 	// Initialize LLM
-	llmClient, err := llm.NewLLM(cfg)
+	var err error
+	e.llmClient, err = llm.NewLLM(cfg)
 	if err != nil {
-		fmt.Printf("Error initializing LLM: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
 	}
 
 	// Create session logger
-	logger, err := NewLogger(cfg)
+	e.logger, err = NewLogger(cfg)
 	if err != nil {
-		fmt.Printf("Error creating logger: %v\n", err)
-		os.Exit(1)
+		e.Close()
+		return nil, fmt.Errorf("failed to create logger: %w", err)
 	}
 
-	embeddingsGen, err := embeddings.NewGenerator(cfg)
+	e.embedder, err = embeddings.NewGenerator(cfg)
 	if err != nil {
-		fmt.Printf("Error creating embeddings generator: %v\n", err)
-		os.Exit(1)
+		e.Close()
+		return nil, fmt.Errorf("failed to create embeddings generator: %w", err)
 	}
 
-	return &Engine {
-		graphDB: *graphDB,
-		llmClient: llmClient,
-		vectorDB: vectorDB,
-		logger: logger,
-		embeddingsGen: embeddingsGen,
-		cfg: cfg,
-	}, nil
+	return e, nil
+}
+
+// Close releases the LLM client and session log of the engine. The stores
+// passed to NewEngine are left to their owner.
+func (e *Engine) Close() error {
+	var err error
+	if e.llmClient != nil {
+		err = e.llmClient.Close()
+	}
+	if e.logger != nil {
+		if logErr := e.logger.Close(); err == nil {
+			err = logErr
+		}
+	}
+	return err
+}
+
+// ReloadLexical drops the lexical index loaded in memory, so that the next
+// lexical or hybrid search reads it again, e.g. after messages were removed
+// from it
+func (e *Engine) ReloadLexical() {
+	e.lexicalMu.Lock()
+	defer e.lexicalMu.Unlock()
+	e.lexicalIndex, e.lexicalErr = nil, nil
+}
+
+// Persona returns the ID of the persona the engine answers for, or an empty
+// string when the configuration is not scoped to a persona
+func (e *Engine) Persona() string {
+	return e.cfg.Persona
 }
 
 func (e *Engine) Evaluate(params EvaluationParams) (EvaluationResponse, error) {
@@ -324,150 +352,45 @@ func (e *Engine) Evaluate(params EvaluationParams) (EvaluationResponse, error) {
 
 }
 
-func findRelatedMessages(tx neo4j.Transaction, directMatch vector.Message, minConfidence float64, maxMessages int, maxDepth int) ([]RelatedMessage, error) {
-	// findRelatedMessages finds messages related to the directMatch within maxDepth hops.
-	// Note: Neo4j does not support parameterized relationship pattern lengths in MATCH clauses
-	// (e.g., cannot use -[r*1..$maxDepth]-). Therefore, we need to construct the query string
-	// dynamically using fmt.Sprintf. This is safe as maxDepth is an internal parameter,
-	// not user input.
-	query := fmt.Sprintf(`MATCH path = (m:Message {id: $id})-[r*1..%d]-(n:Message)
-		WHERE ALL(rel in r WHERE rel.confidence >= $minConfidence)
-			AND n.id <> $id
-		WITH path, n,
-			LAST(relationships(path)) as last_rel,
-			[rel in relationships(path) |
-				CASE type(rel)
-					WHEN 'RELATED_TO' THEN rel.type
-					ELSE type(rel)
-				END
-			] as rel_types,
-			[rel in relationships(path) | rel.confidence] as confidences,
-			[rel in relationships(path) | rel.evidence] as evidences
-		WITH path, n,
-			LAST(rel_types) as relation_type,
-			REDUCE(acc = 1.0, x IN confidences | acc * x) as confidence,
-			LAST(evidences) as evidence,
-			[node in nodes(path) | node.id] as path_ids
-		RETURN n.id, n.text,
-			relation_type,
-			confidence,
-			evidence,
-			path_ids
-		ORDER BY confidence DESC
-		LIMIT $limit`, maxDepth)
-
-	result, err := tx.Run(
-		query,
-		map[string]interface{}{
-			"id":            directMatch.ID,
-			"minConfidence": minConfidence,
-			"limit":         maxMessages,
-		},
-	)
-
+// findRelatedMessages finds messages related to the directMatch within
+// maxDepth hops along classified relationships, most confident path first
+func findRelatedMessages(ctx context.Context, store graph.Store, directMatch vector.Message, minConfidence float64, maxMessages int, maxDepth int) ([]RelatedMessage, error) {
+	if maxMessages <= 0 {
+		return nil, nil
+	}
+	paths, err := store.Expand(ctx, directMatch.ID, graph.ExpandOptions{
+		Types:         []string{graph.EdgeRelated},
+		MaxDepth:      maxDepth,
+		MinConfidence: minConfidence,
+		Limit:         maxMessages,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute Neo4j query: %w", err)
+		return nil, err
 	}
 
 	var related []RelatedMessage
-	for result.Next() {
-		record := result.Record()
-
-		// Get and validate all required fields
-		id, ok := record.Get("n.id")
-		if !ok || id == nil {
-			continue
-		}
-		idStr, ok := id.(string)
-		if !ok {
+	for _, p := range paths {
+		if p.End.Text == "" || len(p.Edges) == 0 {
 			continue
 		}
-
-		text, ok := record.Get("n.text")
-		if !ok || text == nil {
-			continue
-		}
-		textStr, ok := text.(string)
-		if !ok {
-			continue
-		}
-
-		relationType, ok := record.Get("relation_type")
-		if !ok || relationType == nil {
-			continue
-		}
-		relationTypeStr, ok := relationType.(string)
-		if !ok {
-			continue
-		}
-
-		confidence, ok := record.Get("confidence")
-		if !ok || confidence == nil {
-			continue
-		}
-		confidenceFloat, ok := confidence.(float64)
-		if !ok {
-			continue
-		}
-
-		evidence, ok := record.Get("evidence")
-		if !ok || evidence == nil {
-			continue
-		}
-		evidenceStr, ok := evidence.(string)
-		if !ok {
-			continue
-		}
-
-		pathIds, ok := record.Get("path_ids")
-		if !ok || pathIds == nil {
-			continue
-		}
-
-		// Convert []interface{} to []string
-		pathIdsInterface, ok := pathIds.([]interface{})
-		if !ok {
-			continue
-		}
-		pathIdsString := make([]string, len(pathIdsInterface))
-		validPath := true
-		for i, pathId := range pathIdsInterface {
-			if pathId == nil {
-				validPath = false
-				break
-			}
-			pathIdStr, ok := pathId.(string)
-			if !ok {
-				validPath = false
-				break
-			}
-			pathIdsString[i] = pathIdStr
-		}
-		if !validPath {
-			continue
-		}
-
-		// Create RelatedMessage with validated values
+		last := p.Edges[len(p.Edges)-1]
 		related = append(related, RelatedMessage{
 			Message: message.Message{
-				ID:   idStr,
-				Text: textStr,
+				ID:       p.End.ID,
+				Text:     p.End.Text,
+				Metadata: message.MetadataFromProperties(p.End.Properties),
 			},
 			Relation: graphdb.Relationship{
 				SourceID:   directMatch.ID,
-				TargetID:   idStr,
-				Relation:   relationTypeStr,
-				Confidence: confidenceFloat,
-				Evidence:   evidenceStr,
+				TargetID:   p.End.ID,
+				Relation:   last.Relation,
+				Confidence: p.Confidence,
+				Evidence:   last.Evidence,
 			},
-			Path: pathIdsString,
+			Path: p.IDs,
 		})
 	}
 
-	if err := result.Err(); err != nil {
-		return nil, fmt.Errorf("error while iterating results: %w", err)
-	}
-
 	return related, nil
 }
 
@@ -486,6 +409,14 @@ type InferenceParams struct {
 	IncludeDirectMatches bool
 	SystemPrompt         string
 	SamplingStrategy     SamplingStrategy
+	// Filter restricts the similarity anchors to messages whose payload
+	// matches it; nil searches every message
+	Filter *vector.Filter
+	// AnchorRetrieval is how the similarity anchors are found
+	AnchorRetrieval AnchorRetrieval
+	// MMRLambda diversifies the similarity anchors before graph expansion
+	// (see vector.MMR); 0 leaves them as retrieved
+	MMRLambda float64
 }
 
 type InferenceStrategy int
@@ -496,6 +427,18 @@ const (
 	SemanticOnly
 )
 
+// String returns the strategy's name as used in requests and configuration
+func (s InferenceStrategy) String() string {
+	switch s {
+	case SimilarityOnly:
+		return "similarity"
+	case SemanticOnly:
+		return "semantic"
+	default:
+		return "hybrid"
+	}
+}
+
 func GetInferenceParams(cfg *config.Config, strategy InferenceStrategy) InferenceParams {
 	var params InferenceParams
 	switch strategy {
@@ -528,6 +471,8 @@ func GetInferenceParams(cfg *config.Config, strategy InferenceStrategy) Inferenc
 			MaxRelatedDepth:  cfg.Inference.MaxRelatedDepth,
 		}
 	}
+	params.AnchorRetrieval = anchorRetrieval(cfg, strategy)
+	params.MMRLambda = cfg.Inference.MMRLambda
 	return params
 }
 
@@ -579,49 +524,40 @@ func sampleRelatedMessages(bins [][]RelatedMessage, maxMessages int, strategy Sa
 }
 
 func (e *Engine) getRelatedMessages(similar []vector.Message, minConfidence float64, maxRelatedMessages int, maxRelatedDepth int) ([][]RelatedMessage, error) {
-	session := e.graphDB.GetSession()
-	defer session.Close()
 	allRelatedMessages := make([][]RelatedMessage, len(similar))
 
 	for i, directMatch := range similar {
-		// Find related messages using Neo4j traversal
-		relatedResult, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-			return findRelatedMessages(tx, directMatch, minConfidence, maxRelatedMessages, maxRelatedDepth)
-		})
-
+		// Find related messages by traversing the graph
+		relatedMessages, err := findRelatedMessages(context.Background(), e.graph, directMatch, minConfidence, maxRelatedMessages, maxRelatedDepth)
 		if err != nil {
 			return [][]RelatedMessage{}, fmt.Errorf("failed to traverse graph for match %s: %w", directMatch.ID, err)
 		}
 
-		relatedMessages := relatedResult.([]RelatedMessage)
 		allRelatedMessages[i] = relatedMessages
 	}
 
 	return allRelatedMessages, nil
 }
 
-func (e *Engine) getSimilarityAnchors(embedding []float32, maxMessages int) ([]vector.Message, error) {
-	similar, err := e.vectorDB.Search(embedding, maxMessages)
-	if err != nil {
-		return []vector.Message{}, fmt.Errorf("failed to find closest message: %w", err)
-	}
-	return similar, nil
-}
-
 func (e *Engine) Infer(params InferenceParams) (Response, error) {
 	// Create message for the question
 	questionMsg := message.Message{
 		Text: params.Query.Question,
 	}
 
-	// Generate embedding for the question
-	embedding, err := e.embeddingsGen.GenerateEmbedding(questionMsg.Text)
-	if err != nil {
-		return Response{}, fmt.Errorf("failed to generate embedding: %w", err)
+	// Generate embedding for the question, unless only the lexical index
+	// is searched
+	var embedding []float32
+	if params.AnchorRetrieval != AnchorsLexical {
+		var err error
+		embedding, err = embeddings.EmbedText(context.Background(), e.embedder, questionMsg.Text)
+		if err != nil {
+			return Response{}, fmt.Errorf("failed to generate embedding: %w", err)
+		}
 	}
 
 	// Find closest message in the database
-	similar, err := e.getSimilarityAnchors(embedding, params.MaxSimilarityAnchors)
+	similar, err := e.getSimilarityAnchors(questionMsg.Text, embedding, params)
 	if err != nil {
 		return Response{}, fmt.Errorf("failed to find closest message: %w", err)
 	}
@@ -659,10 +595,12 @@ func (e *Engine) Infer(params InferenceParams) (Response, error) {
 		inferencePrompt.Input.Context.DirectMatch = make([]struct {
 			ID   string `json:"id"`
 			Text string `json:"text"`
+			message.Metadata
 		}, len(similar))
 		for i, match := range similar {
 			inferencePrompt.Input.Context.DirectMatch[i].ID = match.ID
 				inferencePrompt.Input.Context.DirectMatch[i].Text = match.Text
+				inferencePrompt.Input.Context.DirectMatch[i].Metadata = match.Metadata
 			}
 	}
 
@@ -670,6 +608,7 @@ func (e *Engine) Infer(params InferenceParams) (Response, error) {
 		Message struct {
 			ID   string `json:"id"`
 			Text string `json:"text"`
+			message.Metadata
 		} `json:"message"`
 		Relation struct {
 			Type       string  `json:"type"`
@@ -682,6 +621,7 @@ func (e *Engine) Infer(params InferenceParams) (Response, error) {
 	for i, msg := range sampledRelatedMessages {
 		inferencePrompt.Input.Context.RelatedMessages[i].Message.ID = msg.Message.ID
 		inferencePrompt.Input.Context.RelatedMessages[i].Message.Text = msg.Message.Text
+		inferencePrompt.Input.Context.RelatedMessages[i].Message.Metadata = msg.Message.Metadata
 		inferencePrompt.Input.Context.RelatedMessages[i].Relation.Type = msg.Relation.Relation
 		inferencePrompt.Input.Context.RelatedMessages[i].Relation.Confidence = msg.Relation.Confidence
 		inferencePrompt.Input.Context.RelatedMessages[i].Relation.Evidence = msg.Relation.Evidence
@@ -0,0 +1,166 @@
+// Package lexical is a BM25 keyword index over message text, complementing
+// dense vector search for queries whose answer shares words with the
+// question but not necessarily its meaning.
+package lexical
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// BM25 parameters: k1 saturates term frequency, b normalises for length
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// stopwords are too common to say anything about a message
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "do": true, "for": true, "from": true,
+	"has": true, "have": true, "i": true, "in": true, "is": true, "it": true,
+	"of": true, "on": true, "or": true, "that": true, "the": true, "this": true,
+	"to": true, "was": true, "what": true, "when": true, "where": true,
+	"which": true, "who": true, "with": true, "you": true,
+}
+
+// Tokenize splits text into lowercase words, dropping punctuation and
+// stopwords
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if !stopwords[w] {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+// document is an indexed message: its ID and payload (see
+// vector.PayloadFields), which holds its text
+type document struct {
+	ID      string            `json:"id"`
+	Payload map[string]string `json:"payload"`
+	length  int
+}
+
+type posting struct {
+	doc int
+	tf  int
+}
+
+// Index is an in-memory BM25 index. It is not safe for concurrent writes;
+// searches may run concurrently once it is built.
+type Index struct {
+	docs     []document
+	byID     map[string]int
+	postings map[string][]posting
+	totalLen int
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{
+		byID:     make(map[string]int),
+		postings: make(map[string][]posting),
+	}
+}
+
+// Len returns the number of indexed messages
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+// Has reports whether a message is indexed
+func (ix *Index) Has(id string) bool {
+	_, ok := ix.byID[id]
+	return ok
+}
+
+// Add indexes a message's text under its ID. Adding an indexed ID again is
+// a no-op.
+func (ix *Index) Add(id string, payload map[string]string) {
+	if ix.Has(id) {
+		return
+	}
+	tokens := Tokenize(payload["text"])
+	doc := len(ix.docs)
+	ix.docs = append(ix.docs, document{ID: id, Payload: payload, length: len(tokens)})
+	ix.byID[id] = doc
+	ix.totalLen += len(tokens)
+
+	counts := make(map[string]int)
+	for _, t := range tokens {
+		counts[t]++
+	}
+	for term, tf := range counts {
+		ix.postings[term] = append(ix.postings[term], posting{doc: doc, tf: tf})
+	}
+}
+
+// Search returns up to limit messages matching filter ranked by their BM25
+// score for query. Messages sharing no word with the query are left out.
+func (ix *Index) Search(query string, limit int, filter *vector.Filter) []vector.Message {
+	if len(ix.docs) == 0 || limit <= 0 {
+		return nil
+	}
+
+	n := float64(len(ix.docs))
+	avgLen := float64(ix.totalLen) / n
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := ix.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.tf)
+			norm := 1 - b + b*float64(ix.docs[p.doc].length)/avgLen
+			scores[p.doc] += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+
+	hits := make([]int, 0, len(scores))
+	for doc := range scores {
+		if filter.Matches(ix.docs[doc].Payload) {
+			hits = append(hits, doc)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if scores[hits[i]] != scores[hits[j]] {
+			return scores[hits[i]] > scores[hits[j]]
+		}
+		return hits[i] < hits[j]
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	messages := make([]vector.Message, len(hits))
+	for i, doc := range hits {
+		d := ix.docs[doc]
+		messages[i] = vector.Message{
+			ID:       d.ID,
+			Text:     d.Payload["text"],
+			Score:    float32(scores[doc]),
+			Metadata: message.MetadataFromFields(d.Payload),
+			Chunk:    message.ChunkFromFields(d.Payload),
+		}
+	}
+	return messages
+}
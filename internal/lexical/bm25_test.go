@@ -0,0 +1,112 @@
+package lexical
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("What is my job? I'm a nurse, since 2019!")
+	want := []string{"my", "job", "m", "nurse", "since", "2019"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix := NewIndex()
+	ix.Add("a", map[string]string{"text": "I started my new job as a nurse today", "sender": "me"})
+	ix.Add("b", map[string]string{"text": "The weather is lovely today"})
+	ix.Add("c", map[string]string{"text": "job job job interview went fine", "sender": "you"})
+	ix.Add("d", map[string]string{"text": "Dinner with friends"})
+	ix.Add("a", map[string]string{"text": "duplicate"})
+
+	if ix.Len() != 4 {
+		t.Fatalf("Expected 4 documents, got %d", ix.Len())
+	}
+
+	results := ix.Search("What is my job?", 10, nil)
+	if len(results) != 2 || results[0].Score < results[1].Score {
+		t.Fatalf("Expected two ranked job messages, got %+v", results)
+	}
+	for _, r := range results {
+		if r.ID != "a" && r.ID != "c" {
+			t.Errorf("Expected only messages mentioning a job, got %s", r.ID)
+		}
+	}
+
+	filter := &vector.Filter{Must: []vector.Condition{vector.Equals("sender", "me")}}
+	results = ix.Search("job", 10, filter)
+	if len(results) != 1 || results[0].ID != "a" || results[0].Sender != "me" {
+		t.Errorf("Expected only message a, got %+v", results)
+	}
+
+	if results := ix.Search("unrelated words", 10, nil); len(results) != 0 {
+		t.Errorf("Expected no results without shared words, got %+v", results)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant: config.QdrantConfig{Path: filepath.Join(tmpDir, "vectors"), CollectionName: "messages"},
+		Data:   config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+	}
+
+	write := func(messages ...embeddings.MessageEmbeddingOut) {
+		if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+			t.Fatalf("Failed to create output directory: %v", err)
+		}
+		file, err := os.Create(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+		if err != nil {
+			t.Fatalf("Failed to create embeddings file: %v", err)
+		}
+		defer file.Close()
+		encoder := json.NewEncoder(file)
+		for _, msg := range messages {
+			if err := encoder.Encode(msg); err != nil {
+				t.Fatalf("Failed to write embedding: %v", err)
+			}
+		}
+	}
+
+	if _, err := Open(cfg); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-exist error before building, got %v", err)
+	}
+
+	write(
+		embeddings.MessageEmbeddingOut{ID: "long#0", Text: "nurse", Chunk: message.Chunk{ParentID: "long"}},
+		embeddings.MessageEmbeddingOut{ID: "long", Text: "my job as a nurse", Chunk: message.Chunk{ChunkCount: 1}},
+	)
+	if added, err := Build(cfg); err != nil || added != 1 {
+		t.Fatalf("Expected one message indexed, got %d (%v)", added, err)
+	}
+
+	// Incremental builds extend the stored index
+	cfg.Pipeline.Incremental = true
+	write(
+		embeddings.MessageEmbeddingOut{ID: "long", Text: "my job as a nurse", Chunk: message.Chunk{ChunkCount: 1}},
+		embeddings.MessageEmbeddingOut{ID: "new", Text: "a new job"},
+	)
+	if added, err := Build(cfg); err != nil || added != 1 {
+		t.Fatalf("Expected one new message indexed, got %d (%v)", added, err)
+	}
+
+	ix, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open index: %v", err)
+	}
+	results := ix.Search("nurse", 10, nil)
+	if ix.Len() != 2 || len(results) != 1 || results[0].ID != "long" || results[0].ChunkCount != 1 {
+		t.Errorf("Expected the whole message only, got %d documents and %+v", ix.Len(), results)
+	}
+}
@@ -0,0 +1,144 @@
+package lexical
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// formatVersion is bumped when the index file layout changes
+const formatVersion = 1
+
+// indexFile is the on-disk form of an index. Postings are rebuilt on load,
+// which is cheap next to reading the text.
+type indexFile struct {
+	Version   int        `json:"version"`
+	Documents []document `json:"documents"`
+}
+
+// Path returns the file a configuration's lexical index lives in, next to
+// its vector store
+func Path(cfg *config.Config) string {
+	return filepath.Join(cfg.Qdrant.Path, cfg.Qdrant.CollectionName+".bm25.json")
+}
+
+// Open loads the lexical index of a configuration. The error wraps
+// os.ErrNotExist when the index has not been built yet.
+func Open(cfg *config.Config) (*Index, error) {
+	path := Path(cfg)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lexical index: %w", err)
+	}
+	var f indexFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse lexical index %s: %w", path, err)
+	}
+	if f.Version != formatVersion {
+		return nil, fmt.Errorf("lexical index %s has version %d, expected %d", path, f.Version, formatVersion)
+	}
+
+	ix := NewIndex()
+	for _, d := range f.Documents {
+		ix.Add(d.ID, d.Payload)
+	}
+	return ix, nil
+}
+
+// Save writes the index to path through a temporary file renamed into place
+func (ix *Index) Save(path string) error {
+	data, err := json.Marshal(indexFile{Version: formatVersion, Documents: ix.docs})
+	if err != nil {
+		return fmt.Errorf("failed to encode lexical index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create lexical index directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lexical index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace lexical index: %w", err)
+	}
+	return nil
+}
+
+// Build indexes the messages of the embeddings file and saves the index,
+// returning how many messages were added. Chunks are skipped: BM25 already
+// normalises for length, so whole messages are indexed. In incremental mode
+// the stored index is extended, otherwise it is rebuilt.
+func Build(cfg *config.Config) (int, error) {
+	ix := NewIndex()
+	if cfg.Pipeline.Incremental {
+		stored, err := Open(cfg)
+		switch {
+		case err == nil:
+			ix = stored
+		case !errors.Is(err, os.ErrNotExist):
+			return 0, err
+		}
+	}
+
+	filePath := filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl")
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open embeddings file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	added := 0
+	for scanner.Scan() {
+		var msg embeddings.MessageEmbeddingOut
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return 0, fmt.Errorf("failed to parse message JSON: %w", err)
+		}
+		if msg.IsChunk() || ix.Has(msg.ID) {
+			continue
+		}
+		ix.Add(msg.ID, vector.PayloadFields(msg.Text, msg.Metadata, msg.Chunk, cfg.Persona))
+		added++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading embeddings file: %w", err)
+	}
+
+	if err := ix.Save(Path(cfg)); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// Forget removes the messages with the given IDs from the stored index and
+// returns how many were removed. Without a stored index there is nothing to
+// remove.
+func Forget(cfg *config.Config, ids map[string]bool) (int, error) {
+	stored, err := Open(cfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ix := NewIndex()
+	for _, d := range stored.docs {
+		if !ids[d.ID] {
+			ix.Add(d.ID, d.Payload)
+		}
+	}
+	removed := stored.Len() - ix.Len()
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, ix.Save(Path(cfg))
+}
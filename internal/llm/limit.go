@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yourusername/psagents/config"
+)
+
+// Limiter spaces out requests so that callers sharing it stay under a
+// provider's rate limit. A nil Limiter never waits.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // earliest time the next request may start
+}
+
+// NewLimiter returns a limiter for the requests_per_minute of the
+// configured provider, or nil when it has no limit
+func NewLimiter(cfg *config.Config) *Limiter {
+	perMinute := cfg.LLM.Providers[cfg.LLM.Provider].RequestsPerMinute
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// Wait blocks until a request may be sent, or returns the error of ctx when
+// it is done first
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleep(ctx, time.Until(slot))
+}
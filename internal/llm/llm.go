@@ -2,6 +2,7 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,6 +22,23 @@ type LLM interface {
 	Close() error
 }
 
+// ContextLLM is implemented by LLMs whose requests can be cancelled
+type ContextLLM interface {
+	GetInferenceContext(ctx context.Context, prompt string, system_prompt string) (string, error)
+}
+
+// Infer gets an inference from an LLM, cancelling the request with ctx when
+// the LLM supports it
+func Infer(ctx context.Context, l LLM, prompt string, system_prompt string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if cl, ok := l.(ContextLLM); ok {
+		return cl.GetInferenceContext(ctx, prompt, system_prompt)
+	}
+	return l.GetInference(prompt, system_prompt)
+}
+
 // OllamaLLM implements the LLM interface for Ollama
 type OllamaLLM struct {
 	cfg    *config.Config
@@ -220,37 +238,20 @@ func (l *OllamaLLM) HealthCheck() error {
 	return nil
 }
 
-// HealthCheck verifies that OpenAI API is accessible
+// HealthCheck is a no-op for OpenAI: the endpoint is usually OpenRouter,
+// which the api.openai.com model listing doesn't cover, so a bad endpoint or
+// key only shows with the first request
 func (l *OpenAILLM) HealthCheck() error {
 	return nil
-	providerCfg := l.cfg.LLM.Providers["openai"]
-	// Create a simple request to list models
-	req, err := http.NewRequest("GET", "https://api.openai.com/v1/models", nil)
-	if err != nil {
-		return fmt.Errorf("failed to create health check request: %w", err)
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", providerCfg.APIKey))
-
-	// Send request
-	resp, err := l.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to connect to OpenAI API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("OpenAI health check failed with status code: %d", resp.StatusCode)
-	}
-
-	l.logger.WithFields(logrus.Fields{
-		"model": providerCfg.Model,
-	}).Debug("OpenAI health check passed")
-	return nil
 }
 
 // GetInference gets an inference from Ollama for the given prompt
 func (l *OllamaLLM) GetInference(prompt string, system_prompt string) (string, error) {
+	return l.GetInferenceContext(context.Background(), prompt, system_prompt)
+}
+
+// GetInferenceContext gets an inference from Ollama, cancelled with ctx
+func (l *OllamaLLM) GetInferenceContext(ctx context.Context, prompt string, system_prompt string) (string, error) {
 	startTime := time.Now()
 	providerCfg := l.cfg.LLM.Providers["ollama"]
 	// Create request body
@@ -275,7 +276,7 @@ func (l *OllamaLLM) GetInference(prompt string, system_prompt string) (string, e
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", providerCfg.Endpoint, bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", providerCfg.Endpoint, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -293,6 +294,9 @@ func (l *OllamaLLM) GetInference(prompt string, system_prompt string) (string, e
 	// Send request
 	resp, err := l.client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		// Check if Ollama is still running
 		if healthErr := l.HealthCheck(); healthErr != nil {
 			return "", fmt.Errorf("Ollama appears to be down: %w", healthErr)
@@ -334,6 +338,12 @@ func (l *OllamaLLM) GetInference(prompt string, system_prompt string) (string, e
 
 // GetInference gets an inference from OpenAI for the given prompt
 func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, error) {
+	return l.GetInferenceContext(context.Background(), prompt, system_prompt)
+}
+
+// GetInferenceContext gets an inference from OpenAI, cancelled with ctx
+// along with any wait between retries
+func (l *OpenAILLM) GetInferenceContext(ctx context.Context, prompt string, system_prompt string) (string, error) {
 	startTime := time.Now()
 	providerCfg := l.cfg.LLM.Providers["openai"]
 
@@ -362,17 +372,20 @@ func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, e
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create request
-	req, err := http.NewRequest("POST", providerCfg.Endpoint, bytes.NewBuffer(reqBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
+	// Create a request per attempt, as sending one consumes its body
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", providerCfg.Endpoint, bytes.NewReader(reqBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
-	// Set required headers for OpenRouter
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", providerCfg.APIKey))
-	req.Header.Set("HTTP-Referer", "https://github.com/yourusername/psagents")  // Required by OpenRouter
-	req.Header.Set("X-Title", "PS Agents")  // Required by OpenRouter
+		// Set required headers for OpenRouter
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", providerCfg.APIKey))
+		req.Header.Set("HTTP-Referer", "https://github.com/yourusername/psagents")  // Required by OpenRouter
+		req.Header.Set("X-Title", "PS Agents")  // Required by OpenRouter
+		return req, nil
+	}
 
 	// Log request in dev mode
 	if l.cfg.DevMode.Enabled {
@@ -391,8 +404,15 @@ func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, e
 	var lastError error
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return "", err
+		}
 		resp, err = l.client.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
 			lastError = fmt.Errorf("failed to send request (attempt %d): %w", attempt+1, err)
 			continue
 		}
@@ -404,6 +424,7 @@ func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, e
 			goto ProcessResponse
 		case http.StatusTooManyRequests:
 			resp.Body.Close()
+			lastError = fmt.Errorf("rate limited (attempt %d)", attempt+1)
 			if attempt < maxRetries-1 {
 				// Get retry delay from response header or use default
 				retryAfter := resp.Header.Get("Retry-After")
@@ -416,7 +437,9 @@ func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, e
 					"attempt": attempt + 1,
 					"delay":   retryDelay.String(),
 				}).Warn("Rate limited by OpenRouter, retrying after delay")
-				time.Sleep(retryDelay)
+				if err := sleep(ctx, retryDelay); err != nil {
+					return "", err
+				}
 				continue
 			}
 		default:
@@ -430,7 +453,9 @@ func (l *OpenAILLM) GetInference(prompt string, system_prompt string) (string, e
 					"status":  resp.StatusCode,
 					"error":   string(body),
 				}).Warn("Request failed, retrying")
-				time.Sleep(retryDelay)
+				if err := sleep(ctx, retryDelay); err != nil {
+					return "", err
+				}
 				continue
 			}
 		}
@@ -473,6 +498,18 @@ ProcessResponse:
 	return chatResp.Choices[0].Message.Content, nil
 }
 
+// sleep waits for d, returning early with the error of ctx when it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Close closes the LLM client
 func (l *OllamaLLM) Close() error {
 	return nil
@@ -1,11 +1,15 @@
 package llm
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yourusername/psagents/config"
@@ -15,7 +19,7 @@ func TestOllamaLLM(t *testing.T) {
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Handle health check
-		if strings.HasSuffix(r.URL.Path, "/health") {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -43,15 +47,18 @@ func TestOllamaLLM(t *testing.T) {
 			if req.Model != "test-model" {
 				t.Errorf("Expected model test-model, got %s", req.Model)
 			}
-			if len(req.Messages) != 1 {
-				t.Errorf("Expected 1 message, got %d", len(req.Messages))
+			if len(req.Messages) != 2 {
+				t.Errorf("Expected 2 messages, got %d", len(req.Messages))
 				return
 			}
-			if req.Messages[0].Role != "user" {
-				t.Errorf("Expected role user, got %s", req.Messages[0].Role)
+			if req.Messages[0].Role != "system" || req.Messages[0].Content != "test system prompt" {
+				t.Errorf("Expected the system prompt first, got %+v", req.Messages[0])
 			}
-			if req.Messages[0].Content != "test prompt" {
-				t.Errorf("Expected content 'test prompt', got %s", req.Messages[0].Content)
+			if req.Messages[1].Role != "user" {
+				t.Errorf("Expected role user, got %s", req.Messages[1].Role)
+			}
+			if req.Messages[1].Content != "test prompt" {
+				t.Errorf("Expected content 'test prompt', got %s", req.Messages[1].Content)
 			}
 
 			// Send response
@@ -75,9 +82,10 @@ func TestOllamaLLM(t *testing.T) {
 	cfg := &config.Config{
 		LLM: config.LLMConfig{
 			Provider: "ollama",
-			Model:    "test-model",
-			Endpoint: server.URL + "/chat",
 			Timeout:  30,
+			Providers: map[string]config.ProviderConfig{
+				"ollama": {Enabled: true, Model: "test-model", Endpoint: server.URL + "/chat"},
+			},
 		},
 		Logging: config.LoggingConfig{
 			Level:  "debug",
@@ -108,7 +116,7 @@ func TestOllamaLLMError(t *testing.T) {
 	// Create test server that returns an error
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Handle health check
-		if strings.HasSuffix(r.URL.Path, "/health") {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -125,9 +133,10 @@ func TestOllamaLLMError(t *testing.T) {
 	cfg := &config.Config{
 		LLM: config.LLMConfig{
 			Provider: "ollama",
-			Model:    "test-model",
-			Endpoint: server.URL + "/chat",
 			Timeout:  30,
+			Providers: map[string]config.ProviderConfig{
+				"ollama": {Enabled: true, Model: "test-model", Endpoint: server.URL + "/chat"},
+			},
 		},
 		Logging: config.LoggingConfig{
 			Level:  "debug",
@@ -194,9 +203,10 @@ func TestOllamaLLMHealthCheck(t *testing.T) {
 			cfg := &config.Config{
 				LLM: config.LLMConfig{
 					Provider: "ollama",
-					Model:    "test-model",
-					Endpoint: "http://localhost:11434/chat",
 					Timeout:  1,
+					Providers: map[string]config.ProviderConfig{
+						"ollama": {Enabled: true, Model: "test-model", Endpoint: "http://localhost:11434/chat"},
+					},
 				},
 				Logging: config.LoggingConfig{
 					Level:  "debug",
@@ -205,7 +215,9 @@ func TestOllamaLLMHealthCheck(t *testing.T) {
 			}
 
 			if tt.ollamaRunning {
-				cfg.LLM.Endpoint = server.URL + "/chat"
+				providerCfg := cfg.LLM.Providers["ollama"]
+				providerCfg.Endpoint = server.URL + "/chat"
+				cfg.LLM.Providers["ollama"] = providerCfg
 			}
 
 			llm := &OllamaLLM{
@@ -226,4 +238,88 @@ func TestOllamaLLMHealthCheck(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+} 
+
+func TestInferCancel(t *testing.T) {
+	// The server answers health checks and holds chat requests until the
+	// client goes away or the test ends
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	for _, provider := range []string{"ollama", "openai"} {
+		t.Run(provider, func(t *testing.T) {
+			cfg := &config.Config{
+				LLM: config.LLMConfig{
+					Provider: provider,
+					Timeout:  30,
+					Providers: map[string]config.ProviderConfig{
+						provider: {Enabled: true, Model: "test-model", Endpoint: server.URL + "/chat", APIKey: "test-key"},
+					},
+				},
+				Logging: config.LoggingConfig{Level: "error", Format: "text"},
+			}
+			llm, err := NewLLM(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create LLM: %v", err)
+			}
+			defer llm.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if _, err := Infer(ctx, llm, "test prompt", "test system prompt"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected the deadline to cancel the request, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Expected the request to stop with its context, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	cfg := &config.Config{LLM: config.LLMConfig{
+		Provider:  "openai",
+		Providers: map[string]config.ProviderConfig{"openai": {RequestsPerMinute: 1200}},
+	}}
+	limiter := NewLimiter(cfg)
+	if limiter == nil {
+		t.Fatal("Expected a limiter for a provider with a rate limit")
+	}
+
+	// 1200 requests a minute are 50ms apart
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 3 requests to take at least 100ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.Wait(ctx)
+	if err := limiter.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled wait, got %v", err)
+	}
+
+	// Without a limit nothing waits
+	cfg.LLM.Providers["openai"] = config.ProviderConfig{}
+	if limiter := NewLimiter(cfg); limiter != nil || limiter.Wait(context.Background()) != nil {
+		t.Error("Expected no limiter for a provider without a rate limit")
+	}
+}
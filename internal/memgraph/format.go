@@ -0,0 +1,307 @@
+package memgraph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+// The snapshot file is little-endian binary:
+//
+//	magic "PSGS" | version u32 | nodes u32 | edges u32
+//	nodes × (id str | text str | properties u32 | properties × (key str | value))
+//	edges × (source u32 | target u32 | type str | relation str
+//	         score f64 | confidence f64 | evidence str | reason str)
+//
+// where str is a u32 length followed by UTF-8 bytes, edge endpoints are
+// node numbers in file order and a value is a kind byte followed by a str
+// (0), a byte (1, bool), an f64 (2) or a u32 count of strs (3, list).
+// Nodes are sorted by ID and edges by endpoints, type and relation, so the
+// same graph always gives the same file.
+var fileMagic = [4]byte{'P', 'S', 'G', 'S'}
+
+const fileVersion = 1
+
+const (
+	kindString byte = iota
+	kindBool
+	kindFloat
+	kindList
+)
+
+// save writes the nodes and edges to path, replacing it atomically
+func save(path string, nodes []graph.Node, edges []graph.Edge) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create graph store directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create graph store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := encode(w, nodes, edges); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write graph store file: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write graph store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write graph store file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load reads a file written by save
+func load(path string) ([]graph.Node, []graph.Edge, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	nodes, edges, err := decode(&decoder{r: bufio.NewReader(file)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read graph store file %s: %w", path, err)
+	}
+	return nodes, edges, nil
+}
+
+func encode(w io.Writer, nodes []graph.Node, edges []graph.Edge) error {
+	e := &encoder{w: w}
+	e.bytes(fileMagic[:])
+	e.u32(fileVersion)
+	e.u32(uint32(len(nodes)))
+	e.u32(uint32(len(edges)))
+
+	numbers := make(map[string]uint32, len(nodes))
+	for i, n := range nodes {
+		numbers[n.ID] = uint32(i)
+		e.str(n.ID)
+		e.str(n.Text)
+		keys := make([]string, 0, len(n.Properties))
+		for key := range n.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.u32(uint32(len(keys)))
+		for _, key := range keys {
+			e.str(key)
+			e.value(n.Properties[key])
+		}
+	}
+	for _, edge := range edges {
+		source, ok := numbers[edge.Source]
+		target, ok2 := numbers[edge.Target]
+		if !ok || !ok2 {
+			return fmt.Errorf("edge %s -> %s has a missing endpoint", edge.Source, edge.Target)
+		}
+		e.u32(source)
+		e.u32(target)
+		e.str(edge.Type)
+		e.str(edge.Relation)
+		e.f64(edge.Score)
+		e.f64(edge.Confidence)
+		e.str(edge.Evidence)
+		e.str(edge.Reason)
+	}
+	return e.err
+}
+
+func decode(d *decoder) ([]graph.Node, []graph.Edge, error) {
+	var magic [4]byte
+	d.bytes(magic[:])
+	if d.err == nil && magic != fileMagic {
+		return nil, nil, errors.New("not a graph store file")
+	}
+	if version := d.u32(); d.err == nil && version != fileVersion {
+		return nil, nil, fmt.Errorf("unsupported graph store file version %d", version)
+	}
+	nodeCount, edgeCount := d.u32(), d.u32()
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+
+	var nodes []graph.Node
+	for i := uint32(0); i < nodeCount && d.err == nil; i++ {
+		n := graph.Node{ID: d.str(), Text: d.str()}
+		if count := d.u32(); count > 0 {
+			n.Properties = make(map[string]interface{}, count)
+			for ; count > 0 && d.err == nil; count-- {
+				key := d.str()
+				n.Properties[key] = d.value()
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	var edges []graph.Edge
+	for i := uint32(0); i < edgeCount && d.err == nil; i++ {
+		source, target := d.u32(), d.u32()
+		if d.err == nil && (source >= nodeCount || target >= nodeCount) {
+			d.fail(fmt.Errorf("edge %d links missing nodes %d and %d", i, source, target))
+			break
+		}
+		edge := graph.Edge{Type: d.str(), Relation: d.str(), Score: d.f64(), Confidence: d.f64(), Evidence: d.str(), Reason: d.str()}
+		if d.err == nil {
+			edge.Source, edge.Target = nodes[source].ID, nodes[target].ID
+		}
+		edges = append(edges, edge)
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return nodes, edges, nil
+}
+
+// encoder writes little-endian values, keeping the first error
+type encoder struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func (e *encoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:4], v)
+	e.bytes(e.buf[:4])
+}
+
+func (e *encoder) f64(v float64) {
+	binary.LittleEndian.PutUint64(e.buf[:], math.Float64bits(v))
+	e.bytes(e.buf[:])
+}
+
+func (e *encoder) str(s string) {
+	e.u32(uint32(len(s)))
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		e.bytes([]byte{kindString})
+		e.str(v)
+	case bool:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		e.bytes([]byte{kindBool, b})
+	case float64:
+		e.bytes([]byte{kindFloat})
+		e.f64(v)
+	case []string:
+		e.bytes([]byte{kindList})
+		e.u32(uint32(len(v)))
+		for _, s := range v {
+			e.str(s)
+		}
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("unsupported property value %T", v)
+		}
+	}
+}
+
+// decoder reads little-endian values, keeping the first error
+type decoder struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+// maxString bounds string lengths so a corrupt file can't allocate wildly
+const maxString = 64 << 20
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) bytes(b []byte) {
+	if d.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) byte() byte {
+	d.bytes(d.buf[:1])
+	return d.buf[0]
+}
+
+func (d *decoder) u32() uint32 {
+	d.bytes(d.buf[:4])
+	if d.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(d.buf[:4])
+}
+
+func (d *decoder) f64() float64 {
+	d.bytes(d.buf[:])
+	if d.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:]))
+}
+
+func (d *decoder) str() string {
+	n := d.u32()
+	if n > maxString {
+		d.fail(fmt.Errorf("string of %d bytes exceeds the limit", n))
+	}
+	if d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	d.bytes(b)
+	return string(b)
+}
+
+func (d *decoder) value() interface{} {
+	switch kind := d.byte(); kind {
+	case kindString:
+		return d.str()
+	case kindBool:
+		return d.byte() == 1
+	case kindFloat:
+		return d.f64()
+	case kindList:
+		count := d.u32()
+		if count > maxString {
+			d.fail(fmt.Errorf("list of %d items exceeds the limit", count))
+			return nil
+		}
+		list := make([]string, 0, count)
+		for ; count > 0 && d.err == nil; count-- {
+			list = append(list, d.str())
+		}
+		return list
+	default:
+		d.fail(fmt.Errorf("unknown property kind %d", kind))
+		return nil
+	}
+}
@@ -0,0 +1,670 @@
+package memgraph
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/filelock"
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+// Store is an embedded graph database: the message nodes of one label and
+// their edges, kept in memory and persisted under graphdb.path. Every change
+// is appended to a journal before it is applied, and the journal is folded
+// into the snapshot file when the store is opened and closed, so a run that
+// dies midway loses nothing it wrote. Only one Store at a time can have a
+// path open; it holds a lock on it until it is closed.
+type Store struct {
+	logger  *logrus.Logger
+	path    string
+	lock    *filelock.Lock
+	mu      sync.RWMutex
+	nodes   map[string]*entry
+	edges   map[edgeKey]*graph.Edge
+	journal *os.File
+	pending int // changes journaled since the last snapshot
+}
+
+// entry is a node with the keys of its edges
+type entry struct {
+	node graph.Node
+	out  map[edgeKey]bool
+	in   map[edgeKey]bool
+}
+
+// edgeKey identifies an edge
+type edgeKey struct {
+	source, target, typ, relation string
+}
+
+func keyOf(e graph.Edge) edgeKey {
+	return edgeKey{e.Source, e.Target, e.Type, e.Relation}
+}
+
+// change is a journal line
+type change struct {
+	Op    string       `json:"op"` // nodes, edges, delete_nodes or delete_edges
+	Nodes []graph.Node `json:"nodes,omitempty"`
+	Edges []graph.Edge `json:"edges,omitempty"`
+	IDs   []string     `json:"ids,omitempty"`
+}
+
+// StorePath returns the snapshot file of a configuration's store, named
+// after the persona scoped message label
+func StorePath(cfg *config.Config) string {
+	return filepath.Join(cfg.GraphDB.StoreDir(), cfg.GraphDB.MessageLabel()+".graph")
+}
+
+// JournalPath returns the journal next to a snapshot file
+func JournalPath(path string) string {
+	return path + ".journal"
+}
+
+// LockPath returns the lock file next to a snapshot file
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// Open opens the store of a configuration, loading its snapshot and
+// replaying its journal if they exist
+func Open(cfg *config.Config) (*Store, error) {
+	logger := logrus.New()
+	if cfg.Logging.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	logger.SetLevel(level)
+
+	s := &Store{
+		logger: logger,
+		path:   StorePath(cfg),
+		nodes:  make(map[string]*entry),
+		edges:  make(map[edgeKey]*graph.Edge),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create graph store directory: %w", err)
+	}
+	if s.lock, err = filelock.Acquire(LockPath(s.path)); err != nil {
+		return nil, fmt.Errorf("failed to open graph store: %w", err)
+	}
+	replayed, err := s.openFiles()
+	if err != nil {
+		s.lock.Release()
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"path":     s.path,
+		"nodes":    len(s.nodes),
+		"edges":    len(s.edges),
+		"replayed": replayed,
+	}).Info("Loaded graph store")
+	return s, nil
+}
+
+// openFiles loads the snapshot, replays the journal and opens it for
+// appending. It returns how many changes were replayed.
+func (s *Store) openFiles() (int, error) {
+	nodes, edges, err := load(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.logger.WithField("path", s.path).Debug("No graph store file yet")
+	case err != nil:
+		return 0, err
+	default:
+		s.upsertNodes(nodes)
+		s.upsertEdges(edges)
+	}
+
+	replayed, err := s.replay()
+	if err != nil {
+		return 0, err
+	}
+	if replayed > 0 {
+		if err := s.snapshot(); err != nil {
+			return 0, err
+		}
+	}
+
+	s.journal, err = os.OpenFile(JournalPath(s.path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open graph store journal: %w", err)
+	}
+	return replayed, nil
+}
+
+// replay applies the changes of the journal and returns how many there were.
+// A last line cut short by a crash is ignored, as its change was never
+// applied.
+func (s *Store) replay() (int, error) {
+	file, err := os.Open(JournalPath(s.path))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open graph store journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	count := 0
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("failed to read graph store journal: %w", err)
+		}
+		last := err == io.EOF
+		if len(bytes.TrimSpace(line)) > 0 {
+			var c change
+			if err := json.Unmarshal(line, &c); err != nil {
+				if last {
+					s.logger.WithField("line", lineNum).Warn("Ignoring incomplete last journal line")
+					break
+				}
+				return 0, fmt.Errorf("failed to parse graph store journal line %d: %w", lineNum, err)
+			}
+			if err := s.apply(c); err != nil {
+				return 0, fmt.Errorf("graph store journal line %d: %w", lineNum, err)
+			}
+			count++
+		}
+		if last {
+			break
+		}
+	}
+	return count, nil
+}
+
+// apply makes a journaled change in memory
+func (s *Store) apply(c change) error {
+	switch c.Op {
+	case "nodes":
+		for i := range c.Nodes {
+			if err := normalize(&c.Nodes[i]); err != nil {
+				return err
+			}
+		}
+		s.upsertNodes(c.Nodes)
+	case "edges":
+		s.upsertEdges(c.Edges)
+	case "delete_nodes":
+		s.deleteNodes(c.IDs)
+	case "delete_edges":
+		s.deleteEdges(c.Edges)
+	default:
+		return fmt.Errorf("unknown change %q", c.Op)
+	}
+	return nil
+}
+
+// record appends a change to the journal
+func (s *Store) record(c change) error {
+	if s.journal == nil {
+		return errors.New("graph store is closed")
+	}
+	line, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode graph store change: %w", err)
+	}
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write graph store journal: %w", err)
+	}
+	s.pending++
+	return nil
+}
+
+// snapshot saves the graph and empties the journal. Should the journal
+// survive a crash in between, replaying it onto the new snapshot changes
+// nothing, as every change is idempotent.
+func (s *Store) snapshot() error {
+	nodes := make([]graph.Node, 0, len(s.nodes))
+	for _, e := range s.nodes {
+		nodes = append(nodes, e.node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	if err := save(s.path, nodes, s.sortedEdges("")); err != nil {
+		return err
+	}
+	if s.journal != nil {
+		if err := s.journal.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate graph store journal: %w", err)
+		}
+	} else if err := os.Remove(JournalPath(s.path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove graph store journal: %w", err)
+	}
+	s.pending = 0
+	return nil
+}
+
+// normalize checks a node and converts its property values
+func normalize(n *graph.Node) error {
+	if n.ID == "" {
+		return errors.New("node without an ID")
+	}
+	if len(n.Properties) == 0 {
+		return nil
+	}
+	props := make(map[string]interface{}, len(n.Properties))
+	for key, value := range n.Properties {
+		v, err := graph.NormalizeValue(value)
+		if err != nil {
+			return fmt.Errorf("property %s of node %s: %w", key, n.ID, err)
+		}
+		props[key] = v
+	}
+	n.Properties = props
+	return nil
+}
+
+func validEdge(e graph.Edge) error {
+	if e.Source == "" || e.Target == "" {
+		return fmt.Errorf("edge %q -> %q is missing an endpoint", e.Source, e.Target)
+	}
+	if !graph.ValidEdgeType(e.Type) {
+		return fmt.Errorf("unknown edge type %q", e.Type)
+	}
+	return nil
+}
+
+// node returns the entry of a node, creating it if needed
+func (s *Store) node(id string) *entry {
+	e, ok := s.nodes[id]
+	if !ok {
+		e = &entry{node: graph.Node{ID: id}, out: make(map[edgeKey]bool), in: make(map[edgeKey]bool)}
+		s.nodes[id] = e
+	}
+	return e
+}
+
+func (s *Store) upsertNodes(nodes []graph.Node) {
+	for _, n := range nodes {
+		e := s.node(n.ID)
+		if n.Text != "" {
+			e.node.Text = n.Text
+		}
+		for key, value := range n.Properties {
+			if value == nil {
+				delete(e.node.Properties, key)
+				continue
+			}
+			if e.node.Properties == nil {
+				e.node.Properties = make(map[string]interface{})
+			}
+			e.node.Properties[key] = value
+		}
+	}
+}
+
+func (s *Store) upsertEdges(edges []graph.Edge) {
+	for _, edge := range edges {
+		key := keyOf(edge)
+		if existing, ok := s.edges[key]; ok {
+			*existing = edge
+			continue
+		}
+		stored := edge
+		s.edges[key] = &stored
+		s.node(edge.Source).out[key] = true
+		s.node(edge.Target).in[key] = true
+	}
+}
+
+func (s *Store) removeEdge(key edgeKey) bool {
+	if _, ok := s.edges[key]; !ok {
+		return false
+	}
+	delete(s.edges, key)
+	if e, ok := s.nodes[key.source]; ok {
+		delete(e.out, key)
+	}
+	if e, ok := s.nodes[key.target]; ok {
+		delete(e.in, key)
+	}
+	return true
+}
+
+func (s *Store) deleteNodes(ids []string) (int, int) {
+	nodes, edges := 0, 0
+	for _, id := range ids {
+		e, ok := s.nodes[id]
+		if !ok {
+			continue
+		}
+		for _, keys := range []map[edgeKey]bool{e.out, e.in} {
+			for key := range keys {
+				if s.removeEdge(key) {
+					edges++
+				}
+			}
+		}
+		delete(s.nodes, id)
+		nodes++
+	}
+	return nodes, edges
+}
+
+func (s *Store) deleteEdges(edges []graph.Edge) int {
+	removed := 0
+	for _, edge := range edges {
+		if s.removeEdge(keyOf(edge)) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// UpsertNodes creates or updates nodes
+func (s *Store) UpsertNodes(ctx context.Context, nodes []graph.Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	normalized := make([]graph.Node, len(nodes))
+	for i, n := range nodes {
+		normalized[i] = n
+		if err := normalize(&normalized[i]); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record(change{Op: "nodes", Nodes: normalized}); err != nil {
+		return err
+	}
+	s.upsertNodes(normalized)
+	return nil
+}
+
+// UpsertEdges creates or updates edges and their missing endpoints
+func (s *Store) UpsertEdges(ctx context.Context, edges []graph.Edge) error {
+	if len(edges) == 0 {
+		return nil
+	}
+	for _, edge := range edges {
+		if err := validEdge(edge); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record(change{Op: "edges", Edges: edges}); err != nil {
+		return err
+	}
+	s.upsertEdges(edges)
+	return nil
+}
+
+// copyNode returns a node that shares nothing with the store
+func copyNode(n graph.Node) graph.Node {
+	if n.Properties == nil {
+		return n
+	}
+	props := make(map[string]interface{}, len(n.Properties))
+	for key, value := range n.Properties {
+		if list, ok := value.([]string); ok {
+			value = append([]string(nil), list...)
+		}
+		props[key] = value
+	}
+	n.Properties = props
+	return n
+}
+
+// Nodes returns the nodes with the given IDs, or all of them sorted by ID
+func (s *Store) Nodes(ctx context.Context, ids []string) ([]graph.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var nodes []graph.Node
+	if ids == nil {
+		nodes = make([]graph.Node, 0, len(s.nodes))
+		for _, e := range s.nodes {
+			nodes = append(nodes, copyNode(e.node))
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+		return nodes, nil
+	}
+	for _, id := range ids {
+		if e, ok := s.nodes[id]; ok {
+			nodes = append(nodes, copyNode(e.node))
+		}
+	}
+	return nodes, nil
+}
+
+// sortedEdges returns the edges of a type, or all of them, in key order
+func (s *Store) sortedEdges(edgeType string) []graph.Edge {
+	edges := make([]graph.Edge, 0, len(s.edges))
+	for _, edge := range s.edges {
+		if edgeType == "" || edge.Type == edgeType {
+			edges = append(edges, *edge)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return lessKey(keyOf(edges[i]), keyOf(edges[j])) })
+	return edges
+}
+
+func lessKey(a, b edgeKey) bool {
+	if a.source != b.source {
+		return a.source < b.source
+	}
+	if a.target != b.target {
+		return a.target < b.target
+	}
+	if a.typ != b.typ {
+		return a.typ < b.typ
+	}
+	return a.relation < b.relation
+}
+
+// Edges returns the edges of a type, or all of them
+func (s *Store) Edges(ctx context.Context, edgeType string) ([]graph.Edge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedEdges(edgeType), nil
+}
+
+// adjacent returns the edges of a node in a direction whose type is one of
+// types (any when empty), strongest first
+func (s *Store) adjacent(e *entry, dir graph.Direction, types ...string) []graph.Edge {
+	var keys []map[edgeKey]bool
+	switch dir {
+	case graph.Outgoing:
+		keys = []map[edgeKey]bool{e.out}
+	case graph.Incoming:
+		keys = []map[edgeKey]bool{e.in}
+	default:
+		keys = []map[edgeKey]bool{e.out, e.in}
+	}
+
+	var edges []graph.Edge
+	seen := make(map[edgeKey]bool)
+	for _, set := range keys {
+		for key := range set {
+			if seen[key] || !hasType(types, key.typ) {
+				continue
+			}
+			seen[key] = true
+			edges = append(edges, *s.edges[key])
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if wi, wj := edges[i].Weight(), edges[j].Weight(); wi != wj {
+			return wi > wj
+		}
+		return lessKey(keyOf(edges[i]), keyOf(edges[j]))
+	})
+	return edges
+}
+
+func hasType(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, known := range types {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// other returns the end of an edge that isn't id
+func other(edge graph.Edge, id string) string {
+	if edge.Source == id {
+		return edge.Target
+	}
+	return edge.Source
+}
+
+// Neighbors returns the neighbours of a node, strongest edge first
+func (s *Store) Neighbors(ctx context.Context, id string, edgeType string, dir graph.Direction, limit int) ([]graph.Neighbor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.nodes[id]
+	if !ok {
+		return nil, nil
+	}
+	var types []string
+	if edgeType != "" {
+		types = []string{edgeType}
+	}
+	var neighbors []graph.Neighbor
+	for _, edge := range s.adjacent(e, dir, types...) {
+		if limit > 0 && len(neighbors) == limit {
+			break
+		}
+		neighbors = append(neighbors, graph.Neighbor{Node: copyNode(s.nodes[other(edge, id)].node), Edge: edge})
+	}
+	return neighbors, nil
+}
+
+// Expand walks every path of up to opts.MaxDepth edges from a node
+func (s *Store) Expand(ctx context.Context, id string, opts graph.ExpandOptions) ([]graph.Path, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start, ok := s.nodes[id]
+	if !ok || opts.MaxDepth < 1 {
+		return nil, nil
+	}
+
+	var paths []graph.Path
+	ids := []string{id}
+	var edges []graph.Edge
+	visited := map[string]bool{id: true}
+	var walk func(e *entry, confidence float64)
+	walk = func(e *entry, confidence float64) {
+		for _, edge := range s.adjacent(e, graph.Both, opts.Types...) {
+			next := other(edge, e.node.ID)
+			if visited[next] || edge.Confidence < opts.MinConfidence {
+				continue
+			}
+			visited[next] = true
+			ids = append(ids, next)
+			edges = append(edges, edge)
+
+			c := confidence * edge.Confidence
+			paths = append(paths, graph.Path{
+				IDs:        append([]string(nil), ids...),
+				End:        copyNode(s.nodes[next].node),
+				Edges:      append([]graph.Edge(nil), edges...),
+				Confidence: c,
+			})
+			if len(edges) < opts.MaxDepth {
+				walk(s.nodes[next], c)
+			}
+
+			visited[next] = false
+			ids = ids[:len(ids)-1]
+			edges = edges[:len(edges)-1]
+		}
+	}
+	walk(start, 1)
+
+	sort.SliceStable(paths, func(i, j int) bool { return paths[i].Confidence > paths[j].Confidence })
+	if opts.Limit > 0 && len(paths) > opts.Limit {
+		paths = paths[:opts.Limit]
+	}
+	return paths, nil
+}
+
+// DeleteNodes removes nodes and their edges
+func (s *Store) DeleteNodes(ctx context.Context, ids []string) (int, int, error) {
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record(change{Op: "delete_nodes", IDs: ids}); err != nil {
+		return 0, 0, err
+	}
+	nodes, edges := s.deleteNodes(ids)
+	return nodes, edges, nil
+}
+
+// DeleteEdges removes edges
+func (s *Store) DeleteEdges(ctx context.Context, edges []graph.Edge) (int, error) {
+	if len(edges) == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record(change{Op: "delete_edges", Edges: edges}); err != nil {
+		return 0, err
+	}
+	return s.deleteEdges(edges), nil
+}
+
+// Stats counts the nodes and edges
+func (s *Store) Stats(ctx context.Context) (graph.Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := graph.Stats{Nodes: len(s.nodes), Edges: make(map[string]int)}
+	for _, t := range graph.EdgeTypes {
+		stats.Edges[t] = 0
+	}
+	for key := range s.edges {
+		stats.Edges[key.typ]++
+	}
+	return stats, nil
+}
+
+// Close folds the journal into the snapshot and closes the store
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	var err error
+	if s.pending > 0 {
+		err = s.snapshot()
+	}
+	if closeErr := s.journal.Close(); err == nil {
+		err = closeErr
+	}
+	s.journal = nil
+	if lockErr := s.lock.Release(); err == nil {
+		err = lockErr
+	}
+	return err
+}
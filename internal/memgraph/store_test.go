@@ -0,0 +1,191 @@
+package memgraph
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/filelock"
+	"github.com/yourusername/psagents/internal/graph"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		GraphDB: config.GraphDBConfig{Type: "embedded", Path: filepath.Join(t.TempDir(), "graph")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+	}
+}
+
+func openStore(t *testing.T, cfg *config.Config) *Store {
+	t.Helper()
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	return store
+}
+
+// populate builds a chain a -> b -> c of relationships with a similar edge
+// from a to c
+func populate(t *testing.T, store *Store) {
+	t.Helper()
+	ctx := context.Background()
+	err := store.UpsertNodes(ctx, []graph.Node{
+		{ID: "a", Text: "about cats", Properties: map[string]interface{}{"sender": "me", "aliases": []interface{}{"x"}, "count": 2}},
+		{ID: "b", Text: "about dogs"},
+		{ID: "c", Text: "about birds"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert nodes: %v", err)
+	}
+	err = store.UpsertEdges(ctx, []graph.Edge{
+		{Source: "a", Target: "b", Type: graph.EdgeRelated, Relation: "Elaboration", Confidence: 0.8, Evidence: "pets"},
+		{Source: "b", Target: "c", Type: graph.EdgeRelated, Relation: "Contrast", Confidence: 0.5},
+		{Source: "a", Target: "c", Type: graph.EdgeSimilar, Score: 0.9},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert edges: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t, testConfig(t))
+	defer store.Close()
+	populate(t, store)
+
+	nodes, err := store.Nodes(ctx, []string{"a", "missing"})
+	if err != nil {
+		t.Fatalf("Failed to get nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Text != "about cats" || nodes[0].Properties["count"] != 2.0 ||
+		len(nodes[0].StringList("aliases")) != 1 {
+		t.Errorf("Expected node a with normalized properties, got %+v", nodes)
+	}
+
+	// Upserts merge properties, nil removing them, and keep the text when
+	// none is given
+	err = store.UpsertNodes(ctx, []graph.Node{{ID: "a", Properties: map[string]interface{}{"sender": nil, "flag": true}}})
+	if err != nil {
+		t.Fatalf("Failed to upsert node: %v", err)
+	}
+	nodes, _ = store.Nodes(ctx, []string{"a"})
+	if nodes[0].Text != "about cats" || nodes[0].Properties["sender"] != nil || nodes[0].Properties["flag"] != true {
+		t.Errorf("Expected merged properties, got %+v", nodes[0])
+	}
+
+	// Edges are identified by endpoints, type and relation
+	err = store.UpsertEdges(ctx, []graph.Edge{
+		{Source: "a", Target: "b", Type: graph.EdgeRelated, Relation: "Elaboration", Confidence: 0.9},
+		{Source: "a", Target: "b", Type: graph.EdgeRelated, Relation: "Answer", Confidence: 0.3},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert edges: %v", err)
+	}
+	if err := store.UpsertEdges(ctx, []graph.Edge{{Source: "a", Target: "b", Type: "UNKNOWN"}}); err == nil {
+		t.Error("Expected an error for an unknown edge type")
+	}
+
+	neighbors, err := store.Neighbors(ctx, "b", graph.EdgeRelated, graph.Both, 0)
+	if err != nil {
+		t.Fatalf("Failed to get neighbors: %v", err)
+	}
+	if len(neighbors) != 3 || neighbors[0].Node.ID != "a" || neighbors[0].Edge.Confidence != 0.9 ||
+		neighbors[1].Node.ID != "c" || neighbors[2].Edge.Relation != "Answer" {
+		t.Errorf("Expected the neighbors of b strongest first, got %+v", neighbors)
+	}
+	if neighbors, _ := store.Neighbors(ctx, "a", graph.EdgeSimilar, graph.Incoming, 0); len(neighbors) != 0 {
+		t.Errorf("Expected no incoming similar edges, got %+v", neighbors)
+	}
+	if neighbors, _ := store.Neighbors(ctx, "b", "", graph.Both, 1); len(neighbors) != 1 {
+		t.Errorf("Expected the limit to apply, got %+v", neighbors)
+	}
+
+	paths, err := store.Expand(ctx, "a", graph.ExpandOptions{Types: []string{graph.EdgeRelated}, MaxDepth: 2, MinConfidence: 0.4})
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	if len(paths) != 2 || paths[0].End.ID != "b" || paths[0].Confidence != 0.9 ||
+		paths[1].End.ID != "c" || len(paths[1].IDs) != 3 || paths[1].Confidence != 0.9*0.5 {
+		t.Errorf("Expected paths to b and c, got %+v", paths)
+	}
+	// The similar edge has no confidence, so a minimum leaves it out
+	if paths, _ := store.Expand(ctx, "a", graph.ExpandOptions{MaxDepth: 1, MinConfidence: 0.1}); len(paths) != 2 {
+		t.Errorf("Expected the similar edge to be skipped, got %+v", paths)
+	}
+
+	removed, err := store.DeleteEdges(ctx, []graph.Edge{
+		{Source: "a", Target: "b", Type: graph.EdgeRelated, Relation: "Answer"},
+		{Source: "a", Target: "b", Type: graph.EdgeRelated, Relation: "Missing"},
+	})
+	if err != nil || removed != 1 {
+		t.Errorf("Expected one removed edge, got %d (%v)", removed, err)
+	}
+
+	removedNodes, removedEdges, err := store.DeleteNodes(ctx, []string{"c", "missing"})
+	if err != nil || removedNodes != 1 || removedEdges != 2 {
+		t.Errorf("Expected c and its 2 edges removed, got %d and %d (%v)", removedNodes, removedEdges, err)
+	}
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Nodes != 2 || stats.Edges[graph.EdgeRelated] != 1 || stats.Edges[graph.EdgeSimilar] != 0 || stats.TotalEdges() != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestStoreLocked(t *testing.T) {
+	cfg := testConfig(t)
+	store := openStore(t, cfg)
+	if _, err := Open(cfg); !errors.Is(err, filelock.ErrLocked) {
+		t.Fatalf("Expected a second open to fail while the store is open, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+	openStore(t, cfg).Close()
+}
+
+func TestStorePersistence(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig(t)
+	path := StorePath(cfg)
+
+	store := openStore(t, cfg)
+	populate(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+	if info, err := os.Stat(JournalPath(path)); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty journal after close, got %v (%v)", info, err)
+	}
+
+	// Changes made without a close, as after a crash, are replayed from the
+	// journal, ignoring a last line cut short. The crashed process's lock is
+	// released by the OS.
+	store = openStore(t, cfg)
+	if _, _, err := store.DeleteNodes(ctx, []string{"b"}); err != nil {
+		t.Fatalf("Failed to delete node: %v", err)
+	}
+	store.journal.WriteString(`{"op":"nodes","nodes":[{"id":"d"`)
+	store.journal.Close()
+	store.lock.Release()
+
+	store = openStore(t, cfg)
+	defer store.Close()
+	stats, _ := store.Stats(ctx)
+	if stats.Nodes != 2 || stats.Edges[graph.EdgeSimilar] != 1 || stats.Edges[graph.EdgeRelated] != 0 {
+		t.Errorf("Expected a and c with their similar edge, got %+v", stats)
+	}
+	nodes, _ := store.Nodes(ctx, []string{"a"})
+	if len(nodes) != 1 || nodes[0].Properties["sender"] != "me" || nodes[0].StringList("aliases")[0] != "x" {
+		t.Errorf("Expected node a with its properties, got %+v", nodes)
+	}
+	if info, err := os.Stat(JournalPath(path)); err != nil || info.Size() != 0 {
+		t.Errorf("Expected the replayed journal folded into the snapshot, got %v (%v)", info, err)
+	}
+}
@@ -1,10 +1,136 @@
 package message
 
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Metadata describes where a message came from: who wrote it, to whom,
+// when, and in which channel and conversation thread. Every field is
+// optional; Timestamp is RFC3339. Context holds the reply the message
+// received (e.g. the assistant's answer); it is shown to the LLM but never
+// embedded.
+type Metadata struct {
+	Sender    string `json:"sender,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	Context   string `json:"context,omitempty"`
+}
+
+// MetadataFields lists the metadata keys as they appear in JSON, vector
+// payloads and graph node properties
+var MetadataFields = []string{"sender", "recipient", "timestamp", "channel", "thread_id", "context"}
+
 // Message represents a message with its text and metadata
 type Message struct {
 	ID    string   `json:"id"`
 	Text  string   `json:"text"`
 	Score float32  `json:"score,omitempty"`
+	Metadata
+}
+
+// Fields returns the non-empty metadata values keyed by their JSON name
+func (m Metadata) Fields() map[string]string {
+	fields := make(map[string]string)
+	for key, value := range map[string]string{
+		"sender":    m.Sender,
+		"recipient": m.Recipient,
+		"timestamp": m.Timestamp,
+		"channel":   m.Channel,
+		"thread_id": m.ThreadID,
+		"context":   m.Context,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// Properties returns the non-empty metadata values as graph node properties
+func (m Metadata) Properties() map[string]interface{} {
+	props := make(map[string]interface{})
+	for key, value := range m.Fields() {
+		props[key] = value
+	}
+	return props
+}
+
+// Validate checks that the metadata values are well formed
+func (m Metadata) Validate() error {
+	if m.Timestamp != "" {
+		if _, err := time.Parse(time.RFC3339, m.Timestamp); err != nil {
+			return fmt.Errorf("invalid timestamp %q (expected RFC3339): %w", m.Timestamp, err)
+		}
+	}
+	return nil
+}
+
+// MetadataFromFields builds metadata from a string map such as a vector payload
+func MetadataFromFields(fields map[string]string) Metadata {
+	return Metadata{
+		Sender:    fields["sender"],
+		Recipient: fields["recipient"],
+		Timestamp: fields["timestamp"],
+		Channel:   fields["channel"],
+		ThreadID:  fields["thread_id"],
+		Context:   fields["context"],
+	}
+}
+
+// MetadataFromProperties builds metadata from graph node properties,
+// ignoring missing or non-string values
+func MetadataFromProperties(props map[string]interface{}) Metadata {
+	fields := make(map[string]string)
+	for _, key := range MetadataFields {
+		if value, ok := props[key].(string); ok {
+			fields[key] = value
+		}
+	}
+	return MetadataFromFields(fields)
+}
+
+// Chunk links a chunk of a long message to the message it was cut from.
+// Chunks are embedded and stored as points of their own with ParentID set;
+// the message itself has ChunkCount set. Both are zero for messages that
+// were embedded whole.
+type Chunk struct {
+	ParentID   string `json:"parent_id,omitempty"`
+	ChunkIndex int    `json:"chunk_index,omitempty"`
+	ChunkCount int    `json:"chunk_count,omitempty"`
+}
+
+// IsChunk reports whether this is a chunk rather than a whole message
+func (c Chunk) IsChunk() bool {
+	return c.ParentID != ""
+}
+
+// Fields returns the non-zero chunk values keyed by their JSON name
+func (c Chunk) Fields() map[string]string {
+	fields := make(map[string]string)
+	if c.ParentID != "" {
+		fields["parent_id"] = c.ParentID
+		fields["chunk_index"] = strconv.Itoa(c.ChunkIndex)
+	}
+	if c.ChunkCount > 0 {
+		fields["chunk_count"] = strconv.Itoa(c.ChunkCount)
+	}
+	return fields
+}
+
+// ChunkFromFields builds chunk provenance from a string map such as a vector
+// payload
+func ChunkFromFields(fields map[string]string) Chunk {
+	index, _ := strconv.Atoi(fields["chunk_index"])
+	count, _ := strconv.Atoi(fields["chunk_count"])
+	return Chunk{
+		ParentID:   fields["parent_id"],
+		ChunkIndex: index,
+		ChunkCount: count,
+	}
 }
 
 // BatchRelationshipInput represents the input for batch relationship classification
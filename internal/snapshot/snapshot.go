@@ -0,0 +1,343 @@
+// Package snapshot saves the vector store of a persona to a portable dump
+// and restores it into either backend, to roll an agent back after a bad
+// ingest. A snapshot is a directory holding a manifest and the stored points
+// in the embeddings file format, which is what the backends inject from.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/lexical"
+	"github.com/yourusername/psagents/internal/vector_db"
+)
+
+const (
+	// defaultDir is where snapshots go when data.backup_dir is unset
+	defaultDir = "index_backups"
+	// manifestFile and pointsFile, with its vectors sidecar, are the files
+	// of a snapshot directory
+	manifestFile = "manifest.json"
+	pointsFile   = "messages_embeddings.jsonl"
+)
+
+// namePattern restricts snapshot names to safe directory names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Manifest describes a snapshot
+type Manifest struct {
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at"`
+	Persona        string    `json:"persona,omitempty"`
+	Backend        string    `json:"backend"`
+	Collection     string    `json:"collection"`
+	EmbeddingModel string    `json:"embedding_model"`
+	Dimension      int       `json:"dimension"`
+	Points         int       `json:"points"`
+}
+
+// Dir returns the directory the snapshots of a configuration live in
+func Dir(cfg *config.Config) string {
+	if cfg.Data.BackupDir != "" {
+		return cfg.Data.BackupDir
+	}
+	return filepath.Join(defaultDir, cfg.Persona)
+}
+
+// embeddingModel returns the name of the configured embedding model
+func embeddingModel(cfg *config.Config) (string, error) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	embedder, err := embeddings.NewEmbedder(cfg, logger)
+	if err != nil {
+		return "", err
+	}
+	return embedder.Model(), nil
+}
+
+// Create dumps every point of the configured vector store into a new
+// snapshot. An empty name uses the current UTC time.
+func Create(cfg *config.Config, name string) (Manifest, error) {
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if !namePattern.MatchString(name) {
+		return Manifest{}, fmt.Errorf("invalid snapshot name %q", name)
+	}
+	path := filepath.Join(Dir(cfg), name)
+	if _, err := os.Stat(path); err == nil {
+		return Manifest{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	model, err := embeddingModel(cfg)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to determine the embedding model: %w", err)
+	}
+
+	db, err := vector_db.New(cfg)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	defer db.Close()
+	messages, err := db.GetAllMessages()
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read stored points: %w", err)
+	}
+
+	backend := cfg.Vector.Backend
+	if backend == "" {
+		backend = vector_db.BackendQdrant
+	}
+	manifest := Manifest{
+		Name:           name,
+		CreatedAt:      time.Now().UTC(),
+		Persona:        cfg.Persona,
+		Backend:        backend,
+		Collection:     cfg.Qdrant.CollectionName,
+		EmbeddingModel: model,
+		Points:         len(messages),
+	}
+
+	// Write into a temporary directory renamed into place, so that a failed
+	// snapshot never shows up in the list
+	tmp := path + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return Manifest{}, fmt.Errorf("failed to clear %s: %w", tmp, err)
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return Manifest{}, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	writer, err := embeddings.CreateEmbeddings(filepath.Join(tmp, pointsFile), false)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to create points file: %w", err)
+	}
+	for _, msg := range messages {
+		if manifest.Dimension == 0 {
+			manifest.Dimension = len(msg.Embedding)
+		}
+		point := embeddings.MessageEmbeddingOut{
+			ID:        msg.ID,
+			Text:      msg.Text,
+			Embedding: msg.Embedding,
+			Metadata:  msg.Metadata,
+			Chunk:     msg.Chunk,
+		}
+		if err := writer.Write(point); err != nil {
+			writer.Close()
+			return Manifest{}, fmt.Errorf("failed to write point: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return Manifest{}, fmt.Errorf("failed to write points file: %w", err)
+	}
+	if err := writeManifest(filepath.Join(tmp, manifestFile), manifest); err != nil {
+		return Manifest{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Manifest{}, fmt.Errorf("failed to finalize snapshot: %w", err)
+	}
+	return manifest, nil
+}
+
+// List returns the snapshots of a configuration, oldest first
+func List(cfg *config.Config) ([]Manifest, error) {
+	entries, err := os.ReadDir(Dir(cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var manifests []Manifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(Dir(cfg), entry.Name(), manifestFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // not a snapshot, e.g. a graph backup
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].CreatedAt.Before(manifests[j].CreatedAt) })
+	return manifests, nil
+}
+
+// Restore replaces the collection of the given backend (the configured one
+// when empty) with the points of a snapshot and rebuilds the lexical index
+// from them. A snapshot taken with another embedding model than the
+// configured one is refused unless force is set, since questions would be
+// embedded differently from the restored points.
+//
+// The points being replaced are first saved to a snapshot of their own,
+// whose name is returned (empty when the collection was empty). If loading
+// the snapshot fails, they are loaded back from it.
+//
+// Only the vector store and lexical index are restored. The graph is left
+// as it is, so it may link messages the restored points don't have, and
+// lack the ones they add; rerun graph construction after a restore.
+func Restore(cfg *config.Config, name, backend string, force bool) (Manifest, string, error) {
+	if !namePattern.MatchString(name) {
+		return Manifest{}, "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	manifest, err := readManifest(filepath.Join(Dir(cfg), name, manifestFile))
+	if err != nil {
+		return Manifest{}, "", err
+	}
+
+	model, err := embeddingModel(cfg)
+	if err != nil {
+		return Manifest{}, "", fmt.Errorf("failed to determine the embedding model: %w", err)
+	}
+	if model != manifest.EmbeddingModel && !force {
+		return Manifest{}, "", fmt.Errorf("snapshot %s was embedded with %s but the config uses %s; pass --force to restore anyway",
+			name, manifest.EmbeddingModel, model)
+	}
+
+	target := *cfg
+	if backend != "" {
+		target.Vector.Backend = backend
+	}
+	previous, err := savePrevious(&target)
+	if err != nil {
+		return Manifest{}, "", fmt.Errorf("failed to snapshot the points being replaced: %w", err)
+	}
+	if previous == nil {
+		return manifest, "", load(&target, manifest)
+	}
+
+	if err := load(&target, manifest); err != nil {
+		if rollbackErr := load(&target, *previous); rollbackErr != nil {
+			return Manifest{}, previous.Name, fmt.Errorf("%w; loading back the previous points from snapshot %s failed too: %v",
+				err, previous.Name, rollbackErr)
+		}
+		return Manifest{}, previous.Name, fmt.Errorf("%w; the previous points were loaded back from snapshot %s", err, previous.Name)
+	}
+	return manifest, previous.Name, nil
+}
+
+// savePrevious snapshots the points of a configuration's collection before
+// a restore replaces them. It returns nil when there are none.
+func savePrevious(cfg *config.Config) (*Manifest, error) {
+	db, err := vector_db.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	manager, ok := db.(vector_db.CollectionManager)
+	if !ok {
+		db.Close()
+		return nil, fmt.Errorf("the %s backend cannot be restored into", cfg.Vector.Backend)
+	}
+	info, err := manager.DescribeCollection()
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+	if info.Points == 0 {
+		return nil, nil
+	}
+
+	manifest, err := Create(cfg, "pre-restore-"+time.Now().UTC().Format("20060102T150405Z"))
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// load replaces the collection of a configuration with the points of a
+// snapshot and rebuilds the lexical index from them
+func load(cfg *config.Config, manifest Manifest) error {
+	// Inject from the snapshot into a fresh collection sized like it
+	target := *cfg
+	target.Data.OutputDir = filepath.Join(Dir(cfg), manifest.Name)
+	target.Pipeline.Incremental = false
+	target.Qdrant.VectorSize = manifest.Dimension
+
+	db, err := vector_db.New(&target)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vector database: %w", err)
+	}
+	defer db.Close()
+	manager, ok := db.(vector_db.CollectionManager)
+	if !ok {
+		return fmt.Errorf("the %s backend cannot be restored into", target.Vector.Backend)
+	}
+	if err := manager.DropCollection(); err != nil {
+		return err
+	}
+	if err := db.CreateCollection(); err != nil {
+		return err
+	}
+	if err := db.InjectMessages(); err != nil {
+		return fmt.Errorf("failed to inject snapshot points: %w", err)
+	}
+	if _, err := lexical.Build(&target); err != nil {
+		return fmt.Errorf("failed to rebuild lexical index: %w", err)
+	}
+	return nil
+}
+
+// Forget removes the points of the given message IDs, and the chunks cut
+// from them, from every snapshot so that a restore cannot bring them back.
+// It returns how many points were removed.
+func Forget(cfg *config.Config, ids map[string]bool) (int, error) {
+	manifests, err := List(cfg)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, manifest := range manifests {
+		path := filepath.Join(Dir(cfg), manifest.Name)
+		removed, err := embeddings.RemoveMessages(filepath.Join(path, pointsFile), func(msg embeddings.MessageEmbeddingOut) bool {
+			return ids[msg.ID] || ids[msg.ParentID]
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to forget messages in snapshot %s: %w", manifest.Name, err)
+		}
+		if removed == 0 {
+			continue
+		}
+		manifest.Points -= removed
+		if err := writeManifest(filepath.Join(path, manifestFile), manifest); err != nil {
+			return total, err
+		}
+		total += removed
+	}
+	return total, nil
+}
+
+func writeManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+func readManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/hnsw"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector_db"
+)
+
+func writeEmbeddings(t *testing.T, dir string, messages ...embeddings.MessageEmbeddingOut) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	file, err := os.Create(filepath.Join(dir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create embeddings file: %v", err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("Failed to write embedding: %v", err)
+		}
+	}
+}
+
+func inject(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	db, err := vector_db.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open vector database: %v", err)
+	}
+	defer db.Close()
+	if err := db.CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := db.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:     config.QdrantConfig{Path: filepath.Join(tmpDir, "vectors"), CollectionName: "messages"},
+		Data:       config.DataConfig{OutputDir: filepath.Join(tmpDir, "output"), BackupDir: filepath.Join(tmpDir, "backups")},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:     config.VectorConfig{Backend: vector_db.BackendHNSW},
+		Embeddings: config.EmbeddingsConfig{Provider: embeddings.ProviderLocal, Dimension: 2},
+	}
+
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "a", Text: "good", Embedding: []float32{1, 0}, Metadata: message.Metadata{Sender: "me"}},
+		embeddings.MessageEmbeddingOut{ID: "b", Text: "also good", Embedding: []float32{0, 1}},
+	)
+	inject(t, cfg)
+
+	manifest, err := Create(cfg, "before")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if manifest.Points != 2 || manifest.Dimension != 2 || manifest.EmbeddingModel != "local-ngram-2" || manifest.Backend != vector_db.BackendHNSW {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+	if _, err := Create(cfg, "before"); err == nil {
+		t.Error("Expected an error for an existing snapshot name")
+	}
+
+	// A bad ingest replaces the store
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "x", Text: "bad", Embedding: []float32{1, 1}},
+	)
+	inject(t, cfg)
+
+	manifests, err := List(cfg)
+	if err != nil || len(manifests) != 1 || manifests[0].Name != "before" {
+		t.Fatalf("Expected the snapshot listed, got %+v (%v)", manifests, err)
+	}
+
+	// Another embedding model is refused unless forced
+	other := *cfg
+	other.Embeddings.Dimension = 3
+	if _, _, err := Restore(&other, "before", "", false); err == nil {
+		t.Error("Expected a restore with another embedding model to be refused")
+	}
+
+	_, previous, err := Restore(cfg, "before", "", false)
+	if err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	// The replaced points are kept in a snapshot of their own
+	manifests, err = List(cfg)
+	if err != nil || len(manifests) != 2 || manifests[1].Name != previous || manifests[1].Points != 1 {
+		t.Errorf("Expected the replaced point saved to snapshot %q, got %+v (%v)", previous, manifests, err)
+	}
+	store, err := hnsw.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open restored store: %v", err)
+	}
+	messages, err := store.GetAllMessages()
+	if err != nil || len(messages) != 2 || messages[0].ID != "a" || messages[0].Sender != "me" {
+		t.Errorf("Expected the snapshot points back, got %+v (%v)", messages, err)
+	}
+
+	// The same snapshot restores into the Qdrant backend (dev mode file store)
+	qdrantCfg := *cfg
+	qdrantCfg.DevMode = config.DevModeConfig{Enabled: true}
+	qdrantCfg.Vector.Backend = vector_db.BackendQdrant
+	manifest, previous, err = Restore(&qdrantCfg, "before", vector_db.BackendQdrant, false)
+	if err != nil {
+		t.Fatalf("Failed to restore snapshot into Qdrant: %v", err)
+	}
+	if previous != "" {
+		t.Errorf("Expected no snapshot of an empty collection, got %q", previous)
+	}
+	if manifest.Backend != vector_db.BackendHNSW {
+		t.Errorf("Expected the manifest to keep its source backend, got %q", manifest.Backend)
+	}
+	db, err := vector_db.New(&qdrantCfg)
+	if err != nil {
+		t.Fatalf("Failed to open vector database: %v", err)
+	}
+	defer db.Close()
+	messages, err = db.GetAllMessages()
+	if err != nil || len(messages) != 2 {
+		t.Errorf("Expected 2 restored points in Qdrant, got %d (%v)", len(messages), err)
+	}
+}
+
+func TestRestoreRollsBack(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:     config.QdrantConfig{Path: filepath.Join(tmpDir, "vectors"), CollectionName: "messages"},
+		Data:       config.DataConfig{OutputDir: filepath.Join(tmpDir, "output"), BackupDir: filepath.Join(tmpDir, "backups")},
+		Logging:    config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:     config.VectorConfig{Backend: vector_db.BackendHNSW},
+		Embeddings: config.EmbeddingsConfig{Provider: embeddings.ProviderLocal, Dimension: 2},
+	}
+	writeEmbeddings(t, cfg.Data.OutputDir,
+		embeddings.MessageEmbeddingOut{ID: "a", Text: "good", Embedding: []float32{1, 0}},
+	)
+	inject(t, cfg)
+	if _, err := Create(cfg, "broken"); err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	points := filepath.Join(Dir(cfg), "broken", "messages_embeddings.jsonl")
+	if err := os.WriteFile(points, []byte("{not json\n"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt snapshot: %v", err)
+	}
+
+	_, previous, err := Restore(cfg, "broken", "", false)
+	if err == nil || previous == "" {
+		t.Fatalf("Expected the restore to fail after saving the previous points, got %q (%v)", previous, err)
+	}
+	store, err := hnsw.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	if messages, _ := store.GetAllMessages(); len(messages) != 1 || messages[0].ID != "a" {
+		t.Errorf("Expected the previous points loaded back, got %+v", messages)
+	}
+}
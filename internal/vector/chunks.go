@@ -0,0 +1,47 @@
+package vector
+
+// CollapseChunks folds chunks into the message they were cut from. Every
+// chunk is replaced by its parent, taken from the messages themselves or from
+// parents, and each message is kept once, at the position of its first
+// occurrence and with the best score of its hits. Chunks whose parent is
+// unknown stand in for it, with the parent's ID. Messages that are not chunks
+// pass through unchanged.
+func CollapseChunks(messages []Message, parents map[string]Message) []Message {
+	collapsed := make([]Message, 0, len(messages))
+	index := make(map[string]int, len(messages))
+	resolved := make(map[string]bool, len(messages))
+
+	for _, msg := range messages {
+		id, score := msg.ID, msg.Score
+		entry, isParent := msg, !msg.IsChunk()
+		if !isParent {
+			id = msg.ParentID
+			if parent, ok := parents[id]; ok {
+				entry, isParent = parent, true
+			} else {
+				entry.ID = id
+				entry.Embedding = nil
+			}
+			entry.ParentID, entry.ChunkIndex = "", 0
+		}
+		entry.Score = score
+
+		i, seen := index[id]
+		if !seen {
+			index[id] = len(collapsed)
+			resolved[id] = isParent
+			collapsed = append(collapsed, entry)
+			continue
+		}
+		if score < collapsed[i].Score {
+			score = collapsed[i].Score
+		}
+		if isParent && !resolved[id] {
+			collapsed[i] = entry
+			resolved[id] = true
+		}
+		collapsed[i].Score = score
+	}
+
+	return collapsed
+}
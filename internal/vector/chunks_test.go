@@ -0,0 +1,48 @@
+package vector
+
+import (
+	"testing"
+
+	"github.com/yourusername/psagents/internal/message"
+)
+
+func TestCollapseChunks(t *testing.T) {
+	chunk := func(id, parent string, score float32) Message {
+		return Message{ID: id, Text: "chunk of " + parent, Score: score, Chunk: message.Chunk{ParentID: parent}}
+	}
+	hits := []Message{
+		chunk("a#1", "a", 0.9),
+		{ID: "b", Text: "message b", Score: 0.8},
+		chunk("a#0", "a", 0.7),
+		chunk("c#0", "c", 0.6),
+		{ID: "a", Text: "message a", Score: 0.5, Chunk: message.Chunk{ChunkCount: 2}},
+		chunk("d#0", "d", 0.4),
+	}
+	parents := map[string]Message{
+		"c": {ID: "c", Text: "message c", Chunk: message.Chunk{ChunkCount: 3}},
+	}
+
+	collapsed := CollapseChunks(hits, parents)
+	want := []struct {
+		id    string
+		text  string
+		score float32
+	}{
+		{"a", "message a", 0.9},
+		{"b", "message b", 0.8},
+		{"c", "message c", 0.6},
+		{"d", "chunk of d", 0.4},
+	}
+	if len(collapsed) != len(want) {
+		t.Fatalf("Expected %d messages, got %+v", len(want), collapsed)
+	}
+	for i, w := range want {
+		got := collapsed[i]
+		if got.ID != w.id || got.Text != w.text || got.Score != w.score {
+			t.Errorf("Message %d: expected %s %q %.1f, got %s %q %.1f", i, w.id, w.text, w.score, got.ID, got.Text, got.Score)
+		}
+		if got.IsChunk() {
+			t.Errorf("Message %d: expected no parent ID, got %q", i, got.ParentID)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package vector
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/psagents/internal/message"
+)
+
+// Payload fields set on every stored point besides the message metadata and
+// chunk fields
+const (
+	// FieldKind is "message" for whole messages and "chunk" for chunks
+	FieldKind = "kind"
+	// FieldTimestampUnix is the message timestamp in seconds since the epoch,
+	// for range filters
+	FieldTimestampUnix = "timestamp_unix"
+	// FieldPersona is the persona the message was ingested for
+	FieldPersona = "persona"
+)
+
+// Kinds of stored points
+const (
+	KindMessage = "message"
+	KindChunk   = "chunk"
+)
+
+// NumericFields lists the payload fields stored as numbers, the only ones
+// range conditions apply to
+var NumericFields = []string{FieldTimestampUnix}
+
+// IsNumericField reports whether a payload field is stored as a number
+func IsNumericField(field string) bool {
+	for _, f := range NumericFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// PayloadFields returns the payload stored with a point: its text, non-empty
+// metadata and chunk fields, its kind, its timestamp in seconds if it has
+// one and the persona if set
+func PayloadFields(text string, metadata message.Metadata, chunk message.Chunk, persona string) map[string]string {
+	fields := metadata.Fields()
+	for key, value := range chunk.Fields() {
+		fields[key] = value
+	}
+	fields["text"] = text
+	fields[FieldKind] = KindMessage
+	if chunk.IsChunk() {
+		fields[FieldKind] = KindChunk
+	}
+	if metadata.Timestamp != "" {
+		if t, err := time.Parse(time.RFC3339, metadata.Timestamp); err == nil {
+			fields[FieldTimestampUnix] = strconv.FormatInt(t.Unix(), 10)
+		}
+	}
+	if persona != "" {
+		fields[FieldPersona] = persona
+	}
+	return fields
+}
+
+// Filter restricts a search to the points whose payload satisfies every
+// condition in Must. A nil or empty filter matches every point.
+type Filter struct {
+	Must []Condition `json:"must"`
+}
+
+// Condition tests one payload field with exactly one of Equals, In or Range
+type Condition struct {
+	Field  string   `json:"field"`
+	Equals *string  `json:"equals,omitempty"`
+	In     []string `json:"in,omitempty"`
+	Range  *Range   `json:"range,omitempty"`
+}
+
+// Range bounds a numeric payload field; unset bounds are open
+type Range struct {
+	Gt  *float64 `json:"gt,omitempty"`
+	Gte *float64 `json:"gte,omitempty"`
+	Lt  *float64 `json:"lt,omitempty"`
+	Lte *float64 `json:"lte,omitempty"`
+}
+
+// Equals matches points whose field has the given value
+func Equals(field, value string) Condition {
+	return Condition{Field: field, Equals: &value}
+}
+
+// In matches points whose field has one of the given values
+func In(field string, values ...string) Condition {
+	return Condition{Field: field, In: values}
+}
+
+// Between matches points whose timestamp lies in [from, to]. A zero time
+// leaves that end open.
+func Between(from, to time.Time) Condition {
+	r := &Range{}
+	if !from.IsZero() {
+		gte := float64(from.Unix())
+		r.Gte = &gte
+	}
+	if !to.IsZero() {
+		lte := float64(to.Unix())
+		r.Lte = &lte
+	}
+	return Condition{Field: FieldTimestampUnix, Range: r}
+}
+
+// Empty reports whether the filter has no conditions
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.Must) == 0
+}
+
+// Validate checks that every condition is well formed
+func (f *Filter) Validate() error {
+	if f.Empty() {
+		return nil
+	}
+	for i, c := range f.Must {
+		if c.Field == "" {
+			return fmt.Errorf("condition %d: missing field", i)
+		}
+		set := 0
+		if c.Equals != nil {
+			set++
+		}
+		if c.In != nil {
+			set++
+		}
+		if c.Range != nil {
+			set++
+		}
+		if set != 1 {
+			return fmt.Errorf("condition %d on %s: expected exactly one of equals, in or range", i, c.Field)
+		}
+		if c.Range != nil && !IsNumericField(c.Field) {
+			return fmt.Errorf("condition %d: range on non-numeric field %s", i, c.Field)
+		}
+	}
+	return nil
+}
+
+// Matches evaluates the filter against a point's payload fields
+func (f *Filter) Matches(fields map[string]string) bool {
+	if f.Empty() {
+		return true
+	}
+	for _, c := range f.Must {
+		if !c.matches(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matches(fields map[string]string) bool {
+	value, ok := fields[c.Field]
+	if !ok {
+		return false
+	}
+	switch {
+	case c.Equals != nil:
+		return value == *c.Equals
+	case c.In != nil:
+		for _, v := range c.In {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	case c.Range != nil:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		r := c.Range
+		return (r.Gt == nil || n > *r.Gt) && (r.Gte == nil || n >= *r.Gte) &&
+			(r.Lt == nil || n < *r.Lt) && (r.Lte == nil || n <= *r.Lte)
+	}
+	return false
+}
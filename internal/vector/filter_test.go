@@ -0,0 +1,72 @@
+package vector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yourusername/psagents/internal/message"
+)
+
+func TestPayloadFields(t *testing.T) {
+	fields := PayloadFields("hi", message.Metadata{Sender: "me", Timestamp: "2024-01-02T03:04:05Z"}, message.Chunk{}, "alice")
+	if fields["text"] != "hi" || fields["sender"] != "me" || fields[FieldKind] != KindMessage || fields[FieldPersona] != "alice" {
+		t.Errorf("Unexpected payload %v", fields)
+	}
+	if fields[FieldTimestampUnix] != "1704164645" {
+		t.Errorf("Expected the timestamp in seconds, got %q", fields[FieldTimestampUnix])
+	}
+
+	fields = PayloadFields("h", message.Metadata{Timestamp: "yesterday"}, message.Chunk{ParentID: "p"}, "")
+	if fields[FieldKind] != KindChunk || fields["parent_id"] != "p" {
+		t.Errorf("Expected a chunk payload, got %v", fields)
+	}
+	if _, ok := fields[FieldTimestampUnix]; ok {
+		t.Error("Expected no numeric timestamp for an unparseable one")
+	}
+	if _, ok := fields[FieldPersona]; ok {
+		t.Error("Expected no persona when unset")
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	fields := map[string]string{"sender": "me", "channel": "sms", FieldTimestampUnix: "1000"}
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   bool
+	}{
+		{"nil", nil, true},
+		{"equals", &Filter{Must: []Condition{Equals("sender", "me")}}, true},
+		{"equals mismatch", &Filter{Must: []Condition{Equals("sender", "you")}}, false},
+		{"missing field", &Filter{Must: []Condition{Equals("thread_id", "t")}}, false},
+		{"in", &Filter{Must: []Condition{In("channel", "email", "sms")}}, true},
+		{"in mismatch", &Filter{Must: []Condition{In("channel", "email")}}, false},
+		{"between", &Filter{Must: []Condition{Between(time.Unix(500, 0), time.Unix(1000, 0))}}, true},
+		{"open end", &Filter{Must: []Condition{Between(time.Unix(1001, 0), time.Time{})}}, false},
+		{"all must hold", &Filter{Must: []Condition{Equals("sender", "me"), In("channel", "email")}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(fields); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	value := "me"
+	invalid := []*Filter{
+		{Must: []Condition{{Equals: &value}}},
+		{Must: []Condition{{Field: "sender"}}},
+		{Must: []Condition{{Field: "sender", Equals: &value, In: []string{"me"}}}},
+		{Must: []Condition{{Field: "sender", Range: &Range{}}}},
+	}
+	for i, f := range invalid {
+		if err := f.Validate(); err == nil {
+			t.Errorf("Expected filter %d to be invalid", i)
+		}
+	}
+	valid := &Filter{Must: []Condition{Equals("sender", "me"), Between(time.Unix(0, 0), time.Time{})}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected a valid filter, got %v", err)
+	}
+}
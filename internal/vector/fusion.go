@@ -0,0 +1,38 @@
+package vector
+
+import "sort"
+
+// DefaultRRFK is the usual reciprocal rank fusion constant; larger values
+// flatten the advantage of the top ranks
+const DefaultRRFK = 60
+
+// FuseRRF merges rankings of the same messages from different retrievers
+// with reciprocal rank fusion: a message scores the sum of 1/(k+rank) over
+// the rankings it appears in, ranks starting at 1. Scores from different
+// retrievers are not comparable, ranks are. Each message keeps its fields
+// from the first ranking it appears in, with Score set to the fused score.
+// At most limit messages are returned.
+func FuseRRF(k, limit int, rankings ...[]Message) []Message {
+	if k <= 0 {
+		k = DefaultRRFK
+	}
+	var fused []Message
+	index := make(map[string]int)
+	for _, ranking := range rankings {
+		for rank, msg := range ranking {
+			score := float32(1 / float64(k+rank+1))
+			if i, ok := index[msg.ID]; ok {
+				fused[i].Score += score
+				continue
+			}
+			index[msg.ID] = len(fused)
+			msg.Score = score
+			fused = append(fused, msg)
+		}
+	}
+	sort.SliceStable(fused, func(i, j int) bool { return fused[i].Score > fused[j].Score })
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused
+}
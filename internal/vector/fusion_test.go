@@ -0,0 +1,28 @@
+package vector
+
+import "testing"
+
+func TestFuseRRF(t *testing.T) {
+	dense := []Message{{ID: "a", Text: "dense a", Score: 0.9}, {ID: "b", Score: 0.8}, {ID: "c", Score: 0.7}}
+	lexical := []Message{{ID: "c", Score: 12}, {ID: "d", Score: 9}, {ID: "a", Score: 3}}
+
+	fused := FuseRRF(60, 3, dense, lexical)
+	if len(fused) != 3 {
+		t.Fatalf("Expected 3 messages, got %+v", fused)
+	}
+	// a: 1/61 + 1/63, c: 1/63 + 1/61, b: 1/62, d: 1/62
+	if fused[0].ID != "a" || fused[1].ID != "c" || fused[2].ID != "b" {
+		t.Errorf("Expected a, c, b, got %s, %s, %s", fused[0].ID, fused[1].ID, fused[2].ID)
+	}
+	if fused[0].Text != "dense a" {
+		t.Errorf("Expected fields from the first ranking, got %+v", fused[0])
+	}
+	want := float32(1.0/61 + 1.0/63)
+	if diff := fused[0].Score - want; diff > 1e-6 || diff < -1e-6 {
+		t.Errorf("Expected fused score %f, got %f", want, fused[0].Score)
+	}
+
+	if fused := FuseRRF(0, 10, nil, lexical); len(fused) != 3 || fused[0].ID != "c" {
+		t.Errorf("Expected the lexical ranking alone, got %+v", fused)
+	}
+}
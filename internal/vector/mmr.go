@@ -0,0 +1,81 @@
+package vector
+
+import "math"
+
+// MMR reorders candidates by maximal marginal relevance and keeps at most
+// limit of them. Each pick maximises
+//
+//	lambda*relevance - (1-lambda)*redundancy
+//
+// where relevance is the candidate's score rescaled to [0, 1] across the
+// candidates and redundancy its highest cosine similarity to a message
+// already picked. A lambda of 1 keeps the ranking; lower values trade
+// relevance for diversity, so that near-duplicates of a pick sink. Candidates
+// without an embedding are redundant with nothing.
+func MMR(candidates []Message, lambda float64, limit int) []Message {
+	if limit > len(candidates) {
+		limit = len(candidates)
+	}
+	if len(candidates) == 0 || limit <= 0 {
+		return nil
+	}
+
+	lo, hi := candidates[0].Score, candidates[0].Score
+	for _, c := range candidates {
+		lo = float32(math.Min(float64(lo), float64(c.Score)))
+		hi = float32(math.Max(float64(hi), float64(c.Score)))
+	}
+	relevance := make([]float64, len(candidates))
+	for i, c := range candidates {
+		relevance[i] = 1
+		if hi > lo {
+			relevance[i] = float64(c.Score-lo) / float64(hi-lo)
+		}
+	}
+
+	// redundancy[i] is the highest similarity of candidate i to a pick
+	redundancy := make([]float64, len(candidates))
+	picked := make([]bool, len(candidates))
+	selected := make([]Message, 0, limit)
+	for len(selected) < limit {
+		best, bestValue := -1, math.Inf(-1)
+		for i := range candidates {
+			if picked[i] {
+				continue
+			}
+			value := lambda*relevance[i] - (1-lambda)*redundancy[i]
+			if value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		picked[best] = true
+		selected = append(selected, candidates[best])
+
+		for i := range candidates {
+			if !picked[i] {
+				if sim := cosine(candidates[i].Embedding, candidates[best].Embedding); sim > redundancy[i] {
+					redundancy[i] = sim
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// cosine is the cosine similarity of two vectors, 0 if either is empty or
+// their dimensions differ
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
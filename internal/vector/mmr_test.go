@@ -0,0 +1,39 @@
+package vector
+
+import "testing"
+
+func TestMMR(t *testing.T) {
+	candidates := []Message{
+		{ID: "a", Score: 0.95, Embedding: []float32{1, 0, 0}},
+		{ID: "a2", Score: 0.94, Embedding: []float32{0.99, 0.01, 0}},
+		{ID: "a3", Score: 0.93, Embedding: []float32{0.98, 0.02, 0}},
+		{ID: "b", Score: 0.80, Embedding: []float32{0, 1, 0}},
+		{ID: "c", Score: 0.70},
+	}
+
+	ids := func(messages []Message) []string {
+		out := make([]string, len(messages))
+		for i, m := range messages {
+			out[i] = m.ID
+		}
+		return out
+	}
+
+	// lambda 1 keeps the ranking
+	if got := ids(MMR(candidates, 1, 3)); got[0] != "a" || got[1] != "a2" || got[2] != "a3" {
+		t.Errorf("Expected the original ranking, got %v", got)
+	}
+
+	// Near-duplicates of the best hit sink below diverse messages
+	got := ids(MMR(candidates, 0.5, 3))
+	if got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Expected a, b, c, got %v", got)
+	}
+
+	if got := MMR(candidates, 0.5, 10); len(got) != len(candidates) {
+		t.Errorf("Expected every candidate when the limit exceeds them, got %d", len(got))
+	}
+	if got := MMR(nil, 0.5, 3); len(got) != 0 {
+		t.Errorf("Expected no messages, got %v", got)
+	}
+}
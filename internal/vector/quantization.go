@@ -0,0 +1,41 @@
+package vector
+
+import "fmt"
+
+// Quantization is how a vector database compresses the vectors it searches
+type Quantization string
+
+// Quantization kinds
+const (
+	// QuantizationNone keeps full float32 vectors
+	QuantizationNone Quantization = "none"
+	// QuantizationInt8 keeps a byte per dimension, scaled per vector
+	QuantizationInt8 Quantization = "int8"
+	// QuantizationBinary keeps the sign of every dimension as a bit
+	QuantizationBinary Quantization = "binary"
+)
+
+// DefaultRescoreOversampling is how many candidates per requested result a
+// quantized search rescores with the exact vectors when unset
+const DefaultRescoreOversampling = 4
+
+// ParseQuantization reads vector.quantization; empty means none
+func ParseQuantization(s string) (Quantization, error) {
+	switch q := Quantization(s); q {
+	case "", QuantizationNone:
+		return QuantizationNone, nil
+	case QuantizationInt8, QuantizationBinary:
+		return q, nil
+	default:
+		return "", fmt.Errorf("unknown vector quantization %q (want none, int8 or binary)", s)
+	}
+}
+
+// RescoreOversampling returns the configured oversampling factor, or the
+// default when it is unset
+func RescoreOversampling(configured int) int {
+	if configured < 1 {
+		return DefaultRescoreOversampling
+	}
+	return configured
+}
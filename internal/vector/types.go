@@ -1,16 +1,44 @@
 package vector
 
+import "github.com/yourusername/psagents/internal/message"
+
 // Message represents a message in the vector database
 type Message struct {
 	ID        string
 	Text      string
 	Embedding []float32
 	Score     float32
+	message.Metadata
+	message.Chunk
 }
 
 // DB defines the interface for vector database operations
 type DB interface {
 	Close() error
 	GetAllMessages() ([]Message, error)
-	Search(embedding []float32, limit int) ([]Message, error)
-}
\ No newline at end of file
+	// ListMessages calls fn with every stored point, pageSize at a time and
+	// without embeddings, so that a caller can walk the collection without
+	// holding all its vectors; GetMessages fetches the ones it needs
+	ListMessages(pageSize int, fn func(page []Message) error) error
+	// GetMessages returns the points of the given IDs with their embeddings,
+	// in the order given and leaving out the IDs that are not stored
+	GetMessages(ids []string) ([]Message, error)
+	// Search returns the messages closest to embedding among the points
+	// matching filter (nil for all), with their embeddings. Chunk hits are
+	// collapsed into their parent message (see CollapseChunks).
+	Search(embedding []float32, limit int, filter *Filter) ([]Message, error)
+	// Delete removes the points of the given message IDs along with the
+	// chunks cut from them, and returns how many points were removed
+	Delete(ids []string) (int, error)
+}
+
+// CollectionInfo describes the collection a vector database keeps its
+// points in
+type CollectionInfo struct {
+	Name       string
+	Exists     bool
+	VectorSize int
+	Distance   string
+	Points     uint64
+	Status     string
+}
@@ -0,0 +1,211 @@
+package vector_db
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/vector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Defaults for the Qdrant endpoint when host or port are unset
+const (
+	defaultQdrantHost = "localhost"
+	defaultQdrantPort = 6334
+)
+
+// CollectionManager is implemented by the backends whose collection can be
+// managed with `ingest collection`
+type CollectionManager interface {
+	CreateCollection() error
+	DropCollection() error
+	DescribeCollection() (vector.CollectionInfo, error)
+}
+
+// qdrantAddress returns the host:port of the configured Qdrant endpoint
+func qdrantAddress(cfg config.QdrantConfig) string {
+	host, port := cfg.Host, cfg.Port
+	if host == "" {
+		host = defaultQdrantHost
+	}
+	if port == 0 {
+		port = defaultQdrantPort
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// apiKeyCredentials sends the Qdrant API key with every call
+type apiKeyCredentials struct {
+	key string
+	tls bool
+}
+
+func (c apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"api-key": c.key}, nil
+}
+
+func (c apiKeyCredentials) RequireTransportSecurity() bool {
+	return c.tls
+}
+
+// dialQdrant opens a gRPC connection to the configured Qdrant endpoint,
+// over TLS and with the API key if configured
+func dialQdrant(cfg config.QdrantConfig) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if cfg.TLS {
+		opts[0] = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if cfg.APIKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials{key: cfg.APIKey, tls: cfg.TLS}))
+	}
+	return grpc.Dial(qdrantAddress(cfg), opts...)
+}
+
+// qdrantDistance parses a configured distance name, Cosine when unset
+func qdrantDistance(name string) (qdrant.Distance, error) {
+	switch strings.ToLower(name) {
+	case "", "cosine":
+		return qdrant.Distance_Cosine, nil
+	case "euclid":
+		return qdrant.Distance_Euclid, nil
+	case "dot":
+		return qdrant.Distance_Dot, nil
+	default:
+		return 0, fmt.Errorf("unknown distance %q (expected Cosine, Euclid or Dot)", name)
+	}
+}
+
+// checkCompatible fails when an existing collection's vectors don't have the
+// configured size and distance. Writing to it would fail on the first point
+// or, worse, rank every search by the wrong measure.
+func checkCompatible(cfg config.QdrantConfig, info vector.CollectionInfo) error {
+	distance, err := qdrantDistance(cfg.Distance)
+	if err != nil {
+		return err
+	}
+	if (cfg.VectorSize != 0 && info.VectorSize != cfg.VectorSize) || info.Distance != distance.String() {
+		return fmt.Errorf("collection %s has %d-dimensional vectors with %s distance but the config expects %d with %s; "+
+			"fix qdrant.vector_size/qdrant.distance or run `ingest collection recreate`",
+			info.Name, info.VectorSize, info.Distance, cfg.VectorSize, distance)
+	}
+	return nil
+}
+
+// DescribeCollection returns the collection's vector parameters and size
+func (db *QdrantDB) DescribeCollection() (vector.CollectionInfo, error) {
+	info := vector.CollectionInfo{Name: db.cfg.Qdrant.CollectionName}
+	if db.isTestMode {
+		return db.describeTest(info)
+	}
+
+	ctx := context.Background()
+	exists, err := db.collectionExists(ctx)
+	if err != nil || !exists {
+		return info, err
+	}
+	resp, err := db.client.Get(ctx, &qdrant.GetCollectionInfoRequest{CollectionName: info.Name})
+	if err != nil {
+		return info, fmt.Errorf("failed to get collection info: %w", err)
+	}
+	result := resp.GetResult()
+	info.Exists = true
+	info.Status = result.GetStatus().String()
+	info.Points = result.GetPointsCount()
+	params := result.GetConfig().GetParams().GetVectorsConfig().GetParams()
+	if params == nil {
+		return info, fmt.Errorf("collection %s uses named vectors, which are not supported", info.Name)
+	}
+	info.VectorSize = int(params.GetSize())
+	info.Distance = params.GetDistance().String()
+	return info, nil
+}
+
+// describeTest describes the dev mode file
+func (db *QdrantDB) describeTest(info vector.CollectionInfo) (vector.CollectionInfo, error) {
+	file, err := os.Open(db.testDBPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		return info, fmt.Errorf("failed to open test database file: %w", err)
+	}
+	defer file.Close()
+
+	info.Exists = true
+	info.Status = "dev mode"
+	info.Distance = qdrant.Distance_Cosine.String()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if info.Points == 0 {
+			var point TestPoint
+			if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+				return info, fmt.Errorf("failed to unmarshal point: %w", err)
+			}
+			info.VectorSize = len(point.Vectors)
+		}
+		info.Points++
+	}
+	if err := scanner.Err(); err != nil {
+		return info, fmt.Errorf("error reading test database file: %w", err)
+	}
+	return info, nil
+}
+
+// DropCollection deletes the collection and all its points. Dropping a
+// collection that doesn't exist is not an error.
+func (db *QdrantDB) DropCollection() error {
+	if db.isTestMode {
+		db.testPoints, db.testIndex = nil, nil
+		if err := os.Remove(db.testDBPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove test database file: %w", err)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	exists, err := db.collectionExists(ctx)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := db.client.Delete(ctx, &qdrant.DeleteCollection{CollectionName: db.cfg.Qdrant.CollectionName}); err != nil {
+		return fmt.Errorf("failed to drop collection: %w", err)
+	}
+	db.logger.WithField("collection", db.cfg.Qdrant.CollectionName).Info("Dropped Qdrant collection")
+	return nil
+}
+
+// collectionExists reports whether the configured collection exists
+func (db *QdrantDB) collectionExists(ctx context.Context) (bool, error) {
+	collections, err := db.client.List(ctx, &qdrant.ListCollectionsRequest{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list collections: %w", err)
+	}
+	for _, collection := range collections.Collections {
+		if collection.Name == db.cfg.Qdrant.CollectionName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// logCollection logs a described collection
+func logCollection(logger *logrus.Logger, info vector.CollectionInfo) {
+	logger.WithFields(logrus.Fields{
+		"collection": info.Name,
+		"size":       info.VectorSize,
+		"distance":   info.Distance,
+		"points":     info.Points,
+	}).Info("Collection already exists")
+}
@@ -0,0 +1,126 @@
+package vector_db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"github.com/sirupsen/logrus"
+)
+
+// Delete removes the points of the given message IDs and of the chunks cut
+// from them, and returns how many points were removed
+func (db *QdrantDB) Delete(ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if db.isTestMode {
+		return db.deleteTest(ids)
+	}
+
+	// Points are keyed by a UUID derived from the message ID, chunks point
+	// back to their message through parent_id
+	uuids := make([]*qdrant.PointId, 0, len(ids))
+	for _, id := range ids {
+		uuid, err := pointUUID(id)
+		if err != nil {
+			return 0, err
+		}
+		uuids = append(uuids, &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: uuid}})
+	}
+	filter := &qdrant.Filter{
+		Should: []*qdrant.Condition{
+			{ConditionOneOf: &qdrant.Condition_HasId{HasId: &qdrant.HasIdCondition{HasId: uuids}}},
+			{ConditionOneOf: &qdrant.Condition_Field{Field: &qdrant.FieldCondition{
+				Key: "parent_id",
+				Match: &qdrant.Match{
+					MatchValue: &qdrant.Match_Keywords{Keywords: &qdrant.RepeatedStrings{Strings: ids}},
+				},
+			}}},
+		},
+	}
+
+	ctx := context.Background()
+	exact := true
+	count, err := db.points.Count(ctx, &qdrant.CountPoints{
+		CollectionName: db.cfg.Qdrant.CollectionName,
+		Filter:         filter,
+		Exact:          &exact,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count points: %w", err)
+	}
+
+	wait := true
+	_, err = db.points.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: db.cfg.Qdrant.CollectionName,
+		Wait:           &wait,
+		Points: &qdrant.PointsSelector{
+			PointsSelectorOneOf: &qdrant.PointsSelector_Filter{Filter: filter},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete points: %w", err)
+	}
+
+	deleted := int(count.GetResult().GetCount())
+	db.logger.WithFields(logrus.Fields{
+		"messages":   len(ids),
+		"points":     deleted,
+		"collection": db.cfg.Qdrant.CollectionName,
+	}).Info("Deleted points from Qdrant")
+	return deleted, nil
+}
+
+// deleteTest removes points from the dev mode file by rewriting it
+func (db *QdrantDB) deleteTest(ids []string) (int, error) {
+	if _, err := os.Stat(db.testDBPath); os.IsNotExist(err) {
+		return 0, nil
+	}
+	// Loads the file into db.testPoints if it isn't yet
+	if _, err := db.getAllMessagesTest(); err != nil {
+		return 0, err
+	}
+
+	forget := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		forget[id] = true
+	}
+	kept := make([]*TestPoint, 0, len(db.testPoints))
+	for _, point := range db.testPoints {
+		if forget[point.ID] || forget[point.Payload["parent_id"]] {
+			continue
+		}
+		kept = append(kept, point)
+	}
+	deleted := len(db.testPoints) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	tmpPath := db.testDBPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create test database file: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, point := range kept {
+		if err := encoder.Encode(point); err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return 0, fmt.Errorf("failed to write point: %w", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to write test database file: %w", err)
+	}
+	if err := os.Rename(tmpPath, db.testDBPath); err != nil {
+		return 0, fmt.Errorf("failed to replace test database file: %w", err)
+	}
+	db.testPoints = kept
+	db.testIndex = nil
+	return deleted, nil
+}
@@ -0,0 +1,42 @@
+package vector_db
+
+import (
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// qdrantFilter converts a vector filter to Qdrant's native filter. Equality
+// and set membership become keyword matches, ranges become numeric ranges.
+func qdrantFilter(filter *vector.Filter) *qdrant.Filter {
+	if filter.Empty() {
+		return nil
+	}
+
+	must := make([]*qdrant.Condition, 0, len(filter.Must))
+	for _, c := range filter.Must {
+		field := &qdrant.FieldCondition{Key: c.Field}
+		switch {
+		case c.Equals != nil:
+			field.Match = &qdrant.Match{
+				MatchValue: &qdrant.Match_Keyword{Keyword: *c.Equals},
+			}
+		case c.In != nil:
+			field.Match = &qdrant.Match{
+				MatchValue: &qdrant.Match_Keywords{
+					Keywords: &qdrant.RepeatedStrings{Strings: c.In},
+				},
+			}
+		case c.Range != nil:
+			field.Range = &qdrant.Range{
+				Gt:  c.Range.Gt,
+				Gte: c.Range.Gte,
+				Lt:  c.Range.Lt,
+				Lte: c.Range.Lte,
+			}
+		}
+		must = append(must, &qdrant.Condition{
+			ConditionOneOf: &qdrant.Condition_Field{Field: field},
+		})
+	}
+	return &qdrant.Filter{Must: must}
+}
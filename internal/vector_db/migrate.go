@@ -0,0 +1,96 @@
+package vector_db
+
+import (
+	"context"
+	"fmt"
+
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"github.com/sirupsen/logrus"
+	"github.com/yourusername/psagents/internal/embeddings"
+)
+
+// canonicalID recomputes the message ID of a point from its payload: the
+// SHA-256 of the text and metadata, or the chunk ID for chunks
+func canonicalID(payload map[string]*qdrant.Value) string {
+	chunk := payloadChunk(payload)
+	if chunk.IsChunk() {
+		return embeddings.ChunkID(chunk.ParentID, chunk.ChunkIndex)
+	}
+	return embeddings.MessageID(payload["text"].GetStringValue(), payloadMetadata(payload))
+}
+
+// MigrateIDs adds the message ID to the payload of Qdrant points injected
+// before it was stored there, so that they are returned with their canonical
+// ID instead of their UUID. It returns the number of points updated. Dev mode
+// points are always keyed by message ID and need no migration.
+func (db *QdrantDB) MigrateIDs(ctx context.Context) (int, error) {
+	if db.isTestMode {
+		return 0, nil
+	}
+
+	var limit uint32 = 100
+	req := &qdrant.ScrollPoints{
+		CollectionName: db.cfg.Qdrant.CollectionName,
+		WithPayload:    &qdrant.WithPayloadSelector{SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true}},
+		WithVectors:    &qdrant.WithVectorsSelector{SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: false}},
+		Limit:          &limit,
+	}
+
+	migrated, mismatched := 0, 0
+	for {
+		resp, err := db.points.Scroll(ctx, req)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to scroll points: %w", err)
+		}
+
+		for _, point := range resp.Result {
+			if _, ok := point.Payload[messageIDKey]; ok {
+				continue
+			}
+			if _, ok := point.Payload["text"]; !ok {
+				db.logger.WithField("point_id", point.Id.GetUuid()).Warn("Point has no text, cannot derive its message ID")
+				continue
+			}
+
+			id := canonicalID(point.Payload)
+			if uuid, err := pointUUID(id); err != nil || uuid != point.Id.GetUuid() {
+				// The text no longer hashes to the point: leave it alone
+				mismatched++
+				db.logger.WithFields(logrus.Fields{
+					"point_id":   point.Id.GetUuid(),
+					"message_id": id,
+				}).Warn("Point ID does not match its text, skipping")
+				continue
+			}
+
+			_, err := db.points.SetPayload(ctx, &qdrant.SetPayloadPoints{
+				CollectionName: db.cfg.Qdrant.CollectionName,
+				Payload: map[string]*qdrant.Value{
+					messageIDKey: {Kind: &qdrant.Value_StringValue{StringValue: id}},
+				},
+				PointsSelector: &qdrant.PointsSelector{
+					PointsSelectorOneOf: &qdrant.PointsSelector_Points{
+						Points: &qdrant.PointsIdsList{Ids: []*qdrant.PointId{point.Id}},
+					},
+				},
+			})
+			if err != nil {
+				return migrated, fmt.Errorf("failed to set message ID of point %s: %w", point.Id.GetUuid(), err)
+			}
+			migrated++
+		}
+
+		if resp.NextPageOffset == nil {
+			break
+		}
+		req.Offset = resp.NextPageOffset
+	}
+
+	db.logger.WithFields(logrus.Fields{
+		"migrated":   migrated,
+		"mismatched": mismatched,
+		"collection": db.cfg.Qdrant.CollectionName,
+	}).Info("Migrated Qdrant point IDs")
+
+	return migrated, nil
+}
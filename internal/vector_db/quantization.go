@@ -0,0 +1,235 @@
+package vector_db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	qdrant "github.com/qdrant/go-client/qdrant"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
+)
+
+// qdrantQuantization returns the quantization config a collection is
+// created with, nil for none. Quantized vectors are kept in RAM and the
+// originals on disk, where Qdrant reads them to rescore.
+func qdrantQuantization(q vector.Quantization) *qdrant.QuantizationConfig {
+	alwaysRAM := true
+	switch q {
+	case vector.QuantizationInt8:
+		// Ignore the most extreme 1% of values when fitting the scale
+		quantile := float32(0.99)
+		return &qdrant.QuantizationConfig{Quantization: &qdrant.QuantizationConfig_Scalar{
+			Scalar: &qdrant.ScalarQuantization{Type: qdrant.QuantizationType_Int8, Quantile: &quantile, AlwaysRam: &alwaysRAM},
+		}}
+	case vector.QuantizationBinary:
+		return &qdrant.QuantizationConfig{Quantization: &qdrant.QuantizationConfig_Binary{
+			Binary: &qdrant.BinaryQuantization{AlwaysRam: &alwaysRAM},
+		}}
+	default:
+		return nil
+	}
+}
+
+// searchParams asks Qdrant to rescore the oversampled candidates of a
+// quantized collection with the original vectors
+func (db *QdrantDB) searchParams() *qdrant.SearchParams {
+	if db.quantization == vector.QuantizationNone {
+		return nil
+	}
+	rescore := true
+	oversampling := float64(db.oversampling)
+	return &qdrant.SearchParams{Quantization: &qdrant.QuantizationSearchParams{
+		Rescore:      &rescore,
+		Oversampling: &oversampling,
+	}}
+}
+
+// testIndex is the quantized copy of the dev mode file that a quantized
+// search scores. Only the best candidates are rescored, with their exact
+// vectors read back from the file.
+type testIndex struct {
+	points []quantizedPoint
+}
+
+type quantizedPoint struct {
+	id      string
+	payload map[string]string
+	// offset and length locate the point's line in the file
+	offset int64
+	length int
+	vector quantizedVector
+}
+
+// loadTestIndex quantizes the points of the dev mode file
+func (db *QdrantDB) loadTestIndex() (*testIndex, error) {
+	file, err := os.Open(db.testDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open test database file: %w", err)
+	}
+	defer file.Close()
+
+	ix := &testIndex{}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var point TestPoint
+			if err := json.Unmarshal(line, &point); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal point: %w", err)
+			}
+			ix.points = append(ix.points, quantizedPoint{
+				id:      point.ID,
+				payload: point.Payload,
+				offset:  offset,
+				length:  len(line),
+				vector:  quantize(db.quantization, point.Vectors),
+			})
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return ix, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading test database file: %w", err)
+		}
+	}
+}
+
+// searchQuantizedTest scores the quantized dev mode points matching filter
+// and rescores the oversampled best of them with their exact vectors
+func (db *QdrantDB) searchQuantizedTest(query []float32, limit int, filter *vector.Filter) ([]SearchResult, error) {
+	if db.testIndex == nil {
+		ix, err := db.loadTestIndex()
+		if err != nil {
+			return nil, err
+		}
+		db.testIndex = ix
+	}
+
+	type candidate struct {
+		point int
+		score float32
+	}
+	q := unit(query)
+	var candidates []candidate
+	for i, p := range db.testIndex.points {
+		if filter.Matches(p.payload) {
+			candidates = append(candidates, candidate{i, p.vector.dot(q)})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	if fetch := limit * db.oversampling; len(candidates) > fetch {
+		candidates = candidates[:fetch]
+	}
+
+	file, err := os.Open(db.testDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open test database file: %w", err)
+	}
+	defer file.Close()
+
+	results := make([]SearchResult, 0, len(candidates))
+	for _, c := range candidates {
+		p := db.testIndex.points[c.point]
+		line := make([]byte, p.length)
+		if _, err := file.ReadAt(line, p.offset); err != nil {
+			return nil, fmt.Errorf("failed to read point %s: %w", p.id, err)
+		}
+		var point TestPoint
+		if err := json.Unmarshal(line, &point); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal point %s: %w", p.id, err)
+		}
+		results = append(results, SearchResult{
+			ID:        point.ID,
+			Score:     cosineSimilarity(query, point.Vectors),
+			Text:      point.Payload["text"],
+			Embedding: point.Vectors,
+			Metadata:  message.MetadataFromFields(point.Payload),
+			Chunk:     message.ChunkFromFields(point.Payload),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// quantizedVector is a unit vector kept as vector.quantization says: a byte
+// per dimension, scaled so that its largest component maps to ±127, or the
+// sign of each dimension as a bit
+type quantizedVector struct {
+	dimension int
+	scale     float32
+	codes     []int8
+	bits      []uint64
+}
+
+func quantize(q vector.Quantization, v []float32) quantizedVector {
+	v = unit(v)
+	qv := quantizedVector{dimension: len(v)}
+	if q == vector.QuantizationBinary {
+		qv.scale = float32(1 / math.Sqrt(float64(len(v))))
+		qv.bits = make([]uint64, (len(v)+63)/64)
+		for i, x := range v {
+			if x >= 0 {
+				qv.bits[i/64] |= 1 << (i % 64)
+			}
+		}
+		return qv
+	}
+
+	var max float32
+	for _, x := range v {
+		max = float32(math.Max(float64(max), math.Abs(float64(x))))
+	}
+	qv.scale = max / 127
+	qv.codes = make([]int8, len(v))
+	if qv.scale > 0 {
+		for i, x := range v {
+			qv.codes[i] = int8(math.Round(float64(x / qv.scale)))
+		}
+	}
+	return qv
+}
+
+// dot approximates the dot product of a unit query with the vector
+func (qv quantizedVector) dot(q []float32) float32 {
+	n := min(len(q), qv.dimension)
+	var sum float32
+	for i := 0; i < n; i++ {
+		switch {
+		case qv.bits == nil:
+			sum += q[i] * float32(qv.codes[i])
+		case qv.bits[i/64]&(1<<(i%64)) != 0:
+			sum += q[i]
+		default:
+			sum -= q[i]
+		}
+	}
+	return sum * qv.scale
+}
+
+// unit returns v scaled to length 1, or v itself if it is all zeros
+func unit(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	u := make([]float32, len(v))
+	for i, x := range v {
+		u[i] = x * scale
+	}
+	return u
+}
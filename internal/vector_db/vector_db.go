@@ -10,12 +10,15 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 
 	qdrant "github.com/qdrant/go-client/qdrant"
 	"github.com/sirupsen/logrus"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/hnsw"
+	"github.com/yourusername/psagents/internal/message"
 	"github.com/yourusername/psagents/internal/vector"
-	"google.golang.org/grpc"
 )
 
 // TestPoint represents a simplified point structure for test mode
@@ -34,6 +37,12 @@ type QdrantDB struct {
 	isTestMode  bool
 	testDBPath  string
 	testPoints  []*TestPoint
+
+	quantization vector.Quantization
+	oversampling int
+	// testIndex is built by the first quantized search in dev mode and
+	// dropped whenever the file changes
+	testIndex *testIndex
 }
 
 // DB defines the interface for Qdrant-specific operations
@@ -48,6 +57,8 @@ type MessageEmbedding struct {
 	ID        string    `json:"id"`
 	Text      string    `json:"text"`
 	Embedding []float32 `json:"embedding"`
+	message.Metadata
+	message.Chunk
 }
 
 // SearchResult represents a search result with its score
@@ -55,6 +66,9 @@ type SearchResult struct {
 	ID    string            `json:"id"`
 	Score float32           `json:"score"`
 	Text  string           `json:"text"`
+	Embedding []float32 `json:"embedding,omitempty"`
+	message.Metadata
+	message.Chunk
 }
 
 // MessageWithEmbedding represents a message with its embedding
@@ -62,6 +76,100 @@ type MessageWithEmbedding struct {
 	ID        string    `json:"id"`
 	Text      string    `json:"text"`
 	Embedding []float32 `json:"embedding"`
+	message.Metadata
+	message.Chunk
+}
+
+// chunkOverfetch is how many more points Search asks for when messages are
+// chunked, so that several chunks of one message don't crowd out the others
+const chunkOverfetch = 3
+
+// testPayload builds the dev mode payload for a message (see
+// vector.PayloadFields)
+func (db *QdrantDB) testPayload(text string, metadata message.Metadata, chunk message.Chunk) map[string]string {
+	return vector.PayloadFields(text, metadata, chunk, db.cfg.Persona)
+}
+
+// qdrantPayload builds the Qdrant payload for a message: its ID plus the
+// fields from vector.PayloadFields. Points are keyed by a UUID derived from
+// the ID (see pointUUID), so the ID itself is kept in the payload under
+// message_id. Numeric fields are stored as integers so that range filters
+// apply to them.
+func qdrantPayload(id, text string, metadata message.Metadata, chunk message.Chunk, persona string) map[string]*qdrant.Value {
+	payload := make(map[string]*qdrant.Value)
+	fields := vector.PayloadFields(text, metadata, chunk, persona)
+	fields[messageIDKey] = id
+	for key, value := range fields {
+		if vector.IsNumericField(key) {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				payload[key] = &qdrant.Value{
+					Kind: &qdrant.Value_IntegerValue{
+						IntegerValue: n,
+					},
+				}
+				continue
+			}
+		}
+		payload[key] = &qdrant.Value{
+			Kind: &qdrant.Value_StringValue{
+				StringValue: value,
+			},
+		}
+	}
+	return payload
+}
+
+// payloadMetadata extracts message metadata from a Qdrant payload
+func payloadMetadata(payload map[string]*qdrant.Value) message.Metadata {
+	fields := make(map[string]string)
+	for _, key := range message.MetadataFields {
+		if value, ok := payload[key]; ok {
+			fields[key] = value.GetStringValue()
+		}
+	}
+	return message.MetadataFromFields(fields)
+}
+
+// payloadChunk extracts chunk provenance from a Qdrant payload
+func payloadChunk(payload map[string]*qdrant.Value) message.Chunk {
+	fields := make(map[string]string)
+	for _, key := range []string{"parent_id", "chunk_index", "chunk_count"} {
+		if value, ok := payload[key]; ok {
+			fields[key] = value.GetStringValue()
+		}
+	}
+	return message.ChunkFromFields(fields)
+}
+
+// messageIDKey is the payload key holding the message ID of a Qdrant point
+const messageIDKey = "message_id"
+
+// pointMessageID returns the message ID of a Qdrant point. Points injected
+// before the ID was stored in the payload fall back to their UUID until
+// `ingest migrate-ids` has been run.
+func pointMessageID(id *qdrant.PointId, payload map[string]*qdrant.Value) string {
+	if value, ok := payload[messageIDKey]; ok && value.GetStringValue() != "" {
+		return value.GetStringValue()
+	}
+	return id.GetUuid()
+}
+
+// Vector database backends selected by vector.backend
+const (
+	BackendQdrant = "qdrant"
+	BackendHNSW   = "hnsw"
+)
+
+// New opens the vector database selected by vector.backend
+func New(cfg *config.Config) (DB, error) {
+	switch cfg.Vector.Backend {
+	case "", BackendQdrant:
+		return NewQdrantDB(cfg)
+	case BackendHNSW:
+		return hnsw.Open(cfg)
+	default:
+		return nil, fmt.Errorf("unknown vector backend %q (expected %s or %s)", cfg.Vector.Backend, BackendQdrant, BackendHNSW)
+	}
 }
 
 // NewQdrantDB creates a new Qdrant database connection
@@ -78,14 +186,21 @@ func NewQdrantDB(cfg *config.Config) (DB, error) {
 	}
 	logger.SetLevel(level)
 
+	quantization, err := vector.ParseQuantization(cfg.Vector.Quantization)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create Qdrant directory if it doesn't exist
 	if err := os.MkdirAll(cfg.Qdrant.Path, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create Qdrant directory: %w", err)
 	}
 
 	db := &QdrantDB{
-		cfg:    cfg,
-		logger: logger,
+		cfg:          cfg,
+		logger:       logger,
+		quantization: quantization,
+		oversampling: vector.RescoreOversampling(cfg.Vector.RescoreOversampling),
 	}
 
 	// Check if we're in dev mode
@@ -96,10 +211,10 @@ func NewQdrantDB(cfg *config.Config) (DB, error) {
 		return db, nil
 	}
 
-	// Connect to local Qdrant instance using gRPC
-	conn, err := grpc.Dial("localhost:6334", grpc.WithInsecure())
+	// Connect to the Qdrant instance using gRPC
+	conn, err := dialQdrant(cfg.Qdrant)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Qdrant (make sure to run 'start-qdrant' first): %w", err)
+		return nil, fmt.Errorf("failed to connect to Qdrant at %s (make sure to run 'start-qdrant' first): %w", qdrantAddress(cfg.Qdrant), err)
 	}
 
 	db.client = qdrant.NewCollectionsClient(conn)
@@ -107,18 +222,30 @@ func NewQdrantDB(cfg *config.Config) (DB, error) {
 
 	// Log connection status
 	db.logger.WithFields(logrus.Fields{
-		"host":      "localhost",
-		"grpc_port": 6334,
-		"http_port": 6333,
-		"path":      cfg.Qdrant.Path,
-	}).Info("Connected to local Qdrant instance")
+		"address": qdrantAddress(cfg.Qdrant),
+		"tls":     cfg.Qdrant.TLS,
+		"api_key": cfg.Qdrant.APIKey != "",
+		"path":    cfg.Qdrant.Path,
+	}).Info("Connected to Qdrant")
 
 	return db, nil
 }
 
-// CreateCollection creates a new collection in Qdrant if it doesn't already exist
+// CreateCollection creates a new collection in Qdrant if it doesn't already
+// exist. An existing collection must have the configured vector size and
+// distance.
 func (db *QdrantDB) CreateCollection() error {
 	if db.isTestMode {
+		db.testIndex = nil
+		// In incremental mode keep the stored points
+		if db.cfg.Pipeline.Incremental {
+			file, err := os.OpenFile(db.testDBPath, os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to create test database file: %w", err)
+			}
+			file.Close()
+			return nil
+		}
 		// In test mode, just create an empty file
 		file, err := os.Create(db.testDBPath)
 		if err != nil {
@@ -130,26 +257,22 @@ func (db *QdrantDB) CreateCollection() error {
 
 	ctx := context.Background()
 
-	// Check if collection exists
-	collections, err := db.client.List(ctx, &qdrant.ListCollectionsRequest{})
+	// Reuse an existing collection only if its vectors match the config
+	info, err := db.DescribeCollection()
 	if err != nil {
-		return fmt.Errorf("failed to list collections: %w", err)
+		return err
 	}
-
-	for _, collection := range collections.Collections {
-		if collection.Name == db.cfg.Qdrant.CollectionName {
-			db.logger.WithFields(logrus.Fields{
-				"collection": db.cfg.Qdrant.CollectionName,
-			}).Info("Collection already exists")
-			return nil
+	if info.Exists {
+		if err := checkCompatible(db.cfg.Qdrant, info); err != nil {
+			return err
 		}
+		logCollection(db.logger, info)
+		return nil
 	}
 
-	distance := qdrant.Distance_Cosine
-	if db.cfg.Qdrant.Distance == "Euclid" {
-		distance = qdrant.Distance_Euclid
-	} else if db.cfg.Qdrant.Distance == "Dot" {
-		distance = qdrant.Distance_Dot
+	distance, err := qdrantDistance(db.cfg.Qdrant.Distance)
+	if err != nil {
+		return err
 	}
 
 	onDiskPayload := db.cfg.Qdrant.OnDiskPayload
@@ -164,7 +287,8 @@ func (db *QdrantDB) CreateCollection() error {
 				},
 			},
 		},
-		OnDiskPayload: &onDiskPayload,
+		OnDiskPayload:      &onDiskPayload,
+		QuantizationConfig: qdrantQuantization(db.quantization),
 	}
 
 	_, err = db.client.Create(ctx, req)
@@ -176,58 +300,59 @@ func (db *QdrantDB) CreateCollection() error {
 		"collection": db.cfg.Qdrant.CollectionName,
 		"size":      db.cfg.Qdrant.VectorSize,
 		"distance":  db.cfg.Qdrant.Distance,
+		"quantization": db.quantization,
 	}).Info("Created Qdrant collection")
 
 	return nil
 }
 
-// InjectMessages reads embeddings from the output file and injects them into Qdrant
+// InjectMessages reads embeddings from the output file and injects them into Qdrant.
+// In incremental mode only the points that are not already stored are upserted.
 func (db *QdrantDB) InjectMessages() error {
-	// Open embeddings file
 	filePath := filepath.Join(db.cfg.Data.OutputDir, "messages_embeddings.jsonl")
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open embeddings file: %w", err)
+
+	// In incremental dev mode, load the stored points so they are kept in
+	// memory and can be skipped
+	storedTestIDs := make(map[string]bool)
+	if db.isTestMode && db.cfg.Pipeline.Incremental {
+		stored, err := db.getAllMessagesTest()
+		if err != nil {
+			return fmt.Errorf("failed to read stored points: %w", err)
+		}
+		for _, msg := range stored {
+			storedTestIDs[msg.ID] = true
+		}
 	}
-	defer file.Close()
 
 	// Read and inject messages in batches
-	scanner := bufio.NewScanner(file)
 	batch := make([]*qdrant.PointStruct, 0, 100) // Process 100 messages at a time
 	testBatch := make([]*TestPoint, 0, 100)      // For test mode
 	count := 0
+	skipped := 0
 
-	for scanner.Scan() {
-		// Parse message
-		var msg MessageEmbedding
-		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
-			return fmt.Errorf("failed to parse message JSON: %w", err)
-		}
+	err := embeddings.ScanEmbeddings(filePath, func(out embeddings.MessageEmbeddingOut) error {
+		msg := MessageEmbedding{ID: out.ID, Text: out.Text, Embedding: out.Embedding, Metadata: out.Metadata, Chunk: out.Chunk}
 
 		if db.isTestMode {
+			if storedTestIDs[msg.ID] {
+				skipped++
+				return nil
+			}
+			if db.cfg.Pipeline.Incremental {
+				storedTestIDs[msg.ID] = true
+			}
 			// Create test point
 			point := &TestPoint{
 				ID:      msg.ID,
 				Vectors: msg.Embedding,
-				Payload: map[string]string{
-					"text": msg.Text,
-				},
+				Payload: db.testPayload(msg.Text, msg.Metadata, msg.Chunk),
 			}
 			testBatch = append(testBatch, point)
 		} else {
-			// Convert SHA-256 hash to UUID format
-			// Take first 16 bytes of SHA-256 and format as UUID
-			hashBytes, err := hex.DecodeString(msg.ID)
+			uuid, err := pointUUID(msg.ID)
 			if err != nil {
-				return fmt.Errorf("failed to decode hash: %w", err)
+				return err
 			}
-			uuid := fmt.Sprintf("%x-%x-%x-%x-%x",
-				hashBytes[0:4],
-				hashBytes[4:6],
-				hashBytes[6:8],
-				hashBytes[8:10],
-				hashBytes[10:16],
-			)
 
 			// Create point
 			point := &qdrant.PointStruct{
@@ -243,13 +368,7 @@ func (db *QdrantDB) InjectMessages() error {
 						},
 					},
 				},
-				Payload: map[string]*qdrant.Value{
-					"text": {
-						Kind: &qdrant.Value_StringValue{
-							StringValue: msg.Text,
-						},
-					},
-				},
+				Payload: qdrantPayload(msg.ID, msg.Text, msg.Metadata, msg.Chunk, db.cfg.Persona),
 			}
 			batch = append(batch, point)
 		}
@@ -263,12 +382,19 @@ func (db *QdrantDB) InjectMessages() error {
 				}
 				testBatch = testBatch[:0] // Clear batch
 			} else {
-				if err := db.upsertBatch(batch); err != nil {
+				n, err := db.upsertNewBatch(batch)
+				if err != nil {
 					return err
 				}
+				skipped += len(batch) - n
+				count -= len(batch) - n
 				batch = batch[:0] // Clear batch
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Process remaining messages
@@ -278,28 +404,88 @@ func (db *QdrantDB) InjectMessages() error {
 				return err
 			}
 		} else {
-			if err := db.upsertBatch(batch); err != nil {
+			n, err := db.upsertNewBatch(batch)
+			if err != nil {
 				return err
 			}
+			skipped += len(batch) - n
+			count -= len(batch) - n
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading embeddings file: %w", err)
-	}
-
 	db.logger.WithFields(logrus.Fields{
-		"count":      count,
-		"collection": db.cfg.Qdrant.CollectionName,
+		"count":       count,
+		"skipped":     skipped,
+		"incremental": db.cfg.Pipeline.Incremental,
+		"collection":  db.cfg.Qdrant.CollectionName,
 	}).Info("Successfully injected messages into Qdrant")
 
 	return nil
 }
 
+// pointUUID converts a SHA-256 message ID to the UUID used as Qdrant point ID:
+// the first 16 bytes of the hash formatted as a UUID
+func pointUUID(id string) (string, error) {
+	hashBytes, err := hex.DecodeString(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode hash: %w", err)
+	}
+	if len(hashBytes) < 16 {
+		return "", fmt.Errorf("message ID %q is too short for a point ID", id)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x",
+		hashBytes[0:4],
+		hashBytes[4:6],
+		hashBytes[6:8],
+		hashBytes[8:10],
+		hashBytes[10:16],
+	), nil
+}
+
+// upsertNewBatch upserts a batch of points into Qdrant, leaving out the
+// points that are already stored when in incremental mode. It returns the
+// number of points upserted.
+func (db *QdrantDB) upsertNewBatch(points []*qdrant.PointStruct) (int, error) {
+	if db.cfg.Pipeline.Incremental {
+		ids := make([]*qdrant.PointId, len(points))
+		for i, point := range points {
+			ids[i] = point.Id
+		}
+		resp, err := db.points.Get(context.Background(), &qdrant.GetPoints{
+			CollectionName: db.cfg.Qdrant.CollectionName,
+			Ids:            ids,
+			WithPayload:    &qdrant.WithPayloadSelector{SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: false}},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up stored points: %w", err)
+		}
+		stored := make(map[string]bool, len(resp.Result))
+		for _, point := range resp.Result {
+			stored[point.Id.GetUuid()] = true
+		}
+		newPoints := make([]*qdrant.PointStruct, 0, len(points))
+		for _, point := range points {
+			if !stored[point.Id.GetUuid()] {
+				newPoints = append(newPoints, point)
+			}
+		}
+		points = newPoints
+	}
+
+	if len(points) == 0 {
+		return 0, nil
+	}
+	if err := db.upsertBatch(points); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
 // upsertTestBatch upserts a batch of test points into the test database file
 func (db *QdrantDB) upsertTestBatch(points []*TestPoint) error {
 	// In test mode, just append points to memory and write to file
 	db.testPoints = append(db.testPoints, points...)
+	db.testIndex = nil
 
 	// Write to file
 	file, err := os.OpenFile(db.testDBPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -351,14 +537,125 @@ func (db *QdrantDB) GetAllMessages() ([]vector.Message, error) {
 			ID:        msg.ID,
 			Text:      msg.Text,
 			Embedding: msg.Embedding,
+			Metadata:  msg.Metadata,
+			Chunk:     msg.Chunk,
 		}
 	}
 	return result, nil
 }
 
-// Search searches for similar vectors in the database
-func (db *QdrantDB) Search(embedding []float32, limit int) ([]vector.Message, error) {
-	results, err := db.searchInternal(embedding, limit)
+// ListMessages calls fn with the stored points, pageSize at a time and
+// without their vectors, scrolling through the collection
+func (db *QdrantDB) ListMessages(pageSize int, fn func(page []vector.Message) error) error {
+	if pageSize < 1 {
+		return fmt.Errorf("invalid page size %d", pageSize)
+	}
+	if db.isTestMode {
+		return db.listTest(pageSize, fn)
+	}
+
+	limit := uint32(pageSize)
+	req := &qdrant.ScrollPoints{
+		CollectionName: db.cfg.Qdrant.CollectionName,
+		WithPayload:    &qdrant.WithPayloadSelector{SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true}},
+		WithVectors:    &qdrant.WithVectorsSelector{SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: false}},
+		Limit:          &limit,
+	}
+	for {
+		resp, err := db.points.Scroll(context.Background(), req)
+		if err != nil {
+			return fmt.Errorf("failed to scroll points: %w", err)
+		}
+		if len(resp.Result) == 0 {
+			return nil
+		}
+
+		page := make([]vector.Message, len(resp.Result))
+		for i, point := range resp.Result {
+			page[i] = vector.Message{
+				ID:       pointMessageID(point.Id, point.Payload),
+				Text:     point.Payload["text"].GetStringValue(),
+				Metadata: payloadMetadata(point.Payload),
+				Chunk:    payloadChunk(point.Payload),
+			}
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		if resp.NextPageOffset == nil {
+			return nil
+		}
+		req.Offset = resp.NextPageOffset
+	}
+}
+
+// GetMessages returns the stored points of ids with their vectors
+func (db *QdrantDB) GetMessages(ids []string) ([]vector.Message, error) {
+	if db.isTestMode {
+		return db.getTest(ids)
+	}
+
+	pointIDs := make([]*qdrant.PointId, 0, len(ids))
+	for _, id := range ids {
+		uuid, err := pointUUID(id)
+		if err != nil {
+			continue
+		}
+		pointIDs = append(pointIDs, &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: uuid}})
+	}
+	if len(pointIDs) == 0 {
+		return nil, nil
+	}
+	resp, err := db.points.Get(context.Background(), &qdrant.GetPoints{
+		CollectionName: db.cfg.Qdrant.CollectionName,
+		Ids:            pointIDs,
+		WithPayload:    &qdrant.WithPayloadSelector{SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true}},
+		WithVectors:    &qdrant.WithVectorsSelector{SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: true}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get points: %w", err)
+	}
+
+	found := make(map[string]vector.Message, len(resp.Result))
+	for _, point := range resp.Result {
+		id := pointMessageID(point.Id, point.Payload)
+		found[id] = vector.Message{
+			ID:        id,
+			Text:      point.Payload["text"].GetStringValue(),
+			Embedding: point.Vectors.GetVector().GetData(),
+			Metadata:  payloadMetadata(point.Payload),
+			Chunk:     payloadChunk(point.Payload),
+		}
+	}
+	return inOrder(ids, found), nil
+}
+
+// inOrder returns the messages found of ids, in the order of ids
+func inOrder(ids []string, found map[string]vector.Message) []vector.Message {
+	messages := make([]vector.Message, 0, len(found))
+	for _, id := range ids {
+		if msg, ok := found[id]; ok {
+			messages = append(messages, msg)
+			delete(found, id)
+		}
+	}
+	return messages
+}
+
+// Search searches for similar vectors among the points matching filter.
+// Chunk hits are collapsed into their parent message, scored by their best
+// chunk.
+func (db *QdrantDB) Search(embedding []float32, limit int, filter *vector.Filter) ([]vector.Message, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	fetch := limit
+	if db.cfg.Vector.ChunkSize > 0 {
+		fetch = limit * chunkOverfetch
+	}
+	results, err := db.searchInternal(embedding, fetch, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -369,12 +666,56 @@ func (db *QdrantDB) Search(embedding []float32, limit int) ([]vector.Message, er
 		messages[i] = vector.Message{
 			ID:        result.ID,
 			Text:      result.Text,
+			Embedding: result.Embedding,
 			Score:     result.Score,
+			Metadata:  result.Metadata,
+			Chunk:     result.Chunk,
 		}
 	}
+
+	parents, err := db.missingParents(messages)
+	if err != nil {
+		return nil, err
+	}
+	messages = vector.CollapseChunks(messages, parents)
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
 	return messages, nil
 }
 
+// missingParents looks up the parent messages of chunk hits that are not
+// among the hits themselves
+func (db *QdrantDB) missingParents(hits []vector.Message) (map[string]vector.Message, error) {
+	present := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		present[hit.ID] = true
+	}
+	missing := make(map[string]bool)
+	for _, hit := range hits {
+		if hit.IsChunk() && !present[hit.ParentID] {
+			missing[hit.ParentID] = true
+		}
+	}
+	parents := make(map[string]vector.Message, len(missing))
+	if len(missing) == 0 {
+		return parents, nil
+	}
+
+	ids := make([]string, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	stored, err := db.GetMessages(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up parent messages: %w", err)
+	}
+	for _, msg := range stored {
+		parents[msg.ID] = msg
+	}
+	return parents, nil
+}
+
 // getAllMessagesInternal is the internal implementation of GetAllMessages
 func (db *QdrantDB) getAllMessagesInternal() ([]MessageWithEmbedding, error) {
 	if db.isTestMode {
@@ -432,9 +773,11 @@ func (db *QdrantDB) getAllMessagesInternal() ([]MessageWithEmbedding, error) {
 			}
 
 			msg := MessageWithEmbedding{
-				ID:        point.Id.GetUuid(),
+				ID:        pointMessageID(point.Id, point.Payload),
 				Text:      text,
 				Embedding: vectors.Data,
+				Metadata:  payloadMetadata(point.Payload),
+				Chunk:     payloadChunk(point.Payload),
 			}
 			allMessages = append(allMessages, msg)
 		}
@@ -470,9 +813,9 @@ func (db *QdrantDB) getAllMessagesInternal() ([]MessageWithEmbedding, error) {
 }
 
 // searchInternal is the internal implementation of Search
-func (db *QdrantDB) searchInternal(vector []float32, limit int) ([]SearchResult, error) {
+func (db *QdrantDB) searchInternal(vector []float32, limit int, filter *vector.Filter) ([]SearchResult, error) {
 	if db.isTestMode {
-		return db.searchTest(vector, limit)
+		return db.searchTest(vector, limit, filter)
 	}
 
 	ctx := context.Background()
@@ -481,6 +824,10 @@ func (db *QdrantDB) searchInternal(vector []float32, limit int) ([]SearchResult,
 		CollectionName: db.cfg.Qdrant.CollectionName,
 		Vector:        vector,
 		Limit:         uint64(limit),
+		WithPayload:   &qdrant.WithPayloadSelector{SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true}},
+		WithVectors:   &qdrant.WithVectorsSelector{SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: true}},
+		Filter:        qdrantFilter(filter),
+		Params:        db.searchParams(),
 	}
 
 	resp, err := db.points.Search(ctx, req)
@@ -496,17 +843,25 @@ func (db *QdrantDB) searchInternal(vector []float32, limit int) ([]SearchResult,
 		}
 
 		results = append(results, SearchResult{
-			ID:    point.Id.GetUuid(),
-			Score: point.Score,
-			Text:  text,
+			ID:        pointMessageID(point.Id, point.Payload),
+			Score:     point.Score,
+			Text:      text,
+			Embedding: point.Vectors.GetVector().GetData(),
+			Metadata:  payloadMetadata(point.Payload),
+			Chunk:    payloadChunk(point.Payload),
 		})
 	}
 
 	return results, nil
 }
 
-// searchTest performs a search in test mode using cosine similarity
-func (db *QdrantDB) searchTest(vector []float32, limit int) ([]SearchResult, error) {
+// searchTest performs a search in test mode using cosine similarity,
+// scoring the quantized points first when vector.quantization is set
+func (db *QdrantDB) searchTest(query []float32, limit int, filter *vector.Filter) ([]SearchResult, error) {
+	if db.quantization != vector.QuantizationNone {
+		return db.searchQuantizedTest(query, limit, filter)
+	}
+
 	// Read all points from the test database file
 	file, err := os.Open(db.testDBPath)
 	if err != nil {
@@ -521,13 +876,19 @@ func (db *QdrantDB) searchTest(vector []float32, limit int) ([]SearchResult, err
 		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal point: %w", err)
 		}
+		if !filter.Matches(point.Payload) {
+			continue
+		}
 
 		// Calculate cosine similarity
-		score := cosineSimilarity(vector, point.Vectors)
+		score := cosineSimilarity(query, point.Vectors)
 		results = append(results, SearchResult{
-			ID:    point.ID,
-			Score: score,
-			Text:  point.Payload["text"],
+			ID:        point.ID,
+			Score:     score,
+			Text:      point.Payload["text"],
+			Embedding: point.Vectors,
+			Metadata:  message.MetadataFromFields(point.Payload),
+			Chunk:    message.ChunkFromFields(point.Payload),
 		})
 	}
 
@@ -574,6 +935,90 @@ func (db *QdrantDB) Close() error {
 	return nil
 }
 
+// listTest pages through the dev mode points without their vectors, from
+// memory when they are loaded and else a line at a time from the file
+func (db *QdrantDB) listTest(pageSize int, fn func(page []vector.Message) error) error {
+	if len(db.testPoints) > 0 {
+		for start := 0; start < len(db.testPoints); start += pageSize {
+			var page []vector.Message
+			for _, point := range db.testPoints[start:min(start+pageSize, len(db.testPoints))] {
+				page = append(page, testMessage(point.ID, point.Payload, nil))
+			}
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(db.testDBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open test database file: %w", err)
+	}
+	defer file.Close()
+
+	var page []vector.Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Leave out the vectors
+		var point struct {
+			ID      string            `json:"id"`
+			Payload map[string]string `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			return fmt.Errorf("failed to unmarshal point: %w", err)
+		}
+		if point.ID == "" {
+			continue
+		}
+		page = append(page, testMessage(point.ID, point.Payload, nil))
+		if len(page) == pageSize {
+			if err := fn(page); err != nil {
+				return err
+			}
+			page = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading test database file: %w", err)
+	}
+	if len(page) > 0 {
+		return fn(page)
+	}
+	return nil
+}
+
+// getTest looks up dev mode points, loading the file into memory if it
+// isn't yet
+func (db *QdrantDB) getTest(ids []string) ([]vector.Message, error) {
+	stored, err := db.getAllMessagesTest()
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	found := make(map[string]vector.Message, len(ids))
+	for _, msg := range stored {
+		if want[msg.ID] {
+			found[msg.ID] = vector.Message{ID: msg.ID, Text: msg.Text, Embedding: msg.Embedding, Metadata: msg.Metadata, Chunk: msg.Chunk}
+		}
+	}
+	return inOrder(ids, found), nil
+}
+
+// testMessage converts a dev mode point to a vector message
+func testMessage(id string, payload map[string]string, embedding []float32) vector.Message {
+	return vector.Message{
+		ID:        id,
+		Text:      payload["text"],
+		Embedding: embedding,
+		Metadata:  message.MetadataFromFields(payload),
+		Chunk:     message.ChunkFromFields(payload),
+	}
+}
+
 // getAllMessagesTest retrieves all messages from the test database file
 func (db *QdrantDB) getAllMessagesTest() ([]MessageWithEmbedding, error) {
 	// First check if we have points in memory
@@ -585,6 +1030,8 @@ func (db *QdrantDB) getAllMessagesTest() ([]MessageWithEmbedding, error) {
 				ID:        point.ID,
 				Text:      point.Payload["text"],
 				Embedding: point.Vectors,
+				Metadata:  message.MetadataFromFields(point.Payload),
+				Chunk:     message.ChunkFromFields(point.Payload),
 			}
 		}
 		return messages, nil
@@ -631,6 +1078,8 @@ func (db *QdrantDB) getAllMessagesTest() ([]MessageWithEmbedding, error) {
 			ID:        point.ID,
 			Text:      text,
 			Embedding: point.Vectors,
+			Metadata:  message.MetadataFromFields(point.Payload),
+			Chunk:     message.ChunkFromFields(point.Payload),
 		})
 		pointsRead++
 	}
@@ -650,9 +1099,7 @@ func (db *QdrantDB) getAllMessagesTest() ([]MessageWithEmbedding, error) {
 		db.testPoints[i] = &TestPoint{
 			ID:      msg.ID,
 			Vectors: msg.Embedding,
-			Payload: map[string]string{
-				"text": msg.Text,
-			},
+			Payload: db.testPayload(msg.Text, msg.Metadata, msg.Chunk),
 		}
 	}
 
@@ -4,9 +4,15 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	qdrant "github.com/qdrant/go-client/qdrant"
 	"github.com/yourusername/psagents/config"
+	"github.com/yourusername/psagents/internal/embeddings"
+	"github.com/yourusername/psagents/internal/message"
+	"github.com/yourusername/psagents/internal/vector"
 )
 
 func TestQdrantDB(t *testing.T) {
@@ -24,7 +30,9 @@ func TestQdrantDB(t *testing.T) {
 			VectorSize:     768,
 			Distance:      "Cosine",
 			OnDiskPayload: true,
-			TestMode:      true,
+		},
+		DevMode: config.DevModeConfig{
+			Enabled: true,
 		},
 		Data: config.DataConfig{
 			OutputDir: outputDir,
@@ -46,6 +54,11 @@ func TestQdrantDB(t *testing.T) {
 			ID:        "test1",
 			Text:      "Test message 1",
 			Embedding: make([]float32, 768),
+			Metadata: message.Metadata{
+				Sender:    "me",
+				Timestamp: "2023-04-01T12:00:00Z",
+				ThreadID:  "chat-1",
+			},
 		},
 		{
 			ID:        "test2",
@@ -122,5 +135,481 @@ func TestQdrantDB(t *testing.T) {
 		if len(point.Vectors) != len(embeddings[i].Embedding) {
 			t.Errorf("Point %d: expected vector length %d, got %d", i, len(embeddings[i].Embedding), len(point.Vectors))
 		}
+		if got := message.MetadataFromFields(point.Payload); got != embeddings[i].Metadata {
+			t.Errorf("Point %d: expected metadata %+v, got %+v", i, embeddings[i].Metadata, got)
+		}
+	}
+
+	// Verify metadata survives a reload from the test database file
+	messages, err := db.GetAllMessages()
+	if err != nil {
+		t.Fatalf("Failed to get all messages: %v", err)
+	}
+	if len(messages) == 0 || messages[0].Sender != "me" || messages[0].ThreadID != "chat-1" {
+		t.Errorf("Expected metadata on loaded messages, got %+v", messages)
+	}
+}
+// writeEmbeddings writes an embeddings file with one point per text
+func writeEmbeddings(t *testing.T, outputDir string, texts ...string) {
+	t.Helper()
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	file, err := os.Create(filepath.Join(outputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create test embeddings file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for i, text := range texts {
+		embedding := make([]float32, 4)
+		embedding[i%4] = 1
+		if err := encoder.Encode(MessageEmbedding{ID: "id-" + text, Text: text, Embedding: embedding}); err != nil {
+			t.Fatalf("Failed to write test embedding: %v", err)
+		}
+	}
+}
+
+func TestQdrantDBIncremental(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:   config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "test_embeddings"},
+		DevMode:  config.DevModeConfig{Enabled: true},
+		Data:     config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging:  config.LoggingConfig{Level: "info", Format: "text"},
+		Pipeline: config.PipelineConfig{Incremental: true},
+	}
+
+	inject := func(texts ...string) []string {
+		writeEmbeddings(t, cfg.Data.OutputDir, texts...)
+		db, err := NewQdrantDB(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create QdrantDB: %v", err)
+		}
+		defer db.Close()
+		if err := db.(DB).CreateCollection(); err != nil {
+			t.Fatalf("Failed to create collection: %v", err)
+		}
+		if err := db.(DB).InjectMessages(); err != nil {
+			t.Fatalf("Failed to inject messages: %v", err)
+		}
+		messages, err := db.GetAllMessages()
+		if err != nil {
+			t.Fatalf("Failed to get all messages: %v", err)
+		}
+		var ids []string
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+
+	if ids := inject("one", "two"); len(ids) != 2 {
+		t.Fatalf("Expected 2 stored points, got %v", ids)
+	}
+
+	// A second run keeps the stored points and only adds the new one
+	ids := inject("one", "two", "three")
+	if len(ids) != 3 || ids[0] != "id-one" || ids[2] != "id-three" {
+		t.Errorf("Expected the new point to be appended once, got %v", ids)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.Qdrant.Path, "test.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read test database file: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected 3 points in the test database file, got %d", lines)
+	}
+}
+
+func TestQdrantDBSearchCollapsesChunks(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "test_embeddings"},
+		DevMode: config.DevModeConfig{Enabled: true},
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		Vector:  config.VectorConfig{ChunkSize: 10},
+	}
+
+	// A long message stored as two chunks plus the whole, and a short one
+	points := []MessageEmbedding{
+		{ID: "long#0", Text: "first half", Embedding: []float32{1, 0, 0}, Chunk: message.Chunk{ParentID: "long", ChunkIndex: 0}},
+		{ID: "long#1", Text: "second half", Embedding: []float32{0.9, 0.1, 0}, Chunk: message.Chunk{ParentID: "long", ChunkIndex: 1}},
+		{ID: "long", Text: "first half second half", Embedding: []float32{0, 0, 1}, Chunk: message.Chunk{ChunkCount: 2}},
+		{ID: "short", Text: "short", Embedding: []float32{0.5, 0.5, 0}},
+	}
+	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	file, err := os.Create(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create test embeddings file: %v", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, point := range points {
+		if err := encoder.Encode(point); err != nil {
+			t.Fatalf("Failed to write test embedding: %v", err)
+		}
+	}
+	file.Close()
+
+	db, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.(DB).CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := db.(DB).InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	results, err := db.Search([]float32{1, 0, 0}, 2, nil)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", results)
+	}
+	if results[0].ID != "long" || results[0].Text != "first half second half" || results[0].Score < 0.99 {
+		t.Errorf("Expected the best chunk hit to be collapsed into its message, got %+v", results[0])
+	}
+	if results[1].ID != "short" {
+		t.Errorf("Expected the short message second, got %+v", results[1])
+	}
+	if len(results[0].Embedding) != 3 || results[0].Embedding[2] != 1 {
+		t.Errorf("Expected the parent's embedding on the collapsed hit, got %v", results[0].Embedding)
+	}
+
+	// Filters apply before collapsing, so only the short message can match
+	filter := &vector.Filter{Must: []vector.Condition{vector.Equals(vector.FieldKind, vector.KindMessage), vector.In("text", "short")}}
+	results, err = db.Search([]float32{1, 0, 0}, 2, filter)
+	if err != nil {
+		t.Fatalf("Failed to search with a filter: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "short" {
+		t.Errorf("Expected only the short message, got %+v", results)
+	}
+
+	// Chunks keep their provenance in the store
+	messages, err := db.GetAllMessages()
+	if err != nil {
+		t.Fatalf("Failed to get all messages: %v", err)
+	}
+	if len(messages) != 4 || messages[1].ParentID != "long" || messages[1].ChunkIndex != 1 || messages[2].ChunkCount != 2 {
+		t.Errorf("Expected chunk provenance on stored points, got %+v", messages)
+	}
+}
+
+func TestQdrantPayloadKeepsMessageID(t *testing.T) {
+	meta := message.Metadata{Sender: "me"}
+	id := embeddings.MessageID("hello", meta)
+	uuid, err := pointUUID(id)
+	if err != nil {
+		t.Fatalf("Failed to derive point UUID: %v", err)
+	}
+	pointID := &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: uuid}}
+
+	payload := qdrantPayload(id, "hello", meta, message.Chunk{}, "")
+	if got := pointMessageID(pointID, payload); got != id {
+		t.Errorf("Expected the message ID from the payload, got %q", got)
+	}
+
+	// Points injected before the ID was stored fall back to their UUID
+	// until migrated, and the migration derives the same ID from the text
+	// and metadata
+	delete(payload, messageIDKey)
+	if got := pointMessageID(pointID, payload); got != uuid {
+		t.Errorf("Expected the UUID for an unmigrated point, got %q", got)
+	}
+	if got := canonicalID(payload); got != id {
+		t.Errorf("Expected the canonical ID %q, got %q", id, got)
+	}
+
+	chunkPayload := qdrantPayload("", "hel", message.Metadata{}, message.Chunk{ParentID: id, ChunkIndex: 2}, "")
+	delete(chunkPayload, messageIDKey)
+	if got := canonicalID(chunkPayload); got != embeddings.ChunkID(id, 2) {
+		t.Errorf("Expected the chunk ID, got %q", got)
+	}
+}
+
+func TestQdrantFilter(t *testing.T) {
+	if qdrantFilter(nil) != nil || qdrantFilter(&vector.Filter{}) != nil {
+		t.Error("Expected no Qdrant filter for an empty filter")
+	}
+
+	filter := qdrantFilter(&vector.Filter{Must: []vector.Condition{
+		vector.Equals("sender", "me"),
+		vector.In("channel", "sms", "email"),
+		vector.Between(time.Unix(10, 0), time.Time{}),
+	}})
+	if len(filter.Must) != 3 {
+		t.Fatalf("Expected 3 conditions, got %v", filter.Must)
+	}
+	equals := filter.Must[0].GetField()
+	if equals.Key != "sender" || equals.Match.GetKeyword() != "me" {
+		t.Errorf("Unexpected equality condition %v", equals)
+	}
+	in := filter.Must[1].GetField()
+	if in.Key != "channel" || len(in.Match.GetKeywords().GetStrings()) != 2 {
+		t.Errorf("Unexpected set condition %v", in)
+	}
+	between := filter.Must[2].GetField()
+	if between.Key != vector.FieldTimestampUnix || between.Range.GetGte() != 10 || between.Range.Lte != nil {
+		t.Errorf("Unexpected range condition %v", between)
+	}
+
+	payload := qdrantPayload("id", "hi", message.Metadata{Timestamp: "1970-01-01T00:00:10Z"}, message.Chunk{}, "alice")
+	if payload[vector.FieldTimestampUnix].GetIntegerValue() != 10 || payload[vector.FieldPersona].GetStringValue() != "alice" {
+		t.Errorf("Expected a numeric timestamp and the persona in the payload, got %v", payload)
+	}
+}
+
+func TestCheckCompatible(t *testing.T) {
+	cfg := config.QdrantConfig{VectorSize: 3, Distance: "Cosine"}
+	info := vector.CollectionInfo{Name: "messages", Exists: true, VectorSize: 3, Distance: qdrant.Distance_Cosine.String()}
+	if err := checkCompatible(cfg, info); err != nil {
+		t.Errorf("Expected a compatible collection, got %v", err)
+	}
+
+	info.VectorSize = 1024
+	if err := checkCompatible(cfg, info); err == nil || !strings.Contains(err.Error(), "recreate") {
+		t.Errorf("Expected a size mismatch pointing at recreate, got %v", err)
+	}
+
+	info.VectorSize = 3
+	cfg.Distance = "dot"
+	if err := checkCompatible(cfg, info); err == nil {
+		t.Error("Expected a distance mismatch")
+	}
+
+	cfg.Distance = "Manhattan"
+	if err := checkCompatible(cfg, info); err == nil {
+		t.Error("Expected an unknown distance to be rejected")
+	}
+
+	if got := qdrantAddress(config.QdrantConfig{}); got != "localhost:6334" {
+		t.Errorf("Expected the default address, got %s", got)
+	}
+	if got := qdrantAddress(config.QdrantConfig{Host: "qdrant.example.com", Port: 443}); got != "qdrant.example.com:443" {
+		t.Errorf("Expected the configured address, got %s", got)
+	}
+}
+
+func TestQdrantDBDevCollection(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "messages"},
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		DevMode: config.DevModeConfig{Enabled: true},
+	}
+	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	data := `{"id":"a","text":"a","embedding":[1,0,0]}` + "\n" + `{"id":"b","text":"b","embedding":[0,1,0]}` + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write embeddings: %v", err)
+	}
+
+	db, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	manager := db.(CollectionManager)
+
+	info, err := manager.DescribeCollection()
+	if err != nil || info.Exists {
+		t.Fatalf("Expected no collection yet, got %+v (%v)", info, err)
+	}
+	if err := db.CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := db.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+	info, err = manager.DescribeCollection()
+	if err != nil || !info.Exists || info.Points != 2 || info.VectorSize != 3 {
+		t.Errorf("Expected 2 points of size 3, got %+v (%v)", info, err)
+	}
+
+	if err := manager.DropCollection(); err != nil {
+		t.Fatalf("Failed to drop collection: %v", err)
+	}
+	if info, _ := manager.DescribeCollection(); info.Exists {
+		t.Errorf("Expected the collection to be gone, got %+v", info)
+	}
+	if err := manager.DropCollection(); err != nil {
+		t.Errorf("Expected dropping a missing collection to succeed, got %v", err)
+	}
+}
+
+func TestQdrantDBDevDelete(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "messages"},
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		DevMode: config.DevModeConfig{Enabled: true},
+	}
+	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	data := `{"id":"a","text":"a","embedding":[1,0,0]}` + "\n" +
+		`{"id":"long#0","text":"lo","embedding":[0,0,1],"parent_id":"long"}` + "\n" +
+		`{"id":"long","text":"long","embedding":[0,0,1],"chunk_count":1}` + "\n" +
+		`{"id":"b","text":"b","embedding":[0,1,0]}` + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write embeddings: %v", err)
+	}
+
+	db, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	if err := db.CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := db.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	deleted, err := db.Delete([]string{"long", "a", "missing"})
+	if err != nil || deleted != 3 {
+		t.Fatalf("Expected the message, its chunk and a to be deleted, got %d (%v)", deleted, err)
+	}
+
+	// The file is rewritten, so a fresh connection sees the deletion too
+	reopened, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	messages, err := reopened.GetAllMessages()
+	if err != nil || len(messages) != 1 || messages[0].ID != "b" {
+		t.Errorf("Expected only b to remain, got %+v (%v)", messages, err)
+	}
+	if deleted, err := reopened.Delete([]string{"a"}); err != nil || deleted != 0 {
+		t.Errorf("Expected deleting a missing message to remove nothing, got %d (%v)", deleted, err)
+	}
+}
+
+func TestQdrantDBDevQuantized(t *testing.T) {
+	for _, quantization := range []string{"int8", "binary"} {
+		t.Run(quantization, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			cfg := &config.Config{
+				Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "messages"},
+				Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+				Logging: config.LoggingConfig{Level: "error", Format: "text"},
+				DevMode: config.DevModeConfig{Enabled: true},
+				Vector:  config.VectorConfig{Quantization: quantization},
+			}
+			if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+				t.Fatalf("Failed to create output directory: %v", err)
+			}
+			data := `{"id":"a","text":"a","embedding":[1,0.1,0,0]}` + "\n" +
+				`{"id":"b","text":"b","embedding":[1,0.2,0,0],"sender":"me"}` + "\n" +
+				`{"id":"c","text":"c","embedding":[0,0,1,0.5]}` + "\n" +
+				`{"id":"d","text":"d","embedding":[-1,0,0,1]}` + "\n"
+			if err := os.WriteFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"), []byte(data), 0644); err != nil {
+				t.Fatalf("Failed to write embeddings: %v", err)
+			}
+
+			db, err := NewQdrantDB(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create QdrantDB: %v", err)
+			}
+			if err := db.CreateCollection(); err != nil {
+				t.Fatalf("Failed to create collection: %v", err)
+			}
+			if err := db.InjectMessages(); err != nil {
+				t.Fatalf("Failed to inject messages: %v", err)
+			}
+
+			// The candidates are rescored with the exact vectors
+			query := []float32{1, 0.2, 0, 0}
+			results, err := db.Search(query, 2, nil)
+			if err != nil || len(results) != 2 || results[0].ID != "b" || results[1].ID != "a" {
+				t.Fatalf("Expected b then a, got %+v (%v)", results, err)
+			}
+			if results[0].Score < 0.9999 || len(results[0].Embedding) != 4 {
+				t.Errorf("Expected b's exact score and embedding, got %+v", results[0])
+			}
+			results, err = db.Search(query, 2, &vector.Filter{Must: []vector.Condition{vector.Equals("sender", "me")}})
+			if err != nil || len(results) != 1 || results[0].ID != "b" {
+				t.Errorf("Expected only b to match the filter, got %+v (%v)", results, err)
+			}
+
+			// Deleting a point drops the quantized copy of the file
+			if _, err := db.Delete([]string{"b"}); err != nil {
+				t.Fatalf("Failed to delete b: %v", err)
+			}
+			results, err = db.Search(query, 1, nil)
+			if err != nil || len(results) != 1 || results[0].ID != "a" {
+				t.Errorf("Expected a after deleting b, got %+v (%v)", results, err)
+			}
+		})
+	}
+}
+
+func TestQdrantDBDevListMessages(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Qdrant:  config.QdrantConfig{Path: filepath.Join(tmpDir, "qdrant"), CollectionName: "messages"},
+		Data:    config.DataConfig{OutputDir: filepath.Join(tmpDir, "output")},
+		Logging: config.LoggingConfig{Level: "error", Format: "text"},
+		DevMode: config.DevModeConfig{Enabled: true},
+	}
+	if err := os.MkdirAll(cfg.Data.OutputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output directory: %v", err)
+	}
+	data := `{"id":"a","text":"a","embedding":[1,0,0]}` + "\n" +
+		`{"id":"b","text":"b","embedding":[0,1,0]}` + "\n" +
+		`{"id":"c","text":"c","embedding":[0,0,1]}` + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.Data.OutputDir, "messages_embeddings.jsonl"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write embeddings: %v", err)
+	}
+
+	db, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	if err := db.CreateCollection(); err != nil {
+		t.Fatalf("Failed to create collection: %v", err)
+	}
+	if err := db.InjectMessages(); err != nil {
+		t.Fatalf("Failed to inject messages: %v", err)
+	}
+
+	// A fresh connection reads the pages from the file
+	reopened, err := NewQdrantDB(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create QdrantDB: %v", err)
+	}
+	for _, db := range []DB{db, reopened} {
+		var ids []string
+		pages := 0
+		err := db.ListMessages(2, func(page []vector.Message) error {
+			for _, msg := range page {
+				if msg.Embedding != nil {
+					t.Errorf("Expected %s to be listed without its embedding", msg.ID)
+				}
+				ids = append(ids, msg.ID)
+			}
+			pages++
+			return nil
+		})
+		if err != nil || pages != 2 || strings.Join(ids, ",") != "a,b,c" {
+			t.Errorf("Expected a, b and c in 2 pages, got %v in %d (%v)", ids, pages, err)
+		}
+	}
+
+	messages, err := reopened.GetMessages([]string{"c", "missing", "a"})
+	if err != nil || len(messages) != 2 || messages[0].ID != "c" || messages[1].ID != "a" || len(messages[0].Embedding) != 3 {
+		t.Errorf("Expected c then a with their embeddings, got %+v (%v)", messages, err)
 	}
-}
\ No newline at end of file
+}